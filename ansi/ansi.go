@@ -0,0 +1,372 @@
+// Package ansi renders terminal output that contains ANSI escape sequences as
+// plain text, as ANSI with only colours and styles left, or as HTML.
+package ansi
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Formats output can be rendered in
+const (
+	FormatText = "text" // Escape sequences removed
+	FormatANSI = "ansi" // Colours and styles kept, cursor movement applied
+	FormatHTML = "html" // Colours and styles as <span> elements, to be shown in a <pre>
+)
+
+// window is how many lines back cursor movement can reach. Older lines are
+// written out as they scroll past it.
+const window = 256
+
+// maxColumn is how far right cursor movement can reach. Text written past it
+// still extends the line, but a sequence asking for a huge column would
+// otherwise pad the line with spaces up to it.
+const maxColumn = 1024
+
+// maxLineCells is how many characters of a line are kept. The rest is dropped
+// and the line ends in lineCutMark, so a line that never ends can't take more
+// than this times the size of a cell; the log itself keeps all of it.
+const maxLineCells = 16 * 1024
+
+// lineCutMark ends lines cut at maxLineCells
+const lineCutMark = '…'
+
+// ValidFormat reports whether format is one of the formats output can be rendered in
+func ValidFormat(format string) bool {
+	return format == FormatText || format == FormatANSI || format == FormatHTML
+}
+
+// Convert renders the output read from r in format and writes it to w.
+// Carriage returns, erases and cursor movement are applied the way a terminal
+// would, so progress bars that redraw themselves leave only their final state.
+func Convert(w io.Writer, r io.Reader, format string) error {
+	if !ValidFormat(format) {
+		return fmt.Errorf("invalid format '%s', expected '%s', '%s' or '%s'", format, FormatText, FormatANSI, FormatHTML)
+	}
+
+	out := bufio.NewWriter(w)
+	s := &screen{out: out, format: format, lines: [][]cell{nil}}
+	if err := s.parse(bufio.NewReader(r)); err != nil {
+		return err
+	}
+	s.flush()
+	return out.Flush()
+}
+
+// ConvertString renders output in format, see Convert
+func ConvertString(output, format string) string {
+	var buf bytes.Buffer
+	if err := Convert(&buf, strings.NewReader(output), format); err != nil {
+		return output
+	}
+	return buf.String()
+}
+
+// cell is a character on the screen
+type cell struct {
+	r  rune
+	st style
+}
+
+// screen holds the lines cursor movement can still change
+type screen struct {
+	out    *bufio.Writer
+	format string
+	lines  [][]cell
+	row    int // Cursor, in lines
+	col    int
+	st     style // Style of the next characters written
+}
+
+// parse reads the output and applies it to the screen
+func (s *screen) parse(r *bufio.Reader) error {
+	for {
+		ch, _, err := r.ReadRune()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case ch == 0x1b:
+			if err := s.escape(r); err != nil && err != io.EOF {
+				return err
+			}
+		case ch == '\n':
+			s.moveRow(1)
+			s.col = 0
+		case ch == '\r':
+			s.col = 0
+		case ch == '\b':
+			s.col = max(s.col-1, 0)
+		case ch == '\t':
+			for {
+				s.put(' ')
+				if s.col%8 == 0 {
+					break
+				}
+			}
+		case ch < 0x20 || ch == 0x7f:
+			// Other control characters have nothing to show
+		default:
+			s.put(ch)
+		}
+	}
+}
+
+// escape handles the sequence after an ESC character
+func (s *screen) escape(r *bufio.Reader) error {
+	ch, _, err := r.ReadRune()
+	if err != nil {
+		return err
+	}
+
+	switch ch {
+	case '[':
+		// Control sequence: parameters, then a final byte in 0x40-0x7e
+		var params strings.Builder
+		for {
+			ch, _, err := r.ReadRune()
+			if err != nil {
+				return err
+			}
+			if ch >= 0x40 && ch <= 0x7e {
+				s.control(params.String(), ch)
+				return nil
+			}
+			params.WriteRune(ch)
+		}
+	case ']':
+		// Operating system command, e.g. a window title or hyperlink: ends with BEL or ESC \
+		for {
+			ch, _, err := r.ReadRune()
+			if err != nil {
+				return err
+			}
+			if ch == 0x07 {
+				return nil
+			}
+			if ch == 0x1b {
+				_, _, err := r.ReadRune()
+				return err
+			}
+		}
+	default:
+		// Other sequences, e.g. ESC ( B, may have intermediate bytes before the final one
+		for ch >= 0x20 && ch <= 0x2f {
+			if ch, _, err = r.ReadRune(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// control applies a control sequence
+func (s *screen) control(params string, final rune) {
+	if strings.HasPrefix(params, "?") || strings.HasPrefix(params, ">") {
+		// Private modes, such as hiding the cursor
+		return
+	}
+	args := parseParams(params)
+	n := max(arg(args, 0, 1), 1)
+	rows := min(n, window)
+
+	switch final {
+	case 'm':
+		s.st = s.st.apply(args)
+	case 'A':
+		s.moveRow(-rows)
+	case 'B':
+		s.moveRow(rows)
+	case 'C':
+		s.moveCol(s.col + n)
+	case 'D':
+		s.col = max(s.col-n, 0)
+	case 'E':
+		s.moveRow(rows)
+		s.col = 0
+	case 'F':
+		s.moveRow(-rows)
+		s.col = 0
+	case 'G':
+		s.moveCol(n - 1)
+	case 'K':
+		s.eraseLine(arg(args, 0, 0))
+	case 'J':
+		if arg(args, 0, 0) == 0 {
+			// Erase from the cursor to the end of the screen
+			s.eraseLine(0)
+			s.lines = s.lines[:s.row+1]
+		}
+	}
+}
+
+// put writes a character at the cursor and moves it on. Past maxLineCells it
+// only marks the line as cut.
+func (s *screen) put(ch rune) {
+	line := s.lines[s.row]
+	if s.col >= maxLineCells {
+		for len(line) < maxLineCells {
+			line = append(line, cell{r: ' '})
+		}
+		if len(line) == maxLineCells {
+			line = append(line, cell{r: lineCutMark})
+		}
+		s.lines[s.row] = line
+		s.col++
+		return
+	}
+	for len(line) < s.col {
+		line = append(line, cell{r: ' '})
+	}
+	if s.col < len(line) {
+		line[s.col] = cell{r: ch, st: s.st}
+	} else {
+		line = append(line, cell{r: ch, st: s.st})
+	}
+	s.lines[s.row] = line
+	s.col++
+}
+
+// moveCol moves the cursor to col. Past maxColumn it only moves as far as
+// text has already taken it.
+func (s *screen) moveCol(col int) {
+	if col > maxColumn {
+		col = max(min(col, s.col), maxColumn)
+	}
+	s.col = col
+}
+
+// moveRow moves the cursor up or down, adding lines below as needed and
+// writing out lines that leave the window
+func (s *screen) moveRow(n int) {
+	s.row = max(s.row+n, 0)
+	for s.row >= len(s.lines) {
+		s.lines = append(s.lines, nil)
+	}
+	for len(s.lines) > window && s.row > 0 {
+		s.writeLine(s.lines[0])
+		s.lines = s.lines[1:]
+		s.row--
+	}
+}
+
+// eraseLine erases from the cursor to the end of the line (0), from the start
+// to the cursor (1) or the whole line (2)
+func (s *screen) eraseLine(mode int) {
+	line := s.lines[s.row]
+	switch mode {
+	case 0:
+		if s.col < len(line) {
+			s.lines[s.row] = line[:s.col]
+		}
+	case 1:
+		for i := 0; i <= s.col && i < len(line); i++ {
+			line[i] = cell{r: ' '}
+		}
+	case 2:
+		s.lines[s.row] = nil
+	}
+}
+
+// flush writes out the lines left on the screen. The line the cursor ended on
+// is left out if it is empty, as it follows the last newline.
+func (s *screen) flush() {
+	lines := s.lines
+	if last := len(lines) - 1; last == s.row && len(lines[last]) == 0 {
+		lines = lines[:last]
+	}
+	for _, line := range lines {
+		s.writeLine(line)
+	}
+	s.lines = [][]cell{nil}
+	s.row = 0
+}
+
+// writeLine writes a finished line in the output format
+func (s *screen) writeLine(line []cell) {
+	var current style
+	open := false
+	for _, c := range line {
+		if c.st != current {
+			switch s.format {
+			case FormatANSI:
+				s.out.WriteString(c.st.sgr())
+			case FormatHTML:
+				if open {
+					s.out.WriteString("</span>")
+					open = false
+				}
+				if css := c.st.css(); css != "" {
+					fmt.Fprintf(s.out, `<span style="%s">`, css)
+					open = true
+				}
+			}
+			current = c.st
+		}
+
+		if s.format == FormatHTML {
+			writeHTMLRune(s.out, c.r)
+		} else {
+			s.out.WriteRune(c.r)
+		}
+	}
+
+	if current != (style{}) && s.format == FormatANSI {
+		s.out.WriteString("\x1b[0m")
+	}
+	if open {
+		s.out.WriteString("</span>")
+	}
+	s.out.WriteByte('\n')
+}
+
+// writeHTMLRune writes a character escaped for HTML
+func writeHTMLRune(w *bufio.Writer, r rune) {
+	switch r {
+	case '<':
+		w.WriteString("&lt;")
+	case '>':
+		w.WriteString("&gt;")
+	case '&':
+		w.WriteString("&amp;")
+	case '"':
+		w.WriteString("&#34;")
+	case '\'':
+		w.WriteString("&#39;")
+	default:
+		w.WriteRune(r)
+	}
+}
+
+// parseParams parses the numeric parameters of a control sequence; empty ones are -1
+func parseParams(params string) []int {
+	if params == "" {
+		return nil
+	}
+	fields := strings.Split(strings.ReplaceAll(params, ":", ";"), ";")
+	args := make([]int, len(fields))
+	for i, field := range fields {
+		n, err := strconv.Atoi(field)
+		if err != nil {
+			n = -1
+		}
+		args[i] = n
+	}
+	return args
+}
+
+// arg returns the parameter at i, or def if it is missing or empty
+func arg(args []int, i, def int) int {
+	if i >= len(args) || args[i] < 0 {
+		return def
+	}
+	return args[i]
+}
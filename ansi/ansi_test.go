@@ -0,0 +1,172 @@
+package ansi
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestConvertText(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   string
+	}{
+		{"plain", "hello\nworld\n", "hello\nworld\n"},
+		{"no final newline", "hello", "hello\n"},
+		{"empty", "", ""},
+		{"colours removed", "\x1b[1;31mFAIL\x1b[0m ok\n", "FAIL ok\n"},
+		{"carriage return redraws", "progress 10%\rprogress 100%\n", "progress 100%\n"},
+		{"shorter redraw keeps the rest", "downloading\rdone\n", "doneloading\n"},
+		{"redraw with erase", "downloading\r\x1b[Kdone\n", "done\n"},
+		{"erase whole line", "abc\x1b[2Kxy\n", "   xy\n"},
+		{"erase to the cursor", "abcdef\x1b[3D\x1b[1K\n", "    ef\n"},
+		{"backspace", "ab\bc\n", "ac\n"},
+		{"tab", "a\tb\n", "a       b\n"},
+		{"cursor up redraws lines", "a 1\nb 1\n\x1b[2Aa 2\n\x1b[Kb 2\n", "a 2\nb 2\n"},
+		{"cursor forward", "a\x1b[3Cb\n", "a   b\n"},
+		{"column", "abcdef\x1b[3Gx\n", "abxdef\n"},
+		{"erase below", "one\ntwo\nthree\x1b[2F\x1b[J1\n", "1\n"},
+		{"hidden cursor", "\x1b[?25lspinner\x1b[?25h\n", "spinner\n"},
+		{"window title and hyperlink", "\x1b]0;title\x07\x1b]8;;https://x\x1b\\link\x1b]8;;\x1b\\\n", "link\n"},
+		{"charset", "\x1b(Babc\n", "abc\n"},
+		{"control characters", "a\x00b\x07c\x7f\n", "abc\n"},
+		{"cut off sequence", "abc\x1b[3", "abc\n"},
+		{"unicode", "héllo ✓\rH\n", "Héllo ✓\n"},
+	}
+	for _, tt := range tests {
+		if got := ConvertString(tt.output, FormatText); got != tt.want {
+			t.Errorf("%s: text = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestConvertANSI(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   string
+	}{
+		{"plain", "plain\n", "plain\n"},
+		{"colour", "\x1b[31mred\x1b[0m plain\n", "\x1b[0;31mred\x1b[0m plain\n"},
+		{"reset at the end of the line", "\x1b[1;32mok\n", "\x1b[0;1;32mok\x1b[0m\n"},
+		{"styles", "\x1b[1;2;3;4;7mx\x1b[22;23;24;27my\n", "\x1b[0;1;2;3;4;7mx\x1b[0my\n"},
+		{"bright", "\x1b[91;102mx\n", "\x1b[0;91;102mx\x1b[0m\n"},
+		{"256 colours", "\x1b[38;5;208mx\n", "\x1b[0;38;5;208mx\x1b[0m\n"},
+		{"true colour", "\x1b[48;2;1;2;3mx\x1b[49my\n", "\x1b[0;48;2;1;2;3mx\x1b[0my\n"},
+		{"colon parameters", "\x1b[38:5:9mx\n", "\x1b[0;91mx\x1b[0m\n"},
+		{"redraw keeps the style of each character", "\x1b[33m....\r\x1b[32mOK\n", "\x1b[0;32mOK\x1b[0;33m..\x1b[0m\n"},
+		{"cursor movement removed", "a\x1b[1A\x1b[Bb\n", "a\n b\n"},
+	}
+	for _, tt := range tests {
+		if got := ConvertString(tt.output, FormatANSI); got != tt.want {
+			t.Errorf("%s: ansi = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestConvertHTML(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   string
+	}{
+		{"escaped", "<b>a & 'b' \"c\"</b>\n", "&lt;b&gt;a &amp; &#39;b&#39; &#34;c&#34;&lt;/b&gt;\n"},
+		{"colour", "\x1b[31mred\x1b[0m plain\n", `<span style="color:#cd0000">red</span> plain` + "\n"},
+		{"styles", "\x1b[1;3;4;2mx\n", `<span style="font-weight:bold;opacity:0.7;font-style:italic;text-decoration:underline">x</span>` + "\n"},
+		{"background", "\x1b[97;44mx\n", `<span style="color:#ffffff;background-color:#0000ee">x</span>` + "\n"},
+		{"colour cube", "\x1b[38;5;208mx\n", `<span style="color:#ff8700">x</span>` + "\n"},
+		{"grey ramp", "\x1b[38;5;244mx\n", `<span style="color:#808080">x</span>` + "\n"},
+		{"true colour", "\x1b[38;2;18;52;86mx\n", `<span style="color:#123456">x</span>` + "\n"},
+		{"inverse", "\x1b[7mx\n", `<span style="color:#000000;background-color:#e5e5e5">x</span>` + "\n"},
+		{"inverse colours", "\x1b[7;31;42mx\n", `<span style="color:#00cd00;background-color:#cd0000">x</span>` + "\n"},
+		{"span per style", "\x1b[31ma\x1b[32mb\n", `<span style="color:#cd0000">a</span><span style="color:#00cd00">b</span>` + "\n"},
+		{"escaped sequence text", "\x1b[31m<x>\n", `<span style="color:#cd0000">&lt;x&gt;</span>` + "\n"},
+	}
+	for _, tt := range tests {
+		if got := ConvertString(tt.output, FormatHTML); got != tt.want {
+			t.Errorf("%s: html = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestConvertScrolledLines(t *testing.T) {
+	// Lines that scrolled out of the window are written as they were; moving
+	// the cursor up stops at the oldest line still in the window
+	var output strings.Builder
+	for i := range window + 10 {
+		fmt.Fprintf(&output, "line %d\n", i)
+	}
+	output.WriteString("\x1b[1000Aredrawn\n")
+
+	lines := strings.Split(ConvertString(output.String(), FormatText), "\n")
+	scrolled := 11 // The window holds the cursor's empty line after the last one
+	if lines[scrolled-1] != fmt.Sprintf("line %d", scrolled-1) || lines[scrolled] != "redrawn" {
+		t.Errorf("lines around the top of the window = %q", lines[scrolled-1:scrolled+1])
+	}
+	if last := lines[window+9]; last != fmt.Sprintf("line %d", window+9) {
+		t.Errorf("last line = %q", last)
+	}
+}
+
+func TestConvertHugeParameters(t *testing.T) {
+	// Cursor movement is clamped, so a sequence asking for a huge column or
+	// row count costs no more than a line of maxColumn spaces or a window of lines
+	long := strings.Repeat("x", maxColumn+10)
+	tests := []struct {
+		name   string
+		output string
+		want   string
+	}{
+		{"cursor forward", "\x1b[50000000Cx\n", strings.Repeat(" ", maxColumn) + "x\n"},
+		{"column", "\x1b[999999999Gx\n", strings.Repeat(" ", maxColumn) + "x\n"},
+		{"forward past long text", long + "\x1b[50000000Cy\n", long + "y\n"},
+		{"column inside long text", long + "\x1b[1030Gy\n", long[:1029] + "y" + long[1030:] + "\n"},
+		{"cursor down", "a\x1b[50000000Bb\n", "a\n" + strings.Repeat("\n", window-1) + " b\n"},
+		{"next line", "a\x1b[50000000Eb\n", "a\n" + strings.Repeat("\n", window-1) + "b\n"},
+		{"overflowing parameter", "a\x1b[99999999999999999999999Cb\n", "a b\n"},
+	}
+	for _, tt := range tests {
+		if got := ConvertString(tt.output, FormatText); got != tt.want {
+			t.Errorf("%s: text has %d bytes, want %d", tt.name, len(got), len(tt.want))
+		}
+	}
+}
+
+func TestConvertLongLine(t *testing.T) {
+	// Characters past maxLineCells are dropped and the line is marked as cut,
+	// also when it is redrawn
+	long := strings.Repeat("x", maxLineCells)
+	cut := long + string(lineCutMark) + "\n"
+	tests := []struct {
+		name   string
+		output string
+		want   string
+	}{
+		{"fits", long + "\n", long + "\n"},
+		{"too long", long + strings.Repeat("y", 3*maxLineCells) + "\n", cut},
+		{"redrawn", long + "yy\r" + long + "zz\n", cut},
+		{"start rewritten", long + "yy\rab\n", "ab" + long[2:] + string(lineCutMark) + "\n"},
+		{"erased", long + "yy\r\x1b[Kab\n", "ab\n"},
+	}
+	for _, tt := range tests {
+		if got := ConvertString(tt.output, FormatText); got != tt.want {
+			t.Errorf("%s: text has %d bytes, want %d", tt.name, len(got), len(tt.want))
+		}
+	}
+}
+
+func TestConvertInvalidFormat(t *testing.T) {
+	var out strings.Builder
+	if err := Convert(&out, strings.NewReader("x"), "pdf"); err == nil {
+		t.Error("Convert accepted an invalid format")
+	}
+	if got := ConvertString("\x1b[31mx", "pdf"); got != "\x1b[31mx" {
+		t.Errorf("ConvertString with an invalid format = %q, want the output unchanged", got)
+	}
+	for _, format := range []string{FormatText, FormatANSI, FormatHTML} {
+		if !ValidFormat(format) {
+			t.Errorf("ValidFormat(%q) = false", format)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package ansi
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// color is a foreground or background colour
+type color struct {
+	mode    uint8 // colorDefault, colorIndexed or colorRGB
+	index   uint8
+	r, g, b uint8
+}
+
+const (
+	colorDefault = iota
+	colorIndexed
+	colorRGB
+)
+
+// style is how characters are shown, as set by SGR sequences
+type style struct {
+	fg, bg    color
+	bold      bool
+	dim       bool
+	italic    bool
+	underline bool
+	inverse   bool
+}
+
+// apply updates the style with the parameters of an SGR sequence
+func (st style) apply(args []int) style {
+	if len(args) == 0 {
+		return style{}
+	}
+
+	for i := 0; i < len(args); i++ {
+		switch n := arg(args, i, 0); {
+		case n == 0:
+			st = style{}
+		case n == 1:
+			st.bold = true
+		case n == 2:
+			st.dim = true
+		case n == 3:
+			st.italic = true
+		case n == 4:
+			st.underline = true
+		case n == 7:
+			st.inverse = true
+		case n == 22:
+			st.bold, st.dim = false, false
+		case n == 23:
+			st.italic = false
+		case n == 24:
+			st.underline = false
+		case n == 27:
+			st.inverse = false
+		case n >= 30 && n <= 37:
+			st.fg = color{mode: colorIndexed, index: uint8(n - 30)}
+		case n == 38:
+			st.fg, i = extendedColor(args, i)
+		case n == 39:
+			st.fg = color{}
+		case n >= 40 && n <= 47:
+			st.bg = color{mode: colorIndexed, index: uint8(n - 40)}
+		case n == 48:
+			st.bg, i = extendedColor(args, i)
+		case n == 49:
+			st.bg = color{}
+		case n >= 90 && n <= 97:
+			st.fg = color{mode: colorIndexed, index: uint8(n - 90 + 8)}
+		case n >= 100 && n <= 107:
+			st.bg = color{mode: colorIndexed, index: uint8(n - 100 + 8)}
+		}
+	}
+	return st
+}
+
+// extendedColor parses "5;n" or "2;r;g;b" after a 38 or 48 at args[i]. It
+// returns the colour and the index of the last parameter it used.
+func extendedColor(args []int, i int) (color, int) {
+	switch arg(args, i+1, -1) {
+	case 5:
+		return color{mode: colorIndexed, index: uint8(arg(args, i+2, 0))}, i + 2
+	case 2:
+		return color{mode: colorRGB, r: uint8(arg(args, i+2, 0)), g: uint8(arg(args, i+3, 0)), b: uint8(arg(args, i+4, 0))}, i + 4
+	default:
+		return color{}, len(args)
+	}
+}
+
+// sgr returns the sequence that sets the style from scratch
+func (st style) sgr() string {
+	codes := []string{"0"}
+	if st.bold {
+		codes = append(codes, "1")
+	}
+	if st.dim {
+		codes = append(codes, "2")
+	}
+	if st.italic {
+		codes = append(codes, "3")
+	}
+	if st.underline {
+		codes = append(codes, "4")
+	}
+	if st.inverse {
+		codes = append(codes, "7")
+	}
+	if code := st.fg.sgr(30, 90, 38); code != "" {
+		codes = append(codes, code)
+	}
+	if code := st.bg.sgr(40, 100, 48); code != "" {
+		codes = append(codes, code)
+	}
+	return "\x1b[" + strings.Join(codes, ";") + "m"
+}
+
+// sgr returns the SGR parameters for the colour, given the codes for the
+// basic colours, the bright colours and extended colours
+func (c color) sgr(basic, bright, extended int) string {
+	switch {
+	case c.mode == colorIndexed && c.index < 8:
+		return strconv.Itoa(basic + int(c.index))
+	case c.mode == colorIndexed && c.index < 16:
+		return strconv.Itoa(bright + int(c.index) - 8)
+	case c.mode == colorIndexed:
+		return fmt.Sprintf("%d;5;%d", extended, c.index)
+	case c.mode == colorRGB:
+		return fmt.Sprintf("%d;2;%d;%d;%d", extended, c.r, c.g, c.b)
+	default:
+		return ""
+	}
+}
+
+// css returns the inline CSS for the style, empty for the default style
+func (st style) css() string {
+	fg, bg := st.fg, st.bg
+	if st.inverse {
+		fg, bg = bg, fg
+		// Without colours set, inverse swaps the terminal's default colours
+		if fg.mode == colorDefault {
+			fg = color{mode: colorIndexed, index: 0}
+		}
+		if bg.mode == colorDefault {
+			bg = color{mode: colorIndexed, index: 7}
+		}
+	}
+
+	var rules []string
+	if fg.mode != colorDefault {
+		rules = append(rules, "color:"+fg.hex())
+	}
+	if bg.mode != colorDefault {
+		rules = append(rules, "background-color:"+bg.hex())
+	}
+	if st.bold {
+		rules = append(rules, "font-weight:bold")
+	}
+	if st.dim {
+		rules = append(rules, "opacity:0.7")
+	}
+	if st.italic {
+		rules = append(rules, "font-style:italic")
+	}
+	if st.underline {
+		rules = append(rules, "text-decoration:underline")
+	}
+	return strings.Join(rules, ";")
+}
+
+// palette is the xterm palette of the 16 basic colours
+var palette = [16]string{
+	"#000000", "#cd0000", "#00cd00", "#cdcd00", "#0000ee", "#cd00cd", "#00cdcd", "#e5e5e5",
+	"#7f7f7f", "#ff0000", "#00ff00", "#ffff00", "#5c5cff", "#ff00ff", "#00ffff", "#ffffff",
+}
+
+// hex returns the colour as #rrggbb
+func (c color) hex() string {
+	if c.mode == colorRGB {
+		return fmt.Sprintf("#%02x%02x%02x", c.r, c.g, c.b)
+	}
+
+	n := int(c.index)
+	switch {
+	case n < 16:
+		return palette[n]
+	case n < 232:
+		// 6x6x6 colour cube
+		levels := [6]int{0, 95, 135, 175, 215, 255}
+		n -= 16
+		return fmt.Sprintf("#%02x%02x%02x", levels[n/36], levels[n/6%6], levels[n%6])
+	default:
+		gray := 8 + (n-232)*10
+		return fmt.Sprintf("#%02x%02x%02x", gray, gray, gray)
+	}
+}
@@ -0,0 +1,105 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"pipego/runner/storage"
+)
+
+// GetExecutions returns the most recent pipeline executions, optionally for one project
+func GetExecutions(store *storage.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		limit := 100
+		if value := r.URL.Query().Get("limit"); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed <= 0 {
+				http.Error(w, "Invalid limit", http.StatusBadRequest)
+				return
+			}
+			limit = parsed
+		}
+
+		executions, err := store.GetExecutions(limit, r.URL.Query().Get("project"))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get executions: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(executions)
+	}
+}
+
+// GetExecution returns a pipeline execution with the runs of its parts and their steps
+func GetExecution(store *storage.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		// Parse execution ID from URL: /api/executions/:id
+		pathParts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+		if len(pathParts) < 3 {
+			http.Error(w, "Invalid path", http.StatusBadRequest)
+			return
+		}
+
+		executionID, err := strconv.Atoi(pathParts[2])
+		if err != nil {
+			http.Error(w, "Invalid execution ID", http.StatusBadRequest)
+			return
+		}
+
+		execution, err := store.GetExecution(executionID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Execution not found: %v", err), http.StatusNotFound)
+			return
+		}
+
+		runs, err := store.GetExecutionRuns(executionID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get runs: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		// Build response: execution -> parts -> steps
+		type PartResponse struct {
+			Run   *storage.Run             `json:"run"`
+			Steps []*storage.StepExecution `json:"steps"`
+		}
+		type ExecutionResponse struct {
+			Execution *storage.Execution `json:"execution"`
+			Parts     []PartResponse     `json:"parts"`
+		}
+
+		response := ExecutionResponse{
+			Execution: execution,
+			Parts:     make([]PartResponse, 0, len(runs)),
+		}
+		for _, run := range runs {
+			steps, err := store.GetStepExecutions(run.ID)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Failed to get steps: %v", err), http.StatusInternalServerError)
+				return
+			}
+			if steps == nil {
+				steps = []*storage.StepExecution{}
+			}
+			store.LoadStepOutputs(steps)
+			response.Parts = append(response.Parts, PartResponse{Run: run, Steps: steps})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
@@ -0,0 +1,153 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"pipego/runner"
+	"pipego/runner/storage"
+)
+
+func TestGetExecution(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "app")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	configPath := filepath.Join(dir, "pipego.yml")
+	config := `parts:
+  build:
+    steps:
+      - name: compile
+        run: echo compile
+      - name: package
+        run: echo package
+  test:
+    steps:
+      - name: unit
+        run: exit 1
+  deploy:
+    steps:
+      - name: upload
+        run: echo upload
+`
+	if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	store, err := storage.NewStorage(filepath.Join(dir, "pipego.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	result, err := runner.RunPipelineWithOptions(configPath, runner.RunPipelineOptions{Storage: store, Parts: []string{"build", "test", "deploy"}})
+	if err == nil {
+		t.Fatal("pipeline with a failing part succeeded")
+	}
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		GetExecution(store)(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	rec := get("/api/executions/" + strconv.Itoa(result.ExecutionID))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	var response struct {
+		Execution storage.Execution `json:"execution"`
+		Parts     []struct {
+			Run   storage.Run              `json:"run"`
+			Steps []*storage.StepExecution `json:"steps"`
+		} `json:"parts"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+
+	if e := response.Execution; e.ID != result.ExecutionID || e.Status != "failed" || e.ProjectName != "app" || e.PartCount != 2 {
+		t.Errorf("execution = %+v", e)
+	}
+	// Parts in the order they ran, each with its steps; deploy never started
+	want := []struct {
+		part   string
+		status string
+		steps  []string
+	}{
+		{"build", "success", []string{"compile", "package"}},
+		{"test", "failed", []string{"unit"}},
+	}
+	if len(response.Parts) != len(want) {
+		t.Fatalf("got %d parts, want %d: %+v", len(response.Parts), len(want), response.Parts)
+	}
+	for i, w := range want {
+		part := response.Parts[i]
+		if part.Run.Part != w.part || part.Run.Status != w.status || part.Run.ExecutionID == nil || *part.Run.ExecutionID != result.ExecutionID {
+			t.Errorf("part %d = %+v, want %s %s", i, part.Run, w.part, w.status)
+		}
+		if len(part.Steps) != len(w.steps) {
+			t.Errorf("part %s has %d steps, want %v", w.part, len(part.Steps), w.steps)
+			continue
+		}
+		for j, step := range part.Steps {
+			if step.Name != w.steps[j] || step.RunID != part.Run.ID {
+				t.Errorf("part %s step %d = %s of run %d, want %s of run %d", w.part, j, step.Name, step.RunID, w.steps[j], part.Run.ID)
+			}
+		}
+	}
+
+	for path, status := range map[string]int{
+		"/api/executions/9999": http.StatusNotFound,
+		"/api/executions/x":    http.StatusBadRequest,
+		"/api/executions":      http.StatusBadRequest,
+	} {
+		if rec := get(path); rec.Code != status {
+			t.Errorf("GET %s: status %d, want %d", path, rec.Code, status)
+		}
+	}
+}
+
+func TestGetExecutions(t *testing.T) {
+	store, err := storage.NewStorage(filepath.Join(t.TempDir(), "pipego.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	for _, project := range []string{"app", "lib", "app"} {
+		if _, err := store.CreateExecution(project+"/pipego.yml", project, "api", "queued"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	get := func(query string) (int, []storage.Execution) {
+		rec := httptest.NewRecorder()
+		GetExecutions(store)(rec, httptest.NewRequest(http.MethodGet, "/api/executions"+query, nil))
+		var executions []storage.Execution
+		if rec.Code == http.StatusOK {
+			if err := json.Unmarshal(rec.Body.Bytes(), &executions); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return rec.Code, executions
+	}
+
+	if status, executions := get(""); status != http.StatusOK || len(executions) != 3 || executions[0].ID != 3 {
+		t.Errorf("all executions: status %d, %+v", status, executions)
+	}
+	if status, executions := get("?project=app&limit=1"); status != http.StatusOK || len(executions) != 1 || executions[0].ID != 3 {
+		t.Errorf("latest of app: status %d, %+v", status, executions)
+	}
+	if status, executions := get("?project=lib"); status != http.StatusOK || len(executions) != 1 || executions[0].ProjectName != "lib" {
+		t.Errorf("executions of lib: status %d, %+v", status, executions)
+	}
+	for _, query := range []string{"?limit=0", "?limit=x"} {
+		if status, _ := get(query); status != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want %d", query, status, http.StatusBadRequest)
+		}
+	}
+}
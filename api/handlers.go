@@ -2,6 +2,7 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -9,7 +10,9 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
+	"pipego/ansi"
 	"pipego/runner"
 	"pipego/runner/storage"
 )
@@ -33,7 +36,9 @@ func GetRuns(store *storage.Storage) http.HandlerFunc {
 	}
 }
 
-// GetRun returns a single run with its steps
+// GetRun returns a single run with its steps.
+// ?output=lines gives the output of each step as line records instead, up to
+// limit lines (at most storage.MaxStepLinesLimit) starting at line from.
 func GetRun(store *storage.Storage) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
@@ -68,15 +73,98 @@ func GetRun(store *storage.Storage) http.HandlerFunc {
 			return
 		}
 
+		// Output as plain text, or as line records with their stream and time
+		switch r.URL.Query().Get("output") {
+		case "", "text":
+			store.LoadStepOutputs(steps)
+		case "lines":
+			from, limit := 1, storage.DefaultStepLinesLimit
+			if value := r.URL.Query().Get("from"); value != "" {
+				if from, err = strconv.Atoi(value); err != nil || from < 1 {
+					http.Error(w, "Invalid from, expected a line number", http.StatusBadRequest)
+					return
+				}
+			}
+			if value := r.URL.Query().Get("limit"); value != "" {
+				if limit, err = strconv.Atoi(value); err != nil || limit <= 0 || limit > storage.MaxStepLinesLimit {
+					http.Error(w, fmt.Sprintf("Invalid limit, expected 1 to %d", storage.MaxStepLinesLimit), http.StatusBadRequest)
+					return
+				}
+			}
+			for _, step := range steps {
+				lines, more, err := store.ReadStepLines(step, from, limit)
+				if err != nil {
+					http.Error(w, fmt.Sprintf("Failed to read output of step %d: %v", step.ID, err), http.StatusInternalServerError)
+					return
+				}
+				step.Lines, step.MoreLines = lines, more
+				step.Output = ""
+			}
+		default:
+			http.Error(w, "Invalid output, expected 'text' or 'lines'", http.StatusBadRequest)
+			return
+		}
+
+		// Render escape sequences, e.g. colours, in the output
+		if format := r.URL.Query().Get("format"); format != "" {
+			if !ansi.ValidFormat(format) {
+				http.Error(w, "Invalid format, expected 'text', 'ansi' or 'html'", http.StatusBadRequest)
+				return
+			}
+			for _, step := range steps {
+				if step.Output != "" {
+					step.Output = ansi.ConvertString(step.Output, format)
+				}
+				for i := range step.Lines {
+					step.Lines[i].Text = strings.TrimSuffix(ansi.ConvertString(step.Lines[i].Text, format), "\n")
+				}
+			}
+		}
+
+		// Runs this one was re-run from, oldest first, and the reruns started from it
+		ancestors := make([]*storage.Run, 0)
+		for parentID := run.ParentRunID; parentID != nil && len(ancestors) < maxLineageDepth; {
+			parent, err := store.GetRun(*parentID)
+			if err != nil {
+				break
+			}
+			ancestors = append([]*storage.Run{parent}, ancestors...)
+			parentID = parent.ParentRunID
+		}
+
+		children, err := store.GetChildRuns(runID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get reruns: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		// Sections and messages from workflow commands, so the output can be folded
+		annotations, err := store.GetAnnotations(runID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get annotations: %v", err), http.StatusInternalServerError)
+			return
+		}
+
 		// Build response
+		type LineageResponse struct {
+			Ancestors []*storage.Run `json:"ancestors"`
+			Children  []*storage.Run `json:"children"`
+		}
 		type RunResponse struct {
-			Run   *storage.Run             `json:"run"`
-			Steps []*storage.StepExecution `json:"steps"`
+			Run         *storage.Run             `json:"run"`
+			Steps       []*storage.StepExecution `json:"steps"`
+			Annotations []*storage.Annotation    `json:"annotations"`
+			Lineage     LineageResponse          `json:"lineage"`
 		}
 
 		response := RunResponse{
-			Run:   run,
-			Steps: steps,
+			Run:         run,
+			Steps:       steps,
+			Annotations: annotations,
+			Lineage: LineageResponse{
+				Ancestors: ancestors,
+				Children:  children,
+			},
 		}
 
 		w.Header().Set("Content-Type", "application/json")
@@ -84,6 +172,97 @@ func GetRun(store *storage.Storage) http.HandlerFunc {
 	}
 }
 
+// maxLineageDepth bounds how many parent runs GetRun follows
+const maxLineageDepth = 50
+
+// PostRunRerun queues a new run of the part of a past run: /api/runs/:id/rerun runs
+// it again from the start, /api/runs/:id/resume starts at the first failed step
+func PostRunRerun(store *storage.Storage, queue *runner.RunQueue) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error": "Method not allowed",
+			})
+			return
+		}
+
+		// Parse run ID and action from URL: /api/runs/:id/rerun or /api/runs/:id/resume
+		pathParts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+		if len(pathParts) < 4 {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error": "Invalid path",
+			})
+			return
+		}
+
+		runID, err := strconv.Atoi(pathParts[2])
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error": "Invalid run ID",
+			})
+			return
+		}
+
+		resume := pathParts[3] == "resume"
+
+		if _, err := store.GetRun(runID); err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error": fmt.Sprintf("Run not found: %v", err),
+			})
+			return
+		}
+
+		req, err := runner.PrepareRerun(store, runID, resume)
+		if err != nil {
+			status := http.StatusInternalServerError
+			switch {
+			case errors.Is(err, runner.ErrRunNotFinished), errors.Is(err, runner.ErrNothingToResume), errors.Is(err, runner.ErrResumeIsolated):
+				status = http.StatusConflict
+			case errors.Is(err, runner.ErrPartNotFound):
+				status = http.StatusBadRequest
+			}
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		// A retried request with the same key gets the runs of the first one
+		scope := fmt.Sprintf("run:%d", runID)
+		key, proceed := reserveIdempotencyKey(w, r, store, scope, pathParts[3])
+		if !proceed {
+			return
+		}
+
+		log.Printf("🔁 %s of run #%d (%s), starting at step %d", req.Trigger, runID, req.Parts[0], req.ResumeFromStep+1)
+
+		pipeline, err := queue.Enqueue(req)
+		if err != nil {
+			releaseIdempotencyKey(store, scope, key)
+			w.WriteHeader(enqueueErrorStatus(err))
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		completeIdempotencyKey(store, scope, key, pipeline.RunIDs)
+
+		message := fmt.Sprintf("Rerun of run #%d queued", runID)
+		if resume {
+			message = fmt.Sprintf("Run #%d resumed from step %d", runID, req.ResumeFromStep+1)
+		}
+		writeQueuedRuns(w, store, http.StatusAccepted, message, pipeline.RunIDs)
+	}
+}
+
 // GetRunStatus returns just the status of a run (lightweight for polling)
 func GetRunStatus(store *storage.Storage) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -114,15 +293,19 @@ func GetRunStatus(store *storage.Storage) http.HandlerFunc {
 
 		// Return minimal status info
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]interface{}{
+		status := map[string]interface{}{
 			"id":     run.ID,
 			"status": run.Status,
-		})
+		}
+		if run.Failure != nil {
+			status["failure"] = run.Failure
+		}
+		json.NewEncoder(w).Encode(status)
 	}
 }
 
-// PostRun triggers a new pipeline run
-func PostRun(store *storage.Storage) http.HandlerFunc {
+// PostRun queues a new pipeline run and returns its run IDs without waiting for it
+func PostRun(store *storage.Storage, queue *runner.RunQueue) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 
@@ -178,29 +361,32 @@ func PostRun(store *storage.Storage) http.HandlerFunc {
 			return
 		}
 
-		// Run pipeline in background (minimal for now - no goroutine yet)
+		// A retried request with the same key gets the runs of the first one
+		scope := "config:" + configPath
+		key, proceed := reserveIdempotencyKey(w, r, store, scope, configPath)
+		if !proceed {
+			return
+		}
+
 		log.Printf("🚀 Triggering pipeline: %s", configPath)
 
-		result, err := runner.RunPipelineWithOptions(configPath, runner.RunPipelineOptions{
-			Storage:          store,
-			StreamToTerminal: false, // Don't stream when triggered via API
+		pipeline, err := queue.Enqueue(runner.RunRequest{
+			ConfigPath: configPath,
+			Trigger:    "api",
 		})
-
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
+			releaseIdempotencyKey(store, scope, key)
+			w.WriteHeader(enqueueErrorStatus(err))
 			json.NewEncoder(w).Encode(map[string]interface{}{
-				"error":  err.Error(),
-				"run_id": result.RunID,
+				"error": err.Error(),
 			})
 			return
 		}
 
-		// Return success response
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"run_id":  result.RunID,
-			"status":  result.Status,
-			"message": "Pipeline started successfully",
-		})
+		completeIdempotencyKey(store, scope, key, pipeline.RunIDs)
+
+		// Return immediately - a worker runs the pipeline
+		writeQueuedRuns(w, store, http.StatusAccepted, "Pipeline queued", pipeline.RunIDs)
 	}
 }
 
@@ -271,8 +457,8 @@ func GetProjectRuns(store *storage.Storage) http.HandlerFunc {
 	}
 }
 
-// PostProjectRun triggers a pipeline run for a specific project
-func PostProjectRun(store *storage.Storage, projectsConfig *runner.ProjectsConfig, baseDir string) http.HandlerFunc {
+// PostProjectRun queues a pipeline run for a specific project and returns its run IDs
+func PostProjectRun(store *storage.Storage, queue *runner.RunQueue, projectsConfig *runner.ProjectsConfig, baseDir string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 
@@ -321,38 +507,101 @@ func PostProjectRun(store *storage.Storage, projectsConfig *runner.ProjectsConfi
 		// Get optional part filter from query parameter
 		partFilter := r.URL.Query().Get("part")
 
-		// Run pipeline in background (async)
+		// Get optional priority from query parameter (higher runs first)
+		priority := 0
+		if value := r.URL.Query().Get("priority"); value != "" {
+			priority, err = strconv.Atoi(value)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"error": "Invalid priority",
+				})
+				return
+			}
+		}
+
+		// A retried request with the same key gets the runs of the first one
+		scope := "project:" + projectName
+		key, proceed := reserveIdempotencyKey(w, r, store, scope, fmt.Sprintf("part=%s&priority=%d", partFilter, priority))
+		if !proceed {
+			return
+		}
+
 		if partFilter != "" {
 			log.Printf("🚀 Triggering pipeline for project %s (part: %s): %s", projectName, partFilter, configPath)
 		} else {
 			log.Printf("🚀 Triggering pipeline for project %s: %s", projectName, configPath)
 		}
 
-		// Start pipeline in goroutine - runs completely async
-		go func() {
-			_, err := runner.RunPipelineWithOptions(configPath, runner.RunPipelineOptions{
-				Storage:          store,
-				StreamToTerminal: false,
-				PartFilter:       partFilter,
+		var parts []string
+		if partFilter != "" {
+			parts = []string{partFilter}
+		}
+
+		// Queue the pipeline - a worker runs it asynchronously
+		pipeline, err := queue.Enqueue(runner.RunRequest{
+			ConfigPath: configPath,
+			Parts:      parts,
+			Priority:   priority,
+			Trigger:    "manual",
+		})
+		if err != nil {
+			releaseIdempotencyKey(store, scope, key)
+			w.WriteHeader(enqueueErrorStatus(err))
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error": err.Error(),
 			})
+			return
+		}
 
-			if err != nil {
-				log.Printf("❌ Pipeline execution failed for %s: %v", projectName, err)
-			} else {
-				log.Printf("✅ Pipeline completed successfully for %s", projectName)
-			}
-		}()
+		completeIdempotencyKey(store, scope, key, pipeline.RunIDs)
 
-		// Return immediately - the run will be created in DB and polling will pick it up
-		w.WriteHeader(http.StatusAccepted)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"message": fmt.Sprintf("Pipeline started for %s", projectName),
-			"status":  "starting",
-		})
+		// Return immediately - the runs are already in the DB as "queued"
+		writeQueuedRuns(w, store, http.StatusAccepted, fmt.Sprintf("Pipeline queued for %s", projectName), pipeline.RunIDs)
 	}
 }
 
-// GetProjectStats returns latest runs grouped by part for a project
+// GetQueue returns the pipelines waiting in and being run by the run queue
+func GetQueue(queue *runner.RunQueue) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(queue.Snapshot())
+	}
+}
+
+// GetLocks returns the held project and concurrency group locks with their waiters
+func GetLocks(locks *runner.LockManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(locks.Snapshot())
+	}
+}
+
+// enqueueErrorStatus maps run queue errors to HTTP status codes
+func enqueueErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, runner.ErrQueueClosed):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, runner.ErrPartNotFound):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// GetProjectStats returns latest runs grouped by part for a project, with the
+// resource usage of their steps, or with ?group_by=cause how often each failure
+// cause occurred
 func GetProjectStats(store *storage.Storage, projectsConfig *runner.ProjectsConfig, baseDir string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
@@ -369,8 +618,44 @@ func GetProjectStats(store *storage.Storage, projectsConfig *runner.ProjectsConf
 
 		projectName := pathParts[2]
 
-		// Get latest run per part from database
-		stats, err := store.GetLatestRunsByPart(projectName, 1)
+		// ?group_by=cause counts failed steps by cause instead, optionally ?since=7d
+		switch groupBy := r.URL.Query().Get("group_by"); groupBy {
+		case "":
+		case "cause":
+			var since time.Time
+			if value := r.URL.Query().Get("since"); value != "" {
+				var err error
+				if since, err = ParseSince(value, time.Now()); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+			}
+			causes, err := store.GetFailureCauseStats(projectName, since)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Failed to get project stats: %v", err), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(causes)
+			return
+		default:
+			http.Error(w, fmt.Sprintf("Invalid group_by '%s', expected 'cause'", groupBy), http.StatusBadRequest)
+			return
+		}
+
+		// Number of recent runs per part, to compare resource usage over time
+		limit := 1
+		if value := r.URL.Query().Get("limit"); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed <= 0 {
+				http.Error(w, "Invalid limit", http.StatusBadRequest)
+				return
+			}
+			limit = parsed
+		}
+
+		// Get latest runs per part from database
+		stats, err := store.GetLatestRunsByPart(projectName, limit)
 		if err != nil {
 			http.Error(w, fmt.Sprintf("Failed to get project stats: %v", err), http.StatusInternalServerError)
 			return
@@ -419,3 +704,111 @@ func GetProjectStats(store *storage.Storage, projectsConfig *runner.ProjectsConf
 		json.NewEncoder(w).Encode(stats)
 	}
 }
+
+// idempotencyKeyHeader lets clients retry trigger requests without queueing twice
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// reserveIdempotencyKey claims the request's Idempotency-Key, if it has one, within scope.
+// When the key was used before it writes the response itself - the runs queued by
+// the first request, or an error - and returns false.
+func reserveIdempotencyKey(w http.ResponseWriter, r *http.Request, store *storage.Storage, scope, fingerprint string) (string, bool) {
+	key := strings.TrimSpace(r.Header.Get(idempotencyKeyHeader))
+	if key == "" {
+		return "", true
+	}
+
+	existing, err := store.ReserveIdempotencyKey(scope, key, fingerprint)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": err.Error(),
+		})
+		return "", false
+	}
+	if existing == nil {
+		return key, true
+	}
+
+	switch {
+	case existing.Fingerprint != fingerprint:
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": "Idempotency-Key was already used with different request parameters",
+		})
+	case len(existing.RunIDs) == 0:
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": "A request with this Idempotency-Key is still being processed",
+		})
+	default:
+		w.Header().Set("Idempotent-Replayed", "true")
+		writeQueuedRuns(w, store, http.StatusOK, "Pipeline already queued for this Idempotency-Key", existing.RunIDs)
+	}
+	return "", false
+}
+
+// completeIdempotencyKey records the queued runs for a reserved key
+func completeIdempotencyKey(store *storage.Storage, scope, key string, runIDs []int) {
+	if key == "" {
+		return
+	}
+	if err := store.CompleteIdempotencyKey(scope, key, runIDs); err != nil {
+		log.Printf("⚠️  Failed to record Idempotency-Key %s: %v", key, err)
+	}
+}
+
+// releaseIdempotencyKey frees a reserved key after the request failed
+func releaseIdempotencyKey(store *storage.Storage, scope, key string) {
+	if key == "" {
+		return
+	}
+	if err := store.ReleaseIdempotencyKey(scope, key); err != nil {
+		log.Printf("⚠️  Failed to release Idempotency-Key %s: %v", key, err)
+	}
+}
+
+// writeQueuedRuns responds with the runs of a queued pipeline and where to follow them
+func writeQueuedRuns(w http.ResponseWriter, store *storage.Storage, status int, message string, runIDs []int) {
+	executionID := 0
+	runs := make([]map[string]interface{}, 0, len(runIDs))
+	for _, runID := range runIDs {
+		entry := map[string]interface{}{
+			"run_id":     runID,
+			"url":        fmt.Sprintf("/api/runs/%d", runID),
+			"status_url": fmt.Sprintf("/api/runs/%d/status", runID),
+		}
+		if run, err := store.GetRun(runID); err == nil {
+			entry["project"] = run.ProjectName
+			entry["group"] = run.Group
+			entry["part"] = run.Part
+			entry["status"] = run.Status
+			if run.ExecutionID != nil {
+				executionID = *run.ExecutionID
+			}
+		}
+		runs = append(runs, entry)
+	}
+
+	response := map[string]interface{}{
+		"message": message,
+		"run_ids": runIDs,
+		"runs":    runs,
+	}
+	if status == http.StatusAccepted {
+		response["status"] = "queued"
+	}
+	if executionID != 0 {
+		response["execution_id"] = executionID
+		response["execution_url"] = fmt.Sprintf("/api/executions/%d", executionID)
+	}
+
+	// The first run, for clients that only follow one
+	if len(runIDs) > 0 {
+		response["run_id"] = runIDs[0]
+		response["status_url"] = fmt.Sprintf("/api/runs/%d/status", runIDs[0])
+		w.Header().Set("Location", fmt.Sprintf("/api/runs/%d", runIDs[0]))
+	}
+
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,224 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"pipego/runner"
+	"pipego/runner/storage"
+)
+
+// runProject triggers a project run with an Idempotency-Key and returns the
+// status, the replay header and the run IDs
+func (s *webhookServer) runProject(project, query, key string) (int, string, []int) {
+	s.t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/api/projects/"+project+"/run"+query, nil)
+	req.Header.Set(idempotencyKeyHeader, key)
+	rec := httptest.NewRecorder()
+	PostProjectRun(s.store, s.queue, s.projects, s.baseDir)(rec, req)
+
+	var response struct {
+		RunIDs []int `json:"run_ids"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		s.t.Fatalf("invalid response %q: %v", rec.Body.String(), err)
+	}
+	return rec.Code, rec.Header().Get("Idempotent-Replayed"), response.RunIDs
+}
+
+func TestPostProjectRunIdempotencyKey(t *testing.T) {
+	s := newWebhookServer(t)
+
+	status, replayed, first := s.runProject("app", "?part=build", "k1")
+	if status != http.StatusAccepted || replayed != "" || len(first) != 1 {
+		t.Fatalf("first request: status %d, replayed %q, runs %v", status, replayed, first)
+	}
+
+	// A retry gets the runs of the first request instead of queueing again
+	status, replayed, runs := s.runProject("app", "?part=build", "k1")
+	if status != http.StatusOK || replayed != "true" || !slices.Equal(runs, first) {
+		t.Errorf("retried request: status %d, replayed %q, runs %v, want %v", status, replayed, runs, first)
+	}
+
+	// The same key with other parameters is a client error
+	if status, _, runs := s.runProject("app", "?part=release", "k1"); status != http.StatusUnprocessableEntity || runs != nil {
+		t.Errorf("other parameters: status %d, runs %v", status, runs)
+	}
+	if status, _, runs := s.runProject("app", "?part=build&priority=5", "k1"); status != http.StatusUnprocessableEntity || runs != nil {
+		t.Errorf("other priority: status %d, runs %v", status, runs)
+	}
+
+	// A request still being handled holds the key
+	if _, err := s.store.ReserveIdempotencyKey("project:app", "k2", "part=build&priority=0"); err != nil {
+		t.Fatal(err)
+	}
+	if status, _, _ := s.runProject("app", "?part=build", "k2"); status != http.StatusConflict {
+		t.Errorf("key in progress: status %d, want %d", status, http.StatusConflict)
+	}
+
+	// A request that fails releases its key for the retry
+	if status, _, _ := s.runProject("app", "?part=missing", "k3"); status != http.StatusBadRequest {
+		t.Fatalf("unknown part: status %d", status)
+	}
+	if existing, err := s.store.ReserveIdempotencyKey("project:app", "k3", "part=missing&priority=0"); err != nil || existing != nil {
+		t.Errorf("key of a failed request is still held: %+v, %v", existing, err)
+	}
+
+	// Keys are per project
+	status, _, runs = s.runProject("nosecret", "?part=build", "k1")
+	if status != http.StatusAccepted || len(runs) != 1 || runs[0] == first[0] {
+		t.Errorf("same key for another project: status %d, runs %v", status, runs)
+	}
+}
+
+// rerunRun posts to /api/runs/:id/:action and returns the status, the error
+// and the queued run IDs
+func (s *webhookServer) rerunRun(runID int, action string) (int, string, []int) {
+	s.t.Helper()
+	req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/api/runs/%d/%s", runID, action), nil)
+	rec := httptest.NewRecorder()
+	PostRunRerun(s.store, s.queue)(rec, req)
+
+	var response struct {
+		Error  string `json:"error"`
+		RunIDs []int  `json:"run_ids"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		s.t.Fatalf("invalid response %q: %v", rec.Body.String(), err)
+	}
+	return rec.Code, response.Error, response.RunIDs
+}
+
+// runConfig runs a config in the project directory of the server and returns its run ID
+func (s *webhookServer) runConfig(config string) int {
+	s.t.Helper()
+	configPath := filepath.Join(s.baseDir, "app", "pipego.yml")
+	if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+		s.t.Fatal(err)
+	}
+	result, _ := runner.RunPipelineWithOptions(configPath, runner.RunPipelineOptions{Storage: s.store})
+	if result == nil || result.RunID == 0 {
+		s.t.Fatalf("config did not run:\n%s", config)
+	}
+	return result.RunID
+}
+
+func TestPostRunRerun(t *testing.T) {
+	s := newWebhookServer(t)
+	failing := "parts:\n  build:\n    steps:\n      - name: setup\n        run: echo setup\n      - name: test\n        run: exit 1\n"
+	failed := s.runConfig(failing)
+	succeeded := s.runConfig("parts:\n  build:\n    steps:\n      - name: build\n        run: echo build\n")
+	isolated := s.runConfig("workspace: isolated\n" + failing)
+
+	tests := []struct {
+		name       string
+		runID      int
+		action     string
+		wantStatus int
+		wantError  string
+	}{
+		{"rerun", failed, "rerun", http.StatusAccepted, ""},
+		{"resume", failed, "resume", http.StatusAccepted, ""},
+		{"rerun of a successful run", succeeded, "rerun", http.StatusAccepted, ""},
+		{"nothing to resume", succeeded, "resume", http.StatusConflict, "no failed step"},
+		{"rerun in an isolated workspace", isolated, "rerun", http.StatusAccepted, ""},
+		{"resume in an isolated workspace", isolated, "resume", http.StatusConflict, "can only be re-run"},
+		{"unknown run", 999, "rerun", http.StatusNotFound, "Run not found"},
+	}
+	for _, tt := range tests {
+		status, errorText, runIDs := s.rerunRun(tt.runID, tt.action)
+		if status != tt.wantStatus || !strings.Contains(errorText, tt.wantError) {
+			t.Errorf("%s: status %d, error %q, want %d and %q", tt.name, status, errorText, tt.wantStatus, tt.wantError)
+			continue
+		}
+		if status != http.StatusAccepted {
+			continue
+		}
+		if len(runIDs) != 1 {
+			t.Errorf("%s: queued runs %v, want one", tt.name, runIDs)
+			continue
+		}
+		run, err := s.store.GetRun(runIDs[0])
+		if err != nil || run.Status != "queued" || run.ParentRunID == nil || *run.ParentRunID != tt.runID {
+			t.Errorf("%s: queued run %+v, %v, want a queued child of run #%d", tt.name, run, err, tt.runID)
+		}
+	}
+
+	// The queue is not started, so the rerun is still waiting
+	_, _, runIDs := s.rerunRun(failed, "rerun")
+	for _, action := range []string{"rerun", "resume"} {
+		if status, errorText, _ := s.rerunRun(runIDs[0], action); status != http.StatusConflict || !strings.Contains(errorText, "not finished") {
+			t.Errorf("%s of a queued run: status %d, error %q", action, status, errorText)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/runs/%d/rerun", failed), nil)
+	rec := httptest.NewRecorder()
+	PostRunRerun(s.store, s.queue)(rec, req)
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET: status = %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestGetRunLines(t *testing.T) {
+	store, err := storage.NewStorage(filepath.Join(t.TempDir(), "pipego.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	run, err := store.CreateRun("pipego.yml", "app", "", "build")
+	if err != nil {
+		t.Fatal(err)
+	}
+	step, err := store.CreateStepExecution(run.ID, "build", "make", "", "build", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	logWriter, err := store.CreateStepLog(run.ID, step.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var written []storage.OutputLine
+	for i := 1; i <= 5; i++ {
+		written = append(written, storage.OutputLine{Stream: storage.StreamStdout, Text: fmt.Sprintf("line %d", i)})
+	}
+	if err := logWriter.Append(written); err != nil {
+		t.Fatal(err)
+	}
+	logWriter.Close()
+
+	get := func(query string) (int, *storage.StepExecution) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		GetRun(store)(rec, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/runs/%d?output=lines%s", run.ID, query), nil))
+		var response struct {
+			Steps []*storage.StepExecution `json:"steps"`
+		}
+		if rec.Code != http.StatusOK {
+			return rec.Code, nil
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil || len(response.Steps) != 1 {
+			t.Fatalf("invalid response %q: %v", rec.Body.String(), err)
+		}
+		return rec.Code, response.Steps[0]
+	}
+
+	if _, step := get(""); len(step.Lines) != 5 || step.MoreLines {
+		t.Errorf("all lines = %+v, more %v", step.Lines, step.MoreLines)
+	}
+	if _, step := get("&from=2&limit=2"); len(step.Lines) != 2 || step.Lines[0].Text != "line 2" || !step.MoreLines {
+		t.Errorf("page of lines = %+v, more %v", step.Lines, step.MoreLines)
+	}
+	for _, query := range []string{"&from=0", "&limit=0", fmt.Sprintf("&limit=%d", storage.MaxStepLinesLimit+1)} {
+		if code, _ := get(query); code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want %d", query, code, http.StatusBadRequest)
+		}
+	}
+}
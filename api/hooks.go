@@ -0,0 +1,383 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"pipego/events"
+	"pipego/runner"
+	"pipego/runner/storage"
+)
+
+// maxWebhookBody limits the size of accepted webhook payloads
+const maxWebhookBody = 10 << 20
+
+// zeroSHA is sent by forges as the new SHA of a deleted ref
+const zeroSHA = "0000000000000000000000000000000000000000"
+
+// PostWebhook receives push events from GitHub, GitLab and Gitea and
+// starts the parts selected by the project's "on: push" rules
+func PostWebhook(store *storage.Storage, queue *runner.RunQueue, projectsConfig *runner.ProjectsConfig, baseDir string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error": "Method not allowed",
+			})
+			return
+		}
+
+		// Parse project name from URL: /api/hooks/:project
+		pathParts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+		if len(pathParts) < 3 {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error": "Invalid path",
+			})
+			return
+		}
+
+		projectName := pathParts[2]
+
+		project, err := projectsConfig.GetProject(projectName)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error": fmt.Sprintf("Project not found: %v", err),
+			})
+			return
+		}
+
+		secret := project.GetWebhookSecret()
+		if secret == "" {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error": "Webhooks are not enabled for this project (no webhook_secret configured)",
+			})
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBody))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error": fmt.Sprintf("Failed to read payload: %v", err),
+			})
+			return
+		}
+
+		provider := detectWebhookProvider(r.Header)
+		if provider == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error": "Unknown webhook provider",
+			})
+			return
+		}
+
+		if err := verifyWebhookSignature(provider, r.Header, body, secret); err != nil {
+			log.Printf("⚠️  Rejected %s webhook for %s: %v", provider, projectName, err)
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error": fmt.Sprintf("Invalid signature: %v", err),
+			})
+			return
+		}
+
+		eventName := webhookEventName(provider, r.Header)
+		if eventName == "ping" {
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"message": "pong",
+			})
+			return
+		}
+
+		event, err := parsePushEvent(provider, eventName, body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error": fmt.Sprintf("Invalid payload: %v", err),
+			})
+			return
+		}
+		if event == nil {
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"message": fmt.Sprintf("Event '%s' ignored", eventName),
+			})
+			return
+		}
+		event.DeliveryID = webhookDeliveryID(provider, r.Header, body)
+
+		// Redeliveries must not start the pipeline twice. The delivery is recorded
+		// before it is handled so concurrent redeliveries are ignored, and
+		// forgotten again if it fails so the forge can redeliver it.
+		isNew, err := store.RecordWebhookDelivery(storage.WebhookDelivery{
+			DeliveryID:  event.DeliveryID,
+			ProjectName: projectName,
+			Provider:    provider,
+			Ref:         event.Ref,
+			CommitSHA:   event.SHA,
+		})
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error": err.Error(),
+			})
+			return
+		}
+		if !isNew {
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"message":     "Duplicate delivery ignored",
+				"delivery_id": event.DeliveryID,
+			})
+			return
+		}
+
+		configPath := project.GetPipegoPath(baseDir)
+		cfg, err := runner.LoadConfig(configPath)
+		if err != nil {
+			forgetWebhookDelivery(store, event.DeliveryID)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error": fmt.Sprintf("Failed to load config: %v", err),
+			})
+			return
+		}
+
+		partsToRun := runner.MatchPushRules(cfg, event)
+		if len(partsToRun) == 0 {
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"message":     fmt.Sprintf("No push rules matched %s", event.Ref),
+				"delivery_id": event.DeliveryID,
+			})
+			return
+		}
+
+		log.Printf("🪝 %s push to %s of %s (%s), running: %s", provider, event.Ref, projectName, event.DeliveryID, strings.Join(partsToRun, ", "))
+
+		commit := storage.CommitInfo{
+			CommitSHA:     event.SHA,
+			Branch:        event.Branch(),
+			Tag:           event.Tag(),
+			CommitAuthor:  event.Author,
+			CommitMessage: event.Message,
+		}
+
+		events.GetBroker().Broadcast("run_started", map[string]interface{}{
+			"project":     projectName,
+			"parts":       partsToRun,
+			"type":        "webhook",
+			"ref":         event.Ref,
+			"commit":      event.SHA,
+			"delivery_id": event.DeliveryID,
+		})
+
+		// Queue the pipeline - the forge only waits a few seconds for a response.
+		// The run builds the pushed commit in a worktree, fetching it first.
+		_, err = queue.Enqueue(runner.RunRequest{
+			ConfigPath: configPath,
+			Parts:      partsToRun,
+			Trigger:    "webhook",
+			Commit:     &commit,
+			Checkout:   true,
+		})
+		if err != nil {
+			forgetWebhookDelivery(store, event.DeliveryID)
+			w.WriteHeader(enqueueErrorStatus(err))
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"message":     fmt.Sprintf("Pipeline queued for %s", projectName),
+			"delivery_id": event.DeliveryID,
+			"parts":       partsToRun,
+		})
+	}
+}
+
+// forgetWebhookDelivery releases a delivery that could not be handled
+func forgetWebhookDelivery(store *storage.Storage, deliveryID string) {
+	if err := store.ForgetWebhookDelivery(deliveryID); err != nil {
+		log.Printf("⚠️  %v", err)
+	}
+}
+
+// detectWebhookProvider guesses the forge from its event header.
+// Gitea also sends GitHub headers, so it is checked first.
+func detectWebhookProvider(header http.Header) string {
+	switch {
+	case header.Get("X-Gitea-Event") != "":
+		return "gitea"
+	case header.Get("X-Gitlab-Event") != "":
+		return "gitlab"
+	case header.Get("X-GitHub-Event") != "":
+		return "github"
+	}
+	return ""
+}
+
+// webhookEventName returns the provider's event name for the request
+func webhookEventName(provider string, header http.Header) string {
+	switch provider {
+	case "gitea":
+		return header.Get("X-Gitea-Event")
+	case "gitlab":
+		return header.Get("X-Gitlab-Event")
+	default:
+		return header.Get("X-GitHub-Event")
+	}
+}
+
+// webhookDeliveryID returns the provider's delivery ID, falling back to a
+// hash of the payload for providers that do not send one
+func webhookDeliveryID(provider string, header http.Header, body []byte) string {
+	var id string
+	switch provider {
+	case "gitea":
+		id = header.Get("X-Gitea-Delivery")
+	case "gitlab":
+		id = header.Get("X-Gitlab-Event-UUID")
+	default:
+		id = header.Get("X-GitHub-Delivery")
+	}
+	if id != "" {
+		return provider + ":" + id
+	}
+
+	sum := sha256.Sum256(body)
+	return provider + ":sha256:" + hex.EncodeToString(sum[:])
+}
+
+// verifyWebhookSignature checks the request against the project's secret.
+// GitHub and Gitea sign the payload with HMAC-SHA256, GitLab sends the secret as a token.
+func verifyWebhookSignature(provider string, header http.Header, body []byte, secret string) error {
+	if provider == "gitlab" {
+		token := header.Get("X-Gitlab-Token")
+		if token == "" {
+			return fmt.Errorf("missing X-Gitlab-Token header")
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(secret)) != 1 {
+			return fmt.Errorf("token mismatch")
+		}
+		return nil
+	}
+
+	signature := ""
+	if provider == "gitea" {
+		signature = header.Get("X-Gitea-Signature")
+	}
+	if signature == "" {
+		signature = strings.TrimPrefix(header.Get("X-Hub-Signature-256"), "sha256=")
+	}
+	if signature == "" {
+		return fmt.Errorf("missing signature header")
+	}
+
+	expected, err := hex.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("malformed signature")
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	if !hmac.Equal(mac.Sum(nil), expected) {
+		return fmt.Errorf("signature mismatch")
+	}
+	return nil
+}
+
+// pushPayload covers the push payload fields shared by GitHub, GitLab and Gitea
+type pushPayload struct {
+	Ref         string `json:"ref"`
+	After       string `json:"after"`
+	CheckoutSHA string `json:"checkout_sha"` // GitLab
+	Deleted     bool   `json:"deleted"`      // GitHub
+	HeadCommit  *struct {
+		ID      string `json:"id"`
+		Message string `json:"message"`
+		Author  struct {
+			Name string `json:"name"`
+		} `json:"author"`
+	} `json:"head_commit"` // GitHub, Gitea
+	Commits []struct {
+		ID      string `json:"id"`
+		Message string `json:"message"`
+		Author  struct {
+			Name string `json:"name"`
+		} `json:"author"`
+	} `json:"commits"`
+	UserName string `json:"user_name"` // GitLab: the pusher
+}
+
+// parsePushEvent parses branch and tag push payloads.
+// Returns nil without error for events that are not pushes.
+func parsePushEvent(provider, eventName string, body []byte) (*runner.PushEvent, error) {
+	switch provider {
+	case "gitlab":
+		if eventName != "Push Hook" && eventName != "Tag Push Hook" {
+			return nil, nil
+		}
+	default:
+		if eventName != "push" {
+			return nil, nil
+		}
+	}
+
+	var payload pushPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+	if payload.Ref == "" {
+		return nil, fmt.Errorf("missing ref")
+	}
+
+	event := &runner.PushEvent{
+		Provider: provider,
+		Ref:      payload.Ref,
+		SHA:      payload.After,
+		Deleted:  payload.Deleted || payload.After == zeroSHA,
+	}
+	if payload.CheckoutSHA != "" {
+		event.SHA = payload.CheckoutSHA
+	}
+
+	// Prefer the head commit, otherwise find the pushed commit in the list
+	if payload.HeadCommit != nil {
+		event.Author = payload.HeadCommit.Author.Name
+		event.Message = firstLine(payload.HeadCommit.Message)
+	} else {
+		for _, commit := range payload.Commits {
+			if commit.ID == event.SHA {
+				event.Author = commit.Author.Name
+				event.Message = firstLine(commit.Message)
+			}
+		}
+	}
+	if event.Author == "" {
+		event.Author = payload.UserName
+	}
+
+	return event, nil
+}
+
+// firstLine returns the subject line of a commit message
+func firstLine(message string) string {
+	if idx := strings.IndexByte(message, '\n'); idx >= 0 {
+		return message[:idx]
+	}
+	return message
+}
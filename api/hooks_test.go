@@ -0,0 +1,418 @@
+package api
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"pipego/runner"
+	"pipego/runner/storage"
+)
+
+const testWebhookSecret = "s3cret"
+
+const testPushConfig = `on:
+  push:
+    - branches: [main, "release/*"]
+      parts: [build]
+    - tags: ["v*"]
+      parts: [release]
+parts:
+  build:
+    steps:
+      - name: build
+        run: echo build
+  release:
+    steps:
+      - name: release
+        run: echo release
+`
+
+// webhookServer is a webhook handler with its own storage and an unstarted queue
+type webhookServer struct {
+	t        *testing.T
+	store    *storage.Storage
+	queue    *runner.RunQueue
+	projects *runner.ProjectsConfig
+	baseDir  string
+}
+
+func newWebhookServer(t *testing.T) *webhookServer {
+	t.Helper()
+	dir := t.TempDir()
+
+	projectDir := filepath.Join(dir, "app")
+	if err := os.MkdirAll(projectDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(projectDir, "pipego.yml"), []byte(testPushConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := storage.NewStorage(filepath.Join(dir, "pipego.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	queue := runner.NewRunQueue(store, nil, runner.ServerConfig{})
+	t.Cleanup(func() {
+		queue.Stop()
+		store.Close()
+	})
+
+	return &webhookServer{
+		t:     t,
+		store: store,
+		queue: queue,
+		projects: &runner.ProjectsConfig{Projects: []runner.Project{
+			{Name: "app", Path: "app", WebhookSecret: testWebhookSecret},
+			{Name: "nosecret", Path: "app"},
+		}},
+		baseDir: dir,
+	}
+}
+
+// post sends a webhook and returns the status and the decoded response
+func (s *webhookServer) post(path string, header http.Header, body []byte) (int, map[string]interface{}) {
+	s.t.Helper()
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	rec := httptest.NewRecorder()
+	PostWebhook(s.store, s.queue, s.projects, s.baseDir)(rec, req)
+
+	var response map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		s.t.Fatalf("invalid response %q: %v", rec.Body.String(), err)
+	}
+	return rec.Code, response
+}
+
+func loadFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func signPayload(body []byte, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func githubHeaders(event, delivery string, body []byte, secret string) http.Header {
+	header := http.Header{}
+	header.Set("X-GitHub-Event", event)
+	header.Set("X-GitHub-Delivery", delivery)
+	header.Set("X-Hub-Signature-256", "sha256="+signPayload(body, secret))
+	return header
+}
+
+func gitlabHeaders(event, delivery, token string) http.Header {
+	header := http.Header{}
+	header.Set("X-Gitlab-Event", event)
+	header.Set("X-Gitlab-Event-UUID", delivery)
+	header.Set("X-Gitlab-Token", token)
+	return header
+}
+
+func giteaHeaders(event, delivery string, body []byte, secret string) http.Header {
+	header := http.Header{}
+	header.Set("X-Gitea-Event", event)
+	header.Set("X-Gitea-Delivery", delivery)
+	header.Set("X-Gitea-Signature", signPayload(body, secret))
+	// Gitea also sends the GitHub headers
+	header.Set("X-GitHub-Event", event)
+	header.Set("X-GitHub-Delivery", delivery)
+	return header
+}
+
+// withRef returns a fixture with its ref replaced
+func withRef(body []byte, ref string) []byte {
+	return bytes.Replace(body, []byte(`"refs/heads/main"`), []byte(`"`+ref+`"`), 1)
+}
+
+func responseParts(response map[string]interface{}) []string {
+	var parts []string
+	values, _ := response["parts"].([]interface{})
+	for _, value := range values {
+		parts = append(parts, value.(string))
+	}
+	return parts
+}
+
+func TestPostWebhookProviders(t *testing.T) {
+	github := loadFixture(t, "github_push.json")
+	gitlab := loadFixture(t, "gitlab_push.json")
+	gitea := loadFixture(t, "gitea_push.json")
+	githubTag := withRef(github, "refs/tags/v1.2.0")
+	githubRelease := withRef(github, "refs/heads/release/1.2")
+	githubFeature := withRef(github, "refs/heads/feature/login")
+	githubDeleted := bytes.Replace(github, []byte(`"deleted": false`), []byte(`"deleted": true`), 1)
+	gitlabFeature := withRef(gitlab, "refs/heads/feature/login")
+
+	tests := []struct {
+		name       string
+		header     http.Header
+		body       []byte
+		wantStatus int
+		wantParts  []string
+		wantText   string
+	}{
+		{"github push", githubHeaders("push", "gh-1", github, testWebhookSecret), github, http.StatusAccepted, []string{"build"}, ""},
+		{"github tag", githubHeaders("push", "gh-2", githubTag, testWebhookSecret), githubTag, http.StatusAccepted, []string{"release"}, ""},
+		{"github branch glob", githubHeaders("push", "gh-3", githubRelease, testWebhookSecret), githubRelease, http.StatusAccepted, []string{"build"}, ""},
+		{"github filtered branch", githubHeaders("push", "gh-4", githubFeature, testWebhookSecret), githubFeature, http.StatusOK, nil, "No push rules matched"},
+		{"github deleted branch", githubHeaders("push", "gh-5", githubDeleted, testWebhookSecret), githubDeleted, http.StatusOK, nil, "No push rules matched"},
+		{"github wrong secret", githubHeaders("push", "gh-6", github, "wrong"), github, http.StatusUnauthorized, nil, "signature mismatch"},
+		{"github tampered payload", githubHeaders("push", "gh-7", github, testWebhookSecret), githubFeature, http.StatusUnauthorized, nil, "signature mismatch"},
+		{"github ping", githubHeaders("ping", "gh-8", []byte(`{}`), testWebhookSecret), []byte(`{}`), http.StatusOK, nil, "pong"},
+		{"github other event", githubHeaders("issues", "gh-9", []byte(`{}`), testWebhookSecret), []byte(`{}`), http.StatusOK, nil, "ignored"},
+		{"gitlab push", gitlabHeaders("Push Hook", "gl-1", testWebhookSecret), gitlab, http.StatusAccepted, []string{"build"}, ""},
+		{"gitlab filtered branch", gitlabHeaders("Push Hook", "gl-2", testWebhookSecret), gitlabFeature, http.StatusOK, nil, "No push rules matched"},
+		{"gitlab wrong token", gitlabHeaders("Push Hook", "gl-3", "wrong"), gitlab, http.StatusUnauthorized, nil, "token mismatch"},
+		{"gitlab missing token", gitlabHeaders("Push Hook", "gl-4", ""), gitlab, http.StatusUnauthorized, nil, "missing X-Gitlab-Token"},
+		{"gitea push", giteaHeaders("push", "gt-1", gitea, testWebhookSecret), gitea, http.StatusAccepted, []string{"build"}, ""},
+		{"gitea wrong secret", giteaHeaders("push", "gt-2", gitea, "wrong"), gitea, http.StatusUnauthorized, nil, "signature mismatch"},
+	}
+
+	s := newWebhookServer(t)
+	for _, tt := range tests {
+		status, response := s.post("/api/hooks/app", tt.header, tt.body)
+		if status != tt.wantStatus {
+			t.Errorf("%s: status = %d, want %d (%v)", tt.name, status, tt.wantStatus, response)
+			continue
+		}
+		if parts := responseParts(response); !slices.Equal(parts, tt.wantParts) {
+			t.Errorf("%s: parts = %v, want %v", tt.name, parts, tt.wantParts)
+		}
+		if tt.wantText != "" {
+			text, _ := response["message"].(string)
+			if text == "" {
+				text, _ = response["error"].(string)
+			}
+			if !strings.Contains(text, tt.wantText) {
+				t.Errorf("%s: response %q does not contain %q", tt.name, text, tt.wantText)
+			}
+		}
+	}
+}
+
+func TestPostWebhookCommit(t *testing.T) {
+	s := newWebhookServer(t)
+	gitlab := loadFixture(t, "gitlab_push.json")
+
+	status, response := s.post("/api/hooks/app", gitlabHeaders("Push Hook", "gl-commit", testWebhookSecret), gitlab)
+	if status != http.StatusAccepted {
+		t.Fatalf("status = %d (%v)", status, response)
+	}
+
+	runs, err := s.store.GetRuns(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 1 {
+		t.Fatalf("got %d runs, want 1", len(runs))
+	}
+	run := runs[0]
+	// GitLab lists older commits first, the pushed one is found by checkout_sha
+	if run.CommitSHA != "da1560886d4f094c3e6c9ef40349f7d38b5d27d7" || run.Branch != "main" ||
+		run.CommitAuthor != "GitLab dev user" || run.CommitMessage != "Fix the build" {
+		t.Errorf("commit = %s %s %q %q", run.CommitSHA, run.Branch, run.CommitAuthor, run.CommitMessage)
+	}
+
+	// A tag push records the tag, not a branch
+	githubTag := withRef(loadFixture(t, "github_push.json"), "refs/tags/v1.2.0")
+	if status, response := s.post("/api/hooks/app", githubHeaders("push", "gh-tag", githubTag, testWebhookSecret), githubTag); status != http.StatusAccepted {
+		t.Fatalf("tag push: status = %d (%v)", status, response)
+	}
+	runs, err = s.store.GetRuns(1)
+	if err != nil || len(runs) != 1 {
+		t.Fatalf("runs = %v, %v", runs, err)
+	}
+	if runs[0].Tag != "v1.2.0" || runs[0].Branch != "" {
+		t.Errorf("tag push recorded tag %q and branch %q, want tag v1.2.0 and no branch", runs[0].Tag, runs[0].Branch)
+	}
+}
+
+func TestPostWebhookDuplicateDelivery(t *testing.T) {
+	s := newWebhookServer(t)
+	github := loadFixture(t, "github_push.json")
+	header := githubHeaders("push", "gh-dup", github, testWebhookSecret)
+
+	if status, response := s.post("/api/hooks/app", header, github); status != http.StatusAccepted {
+		t.Fatalf("first delivery: status = %d (%v)", status, response)
+	}
+	status, response := s.post("/api/hooks/app", header, github)
+	if status != http.StatusOK || response["message"] != "Duplicate delivery ignored" {
+		t.Fatalf("redelivery: status = %d (%v)", status, response)
+	}
+
+	// GitLab deliveries without a UUID are identified by their payload
+	gitlab := loadFixture(t, "gitlab_push.json")
+	gitlabHeader := gitlabHeaders("Push Hook", "", testWebhookSecret)
+	if status, response := s.post("/api/hooks/app", gitlabHeader, gitlab); status != http.StatusAccepted {
+		t.Fatalf("gitlab delivery: status = %d (%v)", status, response)
+	}
+	if _, response := s.post("/api/hooks/app", gitlabHeader, gitlab); response["message"] != "Duplicate delivery ignored" {
+		t.Fatalf("gitlab redelivery was not ignored: %v", response)
+	}
+}
+
+func TestPostWebhookRedeliveryAfterFailure(t *testing.T) {
+	s := newWebhookServer(t)
+	github := loadFixture(t, "github_push.json")
+	header := githubHeaders("push", "gh-retry", github, testWebhookSecret)
+	configPath := filepath.Join(s.baseDir, "app", "pipego.yml")
+
+	// A broken config fails the delivery without recording it
+	if err := os.WriteFile(configPath, []byte("parts: ["), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if status, response := s.post("/api/hooks/app", header, github); status != http.StatusInternalServerError {
+		t.Fatalf("broken config: status = %d (%v)", status, response)
+	}
+	if err := os.WriteFile(configPath, []byte(testPushConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// So does a queue that is shutting down
+	open := s.queue
+	s.queue = runner.NewRunQueue(s.store, nil, runner.ServerConfig{})
+	s.queue.Stop()
+	if status, response := s.post("/api/hooks/app", header, github); status != http.StatusServiceUnavailable {
+		t.Fatalf("closed queue: status = %d (%v)", status, response)
+	}
+	s.queue = open
+
+	status, response := s.post("/api/hooks/app", header, github)
+	if status != http.StatusAccepted {
+		t.Fatalf("redelivery after failures: status = %d (%v)", status, response)
+	}
+}
+
+func TestPostWebhookRejected(t *testing.T) {
+	s := newWebhookServer(t)
+	github := loadFixture(t, "github_push.json")
+	signed := githubHeaders("push", "gh-rejected", github, testWebhookSecret)
+
+	unsigned := http.Header{}
+	unsigned.Set("X-GitHub-Event", "push")
+
+	tests := []struct {
+		name       string
+		path       string
+		header     http.Header
+		wantStatus int
+	}{
+		{"missing project", "/api/hooks", signed, http.StatusBadRequest},
+		{"unknown project", "/api/hooks/other", signed, http.StatusNotFound},
+		{"no secret configured", "/api/hooks/nosecret", signed, http.StatusForbidden},
+		{"unknown provider", "/api/hooks/app", http.Header{}, http.StatusBadRequest},
+		{"missing signature", "/api/hooks/app", unsigned, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		if status, response := s.post(tt.path, tt.header, github); status != tt.wantStatus {
+			t.Errorf("%s: status = %d, want %d (%v)", tt.name, status, tt.wantStatus, response)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/hooks/app", nil)
+	rec := httptest.NewRecorder()
+	PostWebhook(s.store, s.queue, s.projects, s.baseDir)(rec, req)
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET: status = %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+}
+
+// git runs a git command for a test, failing it on error
+func git(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=Test", "GIT_AUTHOR_EMAIL=test@example.com",
+		"GIT_COMMITTER_NAME=Test", "GIT_COMMITTER_EMAIL=test@example.com",
+	)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v: %v\n%s", args, err, output)
+	}
+	return strings.TrimSpace(string(output))
+}
+
+func TestPostWebhookBuildsThePushedCommit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	s := newWebhookServer(t)
+	upstream := filepath.Join(s.baseDir, "upstream")
+	clone := filepath.Join(s.baseDir, "repo")
+
+	if err := os.Mkdir(upstream, 0755); err != nil {
+		t.Fatal(err)
+	}
+	config := "on:\n  push:\n    - branches: [main]\n      parts: [build]\nparts:\n  build:\n    steps:\n      - name: version\n        run: cat version.txt\n"
+	for name, content := range map[string]string{"pipego.yml": config, "version.txt": "1\n"} {
+		if err := os.WriteFile(filepath.Join(upstream, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	git(t, upstream, "init", "-q", "-b", "main")
+	git(t, upstream, "add", ".")
+	git(t, upstream, "commit", "-q", "-m", "first")
+	git(t, s.baseDir, "clone", "-q", upstream, clone)
+	s.projects.Projects = append(s.projects.Projects, runner.Project{Name: "repo", Path: "repo", WebhookSecret: testWebhookSecret})
+
+	// The webhook announces a push the clone hasn't fetched
+	if err := os.WriteFile(filepath.Join(upstream, "version.txt"), []byte("2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	git(t, upstream, "commit", "-q", "-am", "second")
+	sha := git(t, upstream, "rev-parse", "HEAD")
+	github := bytes.ReplaceAll(loadFixture(t, "github_push.json"), []byte("0d1a26e67d8f5eaf1f6ba5c57fc3c7d91ac0fd1c"), []byte(sha))
+
+	s.queue.Start()
+	if status, response := s.post("/api/hooks/repo", githubHeaders("push", "gh-checkout", github, testWebhookSecret), github); status != http.StatusAccepted {
+		t.Fatalf("status = %d (%v)", status, response)
+	}
+
+	var run *storage.Run
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(20 * time.Millisecond) {
+		runs, err := s.store.GetRuns(10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(runs) == 1 && runs[0].Status != "queued" && runs[0].Status != "running" {
+			run = runs[0]
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("run did not finish: %+v", runs)
+		}
+	}
+	steps, err := s.store.GetStepExecutions(run.ID)
+	s.store.LoadStepOutputs(steps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run.Status != "success" || run.CommitSHA != sha || len(steps) != 1 || strings.TrimSpace(steps[0].Output) != "2" {
+		t.Errorf("run %s of %s, steps %+v, want the output of the pushed commit", run.Status, run.CommitSHA, steps)
+	}
+	if version, _ := os.ReadFile(filepath.Join(clone, "version.txt")); string(version) != "1\n" {
+		t.Errorf("project directory changed to %q", version)
+	}
+}
@@ -0,0 +1,271 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"pipego/ansi"
+	"pipego/events"
+	"pipego/runner"
+	"pipego/runner/storage"
+)
+
+// logKeepAlive is how often an idle log stream sends a comment to keep the connection open
+const logKeepAlive = 15 * time.Second
+
+// StreamRunLogs streams the output of a run over SSE: /api/runs/:id/logs/stream
+// Stored output is sent first, then each line as the steps write it. Every line
+// has an event ID, so a client reconnecting with Last-Event-ID (or
+// ?last_event_id=) only receives what it missed. The stream ends with an "end"
+// event once the run is over.
+func StreamRunLogs(store *storage.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		// Parse run ID from URL: /api/runs/:id/logs/stream
+		pathParts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+		if len(pathParts) < 5 {
+			http.Error(w, "Invalid path", http.StatusBadRequest)
+			return
+		}
+
+		runID, err := strconv.Atoi(pathParts[2])
+		if err != nil {
+			http.Error(w, "Invalid run ID", http.StatusBadRequest)
+			return
+		}
+
+		if _, err := store.GetRun(runID); err != nil {
+			http.Error(w, fmt.Sprintf("Run not found: %v", err), http.StatusNotFound)
+			return
+		}
+
+		lastEventID := r.Header.Get("Last-Event-ID")
+		if lastEventID == "" {
+			lastEventID = r.URL.Query().Get("last_event_id")
+		}
+		lastStepID, lastLine, _ := runner.ParseLogEventID(lastEventID)
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+
+		// Subscribe before reading what was written so far, so no line falls in between
+		topic := runner.RunLogTopic(runID)
+		client := make(chan string, 256)
+		broker := events.GetBroker()
+		broker.Subscribe(topic, client)
+		defer broker.Unsubscribe(topic, client)
+
+		liveStepID, liveLines, _, live := runner.LiveStepOutput(runID)
+		steps, err := store.GetStepExecutions(runID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get steps: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		// Lines sent per step; live lines up to these are duplicates
+		sent := make(map[int]int)
+		for _, step := range steps {
+			// The client has every line of the steps before its last event
+			if step.ID < lastStepID {
+				continue
+			}
+			first := 1
+			if step.ID == lastStepID {
+				first = lastLine + 1
+			}
+			send := func(line storage.OutputLine) {
+				sent[step.ID] = line.Line
+				if line.Line >= first {
+					writeLogLine(w, runner.LogLine{RunID: runID, StepID: step.ID, Step: step.Name, Line: line.Line, OffsetMs: line.OffsetMs, Stream: line.Stream, Text: line.Text})
+				}
+			}
+
+			// Read line by line, so a large log is not held in memory
+			if err := streamStepLines(store, step, send); err != nil {
+				send(storage.OutputLine{Line: sent[step.ID] + 1, Stream: storage.StreamSystem, Text: fmt.Sprintf("[failed to read step log: %v]", err)})
+			}
+			if live && step.ID == liveStepID {
+				// Lines flushed since LiveStepOutput are in both
+				for _, line := range liveLines {
+					if line.Line > sent[step.ID] {
+						send(line)
+					}
+				}
+			}
+		}
+		flusher.Flush()
+
+		// Nothing more will be written once the run is over
+		if run, err := store.GetRun(runID); err == nil && !live && run.Status != "queued" && run.Status != "running" {
+			data, _ := json.Marshal(map[string]interface{}{"run_id": runID, "status": run.Status})
+			fmt.Fprintf(w, "event: end\ndata: %s\n\n", data)
+			flusher.Flush()
+			return
+		}
+
+		keepAlive := time.NewTicker(logKeepAlive)
+		defer keepAlive.Stop()
+
+		for {
+			select {
+			case message, ok := <-client:
+				if !ok {
+					// Dropped for falling behind, the client reconnects from its last event ID
+					return
+				}
+				if strings.Contains(message, "\nevent: log\n") {
+					id, _, _ := strings.Cut(strings.TrimPrefix(message, "id: "), "\n")
+					if stepID, line, ok := runner.ParseLogEventID(id); ok {
+						if line <= sent[stepID] {
+							continue
+						}
+						sent[stepID] = line
+					}
+				}
+				fmt.Fprint(w, message)
+				flusher.Flush()
+				if strings.HasPrefix(message, "event: end\n") {
+					return
+				}
+			case <-keepAlive.C:
+				fmt.Fprint(w, ": keep-alive\n\n")
+				flusher.Flush()
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}
+}
+
+// GetStepLog serves the stored output of a step as plain text: /api/runs/:id/steps/:step_id/log
+// Range requests are supported, so large logs can be read in pieces or from the end.
+// ?format=text|ansi|html renders the escape sequences in the output, see ansi.Convert.
+// The rendered log of a finished step is kept next to the log, so it is only
+// rendered once.
+func GetStepLog(store *storage.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		// Parse IDs from URL: /api/runs/:id/steps/:step_id/log
+		pathParts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+		if len(pathParts) != 6 || pathParts[3] != "steps" {
+			http.Error(w, "Invalid path", http.StatusBadRequest)
+			return
+		}
+
+		runID, err := strconv.Atoi(pathParts[2])
+		if err != nil {
+			http.Error(w, "Invalid run ID", http.StatusBadRequest)
+			return
+		}
+		stepID, err := strconv.Atoi(pathParts[4])
+		if err != nil {
+			http.Error(w, "Invalid step ID", http.StatusBadRequest)
+			return
+		}
+
+		step, err := store.GetStepExecution(runID, stepID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Step not found: %v", err), http.StatusNotFound)
+			return
+		}
+
+		format := r.URL.Query().Get("format")
+		if format != "" && !ansi.ValidFormat(format) {
+			http.Error(w, "Invalid format, expected 'text', 'ansi' or 'html'", http.StatusBadRequest)
+			return
+		}
+
+		var modTime time.Time
+		if step.FinishedAt != nil {
+			modTime = *step.FinishedAt
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+		// Steps stored before logs were files keep their output in the database
+		var content io.ReadSeeker = strings.NewReader(step.Output)
+		if step.LogPath != "" {
+			logReader, err := store.OpenStepLog(step.LogPath)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Failed to open step log: %v", err), http.StatusInternalServerError)
+				return
+			}
+			defer logReader.Close()
+			content = logReader
+		}
+
+		// Without a format the log is served as stored, escape sequences and all
+		if format != "" {
+			if format == ansi.FormatHTML {
+				w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			}
+			if step.LogPath == "" || step.FinishedAt == nil {
+				// The log of a running step still grows, so it is converted while
+				// it is sent, whole; ranges are only served once it is finished
+				w.Header().Set("Accept-Ranges", "none")
+				if r.Method == http.MethodHead {
+					return
+				}
+				if err := ansi.Convert(w, content, format); err != nil {
+					log.Printf("⚠️  Failed to send log of step %d: %v", step.ID, err)
+				}
+				return
+			}
+
+			converted, err := store.OpenConvertedStepLog(step.LogPath, format)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Failed to read step log: %v", err), http.StatusInternalServerError)
+				return
+			}
+			defer converted.Close()
+			content = converted
+		}
+
+		http.ServeContent(w, r, "", modTime, content)
+	}
+}
+
+// streamStepLines calls send with each stored line of a step
+func streamStepLines(store *storage.Storage, step *storage.StepExecution, send func(storage.OutputLine)) error {
+	r, err := store.OpenStepLines(step)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	for {
+		line, err := r.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		send(line)
+	}
+}
+
+// writeLogLine writes a stored line in the same format as live ones
+func writeLogLine(w http.ResponseWriter, line runner.LogLine) {
+	data, _ := json.Marshal(line)
+	fmt.Fprintf(w, "id: %s\nevent: log\ndata: %s\n\n", runner.LogEventID(line.StepID, line.Line), data)
+}
@@ -0,0 +1,264 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"pipego/runner"
+	"pipego/runner/storage"
+)
+
+// logEvent is an event read from the log stream
+type logEvent struct {
+	id    string
+	event string
+	line  runner.LogLine
+}
+
+// logStream is an open connection to the log stream of a run
+type logStream struct {
+	t       *testing.T
+	cancel  context.CancelFunc
+	scanner *bufio.Scanner
+}
+
+func openLogStream(t *testing.T, server *httptest.Server, runID int, lastEventID string) *logStream {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/api/runs/%d/logs/stream", server.URL, runID), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("log stream status %d", resp.StatusCode)
+	}
+	return &logStream{t: t, cancel: cancel, scanner: bufio.NewScanner(resp.Body)}
+}
+
+// next reads the next event, skipping comments
+func (s *logStream) next() logEvent {
+	s.t.Helper()
+	var e logEvent
+	for s.scanner.Scan() {
+		line := s.scanner.Text()
+		switch {
+		case line == "":
+			if e.event != "" {
+				return e
+			}
+		case strings.HasPrefix(line, "id: "):
+			e.id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			e.event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: ") && e.event == "log":
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &e.line); err != nil {
+				s.t.Fatalf("invalid log event %q: %v", line, err)
+			}
+		}
+	}
+	s.t.Fatalf("log stream ended: %v", s.scanner.Err())
+	return e
+}
+
+// readLines reads log events until the line with the given text, and returns their texts
+func (s *logStream) readLines(until string) ([]string, string) {
+	s.t.Helper()
+	var texts []string
+	for {
+		e := s.next()
+		if e.event != "log" {
+			continue
+		}
+		texts = append(texts, e.line.Text)
+		if e.line.Text == until {
+			return texts, e.id
+		}
+	}
+}
+
+// readToEnd reads log events until the "end" event
+func (s *logStream) readToEnd() []string {
+	s.t.Helper()
+	var texts []string
+	for {
+		e := s.next()
+		switch e.event {
+		case "log":
+			texts = append(texts, e.line.Text)
+		case "end":
+			return texts
+		}
+	}
+}
+
+func TestStreamRunLogsResumesMidStep(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "pipego.yml")
+	// The step writes in bursts, each once the test creates a file
+	config := `parts:
+  build:
+    steps:
+      - name: setup
+        run: echo setup
+      - name: build
+        run: "for i in 1 2 3 4 5; do echo line $i; done; while [ ! -f go1 ]; do sleep 0.02; done; for i in 6 7 8; do echo line $i; done; while [ ! -f go2 ]; do sleep 0.02; done; echo line 9"
+`
+	if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	store, err := storage.NewStorage(filepath.Join(dir, "pipego.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	touch := func(name string) {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	done := make(chan *runner.PipelineResult, 1)
+	go func() {
+		result, _ := runner.RunPipelineWithOptions(configPath, runner.RunPipelineOptions{Storage: store})
+		done <- result
+	}()
+	wait := sync.OnceValue(func() *runner.PipelineResult { return <-done })
+	defer func() {
+		touch("go1")
+		touch("go2")
+		wait()
+	}()
+
+	var runID int
+	for deadline := time.Now().Add(10 * time.Second); runID == 0; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("run did not start")
+		}
+		if runs, err := store.GetRuns(1); err == nil && len(runs) == 1 {
+			runID = runs[0].ID
+		}
+	}
+
+	server := httptest.NewServer(StreamRunLogs(store))
+	defer server.Close()
+
+	// Disconnect in the middle of the first burst
+	first := openLogStream(t, server, runID, "")
+	seen, lastID := first.readLines("line 3")
+	first.cancel()
+
+	// Let the first burst reach the log file, then reconnect while the second
+	// one is partly written: the stream joins the file and the live lines
+	time.Sleep(1200 * time.Millisecond)
+	touch("go1")
+	time.Sleep(50 * time.Millisecond)
+	second := openLogStream(t, server, runID, lastID)
+	lines, lastID := second.readLines("line 8")
+	seen = append(seen, lines...)
+
+	// Live lines after the reconnect follow without a gap
+	touch("go2")
+	seen = append(seen, second.readToEnd()...)
+	second.cancel()
+
+	want := []string{"setup", "line 1", "line 2", "line 3", "line 4", "line 5", "line 6", "line 7", "line 8", "line 9"}
+	if strings.Join(seen, "|") != strings.Join(want, "|") {
+		t.Errorf("lines across the reconnect = %q, want %q", seen, want)
+	}
+
+	// Once the run is over, a reconnect gets the rest from the log file
+	if result := wait(); result == nil || result.Status != "success" {
+		t.Fatalf("run result = %+v", result)
+	}
+	third := openLogStream(t, server, runID, lastID)
+	if rest := third.readToEnd(); strings.Join(rest, "|") != "line 9" {
+		t.Errorf("lines after %s = %q, want line 9", lastID, rest)
+	}
+}
+
+func TestGetStepLogFormat(t *testing.T) {
+	dir := t.TempDir()
+	store, err := storage.NewStorage(filepath.Join(dir, "pipego.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	run, err := store.CreateRun("pipego.yml", "app", "", "build")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeStep := func(name string, finished bool) *storage.StepExecution {
+		t.Helper()
+		step, err := store.CreateStepExecution(run.ID, name, "make", "", "build", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		w, err := store.CreateStepLog(run.ID, step.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer w.Close()
+		if err := w.Append([]storage.OutputLine{{Text: "\x1b[31mred\x1b[0m"}, {Text: "progress 10%\rprogress 100%"}}); err != nil {
+			t.Fatal(err)
+		}
+		if finished {
+			if err := store.UpdateStepExecution(step.ID, "success", "", time.Second); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return step
+	}
+	finished := writeStep("build", true)
+	running := writeStep("test", false)
+
+	get := func(step *storage.StepExecution, rangeHeader string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/runs/%d/steps/%d/log?format=text", run.ID, step.ID), nil)
+		if rangeHeader != "" {
+			req.Header.Set("Range", rangeHeader)
+		}
+		rec := httptest.NewRecorder()
+		GetStepLog(store)(rec, req)
+		return rec
+	}
+	const want = "red\nprogress 100%\n"
+
+	// A finished step's converted log is kept, and served in ranges
+	if rec := get(finished, ""); rec.Code != http.StatusOK || rec.Body.String() != want {
+		t.Errorf("finished step: %d %q, want %q", rec.Code, rec.Body.String(), want)
+	}
+	if rec := get(finished, "bytes=-5"); rec.Code != http.StatusPartialContent || rec.Body.String() != want[len(want)-5:] {
+		t.Errorf("finished step range: %d %q, want %q", rec.Code, rec.Body.String(), want[len(want)-5:])
+	}
+	if _, err := os.Stat(filepath.Join(store.DataDir(), storage.StepLogPath(run.ID, finished.ID)+".text")); err != nil {
+		t.Errorf("converted log not kept: %v", err)
+	}
+
+	// A running step's log is converted as it is sent, whole
+	rec := get(running, "bytes=-5")
+	if rec.Code != http.StatusOK || rec.Body.String() != want || rec.Header().Get("Accept-Ranges") != "none" {
+		t.Errorf("running step: %d %q (Accept-Ranges %q), want the whole log", rec.Code, rec.Body.String(), rec.Header().Get("Accept-Ranges"))
+	}
+	if _, err := os.Stat(filepath.Join(store.DataDir(), storage.StepLogPath(run.ID, running.ID)+".text")); !os.IsNotExist(err) {
+		t.Errorf("converted log of a running step kept: %v", err)
+	}
+}
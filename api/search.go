@@ -0,0 +1,94 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"pipego/runner/storage"
+)
+
+// SearchLogs searches the output, names and commands of past steps:
+// /api/search?q=...&project=...&since=...&limit=...
+// since is a duration back from now ("36h", "7d") or a date ("2024-05-01", RFC 3339).
+// limit is at most storage.MaxSearchLimit.
+// Snippets are HTML, with the matches in <mark> elements.
+func SearchLogs(store *storage.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		query := strings.TrimSpace(r.URL.Query().Get("q"))
+		if query == "" {
+			http.Error(w, "Missing query parameter 'q'", http.StatusBadRequest)
+			return
+		}
+
+		opts := storage.SearchOptions{Project: r.URL.Query().Get("project")}
+		if value := r.URL.Query().Get("since"); value != "" {
+			since, err := ParseSince(value, time.Now())
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			opts.Since = since
+		}
+		if value := r.URL.Query().Get("limit"); value != "" {
+			limit, err := strconv.Atoi(value)
+			if err != nil || limit <= 0 || limit > storage.MaxSearchLimit {
+				http.Error(w, fmt.Sprintf("Invalid limit, expected 1 to %d", storage.MaxSearchLimit), http.StatusBadRequest)
+				return
+			}
+			opts.Limit = limit
+		}
+
+		results, err := store.SearchStepOutputs(query, opts)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Search failed: %v", err), http.StatusInternalServerError)
+			return
+		}
+		for _, result := range results {
+			result.Snippet = SnippetHTML(result.Snippet)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"query":   query,
+			"results": results,
+		})
+	}
+}
+
+// SnippetHTML escapes a search snippet and marks its matches with <mark>
+func SnippetHTML(snippet string) string {
+	return strings.NewReplacer(
+		storage.SnippetMatchStart, "<mark>",
+		storage.SnippetMatchEnd, "</mark>",
+	).Replace(html.EscapeString(snippet))
+}
+
+// ParseSince parses how far back to look: a duration such as "36h" or "7d", or
+// a date such as "2024-05-01" or "2024-05-01T15:04:05Z"
+func ParseSince(value string, now time.Time) (time.Time, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil && n >= 0 {
+			return now.AddDate(0, 0, -n), nil
+		}
+	}
+	if d, err := time.ParseDuration(value); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid since '%s', expected a duration such as 7d or a date such as 2024-05-01", value)
+}
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"pipego/runner/storage"
+)
+
+// GetRunSummary returns the markdown the steps of a run wrote to their
+// PIPEGO_SUMMARY files: /api/runs/:id/summary
+// markdown joins the step summaries in the order the steps ran.
+func GetRunSummary(store *storage.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		// Parse run ID from URL: /api/runs/:id/summary
+		pathParts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+		if len(pathParts) != 4 {
+			http.Error(w, "Invalid path", http.StatusBadRequest)
+			return
+		}
+
+		runID, err := strconv.Atoi(pathParts[2])
+		if err != nil {
+			http.Error(w, "Invalid run ID", http.StatusBadRequest)
+			return
+		}
+
+		if _, err := store.GetRun(runID); err != nil {
+			http.Error(w, fmt.Sprintf("Run not found: %v", err), http.StatusNotFound)
+			return
+		}
+
+		summaries, err := store.GetRunSummary(runID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get summary: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		sections := make([]string, len(summaries))
+		for i, summary := range summaries {
+			sections[i] = strings.TrimRight(summary.Markdown, "\n") + "\n"
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"run_id":   runID,
+			"markdown": strings.Join(sections, "\n"),
+			"steps":    summaries,
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"pipego/api"
+	"pipego/runner/storage"
+)
+
+// LogsSearchOptions holds the flags of the 'logs search' command
+type LogsSearchOptions struct {
+	Project string // Only runs of this project
+	Since   string // How far back to look, e.g. "7d" or "2024-05-01"
+	Limit   int
+	Server  string // Search on a running 'pipego serve' instead of the local history
+}
+
+// LogsSearch executes the 'logs search' command: finds past steps whose output contains query
+func LogsSearch(query string, opts LogsSearchOptions) error {
+	var results []*storage.SearchResult
+	var err error
+	if opts.Server != "" {
+		results, err = searchOnServer(query, opts)
+	} else {
+		results, err = searchLocally(query, opts)
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(results) == 0 {
+		fmt.Printf("🔎 No steps found for %q\n", query)
+		return nil
+	}
+
+	fmt.Printf("🔎 %d step(s) found for %q\n", len(results), query)
+	for _, result := range results {
+		name := result.ProjectName
+		if result.Part != "" && result.Part != "default" {
+			name += "/" + result.Part
+		}
+		fmt.Printf("\n#%d %s [%s] %s - step '%s' (%s)\n", result.RunID, name, result.RunStatus,
+			result.StartedAt.Local().Format("2006-01-02 15:04"), result.Step, result.StepStatus)
+		fmt.Printf("    %s\n", terminalSnippet(result.Snippet))
+	}
+	return nil
+}
+
+// searchLocally searches the run history in ./data
+func searchLocally(query string, opts LogsSearchOptions) ([]*storage.SearchResult, error) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get current directory: %w", err)
+	}
+
+	dbPath := filepath.Join(cwd, "data", "pipego.db")
+	if _, err := os.Stat(dbPath); err != nil {
+		return nil, fmt.Errorf("no run history found at %s", dbPath)
+	}
+
+	store, err := storage.NewStorage(dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize storage: %w", err)
+	}
+	defer store.Close()
+
+	searchOpts := storage.SearchOptions{Project: opts.Project, Limit: opts.Limit}
+	if opts.Since != "" {
+		if searchOpts.Since, err = api.ParseSince(opts.Since, time.Now()); err != nil {
+			return nil, err
+		}
+	}
+
+	// Steps from before search existed are indexed on first use
+	if _, err := store.IndexMissingStepOutputs(); err != nil {
+		return nil, err
+	}
+	return store.SearchStepOutputs(query, searchOpts)
+}
+
+// searchOnServer searches the run history of a PipeGo server
+func searchOnServer(query string, opts LogsSearchOptions) ([]*storage.SearchResult, error) {
+	params := url.Values{"q": {query}}
+	if opts.Project != "" {
+		params.Set("project", opts.Project)
+	}
+	if opts.Since != "" {
+		params.Set("since", opts.Since)
+	}
+	if opts.Limit > 0 {
+		params.Set("limit", strconv.Itoa(opts.Limit))
+	}
+
+	server := strings.TrimSuffix(opts.Server, "/")
+	resp, err := http.Get(server + "/api/search?" + params.Encode())
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach server: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("server rejected search (%s): %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var response struct {
+		Results []*storage.SearchResult `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("invalid server response (%s): %w", resp.Status, err)
+	}
+
+	// The server sends HTML snippets, turn them back into marked text
+	for _, result := range response.Results {
+		snippet := strings.NewReplacer("<mark>", storage.SnippetMatchStart, "</mark>", storage.SnippetMatchEnd).Replace(result.Snippet)
+		result.Snippet = html.UnescapeString(snippet)
+	}
+	return response.Results, nil
+}
+
+// terminalSnippet shows a snippet on one line with its matches in bold
+func terminalSnippet(snippet string) string {
+	snippet = strings.Join(strings.Fields(snippet), " ")
+	return strings.NewReplacer(
+		storage.SnippetMatchStart, "\x1b[1m",
+		storage.SnippetMatchEnd, "\x1b[0m",
+	).Replace(snippet)
+}
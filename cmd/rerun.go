@@ -0,0 +1,103 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"pipego/runner"
+	"pipego/runner/storage"
+)
+
+// RerunOptions holds the flags of the 'rerun' command
+type RerunOptions struct {
+	Resume bool   // Start at the first failed step instead of the beginning
+	Server string // Queue the rerun on a running 'pipego serve' instead of running locally
+}
+
+// Rerun executes the 'rerun' command: runs the part of a past run again
+func Rerun(runID int, opts RerunOptions) error {
+	if opts.Server != "" {
+		return rerunOnServer(runID, opts)
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		log.Fatalf("Failed to get current directory: %v", err)
+	}
+
+	dbPath := filepath.Join(cwd, "data", "pipego.db")
+	if _, err := os.Stat(dbPath); err != nil {
+		return fmt.Errorf("no run history found at %s", dbPath)
+	}
+
+	store, err := storage.NewStorage(dbPath)
+	if err != nil {
+		log.Fatalf("Failed to initialize storage: %v", err)
+	}
+	defer store.Close()
+
+	req, err := runner.PrepareRerun(store, runID, opts.Resume)
+	if err != nil {
+		return err
+	}
+
+	if opts.Resume {
+		fmt.Printf("🔁 Resuming run #%d (%s) from step %d\n", runID, req.Parts[0], req.ResumeFromStep+1)
+	} else {
+		fmt.Printf("🔁 Re-running run #%d (%s)\n", runID, req.Parts[0])
+	}
+
+	result, err := runner.RunPipelineWithOptions(req.ConfigPath, runner.RunPipelineOptions{
+		Storage:          store,
+		StreamToTerminal: true,
+		Parts:            req.Parts,
+		Commit:           req.Commit,
+		CheckoutCommit:   req.Checkout,
+		Trigger:          req.Trigger,
+		ConfigSnapshot:   req.ConfigSnapshot,
+		ParentRunID:      req.ParentRunID,
+		ResumeFromStep:   req.ResumeFromStep,
+	})
+
+	if err != nil {
+		return fmt.Errorf("pipeline failed: %w", err)
+	}
+
+	fmt.Printf("\n📊 Execution ID: %d | Run ID: %d | Status: %s | Duration: %s\n", result.ExecutionID, result.RunID, result.Status, result.Duration)
+
+	return nil
+}
+
+// rerunOnServer asks a PipeGo server to queue the rerun
+func rerunOnServer(runID int, opts RerunOptions) error {
+	server := strings.TrimSuffix(opts.Server, "/")
+
+	action := "rerun"
+	if opts.Resume {
+		action = "resume"
+	}
+
+	resp, err := http.Post(fmt.Sprintf("%s/api/runs/%d/%s", server, runID, action), "application/json", nil)
+	if err != nil {
+		return fmt.Errorf("failed to reach server: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var response map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return fmt.Errorf("invalid server response (%s): %w", resp.Status, err)
+	}
+
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("server rejected %s (%s): %v", action, resp.Status, response["error"])
+	}
+
+	fmt.Printf("📥 %v\n", response["message"])
+	fmt.Printf("   Run #%v: %s%v\n", response["run_id"], server, response["status_url"])
+	return nil
+}
@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"pipego/runner/storage"
+)
+
+func TestRerunLocal(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	if err := Rerun(1, RerunOptions{}); err == nil || !strings.Contains(err.Error(), "no run history") {
+		t.Errorf("rerun without history: err = %v", err)
+	}
+
+	config := `parts:
+  build:
+    steps:
+      - name: compile
+        run: echo compiled
+      - name: test
+        run: exit 3
+`
+	if err := os.WriteFile("pipego.yml", []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	result, _ := runJSON(t, "build")
+	if result.RunID == 0 {
+		t.Fatalf("result = %+v", result)
+	}
+
+	// Keep the streamed output out of the test output
+	stdout := os.Stdout
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stdout = devNull
+	rerunErr := Rerun(result.RunID, RerunOptions{})
+	resumeErr := Rerun(result.RunID, RerunOptions{Resume: true})
+	os.Stdout = stdout
+	devNull.Close()
+
+	if rerunErr == nil || resumeErr == nil {
+		t.Errorf("rerun err = %v, resume err = %v, want both to fail again", rerunErr, resumeErr)
+	}
+
+	store, err := storage.NewStorage(filepath.Join("data", "pipego.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	children, err := store.GetChildRuns(result.RunID)
+	if err != nil || len(children) != 2 {
+		t.Fatalf("child runs = %v, %v, want the rerun and the resume", children, err)
+	}
+	for i, wantFirst := range []string{"success", "skipped"} {
+		steps, err := store.GetStepExecutions(children[i].ID)
+		if err != nil || len(steps) != 2 || steps[0].Status != wantFirst || steps[1].Status != "failed" {
+			t.Errorf("steps of child run %d = %+v, %v, want the first one %s", i, steps, err, wantFirst)
+		}
+	}
+}
+
+func TestRerunOnServer(t *testing.T) {
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.Method+" "+r.URL.Path)
+		if strings.HasSuffix(r.URL.Path, "/resume") {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]interface{}{"error": "run has no failed step to resume from"})
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"message":    "Rerun of run #7 queued",
+			"run_id":     8,
+			"status_url": "/api/runs/8/status",
+		})
+	}))
+	defer server.Close()
+
+	stdout := os.Stdout
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stdout = devNull
+	rerunErr := Rerun(7, RerunOptions{Server: server.URL + "/"})
+	resumeErr := Rerun(7, RerunOptions{Server: server.URL, Resume: true})
+	os.Stdout = stdout
+	devNull.Close()
+
+	if rerunErr != nil {
+		t.Errorf("rerun: %v", rerunErr)
+	}
+	if resumeErr == nil || !strings.Contains(resumeErr.Error(), "no failed step") {
+		t.Errorf("rejected resume: err = %v", resumeErr)
+	}
+	if want := []string{"POST /api/runs/7/rerun", "POST /api/runs/7/resume"}; strings.Join(paths, ",") != strings.Join(want, ",") {
+		t.Errorf("requests = %v, want %v", paths, want)
+	}
+}
@@ -1,17 +1,46 @@
 package cmd
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strconv"
+	"strings"
 
 	"pipego/runner"
 	"pipego/runner/storage"
 )
 
+// ErrPipelineFailed is returned when the pipeline failed and its result was
+// already printed, so the caller only has to exit with a failure status
+var ErrPipelineFailed = errors.New("pipeline failed")
+
+// RunOptions holds the flags of the 'run' command
+type RunOptions struct {
+	Part     string // Run only this part (empty = all parts)
+	Watch    bool   // Re-run whenever project files change
+	Server   string // Queue the run on a running 'pipego serve' instead of running locally
+	Project  string // Project name from projects.yml, used with Server
+	Priority int    // Queue priority, used with Server
+	JSON     bool   // Print the result as JSON instead of streaming the output
+}
+
 // Run executes the 'run' command
-func Run(configPath string) error {
+func Run(configPath string, opts RunOptions) error {
+	if opts.JSON && (opts.Watch || opts.Server != "") {
+		return fmt.Errorf("--json can't be used with --watch or --server")
+	}
+	if opts.Server != "" {
+		return runOnServer(configPath, opts)
+	}
 
 	// Determine database path (its stored in data directory in current working directory)
 	cwd, err := os.Getwd()
@@ -35,18 +64,104 @@ func Run(configPath string) error {
 	}
 	defer store.Close()	
 
+	if opts.Watch {
+		// Ctrl+C cancels the current run and stops watching
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+
+		fmt.Println("👀 Watch mode: re-running on file changes (Ctrl+C to stop)")
+		return runner.WatchPipeline(ctx, configPath, runner.RunPipelineOptions{
+			Storage:          store,
+			StreamToTerminal: true,
+			PartFilter:       opts.Part,
+			Trigger:          "watch",
+		}, []string{dataDir})
+	}
+
 	// Run pipeline with storage and streaming to terminal
 	result, err := runner.RunPipelineWithOptions(configPath, runner.RunPipelineOptions{
 		Storage:          store,
-		StreamToTerminal: true, // Always stream to console for local development
+		StreamToTerminal: !opts.JSON, // Stream to console for local development, unless printing JSON
+		PartFilter:       opts.Part,
 	})
 
+	if opts.JSON {
+		printResultJSON(result, err)
+		if err != nil {
+			// Returning lets the deferred calls close the storage before exiting
+			return ErrPipelineFailed
+		}
+		return nil
+	}
+
 	if err != nil {
-		log.Fatalf("Pipeline failed: %v", err)
+		return fmt.Errorf("pipeline failed: %w", err)
 	}
 
-	fmt.Printf("\n📊 Run ID: %d | Status: %s | Duration: %s\n", result.RunID, result.Status, result.Duration)
+	fmt.Printf("\n📊 Execution ID: %d | Run ID: %d | Status: %s | Duration: %s\n", result.ExecutionID, result.RunID, result.Status, result.Duration)
 
 	return nil
 }
 
+// printResultJSON prints the result of a pipeline, or why it could not run, as JSON
+func printResultJSON(result *runner.PipelineResult, err error) {
+	if result == nil {
+		result = &runner.PipelineResult{Status: "failed", Steps: []runner.StepResult{}}
+	}
+	if result.Failure == nil {
+		result.Failure = runner.FailureOf(err)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(result)
+}
+
+// runOnServer queues the run on a PipeGo server so it shares the server's run queue
+func runOnServer(configPath string, opts RunOptions) error {
+	server := strings.TrimSuffix(opts.Server, "/")
+
+	var resp *http.Response
+	var err error
+	if opts.Project != "" {
+		query := url.Values{}
+		if opts.Part != "" {
+			query.Set("part", opts.Part)
+		}
+		if opts.Priority != 0 {
+			query.Set("priority", strconv.Itoa(opts.Priority))
+		}
+		endpoint := fmt.Sprintf("%s/api/projects/%s/run?%s", server, url.PathEscape(opts.Project), query.Encode())
+		resp, err = http.Post(endpoint, "application/json", nil)
+	} else {
+		// The server must be able to read the config, so send an absolute path
+		absPath, absErr := filepath.Abs(configPath)
+		if absErr != nil {
+			return fmt.Errorf("failed to resolve config path: %w", absErr)
+		}
+		body, _ := json.Marshal(map[string]string{"config_path": absPath})
+		resp, err = http.Post(server+"/api/run", "application/json", bytes.NewReader(body))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to reach server: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var response map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return fmt.Errorf("invalid server response (%s): %w", resp.Status, err)
+	}
+
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("server rejected run (%s): %v", resp.Status, response["error"])
+	}
+
+	fmt.Printf("📥 %v\n", response["message"])
+	if runs, ok := response["runs"].([]interface{}); ok {
+		for _, entry := range runs {
+			run, _ := entry.(map[string]interface{})
+			fmt.Printf("   Run #%v (%v): %s%v\n", run["run_id"], run["part"], server, run["status_url"])
+		}
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"pipego/runner"
+	"pipego/runner/storage"
+)
+
+func TestRunJSONReturnsFailure(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	config := `parts:
+  ok:
+    steps:
+      - name: ok
+        run: "true"
+  fail:
+    steps:
+      - name: fail
+        run: exit 3
+`
+	if err := os.WriteFile("pipego.yml", []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Keep the printed results out of the test output
+	stdout := os.Stdout
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stdout = devNull
+	defer func() {
+		os.Stdout = stdout
+		devNull.Close()
+	}()
+
+	if err := Run("pipego.yml", RunOptions{Part: "ok", JSON: true}); err != nil {
+		t.Errorf("successful run: err = %v", err)
+	}
+	if err := Run("pipego.yml", RunOptions{Part: "fail", JSON: true}); !errors.Is(err, ErrPipelineFailed) {
+		t.Errorf("failed run: err = %v, want %v", err, ErrPipelineFailed)
+	}
+	if err := Run("pipego.yml", RunOptions{Part: "fail"}); err == nil || errors.Is(err, ErrPipelineFailed) {
+		t.Errorf("failed run without --json: err = %v", err)
+	}
+}
+
+// runJSON runs the config in the current directory with --json and decodes what it prints
+func runJSON(t *testing.T, part string) (runner.PipelineResult, error) {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	runErr := Run("pipego.yml", RunOptions{Part: part, JSON: true})
+	os.Stdout = stdout
+	w.Close()
+
+	var result runner.PipelineResult
+	data, err := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		t.Fatalf("output is not a JSON result: %v\n%s", err, data)
+	}
+	return result, runErr
+}
+
+func TestRunJSONOutput(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	config := `parts:
+  build:
+    steps:
+      - name: compile
+        run: echo compiled
+      - name: test
+        run: "echo 'FAIL: TestX' >&2; exit 3"
+`
+	if err := os.WriteFile("pipego.yml", []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := runJSON(t, "build")
+	if !errors.Is(err, ErrPipelineFailed) {
+		t.Errorf("err = %v, want %v", err, ErrPipelineFailed)
+	}
+	if result.Status != "failed" || result.ExecutionID == 0 || result.RunID == 0 || len(result.Steps) != 2 {
+		t.Fatalf("result = %+v", result)
+	}
+	if step := result.Steps[0]; step.Name != "compile" || step.Status != "success" || step.Output != "compiled\n" || step.Failure != nil {
+		t.Errorf("first step = %+v", step)
+	}
+	step := result.Steps[1]
+	if step.Status != "failed" || step.Failure == nil || step.Failure.Reason != storage.FailureCommandFailed ||
+		step.Failure.ExitCode == nil || *step.Failure.ExitCode != 3 || !strings.Contains(step.Output, "FAIL: TestX") {
+		t.Errorf("failed step = %+v with failure %+v", step, step.Failure)
+	}
+	if result.Failure == nil || result.Failure.Reason != storage.FailureCommandFailed || result.Failure.Step != "test" {
+		t.Errorf("run failure = %+v", result.Failure)
+	}
+
+	// A pipeline that can't start still prints a result saying why
+	result, err = runJSON(t, "missing")
+	if !errors.Is(err, ErrPipelineFailed) {
+		t.Errorf("unknown part: err = %v, want %v", err, ErrPipelineFailed)
+	}
+	if result.Status != "failed" || result.Steps == nil || len(result.Steps) != 0 || result.Failure == nil || result.Failure.Reason != storage.FailureConfigError {
+		t.Errorf("unknown part: result = %+v with failure %+v", result, result.Failure)
+	}
+}
@@ -1,12 +1,16 @@
 package cmd
 
 import (
+    "context"
     "fmt"
     "log"
     "net/http"
     "os"
+    "os/signal"
     "path/filepath"
     "strings"
+    "syscall"
+    "time"
 
     "github.com/joho/godotenv"
     "pipego/api"
@@ -53,10 +57,49 @@ func Serve() error {
 		log.Printf("📁 Loaded %d project(s)", len(projectsConfig.Projects))
 	}
 
+	// A schedule must never fire in a freeze window because it was mistyped
+	if err := runner.ValidateBlackout(projectsConfig.Server.Blackout); err != nil {
+		log.Fatalf("Invalid server config: %v", err)
+	}
+
+	// Runs left behind by a previous process that crashed or was killed
+	runner.ReconcileInterruptedRuns(store)
+
+	// Make the output of steps from before search existed searchable
+	go func() {
+		if indexed, err := store.IndexMissingStepOutputs(); err != nil {
+			log.Printf("⚠️  Failed to index step outputs: %v", err)
+		} else if indexed > 0 {
+			log.Printf("🔎 Indexed the output of %d step(s) for search", indexed)
+		}
+	}()
+
+	// Project limits and part concurrency groups are shared by all pipelines
+	locks := runner.NewLockManager(projectsConfig, cwd)
+
+	// Initialize and start the run queue - every trigger goes through it
+	if err := projectsConfig.Server.FailureCauses.Validate(); err != nil {
+		log.Printf("⚠️  Ignoring server failure causes: %v", err)
+		projectsConfig.Server.FailureCauses = nil
+	}
+	if err := projectsConfig.Server.Retry.Validate(); err != nil {
+		log.Printf("⚠️  Ignoring server retry policy: %v", err)
+		projectsConfig.Server.Retry = nil
+	}
+	queue := runner.NewRunQueue(store, locks, projectsConfig.Server)
+	queue.Start()
+
 	// Initialize and start scheduler
-	scheduler := runner.NewScheduler(projectsConfig, store, cwd)
+	scheduler := runner.NewScheduler(projectsConfig, store, queue, cwd)
 	go scheduler.Start()
-	defer scheduler.Stop()
+
+	// Initialize and start file watcher for projects with a watch trigger
+	watcher := runner.NewFileWatcher(projectsConfig, queue, cwd, dataDir)
+	go watcher.Start()
+
+	// Initialize and start git poller for projects with a git trigger
+	gitPoller := runner.NewGitPoller(projectsConfig, queue, cwd)
+	go gitPoller.Start()
 
     // Setup HTTP routes
     mux := http.NewServeMux()
@@ -66,7 +109,7 @@ func Serve() error {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			w.Header().Set("Access-Control-Allow-Origin", "*")
 			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Idempotency-Key")
 			if r.Method == http.MethodOptions {
 				w.WriteHeader(http.StatusOK)
 				return
@@ -99,35 +142,89 @@ func Serve() error {
 		// Route based on path suffix
 		if strings.HasSuffix(r.URL.Path, "/status") {
 			api.GetRunStatus(store)(w, r)
+		} else if strings.HasSuffix(r.URL.Path, "/rerun") || strings.HasSuffix(r.URL.Path, "/resume") {
+			api.PostRunRerun(store, queue)(w, r)
+		} else if strings.HasSuffix(r.URL.Path, "/logs/stream") {
+			api.StreamRunLogs(store)(w, r)
+		} else if strings.HasSuffix(r.URL.Path, "/log") {
+			api.GetStepLog(store)(w, r)
+		} else if strings.HasSuffix(r.URL.Path, "/summary") {
+			api.GetRunSummary(store)(w, r)
 		} else {
 			api.GetRun(store)(w, r)
 		}
 	}) 
-	mux.HandleFunc("/api/run", api.PostRun(store))
+	mux.HandleFunc("/api/run", api.PostRun(store, queue))
+	mux.HandleFunc("/api/search", api.SearchLogs(store))
+	mux.HandleFunc("/api/executions", api.GetExecutions(store))
+	mux.HandleFunc("/api/executions/", api.GetExecution(store))
+	mux.HandleFunc("/api/queue", api.GetQueue(queue))
+	mux.HandleFunc("/api/locks", api.GetLocks(locks))
 	
 	mux.HandleFunc("/api/projects", api.GetProjects(projectsConfig, cwd))
 	mux.HandleFunc("/api/projects/", func(w http.ResponseWriter, r *http.Request) {
 		if strings.HasSuffix(r.URL.Path, "/runs") {
 			api.GetProjectRuns(store)(w, r)
 		} else if strings.HasSuffix(r.URL.Path, "/run") {
-			api.PostProjectRun(store, projectsConfig, cwd)(w, r)
+			api.PostProjectRun(store, queue, projectsConfig, cwd)(w, r)
 		} else if strings.HasSuffix(r.URL.Path, "/stats") {
 			api.GetProjectStats(store, projectsConfig, cwd)(w, r)
 		} else {
 			http.NotFound(w, r)
 		}
 	})
+	mux.HandleFunc("/api/hooks/", api.PostWebhook(store, queue, projectsConfig, cwd)) // Forge push webhooks
 
 	// Start HTTP server with CORS
 	serverAddr := ":" + port
 	log.Printf("🚀 Starting PipeGo server on port %s...", port)
 	log.Printf("📊 Dashboard: http://localhost:%s", port)
 	
-	if err := http.ListenAndServe(serverAddr, corsMiddleware(mux)); err != nil {
-		return fmt.Errorf("server failed: %w", err)
+	server := &http.Server{Addr: serverAddr, Handler: corsMiddleware(mux)}
+
+	// SIGINT/SIGTERM start a graceful shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err = <-serverErr:
+		err = fmt.Errorf("server failed: %w", err)
+	case <-ctx.Done():
+		// A second signal kills the process right away
+		stop()
+		log.Println("🛑 Shutting down...")
+		err = nil
+	}
+
+	shutdown(server, scheduler, watcher, gitPoller, queue, projectsConfig.Server.GetShutdownGrace())
+	return err
+}
+
+// shutdown stops the server in order: schedules stop firing, the run queue refuses
+// new triggers and gives running pipelines the grace period to finish (the API and
+// event stream keep serving meanwhile), then the remaining triggers and HTTP server stop
+func shutdown(server *http.Server, scheduler *runner.Scheduler, watcher *runner.FileWatcher, gitPoller *runner.GitPoller, queue *runner.RunQueue, grace time.Duration) {
+	scheduler.Stop()
+
+	queue.Shutdown(grace)
+
+	// Watch and git triggers cancel their pipelines when stopped, so they go after the queue
+	watcher.Stop()
+	gitPoller.Stop()
+
+	// Event streams never go idle, close them once in-flight requests had a moment to finish
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		server.Close()
 	}
 
-	return nil
+	log.Println("👋 Server stopped")
 }
 
 // getEnv gets environment variable or returns default value
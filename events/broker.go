@@ -10,12 +10,14 @@ import (
 // EventBroker manages SSE connections and broadcasts events
 type EventBroker struct {
 	clients map[chan string]bool
+	topics  map[string]map[chan string]bool // Clients following a single topic, e.g. the logs of a run
 	mu      sync.RWMutex
 }
 
 // Global event broker instance
 var broker = &EventBroker{
 	clients: make(map[chan string]bool),
+	topics:  make(map[string]map[chan string]bool),
 }
 
 // GetBroker returns the global event broker
@@ -68,3 +70,66 @@ func (b *EventBroker) Broadcast(eventType string, data interface{}) {
 	log.Printf("📢 Broadcast event: %s to %d client(s)", eventType, len(b.clients))
 }
 
+// Subscribe adds a client for the events of a single topic
+func (b *EventBroker) Subscribe(topic string, client chan string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.topics[topic] == nil {
+		b.topics[topic] = make(map[chan string]bool)
+	}
+	b.topics[topic][client] = true
+}
+
+// Unsubscribe removes a topic client and closes its channel, unless Publish
+// already dropped it
+func (b *EventBroker) Unsubscribe(topic string, client chan string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.topics[topic][client] {
+		return
+	}
+	b.removeSubscriber(topic, client)
+}
+
+// Publish sends an event with an ID to the clients of a topic. It is called for
+// every line of output, so unlike Broadcast it does not log. A client that can't
+// keep up is dropped and its channel closed, so it reconnects from the last ID it
+// received instead of silently missing events.
+func (b *EventBroker) Publish(topic, eventType, id string, data interface{}) {
+	b.mu.RLock()
+	subscribers := len(b.topics[topic])
+	b.mu.RUnlock()
+	if subscribers == 0 {
+		return
+	}
+
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("Failed to marshal event data: %v", err)
+		return
+	}
+	// An empty ID would reset the client's last event ID
+	message := fmt.Sprintf("event: %s\ndata: %s\n\n", eventType, string(jsonData))
+	if id != "" {
+		message = "id: " + id + "\n" + message
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for client := range b.topics[topic] {
+		select {
+		case client <- message:
+		default:
+			b.removeSubscriber(topic, client)
+		}
+	}
+}
+
+// removeSubscriber drops a topic client, b.mu must be held
+func (b *EventBroker) removeSubscriber(topic string, client chan string) {
+	delete(b.topics[topic], client)
+	if len(b.topics[topic]) == 0 {
+		delete(b.topics, topic)
+	}
+	close(client)
+}
@@ -1,11 +1,16 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"os"
+	"strconv"
+	"strings"
 
 	"pipego/cmd"
+	"pipego/runner/storage"
 )
 
 func main() {
@@ -18,11 +23,63 @@ func main() {
 
 	switch command {
 	case "run":
+		var opts cmd.RunOptions
+		flags := flag.NewFlagSet("run", flag.ExitOnError)
+		flags.StringVar(&opts.Part, "part", "", "run only this part (e.g. frontend.deploy)")
+		flags.BoolVar(&opts.Watch, "watch", false, "re-run when project files change")
+		flags.StringVar(&opts.Server, "server", "", "queue the run on a PipeGo server (e.g. http://localhost:8080)")
+		flags.StringVar(&opts.Project, "project", "", "project name from projects.yml (with --server)")
+		flags.IntVar(&opts.Priority, "priority", 0, "queue priority, higher runs first (with --server)")
+		flags.BoolVar(&opts.JSON, "json", false, "print the result as JSON instead of the output")
+
+		args := parseInterspersed(flags, os.Args[2:])
 		configPath := "pipego.yml"
-		if len(os.Args) >= 3 {
-			configPath = os.Args[2]
+		if len(args) >= 1 {
+			configPath = args[0]
+		}
+		if err := cmd.Run(configPath, opts); err != nil {
+			// The JSON result already says why the pipeline failed
+			if errors.Is(err, cmd.ErrPipelineFailed) {
+				os.Exit(1)
+			}
+			log.Fatal(err)
+		}
+	case "rerun":
+		var opts cmd.RerunOptions
+		flags := flag.NewFlagSet("rerun", flag.ExitOnError)
+		flags.BoolVar(&opts.Resume, "resume", false, "start at the first failed step")
+		flags.StringVar(&opts.Server, "server", "", "queue the rerun on a PipeGo server (e.g. http://localhost:8080)")
+
+		args := parseInterspersed(flags, os.Args[2:])
+		if len(args) != 1 {
+			printUsage()
+			os.Exit(1)
+		}
+		runID, err := strconv.Atoi(args[0])
+		if err != nil {
+			log.Fatalf("Invalid run ID: %s", args[0])
 		}
-		if err := cmd.Run(configPath); err != nil {
+		if err := cmd.Rerun(runID, opts); err != nil {
+			log.Fatal(err)
+		}
+	case "logs":
+		if len(os.Args) < 3 || os.Args[2] != "search" {
+			printUsage()
+			os.Exit(1)
+		}
+		var opts cmd.LogsSearchOptions
+		flags := flag.NewFlagSet("logs search", flag.ExitOnError)
+		flags.StringVar(&opts.Project, "project", "", "only runs of this project")
+		flags.StringVar(&opts.Since, "since", "", "only runs since a duration ago (e.g. 7d, 12h) or a date")
+		flags.IntVar(&opts.Limit, "limit", storage.DefaultSearchLimit, fmt.Sprintf("maximum number of steps to show (at most %d)", storage.MaxSearchLimit))
+		flags.StringVar(&opts.Server, "server", "", "search on a PipeGo server (e.g. http://localhost:8080)")
+
+		args := parseInterspersed(flags, os.Args[3:])
+		if len(args) == 0 {
+			printUsage()
+			os.Exit(1)
+		}
+		if err := cmd.LogsSearch(strings.Join(args, " "), opts); err != nil {
 			log.Fatal(err)
 		}
 	case "serve":
@@ -36,14 +93,46 @@ func main() {
 	}
 }
 
+// parseInterspersed parses flags that may appear before or after positional arguments
+func parseInterspersed(flags *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		flags.Parse(args)
+		args = flags.Args()
+		if len(args) == 0 {
+			return positional
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
 func printUsage() {
 	fmt.Println("Usage: pipego [command]")
 	fmt.Println()
 	fmt.Println("Commands:")
 	fmt.Println("  run [config-path]    Run a pipeline")
+	fmt.Println("      --part <name>    Run only this part")
+	fmt.Println("      --watch          Re-run when project files change")
+	fmt.Println("      --server <url>   Queue the run on a PipeGo server")
+	fmt.Println("      --project <name> Project to run on the server")
+	fmt.Println("      --priority <n>   Queue priority on the server")
+	fmt.Println("      --json           Print the result as JSON")
+	fmt.Println("  rerun <run-id>       Run the part of a past run again")
+	fmt.Println("      --resume         Start at the first failed step (not for isolated workspaces)")
+	fmt.Println("      --server <url>   Queue the rerun on a PipeGo server")
+	fmt.Println("  logs search <query>  Find past steps whose output contains the query")
+	fmt.Println("      --project <name> Only runs of this project")
+	fmt.Println("      --since <when>   Only runs since a duration ago (7d, 12h) or a date")
+	fmt.Printf("      --limit <n>      Show at most n steps (default %d, at most %d)\n", storage.DefaultSearchLimit, storage.MaxSearchLimit)
+	fmt.Println("      --server <url>   Search on a PipeGo server")
 	fmt.Println("  serve                Start HTTP server")
 	fmt.Println()
 	fmt.Println("Examples:")
 	fmt.Println("  pipego run ../dummy-app/pipego.yml")
+	fmt.Println("  pipego run ../dummy-app/pipego.yml --watch --part tests")
+	fmt.Println("  pipego run --server http://localhost:8080 --project dummy-app")
+	fmt.Println("  pipego rerun 42 --resume")
+	fmt.Println("  pipego logs search \"connection refused\" --since 7d")
 	fmt.Println("  pipego serve")
 }
\ No newline at end of file
@@ -0,0 +1,254 @@
+package runner
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BlackoutWindow is a period during which schedules must not fire.
+// A window is either cron-like (active in every minute the expression matches)
+// or a date range (from/to), e.g.:
+//
+//	blackout:
+//	  - cron: "* 17-23 * * 5"   # Friday evenings
+//	    reason: release freeze
+//	  - from: "2025-12-24"
+//	    to: "2025-12-26"
+//	    action: defer
+type BlackoutWindow struct {
+	Cron   string `yaml:"cron,omitempty" json:"cron,omitempty"`     // "minute hour day-of-month month day-of-week"
+	From   string `yaml:"from,omitempty" json:"from,omitempty"`     // "2006-01-02" or "2006-01-02 15:04"
+	To     string `yaml:"to,omitempty" json:"to,omitempty"`         // inclusive when only a date is given
+	Action string `yaml:"action,omitempty" json:"action,omitempty"` // "skip" (default) or "defer"
+	Reason string `yaml:"reason,omitempty" json:"reason,omitempty"`
+}
+
+// Blackout actions
+const (
+	BlackoutSkip  = "skip"
+	BlackoutDefer = "defer"
+)
+
+// ActionOrDefault returns the window's action, defaulting to "skip"
+func (w BlackoutWindow) ActionOrDefault() string {
+	if w.Action == BlackoutDefer {
+		return BlackoutDefer
+	}
+	return BlackoutSkip
+}
+
+// Describe returns a human readable description of the window for logs
+func (w BlackoutWindow) Describe() string {
+	desc := ""
+	if w.Cron != "" {
+		desc = fmt.Sprintf("cron '%s'", w.Cron)
+	} else {
+		desc = fmt.Sprintf("%s - %s", w.From, w.To)
+	}
+	if w.Reason != "" {
+		desc += " (" + w.Reason + ")"
+	}
+	return desc
+}
+
+// Validate checks that the window is a valid cron expression or date range
+// with a known action
+func (w BlackoutWindow) Validate() error {
+	if w.Action != "" && w.Action != BlackoutSkip && w.Action != BlackoutDefer {
+		return fmt.Errorf("invalid action '%s', expected '%s' or '%s'", w.Action, BlackoutSkip, BlackoutDefer)
+	}
+
+	if w.Cron != "" {
+		fields := strings.Fields(w.Cron)
+		if len(fields) != 5 {
+			return fmt.Errorf("invalid cron expression '%s', expected 5 fields", w.Cron)
+		}
+		bounds := [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+		for i, field := range fields {
+			// Items are checked one by one, matching stops at the first that matches
+			for _, item := range strings.Split(field, ",") {
+				if _, err := cronFieldMatches(item, bounds[i][0], bounds[i][0], bounds[i][1]); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	if w.From == "" && w.To == "" {
+		return fmt.Errorf("blackout window needs either cron or from/to")
+	}
+	var from, to time.Time
+	if w.From != "" {
+		t, _, err := parseBlackoutTime(w.From)
+		if err != nil {
+			return err
+		}
+		from = t
+	}
+	if w.To != "" {
+		t, dateOnly, err := parseBlackoutTime(w.To)
+		if err != nil {
+			return err
+		}
+		if dateOnly {
+			t = t.AddDate(0, 0, 1)
+		}
+		to = t
+	}
+	if w.From != "" && w.To != "" && !from.Before(to) {
+		return fmt.Errorf("blackout window ends before it starts")
+	}
+	return nil
+}
+
+// ValidateBlackout checks a list of blackout windows
+func ValidateBlackout(windows []BlackoutWindow) error {
+	for _, window := range windows {
+		if err := window.Validate(); err != nil {
+			return fmt.Errorf("invalid blackout window %s: %w", window.Describe(), err)
+		}
+	}
+	return nil
+}
+
+// Active reports whether the window covers the given time
+func (w BlackoutWindow) Active(t time.Time) (bool, error) {
+	if w.Cron != "" {
+		return cronMatches(w.Cron, t)
+	}
+
+	if w.From == "" && w.To == "" {
+		return false, fmt.Errorf("blackout window needs either cron or from/to")
+	}
+
+	if w.From != "" {
+		from, _, err := parseBlackoutTime(w.From)
+		if err != nil {
+			return false, err
+		}
+		if t.Before(from) {
+			return false, nil
+		}
+	}
+
+	if w.To != "" {
+		to, dateOnly, err := parseBlackoutTime(w.To)
+		if err != nil {
+			return false, err
+		}
+		// A bare date covers the whole day
+		if dateOnly {
+			to = to.AddDate(0, 0, 1)
+		}
+		if !t.Before(to) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// parseBlackoutTime parses "2006-01-02 15:04" or "2006-01-02" in local time
+func parseBlackoutTime(value string) (time.Time, bool, error) {
+	if t, err := time.ParseInLocation("2006-01-02 15:04", value, time.Local); err == nil {
+		return t, false, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, true, nil
+	}
+	return time.Time{}, false, fmt.Errorf("invalid blackout time '%s', expected YYYY-MM-DD or YYYY-MM-DD HH:MM", value)
+}
+
+// cronMatches checks a 5-field cron expression against a time (minute resolution)
+func cronMatches(expr string, t time.Time) (bool, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return false, fmt.Errorf("invalid cron expression '%s', expected 5 fields", expr)
+	}
+
+	minute, err := cronFieldMatches(fields[0], t.Minute(), 0, 59)
+	if err != nil {
+		return false, err
+	}
+	hour, err := cronFieldMatches(fields[1], t.Hour(), 0, 23)
+	if err != nil {
+		return false, err
+	}
+	dom, err := cronFieldMatches(fields[2], t.Day(), 1, 31)
+	if err != nil {
+		return false, err
+	}
+	month, err := cronFieldMatches(fields[3], int(t.Month()), 1, 12)
+	if err != nil {
+		return false, err
+	}
+	dow, err := cronFieldMatches(fields[4], int(t.Weekday()), 0, 7)
+	if err != nil {
+		return false, err
+	}
+	// Sunday may be written as 0 or 7
+	if !dow && t.Weekday() == time.Sunday {
+		dow, _ = cronFieldMatches(fields[4], 7, 0, 7)
+	}
+
+	// Like cron: when both day fields are restricted, either one may match
+	day := dom && dow
+	if fields[2] != "*" && fields[4] != "*" {
+		day = dom || dow
+	}
+
+	return minute && hour && month && day, nil
+}
+
+// cronFieldMatches checks a single cron field ("*", "5", "1-5", "*/15", "5/15", "1,3,5").
+// Like cron, "5/15" steps from 5 to the end of the field: 5,20,35,50.
+func cronFieldMatches(field string, value, min, max int) (bool, error) {
+	for _, item := range strings.Split(field, ",") {
+		step := 1
+		stepped := false
+		if idx := strings.Index(item, "/"); idx >= 0 {
+			s, err := strconv.Atoi(item[idx+1:])
+			if err != nil || s <= 0 {
+				return false, fmt.Errorf("invalid cron step in '%s'", field)
+			}
+			step = s
+			stepped = true
+			item = item[:idx]
+		}
+
+		low, high := min, max
+		switch {
+		case item == "*":
+		case strings.Contains(item, "-"):
+			bounds := strings.SplitN(item, "-", 2)
+			l, err1 := strconv.Atoi(bounds[0])
+			h, err2 := strconv.Atoi(bounds[1])
+			if err1 != nil || err2 != nil || l > h {
+				return false, fmt.Errorf("invalid cron range in '%s'", field)
+			}
+			low, high = l, h
+		default:
+			v, err := strconv.Atoi(item)
+			if err != nil {
+				return false, fmt.Errorf("invalid cron value in '%s'", field)
+			}
+			low, high = v, v
+			if stepped {
+				high = max
+			}
+		}
+
+		if low < min || high > max {
+			return false, fmt.Errorf("cron value out of range in '%s'", field)
+		}
+
+		if value >= low && value <= high && (value-low)%step == 0 {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
@@ -0,0 +1,189 @@
+package runner
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronFieldMatches(t *testing.T) {
+	tests := []struct {
+		field string
+		value int
+		want  bool
+	}{
+		{"*", 17, true},
+		{"5", 5, true},
+		{"5", 6, false},
+		{"1-5", 3, true},
+		{"1-5", 6, false},
+		{"*/15", 30, true},
+		{"*/15", 31, false},
+		{"5/15", 5, true},
+		{"5/15", 20, true},
+		{"5/15", 50, true},
+		{"5/15", 21, false},
+		{"5/15", 0, false},
+		{"10-30/10", 20, true},
+		{"10-30/10", 40, false},
+		{"1,3,5", 3, true},
+		{"1,3,5", 4, false},
+		{"1,30/15", 45, true},
+	}
+	for _, tt := range tests {
+		got, err := cronFieldMatches(tt.field, tt.value, 0, 59)
+		if err != nil {
+			t.Errorf("cronFieldMatches(%q, %d): %v", tt.field, tt.value, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("cronFieldMatches(%q, %d) = %v, want %v", tt.field, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestCronFieldMatchesInvalid(t *testing.T) {
+	for _, field := range []string{"x", "5-1", "*/0", "*/x", "60", "1-70"} {
+		if _, err := cronFieldMatches(field, 0, 0, 59); err == nil {
+			t.Errorf("cronFieldMatches(%q) accepted an invalid field", field)
+		}
+	}
+}
+
+func TestCronMatches(t *testing.T) {
+	// Friday 2025-06-13 18:20
+	friday := time.Date(2025, 6, 13, 18, 20, 0, 0, time.Local)
+	sunday := time.Date(2025, 6, 15, 9, 0, 0, 0, time.Local)
+
+	tests := []struct {
+		expr string
+		at   time.Time
+		want bool
+	}{
+		{"* 17-23 * * 5", friday, true},
+		{"* 17-23 * * 1-4", friday, false},
+		{"5/15 * * * *", friday, true},
+		{"0 9 * * 0", sunday, true},
+		{"0 9 * * 7", sunday, true},
+		{"0 9 * * 1", sunday, false},
+		// Both day fields restricted: either one may match
+		{"20 18 1 * 5", friday, true},
+		{"20 18 13 * 1", friday, true},
+		{"20 18 1 * 1", friday, false},
+		{"* * * 12 *", friday, false},
+	}
+	for _, tt := range tests {
+		got, err := cronMatches(tt.expr, tt.at)
+		if err != nil {
+			t.Errorf("cronMatches(%q): %v", tt.expr, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("cronMatches(%q, %s) = %v, want %v", tt.expr, tt.at, got, tt.want)
+		}
+	}
+
+	if _, err := cronMatches("* * *", friday); err == nil {
+		t.Error("cronMatches accepted an expression with 3 fields")
+	}
+}
+
+func TestBlackoutWindowActive(t *testing.T) {
+	at := func(value string) time.Time {
+		t, err := time.ParseInLocation("2006-01-02 15:04", value, time.Local)
+		if err != nil {
+			panic(err)
+		}
+		return t
+	}
+
+	tests := []struct {
+		name   string
+		window BlackoutWindow
+		at     time.Time
+		want   bool
+	}{
+		{"cron inside", BlackoutWindow{Cron: "* 17-23 * * 5"}, at("2025-06-13 18:00"), true},
+		{"cron outside", BlackoutWindow{Cron: "* 17-23 * * 5"}, at("2025-06-13 16:59"), false},
+		{"date range covers the whole last day", BlackoutWindow{From: "2025-12-24", To: "2025-12-26"}, at("2025-12-26 23:59"), true},
+		{"date range ends after the last day", BlackoutWindow{From: "2025-12-24", To: "2025-12-26"}, at("2025-12-27 00:00"), false},
+		{"before the range", BlackoutWindow{From: "2025-12-24", To: "2025-12-26"}, at("2025-12-23 23:59"), false},
+		{"time range end is exclusive", BlackoutWindow{From: "2025-12-24 08:00", To: "2025-12-24 10:00"}, at("2025-12-24 10:00"), false},
+		{"open-ended range", BlackoutWindow{From: "2025-12-24 08:00"}, at("2030-01-01 00:00"), true},
+	}
+	for _, tt := range tests {
+		got, err := tt.window.Active(tt.at)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: Active = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	if _, err := (BlackoutWindow{}).Active(time.Now()); err == nil {
+		t.Error("Active accepted a window without cron or from/to")
+	}
+	if _, err := (BlackoutWindow{From: "next week"}).Active(time.Now()); err == nil {
+		t.Error("Active accepted an invalid from")
+	}
+}
+
+func TestBlackoutWindowActiveOnDSTDays(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip(err)
+	}
+	local := time.Local
+	time.Local = berlin
+	t.Cleanup(func() { time.Local = local })
+
+	// The day clocks go back has 25 hours, the day they go forward 23
+	for _, day := range []string{"2025-10-26", "2025-03-30"} {
+		window := BlackoutWindow{From: day, To: day}
+		last, _ := time.ParseInLocation("2006-01-02 15:04", day+" 23:30", berlin)
+		if got, err := window.Active(last); err != nil || !got {
+			t.Errorf("%s: Active at 23:30 = %v, %v, want true", day, got, err)
+		}
+		next := time.Date(last.Year(), last.Month(), last.Day()+1, 0, 0, 0, 0, berlin)
+		if got, err := window.Active(next); err != nil || got {
+			t.Errorf("%s: Active at the next midnight = %v, %v, want false", day, got, err)
+		}
+	}
+}
+
+func TestBlackoutWindowValidate(t *testing.T) {
+	valid := []BlackoutWindow{
+		{Cron: "* 17-23 * * 5", Action: BlackoutDefer},
+		{Cron: "0,30 */2 1-7 1,6,12 0-7"},
+		{From: "2025-12-24", To: "2025-12-24"},
+		{From: "2025-12-24 08:00", Action: BlackoutSkip},
+		{To: "2025-12-26"},
+	}
+	for _, window := range valid {
+		if err := window.Validate(); err != nil {
+			t.Errorf("Validate(%+v): %v", window, err)
+		}
+	}
+
+	invalid := []BlackoutWindow{
+		{},
+		{Cron: "* * * *"},
+		{Cron: "* 17-25 * * 5"},
+		// An invalid item after one that matches
+		{Cron: "0,99 * * * *"},
+		{Cron: "* * * * 5", Action: "pause"},
+		{From: "next week"},
+		{From: "2025-12-26", To: "2025-12-24"},
+		{From: "2025-12-24 10:00", To: "2025-12-24 08:00"},
+	}
+	for _, window := range invalid {
+		if err := window.Validate(); err == nil {
+			t.Errorf("Validate accepted %+v", window)
+		}
+	}
+
+	if err := ValidateBlackout([]BlackoutWindow{valid[0], invalid[1]}); err == nil {
+		t.Error("ValidateBlackout accepted an invalid window")
+	}
+}
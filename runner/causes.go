@@ -0,0 +1,79 @@
+package runner
+
+import (
+	"fmt"
+	"regexp"
+
+	"pipego/ansi"
+	"pipego/runner/storage"
+)
+
+// FailureCause is a rule naming why a step failed, e.g. "network_timeout" when
+// its output matches "ETIMEDOUT". Every condition that is set must match.
+// Output patterns are matched against the tail of the output a step keeps in
+// memory, its last 64 KB: the lines explaining a failure are usually the last
+// ones, and reading back a log that may be many megabytes would hold up the run.
+type FailureCause struct {
+	Cause    string `yaml:"cause" json:"cause"`
+	Output   string `yaml:"output,omitempty" json:"output,omitempty"` // Regular expression matched against the end of the output
+	ExitCode *int   `yaml:"exit_code,omitempty" json:"exit_code,omitempty"`
+	Category string `yaml:"category,omitempty" json:"category,omitempty"` // Category of the step
+	Reason   string `yaml:"reason,omitempty" json:"reason,omitempty"`     // Failure reason, e.g. "memory_limit" or "timeout"
+
+	pattern *regexp.Regexp // Output, compiled by Validate
+}
+
+// FailureCauses are checked in order, the first rule a failed step matches names its cause
+type FailureCauses []FailureCause
+
+// Validate checks that each rule has a cause, at least one condition and a
+// valid pattern, and compiles the patterns for classify
+func (c FailureCauses) Validate() error {
+	for i, rule := range c {
+		if rule.Cause == "" {
+			return fmt.Errorf("failure cause %d has no name", i+1)
+		}
+		if rule.Output == "" && rule.ExitCode == nil && rule.Category == "" && rule.Reason == "" {
+			return fmt.Errorf("failure cause '%s' has no condition", rule.Cause)
+		}
+		if rule.Output != "" {
+			pattern, err := regexp.Compile(rule.Output)
+			if err != nil {
+				return fmt.Errorf("invalid output pattern of failure cause '%s': %w", rule.Cause, err)
+			}
+			c[i].pattern = pattern
+		}
+	}
+	return nil
+}
+
+// classify returns the cause of the first rule the failed step matches, or "".
+// The rules must have been validated; output patterns that were not compiled
+// don't match.
+func (c FailureCauses) classify(step Step, failure storage.Failure, output string) string {
+	text := ""
+	for _, rule := range c {
+		if rule.Category != "" && rule.Category != step.Category {
+			continue
+		}
+		if rule.Reason != "" && rule.Reason != failure.Reason {
+			continue
+		}
+		if rule.ExitCode != nil && (failure.ExitCode == nil || *failure.ExitCode != *rule.ExitCode) {
+			continue
+		}
+		if rule.Output != "" {
+			if rule.pattern == nil {
+				continue
+			}
+			if text == "" {
+				text = ansi.ConvertString(output, ansi.FormatText)
+			}
+			if !rule.pattern.MatchString(text) {
+				continue
+			}
+		}
+		return rule.Cause
+	}
+	return ""
+}
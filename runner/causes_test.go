@@ -0,0 +1,128 @@
+package runner
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"pipego/runner/storage"
+)
+
+func TestFailureCausesClassify(t *testing.T) {
+	exitCode := func(code int) *int { return &code }
+	causes := FailureCauses{
+		{Cause: "oom", Reason: FailureMemoryLimit},
+		{Cause: "network_timeout", Output: `(?i)(ETIMEDOUT|i/o timeout)`},
+		{Cause: "lint", Category: "lint", ExitCode: exitCode(1)},
+		{Cause: "test_failure", Category: "test", Output: `(?m)^--- FAIL`},
+		{Cause: "killed", ExitCode: exitCode(137)},
+	}
+	unvalidated := FailureCauses{{Cause: "network_timeout", Output: "ETIMEDOUT"}}
+	if got := unvalidated.classify(Step{}, storage.Failure{Reason: storage.FailureCommandFailed}, "ETIMEDOUT"); got != "" {
+		t.Errorf("classify before Validate = %q, want no match", got)
+	}
+	if err := causes.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	failed := func(reason string, code int) storage.Failure {
+		return storage.Failure{Reason: reason, ExitCode: exitCode(code)}
+	}
+
+	tests := []struct {
+		name    string
+		step    Step
+		failure storage.Failure
+		output  string
+		want    string
+	}{
+		{"reason", Step{}, failed(FailureMemoryLimit, 137), "", "oom"},
+		{"output", Step{}, failed(storage.FailureCommandFailed, 1), "dial tcp: i/o timeout", "network_timeout"},
+		{"output without escape sequences", Step{}, failed(storage.FailureCommandFailed, 1), "\x1b[31mETIMED\x1b[0mOUT", "network_timeout"},
+		{"category and exit code", Step{Category: "lint"}, failed(storage.FailureCommandFailed, 1), "", "lint"},
+		{"category with another exit code", Step{Category: "lint"}, failed(storage.FailureCommandFailed, 2), "", ""},
+		{"exit code in another category", Step{Category: "build"}, failed(storage.FailureCommandFailed, 1), "", ""},
+		{"category and output", Step{Category: "test"}, failed(storage.FailureCommandFailed, 1), "ok\n--- FAIL: TestX", "test_failure"},
+		{"output in another category", Step{}, failed(storage.FailureCommandFailed, 1), "--- FAIL: TestX", ""},
+		{"first matching rule", Step{Category: "lint"}, failed(storage.FailureCommandFailed, 1), "ETIMEDOUT", "network_timeout"},
+		{"exit code", Step{}, failed(storage.FailureCommandFailed, 137), "", "killed"},
+		{"no exit code", Step{}, storage.Failure{Reason: storage.FailureTimeout}, "", ""},
+		{"no match", Step{}, failed(storage.FailureCommandFailed, 2), "undefined: foo", ""},
+	}
+	for _, tt := range tests {
+		if got := causes.classify(tt.step, tt.failure, tt.output); got != tt.want {
+			t.Errorf("%s: classify = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	if got := FailureCauses(nil).classify(Step{}, failed(storage.FailureCommandFailed, 1), "ETIMEDOUT"); got != "" {
+		t.Errorf("classify without rules = %q", got)
+	}
+}
+
+func TestFailureCausesValidate(t *testing.T) {
+	zero := 0
+	valid := FailureCauses{
+		{Cause: "network", Output: "ETIMEDOUT"},
+		{Cause: "success_exit", ExitCode: &zero},
+		{Cause: "lint", Category: "lint"},
+		{Cause: "oom", Reason: FailureMemoryLimit},
+	}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("Validate: %v", err)
+	}
+
+	invalid := []FailureCause{
+		{Output: "ETIMEDOUT"},
+		{Cause: "anything"},
+		{Cause: "bad_pattern", Output: "(unclosed"},
+	}
+	for _, rule := range invalid {
+		if err := (FailureCauses{rule}).Validate(); err == nil {
+			t.Errorf("Validate accepted %+v", rule)
+		}
+	}
+}
+
+func TestStepFailureCause(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "pipego.yml")
+	config := `failure_causes:
+  - cause: network_timeout
+    output: "connection timed out"
+parts:
+  fetch:
+    steps:
+      - name: fetch
+        run: "echo 'connection timed out' >&2; exit 1"
+  build:
+    steps:
+      - name: build
+        run: "echo 'undefined: foo' >&2; exit 1"
+  buried:
+    steps:
+      - name: fetch
+        run: "echo 'connection timed out' >&2; yes trace | head -n 20000; exit 1"
+`
+	if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Server rules apply after the project's own. Only the last 64 KB of output
+	// are matched, so a line followed by a long trace is not seen.
+	server := FailureCauses{{Cause: "exit_zero", ExitCode: new(int)}, {Cause: "command_failed", Reason: storage.FailureCommandFailed}}
+	if err := server.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	for part, want := range map[string]string{"fetch": "network_timeout", "build": "command_failed", "buried": "command_failed"} {
+		result, err := RunPipelineWithOptions(configPath, RunPipelineOptions{PartFilter: part, FailureCauses: server})
+		if err == nil {
+			t.Fatalf("part %s succeeded", part)
+		}
+		if len(result.Steps) != 1 || result.Steps[0].Failure == nil || result.Steps[0].Failure.Cause != want {
+			t.Fatalf("part %s: step failure = %+v, want cause %s", part, result.Steps, want)
+		}
+		if result.Failure == nil || result.Failure.Cause != want {
+			t.Errorf("part %s: run failure = %+v, want cause %s", part, result.Failure, want)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+//go:build linux
+
+package runner
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// cgroupRoot is where the unified (v2) cgroup hierarchy is mounted
+const cgroupRoot = "/sys/fs/cgroup"
+
+var cgroupSeq atomic.Int64
+
+// stepCgroup is a cgroup v2 created for a single step, so its memory and process
+// limits cover every process it spawns. A nil *stepCgroup enforces nothing.
+type stepCgroup struct {
+	path      string
+	dir       *os.File
+	memory    bool
+	processes bool
+}
+
+// newStepCgroup creates a cgroup below the one of the server that enforces the
+// memory and process limits. It returns nil if cgroups v2 are not available or
+// not delegated to the server, in which case rlimits are used instead.
+func newStepCgroup(limits stepLimits) *stepCgroup {
+	if limits.memoryBytes == 0 && limits.Processes == 0 {
+		return nil
+	}
+
+	parent, err := ownCgroup()
+	if err != nil {
+		return nil
+	}
+
+	// Best effort: fails if the server's cgroup also holds processes and no
+	// controllers were enabled for it by the service manager
+	var controllers []string
+	if limits.memoryBytes > 0 {
+		controllers = append(controllers, "+memory")
+	}
+	if limits.Processes > 0 {
+		controllers = append(controllers, "+pids")
+	}
+	_ = os.WriteFile(filepath.Join(parent, "cgroup.subtree_control"), []byte(strings.Join(controllers, " ")), 0644)
+
+	path := filepath.Join(parent, fmt.Sprintf("pipego-%d-step-%d", os.Getpid(), cgroupSeq.Add(1)))
+	if err := os.Mkdir(path, 0755); err != nil {
+		return nil
+	}
+	cg := &stepCgroup{path: path}
+
+	available, _ := os.ReadFile(filepath.Join(path, "cgroup.controllers"))
+	enabled := strings.Fields(string(available))
+	if limits.memoryBytes > 0 && slices.Contains(enabled, "memory") {
+		value := []byte(strconv.FormatInt(limits.memoryBytes, 10))
+		if os.WriteFile(filepath.Join(path, "memory.max"), value, 0644) == nil {
+			// Without this the limit only pushes the step into swap
+			_ = os.WriteFile(filepath.Join(path, "memory.swap.max"), []byte("0"), 0644)
+			cg.memory = true
+		}
+	}
+	if limits.Processes > 0 && slices.Contains(enabled, "pids") {
+		value := []byte(strconv.Itoa(limits.Processes))
+		if os.WriteFile(filepath.Join(path, "pids.max"), value, 0644) == nil {
+			cg.processes = true
+		}
+	}
+
+	if !cg.memory && !cg.processes {
+		os.Remove(path)
+		return nil
+	}
+
+	cg.dir, err = os.Open(path)
+	if err != nil {
+		os.Remove(path)
+		return nil
+	}
+	return cg
+}
+
+// ownCgroup returns the directory of the cgroup v2 the server runs in
+func ownCgroup() (string, error) {
+	if _, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers")); err != nil {
+		return "", fmt.Errorf("cgroups v2 not mounted: %w", err)
+	}
+
+	data, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if rel, ok := strings.CutPrefix(line, "0::"); ok {
+			return filepath.Join(cgroupRoot, rel), nil
+		}
+	}
+	return "", fmt.Errorf("no cgroup v2 entry in /proc/self/cgroup")
+}
+
+// apply starts the command directly in the cgroup
+func (c *stepCgroup) apply(cmd *exec.Cmd) {
+	if c == nil {
+		return
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(c.dir.Fd())
+}
+
+func (c *stepCgroup) limitsMemory() bool {
+	return c != nil && c.memory
+}
+
+func (c *stepCgroup) limitsProcesses() bool {
+	return c != nil && c.processes
+}
+
+// violation returns the limit the cgroup enforced while the step ran, if any
+func (c *stepCgroup) violation() string {
+	if c == nil {
+		return ""
+	}
+	if c.memory && readCgroupEvent(filepath.Join(c.path, "memory.events"), "oom_kill") > 0 {
+		return FailureMemoryLimit
+	}
+	if c.processes && readCgroupEvent(filepath.Join(c.path, "pids.events"), "max") > 0 {
+		return FailureProcessesLimit
+	}
+	return ""
+}
+
+// remove deletes the cgroup once the processes of the step are gone
+func (c *stepCgroup) remove() {
+	if c == nil {
+		return
+	}
+	c.dir.Close()
+	os.Remove(c.path)
+}
+
+// readCgroupEvent reads a counter from a cgroup events file such as memory.events
+func readCgroupEvent(path, name string) int64 {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == name {
+			n, _ := strconv.ParseInt(fields[1], 10, 64)
+			return n
+		}
+	}
+	return 0
+}
@@ -0,0 +1,28 @@
+//go:build !linux
+
+package runner
+
+import "os/exec"
+
+// stepCgroup is only available on Linux, elsewhere limits are applied with rlimits
+type stepCgroup struct{}
+
+func newStepCgroup(limits stepLimits) *stepCgroup {
+	return nil
+}
+
+func (c *stepCgroup) apply(cmd *exec.Cmd) {}
+
+func (c *stepCgroup) limitsMemory() bool {
+	return false
+}
+
+func (c *stepCgroup) limitsProcesses() bool {
+	return false
+}
+
+func (c *stepCgroup) violation() string {
+	return ""
+}
+
+func (c *stepCgroup) remove() {}
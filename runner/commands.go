@@ -0,0 +1,158 @@
+package runner
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"pipego/runner/storage"
+)
+
+// Workflow commands are lines of step output such as
+//
+//	::group::Install
+//	::endgroup::
+//	::warning file=x.go,line=10,col=3,title=Unused::msg
+//	::error::msg
+//
+// They stay in the output and are recorded as annotations of the step.
+
+// workflowCommand is a parsed workflow command line
+type workflowCommand struct {
+	name    string
+	params  map[string]string
+	message string
+}
+
+// parseWorkflowCommand parses a line of output as a workflow command
+func parseWorkflowCommand(line string) (workflowCommand, bool) {
+	rest, ok := strings.CutPrefix(strings.TrimSpace(line), "::")
+	if !ok {
+		return workflowCommand{}, false
+	}
+	spec, message, ok := strings.Cut(rest, "::")
+	if !ok {
+		return workflowCommand{}, false
+	}
+
+	name, paramList, _ := strings.Cut(spec, " ")
+	cmd := workflowCommand{name: name, params: make(map[string]string), message: unescapeCommandData(message)}
+	for _, param := range strings.Split(paramList, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+		if ok && key != "" {
+			cmd.params[key] = unescapeCommandProperty(value)
+		}
+	}
+	return cmd, true
+}
+
+// unescapeCommandData decodes the escapes used in command messages
+func unescapeCommandData(s string) string {
+	return strings.NewReplacer("%0D", "\r", "%0A", "\n", "%25", "%").Replace(s)
+}
+
+// unescapeCommandProperty decodes the escapes used in command parameters
+func unescapeCommandProperty(s string) string {
+	return strings.NewReplacer("%0D", "\r", "%0A", "\n", "%3A", ":", "%2C", ",", "%25", "%").Replace(s)
+}
+
+// maxStepAnnotations is how many annotations a step can record; later
+// commands are left out
+const maxStepAnnotations = 1000
+
+// annotationCollector turns the workflow commands in the output of a step into annotations
+type annotationCollector struct {
+	runID       int
+	stepID      int
+	annotations []*storage.Annotation
+	open        []*storage.Annotation // Groups without their ::endgroup:: yet, innermost last; nil for those left out
+	stopped     bool                  // Whether later commands are left out, see stop
+	dropped     int                   // Commands left out
+}
+
+// add records the command on a line of output, if it is one. logLine is the line's number.
+// It returns the annotation added, or nil.
+func (c *annotationCollector) add(text string, logLine int) *storage.Annotation {
+	cmd, ok := parseWorkflowCommand(text)
+	if !ok {
+		return nil
+	}
+	full := c.stopped || len(c.annotations) >= maxStepAnnotations
+
+	switch cmd.name {
+	case "group":
+		if full {
+			// Kept open as nil, so its ::endgroup:: doesn't end another group
+			c.dropped++
+			c.open = append(c.open, nil)
+			return nil
+		}
+		group := &storage.Annotation{RunID: c.runID, StepID: c.stepID, Type: storage.AnnotationGroup, Message: cmd.message, LogLine: logLine}
+		c.annotations = append(c.annotations, group)
+		c.open = append(c.open, group)
+		return group
+	case "endgroup":
+		if len(c.open) > 0 {
+			if group := c.open[len(c.open)-1]; group != nil {
+				group.EndLogLine = &logLine
+			}
+			c.open = c.open[:len(c.open)-1]
+		}
+		return nil
+	case storage.AnnotationNotice, storage.AnnotationWarning, storage.AnnotationError:
+		if full {
+			c.dropped++
+			return nil
+		}
+		annotation := &storage.Annotation{
+			RunID:   c.runID,
+			StepID:  c.stepID,
+			Type:    cmd.name,
+			Message: cmd.message,
+			Title:   cmd.params["title"],
+			File:    cmd.params["file"],
+			Line:    intParam(cmd.params["line"]),
+			Col:     intParam(cmd.params["col"]),
+			LogLine: logLine,
+		}
+		c.annotations = append(c.annotations, annotation)
+		return annotation
+	default:
+		return nil
+	}
+}
+
+// droppedNote says in the log how many commands were left out and why
+func (c *annotationCollector) droppedNote() string {
+	return fmt.Sprintf("[... %d workflow commands not recorded as annotations: a step records at most %d, and none after the log size limit ...]",
+		c.dropped, maxStepAnnotations)
+}
+
+// stop leaves out the commands after lastLine, e.g. once the lines after it
+// are no longer numbered as in the log, and closes the groups still open there
+func (c *annotationCollector) stop(lastLine int) {
+	c.finish(lastLine)
+	c.stopped = true
+}
+
+// finish closes the groups still open at the last line of output and returns
+// every annotation
+func (c *annotationCollector) finish(lastLine int) []*storage.Annotation {
+	for _, group := range c.open {
+		if group != nil {
+			end := lastLine
+			group.EndLogLine = &end
+		}
+	}
+	c.open = nil
+	return c.annotations
+}
+
+// intParam parses a numeric command parameter, nil if it is missing or invalid
+func intParam(value string) *int {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return nil
+	}
+	return &n
+}
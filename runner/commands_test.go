@@ -0,0 +1,164 @@
+package runner
+
+import (
+	"maps"
+	"testing"
+
+	"pipego/runner/storage"
+)
+
+func TestParseWorkflowCommand(t *testing.T) {
+	tests := []struct {
+		line string
+		ok   bool
+		want workflowCommand
+	}{
+		{"::group::Install", true, workflowCommand{name: "group", message: "Install"}},
+		{"::endgroup::", true, workflowCommand{name: "endgroup"}},
+		{"::error::build failed", true, workflowCommand{name: "error", message: "build failed"}},
+		{"  ::notice::indented  ", true, workflowCommand{name: "notice", message: "indented"}},
+		{"::warning file=x.go,line=10,col=3,title=Unused::x is unused", true, workflowCommand{
+			name:    "warning",
+			params:  map[string]string{"file": "x.go", "line": "10", "col": "3", "title": "Unused"},
+			message: "x is unused",
+		}},
+		{"::warning file=a.go, line=2 ,=bad,novalue::m", true, workflowCommand{
+			name:    "warning",
+			params:  map[string]string{"file": "a.go", "line": "2"},
+			message: "m",
+		}},
+		{"::error title=a%3Ab%2Cc%25d::line one%0Aline two%0D%253A", true, workflowCommand{
+			name:    "error",
+			params:  map[string]string{"title": "a:b,c%d"},
+			message: "line one\nline two\r%3A",
+		}},
+		{"::error::a message with :: inside", true, workflowCommand{name: "error", message: "a message with :: inside"}},
+		{"plain output", false, workflowCommand{}},
+		{"echo ::error::not at the start", false, workflowCommand{}},
+		{"::error without a message", false, workflowCommand{}},
+		{":error::single colon", false, workflowCommand{}},
+	}
+	for _, tt := range tests {
+		got, ok := parseWorkflowCommand(tt.line)
+		if ok != tt.ok {
+			t.Errorf("parseWorkflowCommand(%q) ok = %v, want %v", tt.line, ok, tt.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if tt.want.params == nil {
+			tt.want.params = map[string]string{}
+		}
+		if got.name != tt.want.name || got.message != tt.want.message || !maps.Equal(got.params, tt.want.params) {
+			t.Errorf("parseWorkflowCommand(%q) = %+v, want %+v", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestAnnotationCollector(t *testing.T) {
+	c := &annotationCollector{runID: 1, stepID: 2}
+	lines := []string{
+		"::group::Install",                    // 1
+		"npm install",                         // 2
+		"::group::Nested",                     // 3
+		"::warning::deprecated",               // 4
+		"::endgroup::",                        // 5
+		"::endgroup::",                        // 6
+		"::endgroup::",                        // 7, nothing open
+		"::debug::ignored",                    // 8
+		"::error file=a.go,line=x,col=4::bad", // 9
+		"::group::Test",                       // 10
+		"go test ./...",                       // 11
+	}
+	annotated := map[int]bool{1: true, 3: true, 4: true, 9: true, 10: true}
+	for i, line := range lines {
+		if added := c.add(line, i+1); (added != nil) != annotated[i+1] {
+			t.Errorf("line %d %q: added %+v", i+1, line, added)
+		}
+	}
+	annotations := c.finish(len(lines))
+
+	type want struct {
+		kind    string
+		message string
+		logLine int
+		end     int // 0 for none
+	}
+	wants := []want{
+		{storage.AnnotationGroup, "Install", 1, 6},
+		{storage.AnnotationGroup, "Nested", 3, 5},
+		{storage.AnnotationWarning, "deprecated", 4, 0},
+		{storage.AnnotationError, "bad", 9, 0},
+		{storage.AnnotationGroup, "Test", 10, 11},
+	}
+	if len(annotations) != len(wants) {
+		t.Fatalf("got %d annotations, want %d: %+v", len(annotations), len(wants), annotations)
+	}
+	for i, w := range wants {
+		a := annotations[i]
+		end := 0
+		if a.EndLogLine != nil {
+			end = *a.EndLogLine
+		}
+		if a.RunID != 1 || a.StepID != 2 || a.Type != w.kind || a.Message != w.message || a.LogLine != w.logLine || end != w.end {
+			t.Errorf("annotation %d = %+v (end %d), want %+v", i, a, end, w)
+		}
+	}
+
+	// Invalid numbers are left out rather than stored as zero
+	errAnnotation := annotations[3]
+	if errAnnotation.File != "a.go" || errAnnotation.Line != nil || errAnnotation.Col == nil || *errAnnotation.Col != 4 {
+		t.Errorf("error annotation = %+v", errAnnotation)
+	}
+}
+
+func TestAnnotationCollectorLimit(t *testing.T) {
+	c := &annotationCollector{}
+	c.add("::group::Outer", 1)
+	for i := 2; i <= maxStepAnnotations; i++ {
+		c.add("::warning::w", i)
+	}
+	// Past the limit; the left out group still takes its ::endgroup::
+	line := maxStepAnnotations + 1
+	for _, text := range []string{"::group::Inner", "::error::e", "::endgroup::", "::endgroup::"} {
+		if added := c.add(text, line); added != nil {
+			t.Errorf("line %d %q added %+v past the limit", line, text, added)
+		}
+		line++
+	}
+
+	annotations := c.finish(line)
+	if len(annotations) != maxStepAnnotations {
+		t.Fatalf("got %d annotations, want %d", len(annotations), maxStepAnnotations)
+	}
+	if end := annotations[0].EndLogLine; end == nil || *end != maxStepAnnotations+4 {
+		t.Errorf("outer group ends at %v, want line %d", end, maxStepAnnotations+4)
+	}
+	if c.dropped != 2 {
+		t.Errorf("dropped = %d, want 2", c.dropped)
+	}
+}
+
+func TestAnnotationCollectorStop(t *testing.T) {
+	c := &annotationCollector{}
+	c.add("::group::Build", 1)
+	c.add("::notice::kept", 2)
+	c.stop(3)
+	for i, text := range []string{"::warning::late", "::group::Late", "::endgroup::", "::endgroup::"} {
+		if added := c.add(text, 4+i); added != nil {
+			t.Errorf("%q added %+v after stop", text, added)
+		}
+	}
+
+	annotations := c.finish(10)
+	if len(annotations) != 2 {
+		t.Fatalf("got %d annotations, want 2: %+v", len(annotations), annotations)
+	}
+	if end := annotations[0].EndLogLine; end == nil || *end != 3 {
+		t.Errorf("group ends at %v, want line 3 where collecting stopped", end)
+	}
+	if c.dropped != 2 {
+		t.Errorf("dropped = %d, want 2", c.dropped)
+	}
+}
@@ -1,12 +1,16 @@
 package runner
 
 import (
-	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
+	"sort"
 	"time"
 
 	"pipego/runner/storage"
@@ -18,27 +22,97 @@ func RunPipeline(configPath string) error {
 	return err
 }
 
-// RunPipelineWithOptions executes a pipeline with options for storage and streaming
+// RunPipelineWithOptions executes a pipeline with options for storage and streaming.
+// With storage, the part runs are grouped under one execution record.
 func RunPipelineWithOptions(configPath string, opts RunPipelineOptions) (*PipelineResult, error) {
+	if opts.Storage == nil {
+		return runPipeline(configPath, opts)
+	}
+
 	startTime := time.Now()
 
-	cfg, err := LoadConfig(configPath)
-	if err != nil {
-		return nil, err
+	// Start the execution created when the pipeline was queued, or create one
+	if opts.ExecutionID != 0 {
+		if err := opts.Storage.StartExecution(opts.ExecutionID); err != nil {
+			return nil, failWith(storage.FailureStorageError, err)
+		}
+	} else {
+		trigger := opts.Trigger
+		if trigger == "" {
+			trigger = "cli"
+		}
+		execution, err := opts.Storage.CreateExecution(configPath, filepath.Base(filepath.Dir(configPath)), trigger, "running")
+		if err != nil {
+			return nil, failWith(storage.FailureStorageError, err)
+		}
+		opts.ExecutionID = execution.ID
+
+		if opts.Commit != nil {
+			if err := opts.Storage.SetExecutionCommit(execution.ID, *opts.Commit); err != nil {
+				return nil, failWith(storage.FailureStorageError, err)
+			}
+		}
 	}
 
-	// Change to the directory where config file is located
-	configDir := filepath.Dir(configPath)
-	originalDir, err := os.Getwd()
+	result, err := runPipeline(configPath, opts)
+
+	status := "success"
+	errorMsg := ""
 	if err != nil {
-		return nil, fmt.Errorf("failed to get current directory: %w", err)
+		status = "failed"
+		errorMsg = err.Error()
+		if result != nil && result.Status == "cancelled" {
+			status = "cancelled"
+		} else if result == nil && opts.Context != nil && opts.Context.Err() != nil {
+			status = "cancelled"
+		}
+	}
+	if finishErr := opts.Storage.FinishExecution(opts.ExecutionID, status, time.Since(startTime), errorMsg); finishErr != nil {
+		log.Printf("⚠️  Failed to finish execution %d: %v", opts.ExecutionID, finishErr)
 	}
 
-	err = os.Chdir(configDir)
+	if result != nil {
+		result.ExecutionID = opts.ExecutionID
+	}
+	return result, err
+}
+
+// runPipeline executes the parts of a pipeline one after another
+func runPipeline(configPath string, opts RunPipelineOptions) (*PipelineResult, error) {
+	startTime := time.Now()
+
+	ctx := opts.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	// Read the config once so the execution records exactly what ran
+	data := opts.ConfigSnapshot
+	if data == nil {
+		var err error
+		data, err = os.ReadFile(configPath)
+		if err != nil {
+			err = failWith(storage.FailureConfigError, err)
+			abandonRuns(opts, opts.Runs, "failed", fmt.Sprintf("failed to load config: %v", err), FailureOf(err))
+			return nil, err
+		}
+	}
+
+	cfg, err := ParseConfig(data)
 	if err != nil {
-		return nil, fmt.Errorf("failed to change to config directory: %w", err)
+		err = failWith(storage.FailureConfigError, err)
+		abandonRuns(opts, opts.Runs, "failed", fmt.Sprintf("failed to load config: %v", err), FailureOf(err))
+		return nil, err
 	}
-	defer os.Chdir(originalDir)
+
+	if opts.Storage != nil && opts.ExecutionID != 0 {
+		if err := opts.Storage.SetExecutionConfig(opts.ExecutionID, string(data)); err != nil {
+			log.Printf("⚠️  Failed to store config snapshot of execution %d: %v", opts.ExecutionID, err)
+		}
+	}
+
+	// Steps run in the directory where config file is located
+	configDir := filepath.Dir(configPath)
 
 	// Extract project name from config path (directory name)
 	projectName := filepath.Base(configDir)
@@ -46,65 +120,244 @@ func RunPipelineWithOptions(configPath string, opts RunPipelineOptions) (*Pipeli
 	// Get all parts from config
 	allParts := cfg.GetAllParts()
 
+	partsToRun, err := selectParts(allParts, opts)
+	if err == nil {
+		err = cfg.Workspace.Validate()
+	}
+	if err == nil && opts.ResumeFromStep > 0 && checkoutWorkspace(cfg.Workspace, opts).Isolated() {
+		err = ErrResumeIsolated
+	}
+	if err == nil {
+		err = cfg.FailureCauses.Validate()
+	}
+	if err == nil {
+		err = cfg.Retry.Validate()
+	}
+	if err != nil {
+		err = failWith(storage.FailureConfigError, err)
+		abandonRuns(opts, opts.Runs, "failed", err.Error(), FailureOf(err))
+		return nil, err
+	}
+
+	// Wait for a free slot when the project limits concurrent pipelines
+	if opts.Locks != nil {
+		if lockName, limit := opts.Locks.ProjectLock(configPath); limit > 0 {
+			// The run queue takes the slot before starting the pipeline
+			release, held := takeHeldLock(opts, lockName)
+			if !held {
+				release, err = opts.Locks.Acquire(ctx, LockRequest{
+					Name:     lockName,
+					Capacity: limit,
+					Project:  projectName,
+					RunIDs:   queuedRunIDs(opts, partsToRun),
+				})
+				if err != nil {
+					reason := fmt.Sprintf("cancelled while waiting for lock '%s'", lockName)
+					err = &FailureError{Failure: storage.Failure{Reason: storage.FailureCancelled, Message: reason}, Err: err}
+					abandonRuns(opts, opts.Runs, "cancelled", reason, FailureOf(err))
+					return nil, err
+				}
+			}
+			defer release()
+		}
+	}
+
 	result := &PipelineResult{
 		RunID:  0,
 		Steps:  make([]StepResult, 0),
 		Status: "running",
 	}
 
-	// Execute each part
-	for fullPartPath, steps := range allParts {
-		// Skip this part if filter is set and doesn't match
-		if opts.PartFilter != "" && fullPartPath != opts.PartFilter {
-			continue
+	// Steps run in the project directory, or in a copy of it made for this run
+	workDir := configDir
+	workspaceConfig := checkoutWorkspace(cfg.Workspace, opts)
+	if workspaceConfig.Isolated() {
+		workspace, err := createWorkspace(workspaceConfig, configDir, projectName, opts)
+		if err != nil {
+			err = failWith(storage.FailureStorageError, err)
+			abandonRuns(opts, opts.Runs, "failed", err.Error(), FailureOf(err))
+			return nil, err
+		}
+		defer workspace.finish(workspaceConfig, result)
+		workDir = workspace.dir
+
+		if opts.StreamToTerminal {
+			fmt.Println("📁 Workspace:", workDir)
 		}
+	}
+
+	// Failed steps are classified by the rules of the project, then those of the server
+	causes := slices.Concat(cfg.FailureCauses, opts.FailureCauses)
+
+	retryPolicy := cfg.Retry
+	if retryPolicy == nil {
+		retryPolicy = opts.RetryPolicy
+	}
+
+	// Execute each part
+	for i, fullPartPath := range partsToRun {
+		steps := allParts[fullPartPath]
 
 		// Parse part name to extract group (e.g., "frontend.deploy" -> "frontend", "deploy")
 		groupName, partName := ParsePartName(fullPartPath)
 
+		// Parts after this one will not run if this one does not succeed
+		remaining := make(map[string]*storage.Run)
+		for _, next := range partsToRun[i+1:] {
+			if queued, ok := opts.Runs[next]; ok {
+				remaining[next] = queued
+			}
+		}
+
+		// The part context is cancelled on its own when a newer run
+		// supersedes it in its concurrency group
+		partCtx, cancelPart := context.WithCancelCause(ctx)
+
+		release, err := acquirePartLock(partCtx, cancelPart, cfg, fullPartPath, projectName, opts)
+		if err != nil {
+			cancelPart(nil)
+			err = failWith(storage.FailureCancelled, err)
+			result.Status = "cancelled"
+			result.Duration = time.Since(startTime)
+			result.Error = err
+			result.Failure = FailureOf(err)
+
+			abandonRuns(opts, partRuns(opts, fullPartPath), "cancelled", err.Error(), result.Failure)
+			abandonRuns(opts, remaining, "skipped", "pipeline cancelled", nil)
+
+			return result, err
+		}
+
+		partStart := time.Now()
+
 		if opts.StreamToTerminal {
 			if fullPartPath != "default" {
 				fmt.Printf("\n📦 Part: %s\n", fullPartPath)
 			}
 		}
 
-		// Create run in database for this part if storage is provided
+		// Create run in database for this part if storage is provided,
+		// or start the run that was created when the pipeline was queued
 		var run *storage.Run
 		if opts.Storage != nil {
-			run, err = opts.Storage.CreateRun(configPath, projectName, groupName, partName)
+			if queued, ok := opts.Runs[fullPartPath]; ok {
+				run = queued
+				err = opts.Storage.StartRun(run.ID)
+			} else {
+				run, err = opts.Storage.CreateRun(configPath, projectName, groupName, partName)
+				if err == nil && opts.ExecutionID != 0 {
+					err = opts.Storage.SetRunExecution(run.ID, opts.ExecutionID)
+				}
+				if err == nil && opts.ParentRunID != 0 {
+					err = opts.Storage.SetRunParent(run.ID, opts.ParentRunID)
+				}
+			}
 			if err != nil {
-				return nil, fmt.Errorf("failed to create run: %w", err)
+				release()
+				cancelPart(nil)
+				return nil, failWith(storage.FailureStorageError, fmt.Errorf("failed to create run: %w", err))
 			}
 			result.RunID = run.ID
+
+			if opts.Commit != nil {
+				if err := opts.Storage.SetRunCommit(run.ID, *opts.Commit); err != nil {
+					release()
+					cancelPart(nil)
+					return nil, failWith(storage.FailureStorageError, err)
+				}
+			}
+
+			if workspaceConfig.Isolated() {
+				if err := opts.Storage.SetRunWorkspace(run.ID, workDir); err != nil {
+					log.Printf("⚠️  Failed to record workspace of run %d: %v", run.ID, err)
+				}
+			}
+			if checksOutCommit(opts) {
+				if err := opts.Storage.SetRunCheckedOut(run.ID); err != nil {
+					log.Printf("⚠️  Failed to record checkout of run %d: %v", run.ID, err)
+				}
+			}
 		}
 
-		// Execute each step in the part
-		for _, step := range steps {
-			stepResult, err := executeStep(step, groupName, partName, result.RunID, opts)
+		// Execute each step in the part, sharing the stored output limit of the run
+		logBudget := newRunLogBudget(opts.DefaultLimits)
+		for stepIndex, step := range steps {
+			// A resumed run starts at the step that failed before
+			if i == 0 && stepIndex < opts.ResumeFromStep {
+				result.Steps = append(result.Steps, skipStep(step, groupName, partName, result.RunID, opts))
+				continue
+			}
+
+			stepResult, err := executeStep(partCtx, step, groupName, partName, workDir, result.RunID, logBudget, causes, opts)
 			
 			result.Steps = append(result.Steps, stepResult)
 			
 			if err != nil {
 				result.Status = "failed"
+				reason := fmt.Sprintf("part '%s' failed", fullPartPath)
+				hasCause := false
+				if stepResult.Status == "stalled" {
+					// Keep why the run failed, so hung runs can be told apart
+					hasCause = true
+					reason = err.Error()
+				}
+				if partCtx.Err() != nil {
+					result.Status = "cancelled"
+					reason = "pipeline cancelled"
+
+					// Record why, e.g. a newer run in the concurrency group or a server shutdown
+					if cause := context.Cause(partCtx); cause != nil && !errors.Is(cause, context.Canceled) {
+						hasCause = true
+						reason = cause.Error()
+					}
+				}
 				result.Duration = time.Since(startTime)
 				result.Error = err
+				result.Failure = FailureOf(err)
+
+				// A transient failure runs this part and the ones after it again,
+				// and only notifies if the retry fails too. Like failure causes,
+				// retry patterns see the last recentOutputLimit bytes of output.
+				retrying := opts.AutoRetry && opts.Storage != nil && result.Status == "failed" &&
+					retryPolicy.shouldRetry(result.Failure, stepResult.Output, opts.RetryAttempt)
+				skipReason := reason
+				if retrying {
+					result.RetryParts = partsToRun[i:]
+					skipReason = fmt.Sprintf("part '%s' failed, retrying", fullPartPath)
+				}
 
 				// Update run status in database
 				if opts.Storage != nil {
-					_ = opts.Storage.UpdateRunStatus(result.RunID, "failed", result.Duration)
+					_ = opts.Storage.UpdateRunStatus(result.RunID, result.Status, time.Since(partStart))
+					if hasCause {
+						_ = opts.Storage.SetRunReason(result.RunID, reason)
+					}
+					_ = opts.Storage.SetRunFailure(result.RunID, *result.Failure)
+					if retrying {
+						publishRunRetrying(result.RunID, result.Failure, opts.RetryAttempt+1)
+					} else {
+						publishRunFinished(result.RunID, result.Status, result.Failure)
+					}
 				}
 
+				release()
+				cancelPart(nil)
+				abandonRuns(opts, remaining, "skipped", skipReason, nil)
+
 				return result, err
 			}
 		}
 
+		release()
+		cancelPart(nil)
+
 		// Update run status for this part
 		if opts.Storage != nil {
-			partDuration := time.Since(startTime)
-			err = opts.Storage.UpdateRunStatus(result.RunID, "success", partDuration)
+			err = opts.Storage.UpdateRunStatus(result.RunID, "success", time.Since(partStart))
 			if err != nil {
-				return nil, fmt.Errorf("failed to update run status: %w", err)
+				return nil, failWith(storage.FailureStorageError, fmt.Errorf("failed to update run status: %w", err))
 			}
+			publishRunFinished(result.RunID, "success", nil)
 		}
 	}
 
@@ -118,8 +371,127 @@ func RunPipelineWithOptions(configPath string, opts RunPipelineOptions) (*Pipeli
 	return result, nil
 }
 
+// ErrPartNotFound is returned when a requested part is not defined in the config
+var ErrPartNotFound = errors.New("part not found")
+
+// selectParts returns the parts to run in order: the explicit part list, the
+// part filter, or every part of the config sorted by name
+func selectParts(allParts map[string][]Step, opts RunPipelineOptions) ([]string, error) {
+	selected := opts.Parts
+	if len(selected) == 0 && opts.PartFilter != "" {
+		selected = []string{opts.PartFilter}
+	}
+
+	if len(selected) == 0 {
+		for fullPartPath := range allParts {
+			selected = append(selected, fullPartPath)
+		}
+		sort.Strings(selected)
+		return selected, nil
+	}
+
+	for _, fullPartPath := range selected {
+		if _, exists := allParts[fullPartPath]; !exists {
+			return nil, fmt.Errorf("%w: '%s'", ErrPartNotFound, fullPartPath)
+		}
+	}
+	return selected, nil
+}
+
+// acquirePartLock waits for the concurrency group of a part, if it has one, and
+// returns the function releasing it. cancel is called when a newer run in the
+// group supersedes this one.
+func acquirePartLock(ctx context.Context, cancel context.CancelCauseFunc, cfg *Config, fullPartPath, projectName string, opts RunPipelineOptions) (func(), error) {
+	concurrency := cfg.GetPartConcurrency(fullPartPath)
+	if concurrency == nil || opts.Locks == nil {
+		return func() {}, nil
+	}
+	if release, held := takeHeldLock(opts, concurrency.Group); held {
+		return release, nil
+	}
+
+	release, err := opts.Locks.Acquire(ctx, LockRequest{
+		Name:             concurrency.Group,
+		Capacity:         1,
+		Project:          projectName,
+		Part:             fullPartPath,
+		RunIDs:           queuedRunIDs(opts, []string{fullPartPath}),
+		Cancel:           cancel,
+		CancelInProgress: concurrency.CancelInProgress(),
+	})
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			return nil, fmt.Errorf("cancelled while waiting for lock '%s'", concurrency.Group)
+		}
+		return nil, err
+	}
+	return release, nil
+}
+
+// takeHeldLock takes over a lock the caller acquired before starting the
+// pipeline, so only the first part of a concurrency group uses it
+func takeHeldLock(opts RunPipelineOptions, name string) (func(), bool) {
+	release, held := opts.HeldLocks[name]
+	if held {
+		delete(opts.HeldLocks, name)
+	}
+	return release, held
+}
+
+// queuedRunIDs returns the IDs of the queued runs of the given parts
+func queuedRunIDs(opts RunPipelineOptions, parts []string) []int {
+	ids := make([]int, 0, len(parts))
+	for _, fullPartPath := range parts {
+		if run, ok := opts.Runs[fullPartPath]; ok {
+			ids = append(ids, run.ID)
+		}
+	}
+	return ids
+}
+
+// partRuns returns the queued run of a part as a map for abandonRuns
+func partRuns(opts RunPipelineOptions, fullPartPath string) map[string]*storage.Run {
+	runs := make(map[string]*storage.Run)
+	if run, ok := opts.Runs[fullPartPath]; ok {
+		runs[fullPartPath] = run
+	}
+	return runs
+}
+
+// abandonRuns closes queued runs that will never start, recording the failure
+// that stopped them unless it is nil (e.g. for skipped runs)
+func abandonRuns(opts RunPipelineOptions, runs map[string]*storage.Run, status, reason string, failure *storage.Failure) {
+	if opts.Storage == nil {
+		return
+	}
+	for _, run := range runs {
+		_ = opts.Storage.AbandonRun(run.ID, status, reason)
+		if failure != nil {
+			_ = opts.Storage.SetRunFailure(run.ID, *failure)
+		}
+		publishRunFinished(run.ID, status, failure)
+	}
+}
+
+// skipStep records a step that is not executed because the run resumes after it
+func skipStep(step Step, groupName, partName string, runID int, opts RunPipelineOptions) StepResult {
+	if opts.StreamToTerminal {
+		fmt.Println("⏭️ ", step.Name, "(already succeeded)")
+	}
+
+	if opts.Storage != nil {
+		stepExec, err := opts.Storage.CreateStepExecution(runID, step.Name, step.Run, groupName, partName, step.Category)
+		if err == nil {
+			output := fmt.Sprintf("Skipped: succeeded in run #%d\n", opts.ParentRunID)
+			_ = opts.Storage.UpdateStepExecution(stepExec.ID, "skipped", output, 0)
+		}
+	}
+
+	return StepResult{Name: step.Name, Status: "skipped"}
+}
+
 // executeStep executes a single step and returns its result
-func executeStep(step Step, groupName, partName string, runID int, opts RunPipelineOptions) (StepResult, error) {
+func executeStep(ctx context.Context, step Step, groupName, partName, workDir string, runID int, logBudget *runLogBudget, causes FailureCauses, opts RunPipelineOptions) (StepResult, error) {
 	stepStart := time.Now()
 
 	if opts.StreamToTerminal {
@@ -138,23 +510,130 @@ func executeStep(step Step, groupName, partName string, runID int, opts RunPipel
 	if opts.Storage != nil {
 		stepExec, err = opts.Storage.CreateStepExecution(runID, step.Name, step.Run, groupName, partName, category)
 		if err != nil {
-			return StepResult{}, fmt.Errorf("failed to create step execution: %w", err)
+			return StepResult{}, failWith(storage.FailureStorageError, fmt.Errorf("failed to create step execution: %w", err))
 		}
 	}
 
+	var noOutputTimeout time.Duration
+	if step.NoOutputTimeout != "" {
+		noOutputTimeout, err = time.ParseDuration(step.NoOutputTimeout)
+		if err == nil && noOutputTimeout <= 0 {
+			err = fmt.Errorf("must be positive")
+		}
+		if err != nil {
+			err = fmt.Errorf("invalid no_output_timeout '%s': %w", step.NoOutputTimeout, err)
+			return failInvalidStep(step, stepExec, stepStart, opts, err)
+		}
+	}
+
+	limits, err := resolveLimits(step.Limits, opts.DefaultLimits)
+	if err != nil {
+		return failInvalidStep(step, stepExec, stepStart, opts, err)
+	}
+
+	// Memory and process limits use a cgroup for the step where possible
+	cg := newStepCgroup(limits)
+	defer cg.remove()
+
+	// Watch the output of the step: heartbeats while it writes, and a kill once it
+	// has been silent for longer than no_output_timeout
+	stepCtx, cancelStep := context.WithCancelCause(ctx)
+	activity := newActivityWriter()
+	watch := stepWatch{
+		runID:     runID,
+		step:      step.Name,
+		groupName: groupName,
+		partName:  partName,
+		timeout:   noOutputTimeout,
+	}
+	if stepExec != nil {
+		watch.stepID = stepExec.ID
+	}
+	go watchStep(stepCtx, cancelStep, activity, watch, opts)
+
+	// Publish the output line by line and store it in chunks while the step runs
+	stepOut := newStepOutput(runID, watch.stepID, step.Name, opts.Storage, logBudget.stepLimit(limits.logBytes))
+	defer stepOut.close()
+	stopFlushing := stepOut.startFlushing(logFlushInterval)
+
+	// A limit this host enforces differently is noted at the start of the log
+	if note := limits.fallbackNote(cg); note != "" {
+		stepOut.addLine(storage.StreamSystem, "["+note+"]")
+	}
+
+	// The step can publish a markdown report of its own in PIPEGO_SUMMARY
+	summary, summaryErr := newSummaryFile()
+	if summaryErr != nil {
+		log.Printf("⚠️  %v", summaryErr)
+	}
+
 	// Execute the command and capture output
-	output, err := executeShellCommand(step.Run, opts.StreamToTerminal)
+	output, state, err := executeShellCommand(stepCtx, limits.shellPrefix(cg)+step.Run, workDir, summary.env(), opts.StreamToTerminal, stepOut, activity, cg)
+	cancelStep(nil)
+	stopFlushing()
 	stepDuration := time.Since(stepStart)
 
+	// The log file holds the output; the database only keeps it when there is no file
+	storedOutput := output
+	if stepOut.log != nil {
+		logBudget.use(stepOut.finishLog(opts.Storage))
+		storedOutput = ""
+	}
+	if opts.Storage != nil && stepExec != nil {
+		if err := opts.Storage.AddAnnotations(stepOut.finishAnnotations()); err != nil {
+			log.Printf("⚠️  Failed to store annotations of step %d: %v", stepExec.ID, err)
+		}
+	}
+
 	stepResult := StepResult{
 		Name:     step.Name,
 		Output:   output,
 		Duration: stepDuration,
+		Usage:    stepUsage(state),
 	}
 
+	stepResult.Summary, summaryErr = summary.collect()
+	if summaryErr != nil {
+		log.Printf("⚠️  %v", summaryErr)
+	}
+	if opts.Storage != nil && stepExec != nil && stepResult.Summary != "" {
+		if err := opts.Storage.SetStepSummary(stepExec.ID, stepResult.Summary); err != nil {
+			log.Printf("⚠️  Failed to store summary of step %d: %v", stepExec.ID, err)
+		}
+	}
+
+	if opts.Storage != nil && stepExec != nil && stepResult.Usage != nil {
+		_ = opts.Storage.SetStepUsage(stepExec.ID, *stepResult.Usage)
+	}
+
+	var stall *StallError
 	if err != nil {
 		stepResult.Status = "failed"
+		failure := storage.Failure{Reason: storage.FailureCommandFailed}
+		if stepResult.Usage != nil {
+			failure.ExitCode = stepResult.Usage.ExitCode
+		}
+		if errors.As(context.Cause(stepCtx), &stall) && ctx.Err() == nil {
+			// Killed by the watchdog
+			stepResult.Status = "stalled"
+			failure.Reason = storage.FailureTimeout
+			err = stall
+		} else if ctx.Err() != nil {
+			// Killed because the run was cancelled, not because the command failed
+			stepResult.Status = "cancelled"
+			failure.Reason = storage.FailureCancelled
+			err = ctx.Err()
+		} else if reason := limits.violation(cg, stepResult.Usage); reason != "" {
+			failure.Reason = reason
+			err = fmt.Errorf("%s: %w", limits.describe(reason), err)
+		}
+		failure.Message = err.Error()
+		if failure.Reason != storage.FailureCancelled {
+			failure.Cause = causes.classify(step, failure, output)
+		}
 		stepResult.Error = err
+		stepResult.Failure = &failure
+		stepOut.setResult(stepResult.Status, &failure)
 
 		if opts.StreamToTerminal {
 			fmt.Println("❌ Step failed:", err)
@@ -162,13 +641,16 @@ func executeStep(step Step, groupName, partName string, runID int, opts RunPipel
 
 		// Update step execution in database
 		if opts.Storage != nil && stepExec != nil {
-			_ = opts.Storage.UpdateStepExecution(stepExec.ID, "failed", output, stepDuration)
+			_ = opts.Storage.UpdateStepExecution(stepExec.ID, stepResult.Status, storedOutput, stepDuration)
+			_ = opts.Storage.SetStepFailure(stepExec.ID, failure)
+			opts.Storage.QueueStepIndex(stepExec.ID)
 		}
 
-		return stepResult, fmt.Errorf("step '%s' failed: %w", step.Name, err)
+		return stepResult, stepFailed(step, stepResult.Status, failure, err)
 	}
 
 	stepResult.Status = "success"
+	stepOut.setResult(stepResult.Status, nil)
 
 	if opts.StreamToTerminal {
 		fmt.Println("✅ Done:", step.Name)
@@ -176,26 +658,99 @@ func executeStep(step Step, groupName, partName string, runID int, opts RunPipel
 
 	// Update step execution in database
 	if opts.Storage != nil && stepExec != nil {
-		err = opts.Storage.UpdateStepExecution(stepExec.ID, "success", output, stepDuration)
+		err = opts.Storage.UpdateStepExecution(stepExec.ID, "success", storedOutput, stepDuration)
 		if err != nil {
-			return StepResult{}, fmt.Errorf("failed to update step execution: %w", err)
+			return StepResult{}, failWith(storage.FailureStorageError, fmt.Errorf("failed to update step execution: %w", err))
 		}
+		opts.Storage.QueueStepIndex(stepExec.ID)
 	}
 
 	return stepResult, nil
 }
 
-// executeShellCommand executes a shell command and captures its output
-func executeShellCommand(command string, streamToTerminal bool) (string, error) {
-	cmd := exec.Command("bash", "-c", command)
+// failInvalidStep fails a step whose settings are invalid without running it
+func failInvalidStep(step Step, stepExec *storage.StepExecution, stepStart time.Time, opts RunPipelineOptions, err error) (StepResult, error) {
+	if opts.StreamToTerminal {
+		fmt.Println("❌ Step failed:", err)
+	}
+	failure := storage.Failure{Reason: storage.FailureConfigError, Message: err.Error()}
+	if opts.Storage != nil && stepExec != nil {
+		_ = opts.Storage.UpdateStepExecution(stepExec.ID, "failed", err.Error()+"\n", time.Since(stepStart))
+		_ = opts.Storage.SetStepFailure(stepExec.ID, failure)
+	}
+	return StepResult{Name: step.Name, Status: "failed", Failure: &failure, Error: err}, stepFailed(step, "failed", failure, err)
+}
+
+// stepFailed returns the error of a step that did not succeed, carrying its
+// failure with the step name for the run
+func stepFailed(step Step, status string, failure storage.Failure, err error) error {
+	failure.Step = step.Name
+	return &FailureError{Failure: failure, Err: fmt.Errorf("step '%s' %s: %w", step.Name, status, err)}
+}
+
+// stepUsage reads how a finished step exited and the resources it used,
+// or returns nil if its process never started
+func stepUsage(state *os.ProcessState) *storage.StepUsage {
+	if state == nil {
+		return nil
+	}
+
+	usage := &storage.StepUsage{
+		Signal:   exitSignal(state),
+		MaxRSSKB: maxRSSKB(state),
+	}
+	if exitCode := state.ExitCode(); exitCode >= 0 {
+		usage.ExitCode = &exitCode
+	}
+	userMs := state.UserTime().Milliseconds()
+	systemMs := state.SystemTime().Milliseconds()
+	usage.UserCPUMs = &userMs
+	usage.SystemCPUMs = &systemMs
 
-	var stdout, stderr bytes.Buffer
-	var stdoutWriters []io.Writer
-	var stderrWriters []io.Writer
+	return usage
+}
 
-	// Always capture output
-	stdoutWriters = append(stdoutWriters, &stdout)
-	stderrWriters = append(stderrWriters, &stderr)
+// colorEnv asks tools to colour their output even though it goes to a pipe,
+// unless the environment says otherwise. Logs keep the escape sequences and
+// the API renders them on request.
+func colorEnv(env []string) []string {
+	if os.Getenv("FORCE_COLOR") == "" && os.Getenv("NO_COLOR") == "" {
+		env = append(env, "FORCE_COLOR=1")
+	}
+	if term := os.Getenv("TERM"); term == "" || term == "dumb" {
+		env = append(env, "TERM=xterm-256color")
+	}
+	return env
+}
+
+// executeShellCommand executes a shell command in workDir, with env added to the
+// environment, and captures its output
+// The command is killed if ctx is cancelled before it finishes,
+// together with every process it spawned. Output is collected line by line in
+// output, with stdout and stderr interleaved as written and each line tagged with
+// its stream and time, and also written to activity.
+// The process state is nil if the command could not be started. cg may be nil.
+func executeShellCommand(ctx context.Context, command, workDir string, env []string, streamToTerminal bool, output *stepOutput, activity io.Writer, cg *stepCgroup) (string, *os.ProcessState, error) {
+	cmd := exec.CommandContext(ctx, "bash", "-c", command)
+	cmd.Dir = workDir
+	cmd.Env = append(colorEnv(os.Environ()), env...)
+	setProcessGroup(cmd)
+	cg.apply(cmd)
+	cmd.Cancel = func() error {
+		return killProcessGroup(cmd)
+	}
+	// Don't wait forever on output pipes held open by processes that left the group
+	cmd.WaitDelay = 5 * time.Second
+
+	// Always capture output, each stream split into lines on its own
+	stdout := &lineWriter{out: output, stream: storage.StreamStdout}
+	stderr := &lineWriter{out: output, stream: storage.StreamStderr}
+	stdoutWriters := []io.Writer{stdout}
+	stderrWriters := []io.Writer{stderr}
+	if activity != nil {
+		stdoutWriters = append(stdoutWriters, activity)
+		stderrWriters = append(stderrWriters, activity)
+	}
 
 	// Optionally also stream to terminal
 	if streamToTerminal {
@@ -208,11 +763,9 @@ func executeShellCommand(command string, streamToTerminal bool) (string, error)
 
 	err := cmd.Run()
 
-	// Combine stdout and stderr
-	combinedOutput := stdout.String() + stderr.String()
-	if len(combinedOutput) > 0 && combinedOutput[len(combinedOutput)-1] != '\n' {
-		combinedOutput += "\n"
-	}
+	// Keep output that did not end with a newline
+	stdout.Close()
+	stderr.Close()
 
-	return combinedOutput, err
+	return output.String(), cmd.ProcessState, err
 }
@@ -0,0 +1,180 @@
+package runner
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"pipego/runner/storage"
+)
+
+func TestStepOutputLines(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "pipego.yml")
+	config := `parts:
+  build:
+    steps:
+      - name: build
+        run: "echo one; sleep 0.1; echo two >&2; sleep 0.1; echo three; sleep 0.1; printf 'no newline' >&2"
+`
+	if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	store, err := storage.NewStorage(filepath.Join(dir, "pipego.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	result, err := RunPipelineWithOptions(configPath, RunPipelineOptions{Storage: store})
+	if err != nil {
+		t.Fatal(err)
+	}
+	steps, err := store.GetStepExecutions(result.RunID)
+	if err != nil || len(steps) != 1 {
+		t.Fatalf("GetStepExecutions = %d steps, %v", len(steps), err)
+	}
+	lines, _, err := store.ReadStepLines(steps[0], 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The streams stay interleaved in the order the lines were written
+	want := []storage.OutputLine{
+		{Stream: storage.StreamStdout, Text: "one"},
+		{Stream: storage.StreamStderr, Text: "two"},
+		{Stream: storage.StreamStdout, Text: "three"},
+		{Stream: storage.StreamStderr, Text: "no newline"},
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("lines = %+v, want %+v", lines, want)
+	}
+	var previous int64
+	for i, line := range lines {
+		if line.Line != i+1 || line.Stream != want[i].Stream || line.Text != want[i].Text {
+			t.Errorf("line %d = %+v, want %+v", i+1, line, want[i])
+		}
+		if line.OffsetMs == nil || *line.OffsetMs < previous {
+			t.Errorf("line %d offset %v is before the previous line's %d", i+1, line.OffsetMs, previous)
+			continue
+		}
+		previous = *line.OffsetMs
+	}
+	if previous < 250 {
+		t.Errorf("last line written %dms after the start, want at least 250ms", previous)
+	}
+}
+
+func TestStepAnnotationsStopAtLogLimit(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "pipego.yml")
+	config := `parts:
+  build:
+    steps:
+      - name: build
+        limits:
+          log_size: 4K
+        run: |
+          echo '::group::Build'
+          echo '::notice::early'
+          for i in $(seq 1000); do echo "line $i"; done
+          echo '::warning::late'
+          echo '::endgroup::'
+`
+	if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	store, err := storage.NewStorage(filepath.Join(dir, "pipego.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	result, err := RunPipelineWithOptions(configPath, RunPipelineOptions{Storage: store})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The warning comes after the log was truncated, so its line is not in the
+	// log; the group ends at the last line before the truncation
+	annotations, err := store.GetAnnotations(result.RunID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(annotations) != 2 || annotations[0].Type != storage.AnnotationGroup || annotations[1].Message != "early" {
+		t.Fatalf("annotations = %+v, want the group and the early notice", annotations)
+	}
+	steps, err := store.GetStepExecutions(result.RunID)
+	if err != nil || len(steps) != 1 {
+		t.Fatalf("GetStepExecutions = %d steps, %v", len(steps), err)
+	}
+	lines, _, err := store.ReadStepLines(steps[0], 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	end := annotations[0].EndLogLine
+	if end == nil || *end >= len(lines) || !strings.HasPrefix(lines[*end-1].Text, "line ") || !strings.HasPrefix(lines[*end].Text, "[... ") {
+		t.Errorf("group ends at line %v, want the last line before the truncation marker", end)
+	}
+	if last := lines[len(lines)-1]; last.Stream != storage.StreamSystem || !strings.Contains(last.Text, "1 workflow commands not recorded") {
+		t.Errorf("last line = %+v, want a note on the left out commands", last)
+	}
+}
+
+func TestTruncatedOutputKeepsLineNumbers(t *testing.T) {
+	store, err := storage.NewStorage(filepath.Join(t.TempDir(), "pipego.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	run, err := store.CreateRun("pipego.yml", "app", "", "build")
+	if err != nil {
+		t.Fatal(err)
+	}
+	step, err := store.CreateStepExecution(run.ID, "build", "make", "", "build", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 8 bytes per line: 6 lines fit in each half of the limit, 8 are left out
+	o := newStepOutput(run.ID, step.ID, "build", store, 100)
+	defer o.close()
+	for i := 1; i <= 20; i++ {
+		o.addLine(storage.StreamStdout, fmt.Sprintf("line %02d", i))
+	}
+	numbers := func(lines []storage.OutputLine) []int {
+		var numbers []int
+		for _, line := range lines {
+			numbers = append(numbers, line.Line)
+		}
+		return numbers
+	}
+	want := []int{1, 2, 3, 4, 5, 6, 7, 15, 16, 17, 18, 19, 20}
+
+	// A client connecting while the step runs gets the kept tail, numbered as published
+	_, live, first, ok := LiveStepOutput(run.ID)
+	if !ok || first != 1 || !slices.Equal(numbers(live), want) || !strings.Contains(live[6].Text, "8 lines") {
+		t.Fatalf("live output = %+v from line %d, want lines %v", live, first, want)
+	}
+	o.flush()
+	if _, live, first, _ = LiveStepOutput(run.ID); first != 7 || !slices.Equal(numbers(live), want[6:]) {
+		t.Errorf("live output after a flush = %v from line %d, want %v from line 7", numbers(live), first, want[6:])
+	}
+
+	// The stored log numbers its lines the same way
+	o.finishLog(store)
+	stored, err := store.GetStepExecution(run.ID, step.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines, _, err := store.ReadStepLines(stored, 1, 0)
+	if err != nil || !slices.Equal(numbers(lines), want) || lines[7].Text != "line 15" {
+		t.Errorf("stored lines = %+v, %v, want lines %v", lines, err, want)
+	}
+	if lines, _, err := store.ReadStepLines(stored, 16, 2); err != nil || !slices.Equal(numbers(lines), []int{16, 17}) {
+		t.Errorf("stored lines from 16 = %v, %v", numbers(lines), err)
+	}
+}
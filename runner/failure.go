@@ -0,0 +1,45 @@
+package runner
+
+import (
+	"context"
+	"errors"
+
+	"pipego/runner/storage"
+)
+
+// FailureError is an error that knows why the run or step failed
+type FailureError struct {
+	Failure storage.Failure
+	Err     error
+}
+
+func (e *FailureError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *FailureError) Unwrap() error {
+	return e.Err
+}
+
+// failWith wraps err with the reason it makes a run or step fail
+func failWith(reason string, err error) error {
+	return &FailureError{Failure: storage.Failure{Reason: reason, Message: err.Error()}, Err: err}
+}
+
+// FailureOf describes the error returned by a pipeline run, nil if there is none.
+// Errors that were not classified count as a failed command, or a cancellation
+// when they come from a cancelled context.
+func FailureOf(err error) *storage.Failure {
+	if err == nil {
+		return nil
+	}
+	var failed *FailureError
+	if errors.As(err, &failed) {
+		failure := failed.Failure
+		return &failure
+	}
+	if errors.Is(err, context.Canceled) {
+		return &storage.Failure{Reason: storage.FailureCancelled, Message: err.Error()}
+	}
+	return &storage.Failure{Reason: storage.FailureCommandFailed, Message: err.Error()}
+}
@@ -0,0 +1,51 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"pipego/runner/storage"
+)
+
+func TestFailureOf(t *testing.T) {
+	exitCode := 2
+	classified := &FailureError{
+		Failure: storage.Failure{Reason: storage.FailureTimeout, ExitCode: &exitCode, Message: "no output for 1s", Step: "build"},
+		Err:     errors.New("no output for 1s"),
+	}
+
+	tests := []struct {
+		name string
+		err  error
+		want *storage.Failure
+	}{
+		{"no error", nil, nil},
+		{"classified", classified, &classified.Failure},
+		{"wrapped", fmt.Errorf("part build: %w", classified), &classified.Failure},
+		{"failWith", failWith(storage.FailureConfigError, errors.New("invalid yaml")), &storage.Failure{Reason: storage.FailureConfigError, Message: "invalid yaml"}},
+		{"cancelled context", fmt.Errorf("waiting: %w", context.Canceled), &storage.Failure{Reason: storage.FailureCancelled, Message: "waiting: context canceled"}},
+		{"unclassified", errors.New("exit status 1"), &storage.Failure{Reason: storage.FailureCommandFailed, Message: "exit status 1"}},
+	}
+	for _, tt := range tests {
+		got := FailureOf(tt.err)
+		if (got == nil) != (tt.want == nil) {
+			t.Errorf("%s: FailureOf = %+v, want %+v", tt.name, got, tt.want)
+			continue
+		}
+		if got == nil {
+			continue
+		}
+		sameExitCode := (got.ExitCode == nil) == (tt.want.ExitCode == nil) && (got.ExitCode == nil || *got.ExitCode == *tt.want.ExitCode)
+		if got.Reason != tt.want.Reason || got.Message != tt.want.Message || got.Step != tt.want.Step || !sameExitCode {
+			t.Errorf("%s: FailureOf = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+
+	// The failure is a copy, changing it leaves the error alone
+	FailureOf(classified).Cause = "changed"
+	if classified.Failure.Cause != "" {
+		t.Error("FailureOf returned the failure of the error itself")
+	}
+}
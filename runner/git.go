@@ -0,0 +1,341 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"pipego/events"
+	"pipego/runner/storage"
+)
+
+// defaultGitInterval is used when a git trigger has no interval configured
+const defaultGitInterval = time.Minute
+
+// gitFetchTimeout bounds a fetch, so an unreachable remote doesn't stall polling
+const gitFetchTimeout = 2 * time.Minute
+
+// GitPoller runs the git triggers of all projects while the server is up
+type GitPoller struct {
+	projectsConfig *ProjectsConfig
+	queue          *RunQueue
+	baseDir        string
+	stopChan       chan struct{}
+}
+
+// NewGitPoller creates a new git poller instance
+func NewGitPoller(projectsConfig *ProjectsConfig, queue *RunQueue, baseDir string) *GitPoller {
+	return &GitPoller{
+		projectsConfig: projectsConfig,
+		queue:          queue,
+		baseDir:        baseDir,
+		stopChan:       make(chan struct{}),
+	}
+}
+
+// Start polls every project with a git trigger until Stop is called
+func (g *GitPoller) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for _, project := range g.projectsConfig.Projects {
+		configPath := project.GetPipegoPath(g.baseDir)
+		cfg, err := LoadConfig(configPath)
+		if err != nil || cfg.Git == nil {
+			continue
+		}
+
+		interval := defaultGitInterval
+		if cfg.Git.Interval != "" {
+			interval, err = parseInterval(cfg.Git.Interval)
+			if err != nil {
+				log.Printf("⚠️  Invalid git interval '%s' in %s: %v", cfg.Git.Interval, project.Name, err)
+				continue
+			}
+		}
+
+		log.Printf("🌿 Polling git repository of %s every %s", project.Name, interval)
+
+		wg.Add(1)
+		go func(projectName, configPath string, interval time.Duration) {
+			defer wg.Done()
+			g.pollProject(ctx, projectName, configPath, interval)
+		}(project.Name, configPath, interval)
+	}
+
+	<-g.stopChan
+	cancel()
+	wg.Wait()
+	log.Println("🌿 Git poller stopped")
+}
+
+// Stop stops polling and cancels runs started by the poller
+func (g *GitPoller) Stop() {
+	close(g.stopChan)
+}
+
+// pollProject checks the repository for advanced branches on every interval.
+// Branch heads seen on the first poll are the baseline and do not trigger runs.
+func (g *GitPoller) pollProject(ctx context.Context, projectName, configPath string, interval time.Duration) {
+	repoDir := filepath.Dir(configPath)
+	fetch := func() bool {
+		cfg, err := LoadConfig(configPath)
+		return err == nil && cfg.Git != nil && cfg.Git.Fetch
+	}
+
+	known, err := fetchBranchHeads(ctx, repoDir, projectName, fetch())
+	if err != nil {
+		log.Printf("⚠️  Git trigger disabled for %s: %v", projectName, err)
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		// Reload config so edits to pipego.yml are picked up
+		cfg, err := LoadConfig(configPath)
+		heads, headsErr := fetchBranchHeads(ctx, repoDir, projectName, err == nil && cfg.Git != nil && cfg.Git.Fetch)
+		if headsErr != nil {
+			log.Printf("⚠️  Failed to read git branches of %s: %v", projectName, headsErr)
+			continue
+		}
+		if err != nil || cfg.Git == nil {
+			known = heads
+			continue
+		}
+
+		// A commit on both a local branch and its remote-tracking branch runs once
+		triggered := make(map[string]bool)
+		for _, ref := range advancedBranches(known, heads, cfg.Git.Branches) {
+			if ctx.Err() != nil {
+				return
+			}
+			branch, sha := branchOfRef(ref), heads[ref]
+			if triggered[branch+" "+sha] {
+				continue
+			}
+			triggered[branch+" "+sha] = true
+			g.runGitTrigger(ctx, projectName, configPath, cfg, branch, sha)
+		}
+		known = heads
+	}
+}
+
+// fetchBranchHeads returns the branch heads to compare between polls: the
+// local branches by name and, when fetch is set, the remote-tracking branches
+// by their full ref name after fetching the remotes. A failed fetch is logged
+// and the last fetched heads are used.
+func fetchBranchHeads(ctx context.Context, repoDir, projectName string, fetch bool) (map[string]string, error) {
+	heads, err := listBranchHeads(repoDir)
+	if err != nil || !fetch {
+		return heads, err
+	}
+
+	if err := fetchRemotes(ctx, repoDir); err != nil {
+		log.Printf("⚠️  Failed to fetch git remotes of %s: %v", projectName, err)
+	}
+	remoteHeads, err := listRemoteBranchHeads(repoDir)
+	if err != nil {
+		return nil, err
+	}
+	for ref, sha := range remoteHeads {
+		heads[ref] = sha
+	}
+	return heads, nil
+}
+
+// fetchRemotes updates the remote-tracking branches of every remote
+func fetchRemotes(ctx context.Context, repoDir string) error {
+	ctx, cancel := context.WithTimeout(ctx, gitFetchTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "git", "-C", repoDir, "fetch", "--all", "--prune", "--quiet")
+	// Never wait for credentials nobody can type
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	output, err := cmd.CombinedOutput()
+	if ctx.Err() != nil {
+		return fmt.Errorf("git fetch: %w", ctx.Err())
+	}
+	if err != nil {
+		return fmt.Errorf("git fetch: %s", strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// fetchCommit makes sure a commit is in the repository, fetching the remotes
+// if it isn't yet, e.g. when a webhook announces a push before anything fetched it
+func fetchCommit(ctx context.Context, repoDir, sha string) error {
+	if _, err := runGit(repoDir, "cat-file", "-e", sha+"^{commit}"); err == nil {
+		return nil
+	}
+	if err := fetchRemotes(ctx, repoDir); err != nil {
+		return err
+	}
+	if _, err := runGit(repoDir, "cat-file", "-e", sha+"^{commit}"); err != nil {
+		return fmt.Errorf("commit %s not found after fetching the remotes", shortSHA(sha))
+	}
+	return nil
+}
+
+// runGitTrigger queues the parts selected by the git trigger for a new commit.
+// The run checks the commit out in a worktree, since fetching doesn't move the
+// project directory to it. It doesn't wait for the run: the queue runs it, and
+// commits of one project one after another when the project limits its
+// concurrent pipelines.
+func (g *GitPoller) runGitTrigger(ctx context.Context, projectName, configPath string, cfg *Config, branch, sha string) {
+	repoDir := filepath.Dir(configPath)
+
+	commit, err := getCommitInfo(repoDir, sha)
+	if err != nil {
+		log.Printf("⚠️  Failed to read commit %s of %s: %v", sha, projectName, err)
+		commit = storage.CommitInfo{CommitSHA: sha}
+	}
+	commit.Branch = branch
+
+	partsToRun := resolveParts(cfg, cfg.Git.Parts, cfg.Git.Groups)
+
+	log.Printf("🌿 Branch %s of %s advanced to %s, triggering run", branch, projectName, shortSHA(sha))
+
+	events.GetBroker().Broadcast("run_started", map[string]interface{}{
+		"project": projectName,
+		"parts":   partsToRun,
+		"groups":  cfg.Git.Groups,
+		"type":    "git",
+		"branch":  branch,
+		"commit":  sha,
+	})
+
+	pipeline, err := g.queue.Enqueue(RunRequest{
+		ConfigPath: configPath,
+		Parts:      partsToRun,
+		Trigger:    "git",
+		Commit:     &commit,
+		Checkout:   true,
+		Context:    ctx,
+	})
+	if err != nil {
+		log.Printf("❌ Failed to queue git-triggered run for %s (%s): %v", projectName, branch, err)
+		return
+	}
+	log.Printf("📥 Queued git-triggered run(s) %v for %s (%s)", pipeline.RunIDs, projectName, branch)
+}
+
+// advancedBranches returns the branches of heads (see fetchBranchHeads) whose
+// head differs from the previously known one, including newly created
+// branches, if their branch name is watched
+func advancedBranches(known, heads map[string]string, patterns []string) []string {
+	advanced := make([]string, 0)
+	for ref, sha := range heads {
+		if known[ref] == sha || !branchWatched(branchOfRef(ref), patterns) {
+			continue
+		}
+		advanced = append(advanced, ref)
+	}
+	sort.Strings(advanced)
+	return advanced
+}
+
+// remoteRefPrefix starts the names of remote-tracking branches in branch heads
+const remoteRefPrefix = "refs/remotes/"
+
+// branchOfRef returns the branch name of a local branch or of a
+// remote-tracking one, e.g. "main" for "refs/remotes/origin/main"
+func branchOfRef(ref string) string {
+	if rest, ok := strings.CutPrefix(ref, remoteRefPrefix); ok {
+		_, branch, _ := strings.Cut(rest, "/")
+		return branch
+	}
+	return ref
+}
+
+// branchWatched reports whether a branch matches one of the patterns (no patterns = all)
+func branchWatched(branch string, patterns []string) bool {
+	return len(patterns) == 0 || globMatchAny(patterns, branch)
+}
+
+// listBranchHeads returns the head SHA of every local branch
+func listBranchHeads(repoDir string) (map[string]string, error) {
+	output, err := runGit(repoDir, "for-each-ref", "--format=%(refname:short) %(objectname)", "refs/heads/")
+	if err != nil {
+		return nil, err
+	}
+
+	heads := make(map[string]string)
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 {
+			heads[fields[0]] = fields[1]
+		}
+	}
+	return heads, nil
+}
+
+// listRemoteBranchHeads returns the head SHA of every remote-tracking branch,
+// by full ref name, e.g. "refs/remotes/origin/main"
+func listRemoteBranchHeads(repoDir string) (map[string]string, error) {
+	output, err := runGit(repoDir, "for-each-ref", "--format=%(refname) %(objectname)", remoteRefPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	heads := make(map[string]string)
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 || strings.HasSuffix(fields[0], "/HEAD") {
+			continue
+		}
+		heads[fields[0]] = fields[1]
+	}
+	return heads, nil
+}
+
+// getCommitInfo reads author and subject of a commit
+func getCommitInfo(repoDir, sha string) (storage.CommitInfo, error) {
+	output, err := runGit(repoDir, "log", "-1", "--format=%an%x00%s", sha)
+	if err != nil {
+		return storage.CommitInfo{}, err
+	}
+
+	fields := strings.SplitN(strings.TrimSpace(output), "\x00", 2)
+	commit := storage.CommitInfo{CommitSHA: sha, CommitAuthor: fields[0]}
+	if len(fields) == 2 {
+		commit.CommitMessage = fields[1]
+	}
+	return commit, nil
+}
+
+// runGit runs a git command inside the repository and returns its stdout
+func runGit(repoDir string, args ...string) (string, error) {
+	cmd := exec.Command("git", append([]string{"-C", repoDir}, args...)...)
+	output, err := cmd.Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return "", fmt.Errorf("git %s: %s", args[0], strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return "", fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return string(output), nil
+}
+
+// shortSHA abbreviates a commit SHA for logs
+func shortSHA(sha string) string {
+	if len(sha) > 7 {
+		return sha[:7]
+	}
+	return sha
+}
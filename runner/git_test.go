@@ -0,0 +1,196 @@
+package runner
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"pipego/runner/storage"
+)
+
+func TestAdvancedBranches(t *testing.T) {
+	known := map[string]string{"main": "a1", "dev": "b1", "release/1": "c1"}
+	heads := map[string]string{"main": "a2", "dev": "b1", "release/1": "c2", "release/2": "d1", "feature": "e1",
+		"refs/remotes/origin/hotfix": "f1"}
+
+	tests := []struct {
+		patterns []string
+		want     []string
+	}{
+		{nil, []string{"feature", "main", "refs/remotes/origin/hotfix", "release/1", "release/2"}},
+		{[]string{"main"}, []string{"main"}},
+		{[]string{"release/*"}, []string{"release/1", "release/2"}},
+		{[]string{"dev"}, []string{}},
+		{[]string{"hotfix"}, []string{"refs/remotes/origin/hotfix"}},
+	}
+	for _, tt := range tests {
+		got := advancedBranches(known, heads, tt.patterns)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("advancedBranches(%v) = %v, want %v", tt.patterns, got, tt.want)
+		}
+	}
+}
+
+// git runs a git command for a test, failing it on error
+func git(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=Test", "GIT_AUTHOR_EMAIL=test@example.com",
+		"GIT_COMMITTER_NAME=Test", "GIT_COMMITTER_EMAIL=test@example.com",
+	)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v: %v\n%s", args, err, output)
+	}
+	return string(output)
+}
+
+func TestFetchBranchHeadsSeesRemotePushes(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir := t.TempDir()
+	upstream := filepath.Join(dir, "upstream")
+	clone := filepath.Join(dir, "clone")
+
+	if err := os.Mkdir(upstream, 0755); err != nil {
+		t.Fatal(err)
+	}
+	git(t, upstream, "init", "-q", "-b", "main")
+	git(t, upstream, "commit", "-q", "--allow-empty", "-m", "first")
+	git(t, dir, "clone", "-q", upstream, clone)
+
+	before, err := fetchBranchHeads(context.Background(), clone, "test", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A push to the remote, which the clone has not fetched yet
+	git(t, upstream, "commit", "-q", "--allow-empty", "-m", "second")
+	sha := strings.TrimSpace(git(t, upstream, "rev-parse", "HEAD"))
+
+	// Without fetch the remote is left alone
+	unfetched, err := fetchBranchHeads(context.Background(), clone, "test", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := unfetched["refs/remotes/origin/main"]; ok || len(unfetched) != 1 {
+		t.Errorf("heads without fetch = %v, want only the local main branch", unfetched)
+	}
+
+	after, err := fetchBranchHeads(context.Background(), clone, "test", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after["refs/remotes/origin/main"] != sha {
+		t.Errorf("origin/main = %q after fetch, want %q", after["refs/remotes/origin/main"], sha)
+	}
+	if got := advancedBranches(before, after, []string{"main"}); !reflect.DeepEqual(got, []string{"refs/remotes/origin/main"}) {
+		t.Errorf("advancedBranches = %v, want [refs/remotes/origin/main]", got)
+	}
+}
+
+func TestFetchBranchHeadsSeesLocalCommits(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir := t.TempDir()
+	upstream := filepath.Join(dir, "upstream")
+	clone := filepath.Join(dir, "clone")
+
+	if err := os.Mkdir(upstream, 0755); err != nil {
+		t.Fatal(err)
+	}
+	git(t, upstream, "init", "-q", "-b", "main")
+	git(t, upstream, "commit", "-q", "--allow-empty", "-m", "first")
+	git(t, dir, "clone", "-q", upstream, clone)
+
+	// A repository with a remote still triggers on commits to its local branches
+	for _, fetch := range []bool{false, true} {
+		before, err := fetchBranchHeads(context.Background(), clone, "test", fetch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		git(t, clone, "commit", "-q", "--allow-empty", "-m", "local")
+		after, err := fetchBranchHeads(context.Background(), clone, "test", fetch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := advancedBranches(before, after, []string{"main"}); !reflect.DeepEqual(got, []string{"main"}) {
+			t.Errorf("fetch %v: advancedBranches = %v, want [main]", fetch, got)
+		}
+	}
+}
+
+func TestFetchBranchHeadsWithoutRemotes(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	repo := t.TempDir()
+	git(t, repo, "init", "-q", "-b", "main")
+	git(t, repo, "commit", "-q", "--allow-empty", "-m", "first")
+
+	heads, err := fetchBranchHeads(context.Background(), repo, "test", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := heads["main"]; !ok || len(heads) != 1 {
+		t.Errorf("heads = %v, want the local main branch", heads)
+	}
+}
+
+func TestGitTriggeredRunBuildsTheCommit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir := t.TempDir()
+	upstream := filepath.Join(dir, "upstream")
+	clone := filepath.Join(dir, "clone")
+
+	if err := os.Mkdir(upstream, 0755); err != nil {
+		t.Fatal(err)
+	}
+	config := "parts:\n  build:\n    steps:\n      - name: version\n        run: cat version.txt\n"
+	for name, content := range map[string]string{"pipego.yml": config, "version.txt": "1\n"} {
+		if err := os.WriteFile(filepath.Join(upstream, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	git(t, upstream, "init", "-q", "-b", "main")
+	git(t, upstream, "add", ".")
+	git(t, upstream, "commit", "-q", "-m", "first")
+	git(t, dir, "clone", "-q", upstream, clone)
+
+	// A push the clone only fetches, so its working tree stays at the first commit
+	if err := os.WriteFile(filepath.Join(upstream, "version.txt"), []byte("2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	git(t, upstream, "commit", "-q", "-am", "second")
+	heads, err := fetchBranchHeads(context.Background(), clone, "test", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The project runs in place, but the run builds the fetched commit
+	result, err := RunPipelineWithOptions(filepath.Join(clone, "pipego.yml"), RunPipelineOptions{
+		Commit:         &storage.CommitInfo{CommitSHA: heads["refs/remotes/origin/main"]},
+		CheckoutCommit: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Steps) != 1 || strings.TrimSpace(result.Steps[0].Output) != "2" {
+		t.Errorf("steps = %+v, want the output of the second commit", result.Steps)
+	}
+	if version, _ := os.ReadFile(filepath.Join(clone, "version.txt")); string(version) != "1\n" {
+		t.Errorf("project directory changed to %q", version)
+	}
+	if worktrees := git(t, clone, "worktree", "list"); strings.Count(worktrees, "\n") != 1 {
+		t.Errorf("worktree of the run was not removed:\n%s", worktrees)
+	}
+}
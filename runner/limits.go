@@ -0,0 +1,253 @@
+package runner
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"pipego/runner/storage"
+)
+
+// Limits caps the resources a step may use. Unset fields fall back to the
+// server defaults in projects.yml, 0 = no limit.
+type Limits struct {
+	// Memory of all processes of the step, e.g. "512M", "2G". Enforced with a
+	// cgroup v2; on hosts without one, it limits the address space of each
+	// process instead and the step's log says so.
+	Memory    string `yaml:"memory,omitempty" json:"memory,omitempty"`
+	CPUTime   string `yaml:"cpu_time,omitempty" json:"cpu_time,omitempty"`     // CPU time per process, e.g. "10m"
+	OpenFiles int    `yaml:"open_files,omitempty" json:"open_files,omitempty"` // Open file descriptors per process
+	// Processes of the step. Without cgroups it falls back to ulimit -u, which
+	// counts every process of the user running pipego, not only the step's.
+	Processes int `yaml:"processes,omitempty" json:"processes,omitempty"`
+
+	// Stored output, e.g. "10M". Longer logs keep their start and end.
+	LogSize    string `yaml:"log_size,omitempty" json:"log_size,omitempty"`
+	RunLogSize string `yaml:"run_log_size,omitempty" json:"run_log_size,omitempty"` // Output stored per run, server only
+}
+
+// Stored output limits used when none are configured
+const (
+	DefaultLogSize    = 10 << 20
+	DefaultRunLogSize = 50 << 20
+)
+
+// Failure reasons recorded on steps that exceeded a limit. They are only
+// recorded on evidence from the kernel; running out of open files shows as an
+// ordinary failure, which failure_causes can name from the output.
+const (
+	FailureMemoryLimit    = "memory_limit"
+	FailureCPUTimeLimit   = "cpu_time_limit"
+	FailureProcessesLimit = "processes_limit"
+)
+
+// cpuTimeGrace is how much longer than cpu_time a process that catches the
+// SIGXCPU sent at the limit may run before the kernel kills it
+const cpuTimeGrace = 5 * time.Second
+
+// stepLimits are the parsed limits of a step
+type stepLimits struct {
+	Limits
+	memoryBytes int64
+	cpuTime     time.Duration
+	logBytes    int64
+}
+
+// resolveLimits merges the limits of a step over the defaults and parses them
+func resolveLimits(step, defaults *Limits) (stepLimits, error) {
+	var l stepLimits
+	if defaults != nil {
+		l.Limits = *defaults
+	}
+	if step != nil {
+		if step.Memory != "" {
+			l.Memory = step.Memory
+		}
+		if step.CPUTime != "" {
+			l.CPUTime = step.CPUTime
+		}
+		if step.OpenFiles != 0 {
+			l.OpenFiles = step.OpenFiles
+		}
+		if step.Processes != 0 {
+			l.Processes = step.Processes
+		}
+		if step.LogSize != "" {
+			l.LogSize = step.LogSize
+		}
+	}
+
+	if l.Memory != "" {
+		bytes, err := parseMemory(l.Memory)
+		if err != nil {
+			return l, fmt.Errorf("invalid memory limit '%s': %w", l.Memory, err)
+		}
+		l.memoryBytes = bytes
+	}
+	if l.CPUTime != "" {
+		cpuTime, err := time.ParseDuration(l.CPUTime)
+		if err != nil || cpuTime <= 0 {
+			return l, fmt.Errorf("invalid cpu_time limit '%s'", l.CPUTime)
+		}
+		l.cpuTime = cpuTime
+	}
+	l.logBytes = DefaultLogSize
+	if l.LogSize != "" {
+		bytes, err := parseMemory(l.LogSize)
+		if err != nil {
+			return l, fmt.Errorf("invalid log_size limit '%s': %w", l.LogSize, err)
+		}
+		l.logBytes = bytes
+	}
+	if l.OpenFiles < 0 || l.Processes < 0 {
+		return l, fmt.Errorf("open_files and processes limits must not be negative")
+	}
+
+	return l, nil
+}
+
+// runLogBudget is the stored output left for the steps of a run
+type runLogBudget struct {
+	remaining int64
+}
+
+// newRunLogBudget starts the budget of a run at run_log_size from the defaults
+func newRunLogBudget(defaults *Limits) *runLogBudget {
+	b := &runLogBudget{remaining: DefaultRunLogSize}
+	if defaults != nil && defaults.RunLogSize != "" {
+		bytes, err := parseMemory(defaults.RunLogSize)
+		if err != nil {
+			log.Printf("⚠️  Invalid run_log_size limit '%s', using the default: %v", defaults.RunLogSize, err)
+		} else {
+			b.remaining = bytes
+		}
+	}
+	return b
+}
+
+// stepLimit returns how much output a step with the given log limit may store
+func (b *runLogBudget) stepLimit(logBytes int64) int64 {
+	if b == nil {
+		return logBytes
+	}
+	return min(logBytes, b.remaining)
+}
+
+// use takes the size of a step log from the budget
+func (b *runLogBudget) use(size int64) {
+	if b != nil {
+		b.remaining = max(b.remaining-size, 0)
+	}
+}
+
+// parseMemory parses a size such as "512M", "2G" or "1GiB" into bytes. Units are powers of 1024.
+func parseMemory(value string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(value))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "B"), "I")
+
+	multiplier := int64(1)
+	if s != "" {
+		switch s[len(s)-1] {
+		case 'K':
+			multiplier = 1 << 10
+		case 'M':
+			multiplier = 1 << 20
+		case 'G':
+			multiplier = 1 << 30
+		case 'T':
+			multiplier = 1 << 40
+		}
+		if multiplier > 1 {
+			s = s[:len(s)-1]
+		}
+	}
+
+	n, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("expected a size such as 512M or 2G")
+	}
+	return int64(n * float64(multiplier)), nil
+}
+
+// shellPrefix returns the ulimit commands that apply the limits not enforced by
+// the cgroup to the shell running the step. The step fails if they can't be set.
+// Without a cgroup the memory limit caps the address space of each process,
+// which runtimes reserving more than they use, such as Go or the JVM, may hit
+// well below the limit.
+func (l stepLimits) shellPrefix(cg *stepCgroup) string {
+	var prefix strings.Builder
+	if l.cpuTime > 0 {
+		// The soft limit sends SIGXCPU, telling the CPU time limit apart from other kills
+		soft, hard := l.cpuTimeLimits()
+		fmt.Fprintf(&prefix, "ulimit -S -t %d || exit\nulimit -H -t %d || exit\n", int64(soft/time.Second), int64(hard/time.Second))
+	}
+
+	var args []string
+	if l.memoryBytes > 0 && !cg.limitsMemory() {
+		args = append(args, fmt.Sprintf("-v %d", max(l.memoryBytes>>10, 1)))
+	}
+	if l.OpenFiles > 0 {
+		args = append(args, fmt.Sprintf("-n %d", l.OpenFiles))
+	}
+	if l.Processes > 0 && !cg.limitsProcesses() {
+		args = append(args, fmt.Sprintf("-u %d", l.Processes))
+	}
+	if len(args) > 0 {
+		prefix.WriteString("ulimit " + strings.Join(args, " ") + " || exit\n")
+	}
+	return prefix.String()
+}
+
+// cpuTimeLimits returns the soft and hard RLIMIT_CPU of the step's processes,
+// in whole seconds
+func (l stepLimits) cpuTimeLimits() (time.Duration, time.Duration) {
+	soft := (l.cpuTime + time.Second - 1) / time.Second * time.Second
+	return soft, soft + cpuTimeGrace
+}
+
+// fallbackWarning logs once that this host limits memory per process
+var fallbackWarning sync.Once
+
+// fallbackNote returns a note on the limits of the step this host enforces
+// differently than configured, or "" if it enforces them all as configured
+func (l stepLimits) fallbackNote(cg *stepCgroup) string {
+	if l.memoryBytes > 0 && !cg.limitsMemory() {
+		fallbackWarning.Do(func() {
+			log.Printf("⚠️  Memory limits apply to the address space of each process: this host has no cgroups v2 with the memory controller delegated to pipego")
+		})
+		return fmt.Sprintf("memory limit of %s applied to the address space of each process: this host has no cgroups v2 with the memory controller delegated to pipego", l.Memory)
+	}
+	return ""
+}
+
+// violation returns the failure reason of a failed step that ran into one of
+// its limits, or "" if there is no evidence it did: the cgroup's OOM kill and
+// pids.max counters, or a process stopped by its CPU time limit.
+func (l stepLimits) violation(cg *stepCgroup, usage *storage.StepUsage) string {
+	if reason := cg.violation(); reason != "" {
+		return reason
+	}
+	if l.cpuTime > 0 {
+		soft, hard := l.cpuTimeLimits()
+		if cpuTimeLimitHit(usage, soft, hard) {
+			return FailureCPUTimeLimit
+		}
+	}
+	return ""
+}
+
+// describe returns a message for a failure reason, e.g. "memory limit of 512M exceeded"
+func (l stepLimits) describe(reason string) string {
+	switch reason {
+	case FailureMemoryLimit:
+		return fmt.Sprintf("memory limit of %s exceeded", l.Memory)
+	case FailureCPUTimeLimit:
+		return fmt.Sprintf("cpu_time limit of %s exceeded", l.cpuTime)
+	case FailureProcessesLimit:
+		return fmt.Sprintf("processes limit of %d exceeded", l.Processes)
+	}
+	return reason
+}
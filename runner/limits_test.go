@@ -0,0 +1,242 @@
+package runner
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"pipego/runner/storage"
+)
+
+func TestParseMemory(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int64
+	}{
+		{"512", 512},
+		{"1K", 1 << 10},
+		{"512M", 512 << 20},
+		{"512m", 512 << 20},
+		{"2G", 2 << 30},
+		{"1GiB", 1 << 30},
+		{"1GB", 1 << 30},
+		{"1.5G", 3 << 29},
+		{" 100K ", 100 << 10},
+		{"1T", 1 << 40},
+	}
+	for _, tt := range tests {
+		got, err := parseMemory(tt.value)
+		if err != nil {
+			t.Errorf("parseMemory(%q): %v", tt.value, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseMemory(%q) = %d, want %d", tt.value, got, tt.want)
+		}
+	}
+
+	for _, value := range []string{"", "M", "lots", "-1G", "0", "12X"} {
+		if _, err := parseMemory(value); err == nil {
+			t.Errorf("parseMemory(%q) accepted an invalid size", value)
+		}
+	}
+}
+
+func TestResolveLimits(t *testing.T) {
+	defaults := &Limits{Memory: "1G", CPUTime: "10m", OpenFiles: 1024, LogSize: "1M"}
+
+	l, err := resolveLimits(&Limits{Memory: "256M", Processes: 50}, defaults)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.memoryBytes != 256<<20 || l.cpuTime != 10*time.Minute || l.OpenFiles != 1024 || l.Processes != 50 || l.logBytes != 1<<20 {
+		t.Errorf("resolved limits = %+v", l)
+	}
+
+	l, err = resolveLimits(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.memoryBytes != 0 || l.cpuTime != 0 || l.logBytes != DefaultLogSize {
+		t.Errorf("limits without config = %+v", l)
+	}
+
+	invalid := []*Limits{
+		{Memory: "lots"},
+		{CPUTime: "forever"},
+		{CPUTime: "-1s"},
+		{LogSize: "big"},
+		{OpenFiles: -1},
+	}
+	for _, step := range invalid {
+		if _, err := resolveLimits(step, nil); err == nil {
+			t.Errorf("resolveLimits(%+v) accepted invalid limits", *step)
+		}
+	}
+}
+
+func TestShellPrefix(t *testing.T) {
+	tests := []struct {
+		name   string
+		limits Limits
+		want   string
+	}{
+		{"no limits", Limits{}, ""},
+		{"memory without a cgroup", Limits{Memory: "512M"}, "ulimit -v 524288 || exit\n"},
+		{"cpu time", Limits{CPUTime: "1500ms"}, "ulimit -S -t 2 || exit\nulimit -H -t 7 || exit\n"},
+		{"files and processes", Limits{OpenFiles: 64, Processes: 20}, "ulimit -n 64 -u 20 || exit\n"},
+	}
+	for _, tt := range tests {
+		l, err := resolveLimits(&tt.limits, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := l.shellPrefix(nil); got != tt.want {
+			t.Errorf("%s: shellPrefix = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLimitsViolation(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("CPU time limits are not set on Windows")
+	}
+
+	l, err := resolveLimits(&Limits{CPUTime: "10s", OpenFiles: 64, Processes: 20}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	usage := func(exitCode int, signal string, cpuMs int64) *storage.StepUsage {
+		u := &storage.StepUsage{Signal: signal, UserCPUMs: &cpuMs, SystemCPUMs: new(int64)}
+		if signal == "" {
+			u.ExitCode = &exitCode
+		}
+		return u
+	}
+
+	tests := []struct {
+		name  string
+		usage *storage.StepUsage
+		want  string
+	}{
+		{"SIGXCPU reported by the shell", usage(128+24, "", 10_000), FailureCPUTimeLimit},
+		{"SIGKILL at the hard limit", usage(128+9, "", 15_000), FailureCPUTimeLimit},
+		{"SIGKILL below the hard limit", usage(128+9, "", 11_000), ""},
+		{"exit code of SIGXCPU without the CPU time", usage(128+24, "", 2_000), ""},
+		{"CPU time close to the limit", usage(1, "", 9_500), ""},
+		{"ordinary failure", usage(1, "", 100), ""},
+		{"no usage", nil, ""},
+	}
+	if runtime.GOOS != "linux" {
+		// Signal numbers differ, only the Linux ones are listed above
+		tests = tests[len(tests)-3:]
+	}
+	for _, tt := range tests {
+		if got := l.violation(nil, tt.usage); got != tt.want {
+			t.Errorf("%s: violation = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	// Without a CPU time limit a SIGXCPU is somebody else's
+	noCPU, _ := resolveLimits(&Limits{OpenFiles: 64}, nil)
+	if got := noCPU.violation(nil, usage(128+24, "", 20_000)); got != "" {
+		t.Errorf("violation without cpu_time = %q", got)
+	}
+}
+
+func TestStepLimitsEnforced(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("needs rlimits and cgroups as on Linux")
+	}
+
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "pipego.yml")
+	config := `parts:
+  spin:
+    steps:
+      - name: spin
+        limits:
+          cpu_time: 1s
+        run: "while :; do :; done"
+  memory:
+    steps:
+      - name: memory
+        limits:
+          memory: 64M
+        run: "true"
+  allocate:
+    steps:
+      - name: allocate
+        limits:
+          memory: 64M
+        run: "x=$(head -c 100000000 /dev/zero | tr '\\0' a); echo ${#x}"
+  files:
+    steps:
+      - name: files
+        limits:
+          open_files: 64
+        run: "echo 'Too many open files' >&2; exit 1"
+`
+	if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	run := func(part string) *storage.Failure {
+		t.Helper()
+		result, err := RunPipelineWithOptions(configPath, RunPipelineOptions{PartFilter: part})
+		if err == nil {
+			t.Fatalf("part %s succeeded", part)
+		}
+		if result == nil || len(result.Steps) != 1 || result.Steps[0].Failure == nil {
+			t.Fatalf("part %s: no step failure in %+v", part, result)
+		}
+		return result.Steps[0].Failure
+	}
+
+	start := time.Now()
+	if failure := run("spin"); failure.Reason != FailureCPUTimeLimit {
+		t.Errorf("spinning step failed with %+v, want %s", failure, FailureCPUTimeLimit)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("spinning step ran for %s", elapsed)
+	}
+
+	// Output that looks like a limit is not evidence of one
+	if failure := run("files"); failure.Reason != storage.FailureCommandFailed {
+		t.Errorf("step printing an error failed with %+v, want %s", failure, storage.FailureCommandFailed)
+	}
+
+	// Without cgroups a memory limit caps the address space of each process
+	// and the step's log says so
+	limits, _ := resolveLimits(&Limits{Memory: "64M"}, nil)
+	cg := newStepCgroup(limits)
+	available := cg.limitsMemory()
+	cg.remove()
+	if available {
+		return
+	}
+	store, err := storage.NewStorage(filepath.Join(dir, "pipego.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	result, err := RunPipelineWithOptions(configPath, RunPipelineOptions{PartFilter: "memory", Storage: store})
+	if err != nil || len(result.Steps) != 1 || result.Steps[0].Status != "success" {
+		t.Fatalf("memory limit without cgroups: %+v, %v", result, err)
+	}
+	steps, err := store.GetStepExecutions(result.RunID)
+	if err != nil || len(steps) != 1 {
+		t.Fatalf("GetStepExecutions = %d steps, %v", len(steps), err)
+	}
+	lines, _, err := store.ReadStepLines(steps[0], 1, 0)
+	if err != nil || len(lines) == 0 || lines[0].Stream != storage.StreamSystem || !strings.Contains(lines[0].Text, "memory limit of 64M applied to the address space") {
+		t.Errorf("log of a step with a per-process memory limit = %+v, %v", lines, err)
+	}
+	if failure := run("allocate"); failure.Reason != storage.FailureCommandFailed {
+		t.Errorf("step allocating past the limit failed with %+v, want %s", failure, storage.FailureCommandFailed)
+	}
+}
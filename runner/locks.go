@@ -0,0 +1,318 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// LockRequest describes a lock a pipeline or part needs before it can run
+type LockRequest struct {
+	Name     string // "project:<name>" or the part's concurrency group
+	Capacity int    // Holders allowed at once
+	Project  string
+	Part     string // Empty for project locks
+	RunIDs   []int  // Runs waiting for, then holding, the lock
+	// Cancels the holder when a cancel-in-progress request supersedes it
+	Cancel context.CancelCauseFunc
+	// Cancel current holders and waiters instead of queueing behind them
+	CancelInProgress bool
+}
+
+// LockEntry is a holder of, or waiter for, a lock
+type LockEntry struct {
+	Project string    `json:"project"`
+	Part    string    `json:"part,omitempty"`
+	RunIDs  []int     `json:"run_ids"`
+	Since   time.Time `json:"since"` // When the lock was acquired, or waiting started
+
+	cancel  context.CancelCauseFunc
+	granted chan struct{}
+}
+
+// LockStatus is the state of a lock as reported by the API
+type LockStatus struct {
+	Name     string      `json:"name"`
+	Capacity int         `json:"capacity"`
+	Holders  []LockEntry `json:"holders"`
+	Waiting  []LockEntry `json:"waiting"`
+}
+
+// namedLock is a counting lock with FIFO waiters
+type namedLock struct {
+	capacity int
+	holders  []*LockEntry
+	waiting  []*LockEntry
+}
+
+// LockManager hands out project and concurrency group locks to running pipelines
+type LockManager struct {
+	projectsConfig *ProjectsConfig
+	baseDir        string
+
+	mu        sync.Mutex
+	locks     map[string]*namedLock
+	listeners []func()
+}
+
+// NewLockManager creates a lock manager using the max_concurrent limits of the projects
+func NewLockManager(projectsConfig *ProjectsConfig, baseDir string) *LockManager {
+	return &LockManager{
+		projectsConfig: projectsConfig,
+		baseDir:        baseDir,
+		locks:          make(map[string]*namedLock),
+	}
+}
+
+// ProjectLock returns the lock name and max_concurrent limit of the project
+// owning the config file. A limit of 0 means the project is not limited.
+func (m *LockManager) ProjectLock(configPath string) (string, int) {
+	if m.projectsConfig == nil {
+		return "", 0
+	}
+
+	absPath, err := filepath.Abs(configPath)
+	if err != nil {
+		return "", 0
+	}
+
+	for _, project := range m.projectsConfig.Projects {
+		projectPath, err := filepath.Abs(project.GetPipegoPath(m.baseDir))
+		if err != nil || projectPath != absPath {
+			continue
+		}
+		return "project:" + project.Name, project.MaxConcurrent
+	}
+	return "", 0
+}
+
+// Acquire blocks until the lock is granted or ctx is done, and returns the
+// function releasing it
+func (m *LockManager) Acquire(ctx context.Context, req LockRequest) (func(), error) {
+	capacity := req.Capacity
+	if capacity <= 0 {
+		capacity = 1
+	}
+
+	entry := &LockEntry{
+		Project: req.Project,
+		Part:    req.Part,
+		RunIDs:  req.RunIDs,
+		Since:   time.Now(),
+		cancel:  req.Cancel,
+		granted: make(chan struct{}),
+	}
+
+	m.mu.Lock()
+	lock, exists := m.locks[req.Name]
+	if !exists {
+		lock = &namedLock{}
+		m.locks[req.Name] = lock
+	}
+	lock.capacity = capacity
+
+	if req.CancelInProgress {
+		supersede(lock, req.Name)
+	}
+
+	lock.waiting = append(lock.waiting, entry)
+	m.grant(lock)
+	m.mu.Unlock()
+
+	release := m.releaser(map[string]*LockEntry{req.Name: entry})
+
+	select {
+	case <-entry.granted:
+		return release, nil
+	case <-ctx.Done():
+	}
+
+	// Leave the line, or hand the slot on if it was granted while giving up
+	release()
+	return nil, context.Cause(ctx)
+}
+
+// TryAcquire takes all of the locks if each has a free slot and nobody
+// waiting for it, otherwise none of them. It returns the functions releasing
+// the locks by name, or the name of a lock that is busy. Requests that
+// cancel in progress runs don't cancel anything here, see Supersede.
+func (m *LockManager) TryAcquire(reqs []LockRequest) (map[string]func(), string) {
+	m.mu.Lock()
+	for _, req := range reqs {
+		lock, exists := m.locks[req.Name]
+		if !exists {
+			continue
+		}
+		if len(lock.holders) >= max(req.Capacity, 1) || len(lock.waiting) > 0 {
+			m.mu.Unlock()
+			return nil, req.Name
+		}
+	}
+
+	entries := make(map[string]*LockEntry, len(reqs))
+	for _, req := range reqs {
+		lock, exists := m.locks[req.Name]
+		if !exists {
+			lock = &namedLock{}
+			m.locks[req.Name] = lock
+		}
+		lock.capacity = max(req.Capacity, 1)
+
+		entry := &LockEntry{
+			Project: req.Project,
+			Part:    req.Part,
+			RunIDs:  req.RunIDs,
+			Since:   time.Now(),
+			cancel:  req.Cancel,
+			granted: make(chan struct{}),
+		}
+		close(entry.granted)
+		lock.holders = append(lock.holders, entry)
+		entries[req.Name] = entry
+	}
+	m.mu.Unlock()
+
+	releases := make(map[string]func(), len(entries))
+	for name, entry := range entries {
+		releases[name] = m.releaser(map[string]*LockEntry{name: entry})
+	}
+	return releases, ""
+}
+
+// Supersede cancels the holders of and waiters for a lock, as a request that
+// cancels in progress runs does
+func (m *LockManager) Supersede(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if lock, exists := m.locks[name]; exists {
+		supersede(lock, name)
+	}
+}
+
+// OnRelease registers a function called whenever a lock is released or a
+// waiter gives up, e.g. to look for pipelines that can start now
+func (m *LockManager) OnRelease(fn func()) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.listeners = append(m.listeners, fn)
+}
+
+// releaser returns a function giving up the entries, held or waiting, which
+// is safe to call more than once
+func (m *LockManager) releaser(entries map[string]*LockEntry) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			m.mu.Lock()
+			for name, entry := range entries {
+				lock, exists := m.locks[name]
+				if !exists {
+					continue
+				}
+				lock.holders = removeLockEntry(lock.holders, entry)
+				lock.waiting = removeLockEntry(lock.waiting, entry)
+				m.grant(lock)
+				m.cleanup(name, lock)
+			}
+			listeners := m.listeners
+			m.mu.Unlock()
+
+			for _, fn := range listeners {
+				fn()
+			}
+		})
+	}
+}
+
+// supersede cancels the holders and waiters of a lock. Callers hold m.mu.
+func supersede(lock *namedLock, name string) {
+	cause := fmt.Errorf("superseded by a newer run in concurrency group '%s'", name)
+	for _, other := range lock.holders {
+		if other.cancel != nil {
+			other.cancel(cause)
+		}
+	}
+	for _, other := range lock.waiting {
+		if other.cancel != nil {
+			other.cancel(cause)
+		}
+	}
+}
+
+// grant moves waiters to holders while the lock has free slots. Callers hold m.mu.
+func (m *LockManager) grant(lock *namedLock) {
+	for len(lock.holders) < lock.capacity && len(lock.waiting) > 0 {
+		entry := lock.waiting[0]
+		lock.waiting = lock.waiting[1:]
+		entry.Since = time.Now()
+		lock.holders = append(lock.holders, entry)
+		close(entry.granted)
+	}
+}
+
+// cleanup forgets locks nobody holds or waits for. Callers hold m.mu.
+func (m *LockManager) cleanup(name string, lock *namedLock) {
+	if len(lock.holders) == 0 && len(lock.waiting) == 0 && m.locks[name] == lock {
+		delete(m.locks, name)
+	}
+}
+
+// Snapshot returns the state of all held or awaited locks sorted by name
+func (m *LockManager) Snapshot() []LockStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	statuses := make([]LockStatus, 0, len(m.locks))
+	for name, lock := range m.locks {
+		status := LockStatus{
+			Name:     name,
+			Capacity: lock.capacity,
+			Holders:  make([]LockEntry, 0, len(lock.holders)),
+			Waiting:  make([]LockEntry, 0, len(lock.waiting)),
+		}
+		for _, entry := range lock.holders {
+			status.Holders = append(status.Holders, *entry)
+		}
+		for _, entry := range lock.waiting {
+			status.Waiting = append(status.Waiting, *entry)
+		}
+		statuses = append(statuses, status)
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+	return statuses
+}
+
+// BlockedOn returns the name of the lock one of the runs is waiting for, or ""
+func (m *LockManager) BlockedOn(runIDs []int) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for name, lock := range m.locks {
+		for _, entry := range lock.waiting {
+			for _, waitingID := range entry.RunIDs {
+				for _, runID := range runIDs {
+					if waitingID == runID {
+						return name
+					}
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// removeLockEntry returns entries without the given entry
+func removeLockEntry(entries []*LockEntry, entry *LockEntry) []*LockEntry {
+	for i, other := range entries {
+		if other == entry {
+			return append(entries[:i:i], entries[i+1:]...)
+		}
+	}
+	return entries
+}
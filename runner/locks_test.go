@@ -0,0 +1,245 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// acquireNow acquires a lock that must be free
+func acquireNow(t *testing.T, m *LockManager, req LockRequest) func() {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	release, err := m.Acquire(ctx, req)
+	if err != nil {
+		t.Fatalf("Acquire(%s): %v", req.Name, err)
+	}
+	return release
+}
+
+// acquireAsync acquires a lock in the background and reports the result on the channel
+func acquireAsync(m *LockManager, ctx context.Context, req LockRequest) <-chan func() {
+	granted := make(chan func(), 1)
+	go func() {
+		release, err := m.Acquire(ctx, req)
+		if err != nil {
+			release = nil
+		}
+		granted <- release
+	}()
+	return granted
+}
+
+// waitForWaiters waits until the lock has n waiters
+func waitForWaiters(t *testing.T, m *LockManager, name string, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		for _, status := range m.Snapshot() {
+			if status.Name == name && len(status.Waiting) == n {
+				return
+			}
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("lock %s never had %d waiter(s): %+v", name, n, m.Snapshot())
+}
+
+func TestLockManagerCapacity(t *testing.T) {
+	m := NewLockManager(nil, "")
+	req := LockRequest{Name: "project:app", Capacity: 2, Project: "app"}
+
+	first := acquireNow(t, m, req)
+	acquireNow(t, m, req)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := m.Acquire(ctx, req); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("third holder: err = %v, want deadline exceeded", err)
+	}
+	// Giving up leaves the line
+	if status := m.Snapshot(); len(status) != 1 || len(status[0].Holders) != 2 || len(status[0].Waiting) != 0 {
+		t.Fatalf("snapshot = %+v", status)
+	}
+
+	first()
+	first() // Releasing twice must not free a second slot
+	acquireNow(t, m, req)
+
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := m.Acquire(ctx, req); err == nil {
+		t.Fatal("lock granted above its capacity after a double release")
+	}
+}
+
+func TestLockManagerFIFO(t *testing.T) {
+	m := NewLockManager(nil, "")
+	holder := acquireNow(t, m, LockRequest{Name: "deploy"})
+
+	var waiters []<-chan func()
+	for i := 1; i <= 3; i++ {
+		waiters = append(waiters, acquireAsync(m, context.Background(), LockRequest{Name: "deploy", RunIDs: []int{i}}))
+		waitForWaiters(t, m, "deploy", i)
+	}
+	if got := m.BlockedOn([]int{5, 2}); got != "deploy" {
+		t.Errorf("BlockedOn = %q, want deploy", got)
+	}
+	if got := m.BlockedOn([]int{5}); got != "" {
+		t.Errorf("BlockedOn of a run that is not waiting = %q", got)
+	}
+
+	holder()
+	for i, waiter := range waiters {
+		select {
+		case release := <-waiter:
+			if release == nil {
+				t.Fatalf("waiter %d was not granted", i+1)
+			}
+			// Later waiters are still waiting
+			for _, later := range waiters[i+1:] {
+				select {
+				case <-later:
+					t.Fatalf("waiter granted before waiter %d released", i+1)
+				default:
+				}
+			}
+			release()
+		case <-time.After(time.Second):
+			t.Fatalf("waiter %d was never granted", i+1)
+		}
+	}
+
+	if status := m.Snapshot(); len(status) != 0 {
+		t.Errorf("released locks are still listed: %+v", status)
+	}
+}
+
+func TestLockManagerCancelInProgress(t *testing.T) {
+	m := NewLockManager(nil, "")
+
+	holderCtx, cancelHolder := context.WithCancelCause(context.Background())
+	holder := acquireNow(t, m, LockRequest{Name: "deploy", Cancel: cancelHolder})
+
+	waiterCtx, cancelWaiter := context.WithCancelCause(context.Background())
+	waiter := acquireAsync(m, waiterCtx, LockRequest{Name: "deploy", Cancel: cancelWaiter})
+	waitForWaiters(t, m, "deploy", 1)
+
+	newest := acquireAsync(m, context.Background(), LockRequest{Name: "deploy", CancelInProgress: true})
+
+	for name, ctx := range map[string]context.Context{"holder": holderCtx, "waiter": waiterCtx} {
+		select {
+		case <-ctx.Done():
+			if cause := context.Cause(ctx); cause == nil || !strings.Contains(cause.Error(), "superseded") {
+				t.Errorf("%s cancelled with %v", name, cause)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s was not cancelled", name)
+		}
+	}
+	if release := <-waiter; release != nil {
+		t.Error("cancelled waiter was granted the lock")
+	}
+
+	// The superseded holder still runs until it releases the lock
+	select {
+	case <-newest:
+		t.Fatal("lock granted while the superseded holder still holds it")
+	default:
+	}
+	holder()
+	select {
+	case release := <-newest:
+		release()
+	case <-time.After(time.Second):
+		t.Fatal("newest run was not granted the lock")
+	}
+}
+
+func TestLockManagerTryAcquire(t *testing.T) {
+	m := NewLockManager(nil, "")
+	released := make(chan struct{}, 10)
+	m.OnRelease(func() { released <- struct{}{} })
+
+	project := LockRequest{Name: "project:app", Capacity: 1, Project: "app"}
+	group := LockRequest{Name: "deploy", Capacity: 1, Project: "app", Part: "deploy"}
+
+	held, blocked := m.TryAcquire([]LockRequest{project, group})
+	if blocked != "" || len(held) != 2 {
+		t.Fatalf("TryAcquire of free locks: held %d, blocked on %q", len(held), blocked)
+	}
+
+	// Either lock being busy takes neither
+	other := LockRequest{Name: "project:other", Capacity: 1, Project: "other"}
+	if held, blocked := m.TryAcquire([]LockRequest{other, group}); held != nil || blocked != "deploy" {
+		t.Fatalf("TryAcquire of a busy lock: held %v, blocked on %q", held, blocked)
+	}
+	for _, status := range m.Snapshot() {
+		if status.Name == "project:other" {
+			t.Fatal("TryAcquire took a lock although another one was busy")
+		}
+	}
+
+	held["deploy"]()
+	select {
+	case <-released:
+	default:
+		t.Fatal("release listeners were not called")
+	}
+	held["deploy"]()
+	select {
+	case <-released:
+		t.Fatal("release listeners called for a second release")
+	default:
+	}
+
+	// A waiter is ahead of anyone trying to take the lock
+	waiter := acquireAsync(m, context.Background(), project)
+	waitForWaiters(t, m, "project:app", 1)
+	held["project:app"]()
+	release := <-waiter
+	if release == nil {
+		t.Fatal("waiter was not granted the released lock")
+	}
+	if _, blocked := m.TryAcquire([]LockRequest{project}); blocked != "project:app" {
+		t.Fatalf("TryAcquire of a held lock: blocked on %q", blocked)
+	}
+	release()
+	if held, blocked := m.TryAcquire([]LockRequest{project}); blocked != "" {
+		t.Fatalf("TryAcquire after release: blocked on %q", blocked)
+	} else {
+		held["project:app"]()
+	}
+}
+
+func TestLockManagerProjectLock(t *testing.T) {
+	baseDir := t.TempDir()
+	m := NewLockManager(&ProjectsConfig{Projects: []Project{
+		{Name: "app", Path: "app", MaxConcurrent: 2},
+		{Name: "lib", Path: "lib"},
+	}}, baseDir)
+
+	tests := []struct {
+		configPath string
+		wantName   string
+		wantLimit  int
+	}{
+		{filepath.Join(baseDir, "app", "pipego.yml"), "project:app", 2},
+		{filepath.Join(baseDir, "lib", "pipego.yml"), "project:lib", 0},
+		{filepath.Join(baseDir, "other", "pipego.yml"), "", 0},
+	}
+	for _, tt := range tests {
+		name, limit := m.ProjectLock(tt.configPath)
+		if name != tt.wantName || limit != tt.wantLimit {
+			t.Errorf("ProjectLock(%s) = %q, %d, want %q, %d", tt.configPath, name, limit, tt.wantName, tt.wantLimit)
+		}
+	}
+
+	if name, limit := NewLockManager(nil, "").ProjectLock("pipego.yml"); name != "" || limit != 0 {
+		t.Errorf("ProjectLock without projects = %q, %d", name, limit)
+	}
+}
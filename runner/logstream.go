@@ -0,0 +1,456 @@
+package runner
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"pipego/events"
+	"pipego/runner/storage"
+)
+
+// logFlushInterval is how often new output of a running step is appended to storage
+const logFlushInterval = time.Second
+
+// maxLineLength splits lines that never end so they can't grow without bound
+const maxLineLength = 64 * 1024
+
+// RunLogTopic is the events topic carrying the output of a run, line by line
+func RunLogTopic(runID int) string {
+	return fmt.Sprintf("run:%d", runID)
+}
+
+// LogLine is one line of step output as published on a run's log topic
+type LogLine struct {
+	RunID    int    `json:"run_id"`
+	StepID   int    `json:"step_id"`
+	Step     string `json:"step"`
+	Line     int    `json:"line"`                // 1-based line number within the step
+	OffsetMs *int64 `json:"offset_ms,omitempty"` // When it was written, relative to the step start
+	Stream   string `json:"stream,omitempty"`    // "stdout" or "stderr", not known for old steps
+	Text     string `json:"text"`
+}
+
+// LogEventID identifies a line in the log stream, used as the SSE event ID
+func LogEventID(stepID, line int) string {
+	return fmt.Sprintf("%d:%d", stepID, line)
+}
+
+// recentOutputLimit is how much of the latest output a step keeps in memory
+// for its result and for recognising limit violations
+const recentOutputLimit = 64 * 1024
+
+// stepOutput collects the output of a running step line by line, in the order
+// the lines were written. Each line is published on the run's log topic and
+// new lines are appended to the step's log file periodically, so clients can
+// follow a step while it runs.
+//
+// The log file holds at most limit bytes. Once the first half is used up, only
+// the latest lines are kept in memory and they are written after a marker
+// saying how much was left out when the step finishes, so the log keeps both
+// the start and the end of the output. Lines keep the numbers they were
+// published with; the marker takes the number of the first line left out.
+type stepOutput struct {
+	runID  int
+	stepID int
+	step   string
+	log    *storage.LogWriter // nil without storage
+	limit  int64
+	start  time.Time
+
+	mu        sync.Mutex
+	lineCount int                  // Lines written so far
+	pending   []storage.OutputLine // Lines not appended to the log file yet
+	flushed   int                  // Lines in the log file
+	headBytes int64
+
+	truncating   bool
+	tail         []storage.OutputLine
+	tailBytes    int64
+	droppedLines int
+	droppedBytes int64
+
+	recent      []string
+	recentBytes int
+
+	annotations annotationCollector
+
+	status  string           // How the step ended, sent when it finishes
+	failure *storage.Failure // Why it did not succeed
+}
+
+// liveOutputs holds the output of the step currently running in each run
+var liveOutputs = struct {
+	sync.Mutex
+	byRun map[int]*stepOutput
+}{byRun: make(map[int]*stepOutput)}
+
+// newStepOutput starts collecting the output of a step into a log file of at
+// most limit bytes. stepID is 0 without storage.
+func newStepOutput(runID, stepID int, step string, store *storage.Storage, limit int64) *stepOutput {
+	o := &stepOutput{runID: runID, stepID: stepID, step: step, limit: limit, start: time.Now()}
+	o.annotations = annotationCollector{runID: runID, stepID: stepID}
+	if store != nil && stepID != 0 {
+		logWriter, err := store.CreateStepLog(runID, stepID)
+		if err != nil {
+			log.Printf("⚠️  Failed to create log of step %d, keeping only its latest output: %v", stepID, err)
+		} else {
+			o.log = logWriter
+		}
+	}
+	if runID != 0 {
+		liveOutputs.Lock()
+		liveOutputs.byRun[runID] = o
+		liveOutputs.Unlock()
+	}
+	return o
+}
+
+// LiveStepOutput returns the lines of the running step of a run that are not
+// in its log file yet, and the line number of the first of them. Lines up to
+// there can be read from the file. Once the log is truncated, the lines are
+// followed by the marker for the lines left out so far and the kept tail.
+// ok is false when no step of the run is running.
+func LiveStepOutput(runID int) (stepID int, lines []storage.OutputLine, firstLine int, ok bool) {
+	liveOutputs.Lock()
+	o := liveOutputs.byRun[runID]
+	liveOutputs.Unlock()
+	if o == nil {
+		return 0, nil, 0, false
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	lines = append([]storage.OutputLine(nil), o.pending...)
+	if marker, ok := o.droppedMarker(); ok {
+		lines = append(lines, marker)
+	}
+	lines = append(lines, o.tail...)
+	return o.stepID, lines, o.flushed + 1, true
+}
+
+// droppedMarker returns the line marking where output was left out of the
+// log, numbered as the first line left out, if any was. Call with mu held.
+func (o *stepOutput) droppedMarker() (storage.OutputLine, bool) {
+	if o.droppedLines == 0 {
+		return storage.OutputLine{}, false
+	}
+	offset := time.Since(o.start).Milliseconds()
+	return storage.OutputLine{
+		Line:     o.lineCount - len(o.tail) - o.droppedLines + 1,
+		OffsetMs: &offset,
+		Stream:   storage.StreamSystem,
+		Text:     fmt.Sprintf("[... %d lines (%d bytes) of output left out by the log size limit ...]", o.droppedLines, o.droppedBytes),
+	}, true
+}
+
+// addLine records a line written to stream and publishes it; holding the lock
+// while publishing keeps the lines of a step in order for every client
+func (o *stepOutput) addLine(stream, text string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.lineCount++
+	offset := time.Since(o.start).Milliseconds()
+	o.keepRecent(text)
+	if o.log != nil {
+		o.keepForLog(storage.OutputLine{Line: o.lineCount, OffsetMs: &offset, Stream: stream, Text: text})
+		// Lines from here on may be left out of the log, and the ones kept
+		// are numbered differently, so annotations can't point at them
+		if o.truncating && !o.annotations.stopped {
+			o.annotations.stop(o.lineCount - 1)
+		}
+	}
+
+	if o.runID == 0 {
+		return
+	}
+	events.GetBroker().Publish(RunLogTopic(o.runID), "log", LogEventID(o.stepID, o.lineCount), LogLine{
+		RunID:    o.runID,
+		StepID:   o.stepID,
+		Step:     o.step,
+		Line:     o.lineCount,
+		OffsetMs: &offset,
+		Stream:   stream,
+		Text:     text,
+	})
+
+	if annotation := o.annotations.add(text, o.lineCount); annotation != nil {
+		events.GetBroker().Publish(RunLogTopic(o.runID), "annotation", "", annotation)
+	}
+}
+
+// keepRecent adds a line to the latest output, dropping the oldest lines past recentOutputLimit
+func (o *stepOutput) keepRecent(text string) {
+	o.recent = append(o.recent, text)
+	o.recentBytes += len(text) + 1
+	for o.recentBytes > recentOutputLimit && len(o.recent) > 1 {
+		o.recentBytes -= len(o.recent[0]) + 1
+		o.recent = o.recent[1:]
+	}
+}
+
+// keepForLog queues a line for the log file while the first half of the limit
+// lasts, then keeps it in the tail, dropping the oldest tail lines past the
+// other half
+func (o *stepOutput) keepForLog(line storage.OutputLine) {
+	size := int64(len(line.Text) + 1)
+	headLimit := o.limit / 2
+	if !o.truncating && o.headBytes+size <= headLimit {
+		o.pending = append(o.pending, line)
+		o.headBytes += size
+		return
+	}
+
+	o.truncating = true
+	o.tail = append(o.tail, line)
+	o.tailBytes += size
+	for o.tailBytes > o.limit-headLimit && len(o.tail) > 0 {
+		dropped := int64(len(o.tail[0].Text) + 1)
+		o.tailBytes -= dropped
+		o.droppedBytes += dropped
+		o.droppedLines++
+		o.tail = o.tail[1:]
+	}
+}
+
+// String returns the latest output, one line per line
+func (o *stepOutput) String() string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(o.recent) == 0 {
+		return ""
+	}
+	return strings.Join(o.recent, "\n") + "\n"
+}
+
+// flush appends the lines written since the last flush to the log file. They
+// stay pending until written, so LiveStepOutput never misses them.
+func (o *stepOutput) flush() {
+	if o.log == nil {
+		return
+	}
+
+	o.mu.Lock()
+	pending := o.pending
+	o.mu.Unlock()
+	if len(pending) == 0 {
+		return
+	}
+
+	if err := o.log.Append(pending); err != nil {
+		log.Printf("⚠️  Failed to flush output of step %d: %v", o.stepID, err)
+		return
+	}
+
+	o.mu.Lock()
+	o.pending = o.pending[len(pending):]
+	o.flushed += len(pending)
+	o.mu.Unlock()
+}
+
+// startFlushing flushes the output periodically until the returned function is
+// called, which returns once no flush is in progress
+func (o *stepOutput) startFlushing(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				o.flush()
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// finishLog writes what is left of the output to the log file, with a marker
+// where output was left out, closes it and records its size. It returns the
+// size of the log. Call it once the step has stopped writing and flushing.
+func (o *stepOutput) finishLog(store *storage.Storage) int64 {
+	if o.log == nil {
+		return 0
+	}
+	o.flush()
+
+	o.mu.Lock()
+	var rest []storage.OutputLine
+	if marker, ok := o.droppedMarker(); ok {
+		rest = append(rest, marker)
+	}
+	rest = append(rest, o.tail...)
+	if o.annotations.dropped > 0 {
+		offset := time.Since(o.start).Milliseconds()
+		rest = append(rest, storage.OutputLine{
+			Line:     o.lineCount + 1,
+			OffsetMs: &offset,
+			Stream:   storage.StreamSystem,
+			Text:     o.annotations.droppedNote(),
+		})
+	}
+	dropped := o.droppedBytes
+	o.tail = nil
+	o.mu.Unlock()
+
+	if len(rest) > 0 {
+		if err := o.log.Append(rest); err != nil {
+			log.Printf("⚠️  Failed to write output of step %d: %v", o.stepID, err)
+		}
+	}
+	if err := o.log.Close(); err != nil {
+		log.Printf("⚠️  Failed to close log of step %d: %v", o.stepID, err)
+	}
+
+	size := o.log.Size()
+	if err := store.SetStepLogSize(o.stepID, size, dropped); err != nil {
+		log.Printf("⚠️  %v", err)
+	}
+	return size
+}
+
+// finishAnnotations returns the annotations from the workflow commands in the
+// output, with groups that were never ended closed at the last line
+func (o *stepOutput) finishAnnotations() []*storage.Annotation {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.annotations.finish(o.lineCount)
+}
+
+// setResult records how the step ended, for the event sent when it finishes
+func (o *stepOutput) setResult(status string, failure *storage.Failure) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.status = status
+	o.failure = failure
+}
+
+// close stops publishing the step as the live step of its run. Call it once the
+// full output has been stored.
+func (o *stepOutput) close() {
+	if o.runID == 0 {
+		return
+	}
+	liveOutputs.Lock()
+	if liveOutputs.byRun[o.runID] == o {
+		delete(liveOutputs.byRun, o.runID)
+	}
+	liveOutputs.Unlock()
+
+	o.mu.Lock()
+	lineCount, status, failure := o.lineCount, o.status, o.failure
+	o.mu.Unlock()
+
+	data := map[string]interface{}{
+		"run_id":  o.runID,
+		"step_id": o.stepID,
+		"step":    o.step,
+		"status":  status,
+	}
+	if failure != nil {
+		data["failure"] = failure
+	}
+	events.GetBroker().Publish(RunLogTopic(o.runID), "step_finished", LogEventID(o.stepID, lineCount), data)
+}
+
+// lineWriter splits a stream of output into lines for a stepOutput.
+// Each stream (stdout, stderr) needs its own so partial lines don't mix.
+type lineWriter struct {
+	out     *stepOutput
+	stream  string
+	partial []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+		w.out.addLine(w.stream, string(w.partial[:i]))
+		w.partial = w.partial[i+1:]
+	}
+	if len(w.partial) >= maxLineLength {
+		w.out.addLine(w.stream, string(w.partial))
+		w.partial = nil
+	}
+	w.partial = append([]byte(nil), w.partial...)
+	return len(p), nil
+}
+
+// Close records the last line if it did not end with a newline
+func (w *lineWriter) Close() error {
+	if len(w.partial) > 0 {
+		w.out.addLine(w.stream, string(w.partial))
+		w.partial = nil
+	}
+	return nil
+}
+
+// publishRunFinished tells the clients following a run's logs that it is over,
+// and every client that it completed, failed or was cancelled
+func publishRunFinished(runID int, status string, failure *storage.Failure) {
+	data := runFinishedData(runID, status, failure)
+	events.GetBroker().Publish(RunLogTopic(runID), "end", "", data)
+
+	switch status {
+	case "success":
+		events.GetBroker().Broadcast("run_completed", data)
+	case "failed":
+		events.GetBroker().Broadcast("run_failed", data)
+	case "cancelled":
+		events.GetBroker().Broadcast("run_cancelled", data)
+	}
+}
+
+// publishRunRetrying is publishRunFinished for a failed run that is retried
+// automatically: clients are told about the retry instead of the failure
+func publishRunRetrying(runID int, failure *storage.Failure, attempt int) {
+	events.GetBroker().Publish(RunLogTopic(runID), "end", "", runFinishedData(runID, "failed", failure))
+
+	data := runFinishedData(runID, "failed", failure)
+	data["attempt"] = attempt
+	events.GetBroker().Broadcast("run_retrying", data)
+}
+
+// runFinishedData is the data of the events sent when a run finishes
+func runFinishedData(runID int, status string, failure *storage.Failure) map[string]interface{} {
+	data := map[string]interface{}{
+		"run_id": runID,
+		"status": status,
+	}
+	if failure != nil {
+		data["failure"] = failure
+	}
+	return data
+}
+
+// ParseLogEventID parses an ID made by LogEventID
+func ParseLogEventID(id string) (stepID, line int, ok bool) {
+	stepPart, linePart, found := strings.Cut(id, ":")
+	if !found {
+		return 0, 0, false
+	}
+	stepID, err := strconv.Atoi(stepPart)
+	if err != nil {
+		return 0, 0, false
+	}
+	line, err = strconv.Atoi(linePart)
+	if err != nil {
+		return 0, 0, false
+	}
+	return stepID, line, true
+}
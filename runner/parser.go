@@ -11,10 +11,35 @@ type Step struct {
     Name     string `yaml:"name"`
     Run      string `yaml:"run"`
     Category string `yaml:"category,omitempty"` // Optional category (tests, deploy, setup, etc.)
+    // Optional: kill the step if it writes nothing for this long (e.g. "10m")
+    NoOutputTimeout string `yaml:"no_output_timeout,omitempty"`
+    // Optional: resource limits, unset ones fall back to the server defaults
+    Limits *Limits `yaml:"limits,omitempty"`
 }
 
 type Part struct {
     Steps []Step `yaml:"steps"`
+    // Optional: named lock shared with other parts, possibly in other projects
+    Concurrency *Concurrency `yaml:"concurrency,omitempty"`
+}
+
+// Concurrency puts a part in a named group of which only one part runs at a time
+type Concurrency struct {
+    Group string `yaml:"group"` // Lock name, e.g. "deploy-prod"
+    // What to do when the group is busy: "queue" (default) waits for the
+    // running part, "cancel-in-progress" cancels it and everything waiting
+    Policy string `yaml:"policy,omitempty"`
+}
+
+// Concurrency policies for parts
+const (
+    ConcurrencyQueue            = "queue"
+    ConcurrencyCancelInProgress = "cancel-in-progress"
+)
+
+// CancelInProgress reports whether the part cancels older runs in its group
+func (c *Concurrency) CancelInProgress() bool {
+    return c.Policy == ConcurrencyCancelInProgress
 }
 
 type Group struct {
@@ -26,6 +51,130 @@ type Schedule struct {
     Groups []string `yaml:"groups,omitempty"` // "frontend" runs all parts in group
     At     string   `yaml:"at,omitempty"`
     Every  string   `yaml:"every,omitempty"`
+    // What to do when the schedule fires while its previous run is still going:
+    // "skip" (default), "queue", "cancel_previous" or "allow"
+    Overlap string `yaml:"overlap,omitempty"`
+    // Random delay up to this duration added to each fire (e.g. "10m")
+    Jitter string `yaml:"jitter,omitempty"`
+    // Windows during which this schedule must not fire (in addition to server-level ones)
+    Blackout []BlackoutWindow `yaml:"blackout,omitempty"`
+}
+
+// Overlap policies for schedules
+const (
+    OverlapSkip           = "skip"
+    OverlapQueue          = "queue"
+    OverlapCancelPrevious = "cancel_previous"
+    OverlapAllow          = "allow"
+)
+
+// OverlapPolicy returns the schedule's overlap policy, defaulting to "skip"
+func (s Schedule) OverlapPolicy() string {
+    switch s.Overlap {
+    case OverlapQueue, OverlapCancelPrevious, OverlapAllow:
+        return s.Overlap
+    default:
+        return OverlapSkip
+    }
+}
+
+// WatchTrigger runs parts when files in the project directory change
+type WatchTrigger struct {
+    Include  []string `yaml:"include,omitempty"`  // Globs relative to the project dir (default: everything)
+    Exclude  []string `yaml:"exclude,omitempty"`  // Globs to ignore, ".git/**" is always ignored
+    Debounce string   `yaml:"debounce,omitempty"` // Quiet period before triggering (default: 1s)
+    Parts    []string `yaml:"parts,omitempty"`
+    Groups   []string `yaml:"groups,omitempty"`
+}
+
+// GitTrigger runs parts when a watched local branch of the project repository
+// advances, or with fetch one of its remote-tracking branches
+type GitTrigger struct {
+    Branches []string `yaml:"branches,omitempty"` // Branch globs, e.g. "main", "release/*" (default: all)
+    Interval string   `yaml:"interval,omitempty"` // How often to poll the repository (default: 1m)
+    // Fetch every remote on each poll and watch the remote-tracking branches too.
+    // Off by default, since fetching changes the refs of the project's repository.
+    Fetch    bool     `yaml:"fetch,omitempty"`
+    Parts    []string `yaml:"parts,omitempty"`
+    Groups   []string `yaml:"groups,omitempty"`
+}
+
+// PushRule maps pushed branches or tags to the parts they should run
+type PushRule struct {
+    Branches []string `yaml:"branches,omitempty"` // Branch globs, e.g. "main", "release/*"
+    Tags     []string `yaml:"tags,omitempty"`     // Tag globs, e.g. "v*"
+    Parts    []string `yaml:"parts,omitempty"`
+    Groups   []string `yaml:"groups,omitempty"`
+}
+
+// PushRules accepts either a single rule or a list of rules
+type PushRules []PushRule
+
+// UnmarshalYAML allows "push:" to be written as a single mapping
+func (r *PushRules) UnmarshalYAML(value *yaml.Node) error {
+    if value.Kind == yaml.MappingNode {
+        var rule PushRule
+        if err := value.Decode(&rule); err != nil {
+            return err
+        }
+        *r = PushRules{rule}
+        return nil
+    }
+    var rules []PushRule
+    if err := value.Decode(&rules); err != nil {
+        return err
+    }
+    *r = rules
+    return nil
+}
+
+// EventTriggers holds the rules for runs triggered by forge webhooks
+type EventTriggers struct {
+    Push PushRules `yaml:"push,omitempty"`
+}
+
+// Workspace controls where the steps of a run execute. It is either a mode
+// ("shared" or "isolated") or a mapping; a mapping defaults to isolated.
+// Runs in an isolated workspace can be re-run but not resumed, since the
+// fresh copy lacks the files of the steps a resumed run skips.
+type Workspace struct {
+    Mode     string `yaml:"mode,omitempty"`     // "shared" (default) runs in the project directory, "isolated" in a fresh copy
+    Strategy string `yaml:"strategy,omitempty"` // "copy" (default) copies the project directory, "worktree" checks out a git worktree
+    // Keep the workspace of a failed run for inspection instead of removing it
+    KeepOnFailure bool `yaml:"keep_on_failure,omitempty"`
+    // How long kept workspaces stay before they are cleaned up (default: 24h)
+    KeepFor string `yaml:"keep_for,omitempty"`
+}
+
+// Workspace modes and strategies
+const (
+    WorkspaceShared   = "shared"
+    WorkspaceIsolated = "isolated"
+    WorkspaceCopy     = "copy"
+    WorkspaceWorktree = "worktree"
+)
+
+// UnmarshalYAML allows "workspace:" to be written as just the mode
+func (w *Workspace) UnmarshalYAML(value *yaml.Node) error {
+    if value.Kind == yaml.ScalarNode {
+        *w = Workspace{Mode: value.Value}
+        return nil
+    }
+    type plain Workspace
+    var decoded plain
+    if err := value.Decode(&decoded); err != nil {
+        return err
+    }
+    *w = Workspace(decoded)
+    if w.Mode == "" {
+        w.Mode = WorkspaceIsolated
+    }
+    return nil
+}
+
+// Isolated reports whether each run gets its own copy of the project
+func (w *Workspace) Isolated() bool {
+    return w != nil && w.Mode == WorkspaceIsolated
 }
 
 type Config struct {
@@ -37,6 +186,18 @@ type Config struct {
     Groups map[string]Group `yaml:"groups,omitempty"`
     // Schedules for automatic runs
     Schedules []Schedule `yaml:"schedules,omitempty"`
+    // Run parts when project files change
+    Watch *WatchTrigger `yaml:"watch,omitempty"`
+    // Run parts when new commits land on watched branches
+    Git *GitTrigger `yaml:"git,omitempty"`
+    // Run parts when a forge webhook reports a push
+    On *EventTriggers `yaml:"on,omitempty"`
+    // Where steps run: the project directory (default) or an isolated copy per run
+    Workspace *Workspace `yaml:"workspace,omitempty"`
+    // Rules naming why steps fail, checked before the server rules
+    FailureCauses FailureCauses `yaml:"failure_causes,omitempty"`
+    // Re-queue runs that failed on transient errors, instead of the server policy
+    Retry *RetryPolicy `yaml:"retry,omitempty"`
 }
 
 // GetAllParts returns all parts with their steps
@@ -66,6 +227,23 @@ func (c *Config) GetAllParts() map[string][]Step {
     return result
 }
 
+// GetPartConcurrency returns the concurrency settings of a part, or nil if it has none
+func (c *Config) GetPartConcurrency(fullPath string) *Concurrency {
+    groupName, partName := ParsePartName(fullPath)
+
+    var part Part
+    var exists bool
+    if groupName != "" {
+        part, exists = c.Groups[groupName].Parts[partName]
+    } else {
+        part, exists = c.Parts[partName]
+    }
+    if !exists || part.Concurrency == nil || part.Concurrency.Group == "" {
+        return nil
+    }
+    return part.Concurrency
+}
+
 // GetGroup returns all parts within a specific group
 func (c *Config) GetGroup(groupName string) (map[string][]Step, error) {
     group, exists := c.Groups[groupName]
@@ -108,8 +286,13 @@ func LoadConfig(path string) (*Config, error) {
     if err != nil {
         return nil, err
     }
+    return ParseConfig(data)
+}
+
+// ParseConfig parses the contents of a pipego.yml, e.g. a snapshot stored with an execution
+func ParseConfig(data []byte) (*Config, error) {
     var cfg Config
-    err = yaml.Unmarshal(data, &cfg)
+    err := yaml.Unmarshal(data, &cfg)
     if err != nil {
         return nil, err
     }
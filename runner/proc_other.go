@@ -0,0 +1,46 @@
+//go:build !unix
+
+package runner
+
+import (
+	"os"
+	"os/exec"
+	"time"
+
+	"pipego/runner/storage"
+)
+
+// processAlive reports whether a process with the given PID exists
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	_, err := os.FindProcess(pid)
+	return err == nil
+}
+
+// setProcessGroup is a no-op where process groups are not supported
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// killProcessGroup kills the command; processes it spawned may outlive it
+func killProcessGroup(cmd *exec.Cmd) error {
+	if cmd.Process == nil {
+		return nil
+	}
+	return cmd.Process.Kill()
+}
+
+// exitSignal is not available where processes are not terminated by signals
+func exitSignal(state *os.ProcessState) string {
+	return ""
+}
+
+// maxRSSKB is not available without rusage
+func maxRSSKB(state *os.ProcessState) *int64 {
+	return nil
+}
+
+// cpuTimeLimitHit is never reported where CPU time limits are not set
+func cpuTimeLimitHit(usage *storage.StepUsage, soft, hard time.Duration) bool {
+	return false
+}
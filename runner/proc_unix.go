@@ -0,0 +1,109 @@
+//go:build unix
+
+package runner
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+	"time"
+
+	"pipego/runner/storage"
+)
+
+// processAlive reports whether a process with the given PID exists.
+// Zombies (exited, not yet reaped) count as gone where /proc is available.
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	err := syscall.Kill(pid, 0)
+	if err != nil && !errors.Is(err, syscall.EPERM) {
+		return false
+	}
+
+	// The state follows the parenthesised command name: "pid (comm) S ..."
+	stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return true
+	}
+	if idx := bytes.LastIndexByte(stat, ')'); idx >= 0 && idx+2 < len(stat) {
+		return stat[idx+2] != 'Z'
+	}
+	return true
+}
+
+// setProcessGroup starts the command in its own process group, so that
+// killProcessGroup also reaches the processes it spawns
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// killProcessGroup kills the command and every process in its group
+func killProcessGroup(cmd *exec.Cmd) error {
+	if cmd.Process == nil {
+		return nil
+	}
+	err := syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	if errors.Is(err, syscall.ESRCH) {
+		return os.ErrProcessDone
+	}
+	return err
+}
+
+// exitSignal returns the signal that terminated the process, or "" if it exited
+func exitSignal(state *os.ProcessState) string {
+	status, ok := state.Sys().(syscall.WaitStatus)
+	if !ok || !status.Signaled() {
+		return ""
+	}
+	return status.Signal().String()
+}
+
+// maxRSSKB returns the peak resident set size of the process and its waited-for
+// children in kilobytes
+func maxRSSKB(state *os.ProcessState) *int64 {
+	rusage, ok := state.SysUsage().(*syscall.Rusage)
+	if !ok || rusage == nil {
+		return nil
+	}
+	kb := int64(rusage.Maxrss)
+	// ru_maxrss is in bytes on macOS and in kilobytes elsewhere
+	if runtime.GOOS == "darwin" {
+		kb /= 1024
+	}
+	return &kb
+}
+
+// cpuTimeLimitHit reports whether a step was stopped by its CPU time limit:
+// SIGXCPU at the soft limit, or SIGKILL at the hard limit, received by the
+// shell or by the command it reported as exit code 128+n. The step must also
+// have used that much CPU time, which no single process can exceed.
+func cpuTimeLimitHit(usage *storage.StepUsage, soft, hard time.Duration) bool {
+	if usage == nil || usage.UserCPUMs == nil || usage.SystemCPUMs == nil {
+		return false
+	}
+	// rusage is accounted in ticks, allow it to come out slightly below the limit
+	cpuTime := time.Duration(*usage.UserCPUMs+*usage.SystemCPUMs)*time.Millisecond + time.Second
+
+	switch {
+	case stoppedBy(usage, syscall.SIGXCPU):
+		return cpuTime >= soft
+	case stoppedBy(usage, syscall.SIGKILL):
+		return cpuTime >= hard
+	}
+	return false
+}
+
+// stoppedBy reports whether the shell, or the command it ran last, was
+// terminated by the signal
+func stoppedBy(usage *storage.StepUsage, sig syscall.Signal) bool {
+	if usage.Signal != "" {
+		return usage.Signal == sig.String()
+	}
+	return usage.ExitCode != nil && *usage.ExitCode == 128+int(sig)
+}
@@ -0,0 +1,43 @@
+//go:build unix
+
+package runner
+
+import (
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestProcessAlive(t *testing.T) {
+	if !processAlive(os.Getpid()) {
+		t.Error("this process is not alive")
+	}
+	for _, pid := range []int{0, -1} {
+		if processAlive(pid) {
+			t.Errorf("processAlive(%d) = true", pid)
+		}
+	}
+
+	// An exited child that has not been waited for is a zombie, and gone
+	cmd := exec.Command("true")
+	if err := cmd.Start(); err != nil {
+		t.Skip("true not available:", err)
+	}
+	pid := cmd.Process.Pid
+	if _, err := os.Stat("/proc/self/stat"); err == nil {
+		deadline := time.Now().Add(5 * time.Second)
+		for processAlive(pid) && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if processAlive(pid) {
+			t.Error("zombie process is alive")
+		}
+	}
+
+	// Once reaped, the PID is gone
+	cmd.Wait()
+	if processAlive(pid) {
+		t.Error("reaped process is alive")
+	}
+}
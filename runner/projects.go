@@ -2,8 +2,10 @@ package runner
 
 import (
 	"fmt"
+	"log"
 	"os"
 	"path/filepath"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -13,11 +15,51 @@ type Project struct {
 	Name        string `yaml:"name" json:"name"`
 	Path        string `yaml:"path" json:"path"`
 	Description string `yaml:"description,omitempty" json:"description,omitempty"`
+	// Secret shared with the forge for webhook signatures, $VAR references are expanded
+	WebhookSecret string `yaml:"webhook_secret,omitempty" json:"-"`
+	// Pipelines of this project run at once, 0 = no limit
+	MaxConcurrent int `yaml:"max_concurrent,omitempty" json:"max_concurrent,omitempty"`
+}
+
+// GetWebhookSecret returns the webhook secret with environment variables expanded
+func (p *Project) GetWebhookSecret() string {
+	return os.ExpandEnv(p.WebhookSecret)
+}
+
+// DefaultShutdownGrace is how long running pipelines may finish when the server stops
+const DefaultShutdownGrace = 30 * time.Second
+
+// ServerConfig holds server-wide settings from the "server" section of projects.yml
+type ServerConfig struct {
+	Blackout []BlackoutWindow `yaml:"blackout,omitempty" json:"blackout,omitempty"` // Applies to every schedule
+	Workers  int              `yaml:"workers,omitempty" json:"workers,omitempty"`   // Pipelines run at once (default: 2)
+	// How long running pipelines may finish on shutdown before they are cancelled (default: 30s)
+	ShutdownGrace string `yaml:"shutdown_grace,omitempty" json:"shutdown_grace,omitempty"`
+	// Default resource limits of steps, each step may override them
+	Limits *Limits `yaml:"limits,omitempty" json:"limits,omitempty"`
+	// Rules naming why steps fail, checked after the rules of the project
+	FailureCauses FailureCauses `yaml:"failure_causes,omitempty" json:"failure_causes,omitempty"`
+	// Re-queue runs that failed on transient errors, for projects without their own policy
+	Retry *RetryPolicy `yaml:"retry,omitempty" json:"retry,omitempty"`
+}
+
+// GetShutdownGrace returns the shutdown grace period, falling back to the default
+func (c ServerConfig) GetShutdownGrace() time.Duration {
+	if c.ShutdownGrace == "" {
+		return DefaultShutdownGrace
+	}
+	grace, err := time.ParseDuration(c.ShutdownGrace)
+	if err != nil || grace < 0 {
+		log.Printf("⚠️  Invalid shutdown_grace '%s', using %s", c.ShutdownGrace, DefaultShutdownGrace)
+		return DefaultShutdownGrace
+	}
+	return grace
 }
 
 // ProjectsConfig holds the list of all projects
 type ProjectsConfig struct {
-	Projects []Project `yaml:"projects" json:"projects"`
+	Projects []Project    `yaml:"projects" json:"projects"`
+	Server   ServerConfig `yaml:"server,omitempty" json:"server,omitempty"`
 }
 
 // LoadProjects loads the projects configuration from a YAML file
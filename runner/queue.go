@@ -0,0 +1,598 @@
+package runner
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"pipego/events"
+	"pipego/runner/storage"
+)
+
+// DefaultWorkers is the number of pipelines run at once when not configured
+const DefaultWorkers = 2
+
+// ErrQueueClosed is returned when enqueueing into a stopped queue
+var ErrQueueClosed = errors.New("run queue is closed")
+
+// errShutdown is the cause recorded on pipelines cancelled by a server shutdown
+var errShutdown = errors.New("server shutting down")
+
+// RunRequest describes a pipeline run to be queued
+type RunRequest struct {
+	ConfigPath string
+	Parts      []string            // Full part paths to run in order (empty = all parts)
+	Priority   int                 // Higher priorities run first, equal priorities run in FIFO order
+	Trigger    string              // What started the run: "manual", "api", "scheduled", "watch", "git", "webhook"
+	Commit     *storage.CommitInfo // Optional: commit that triggered the run
+	Checkout   bool                // Optional: build Commit in a worktree of it instead of the project directory
+	Context    context.Context     // Optional: cancelling it removes the pipeline from the queue or stops it
+
+	ConfigSnapshot []byte // Optional: run this config instead of reading ConfigPath
+	ParentRunID    int    // Optional: the run this one re-runs or resumes
+	ResumeFromStep int    // Optional: skip this many steps of the first part
+	RetryAttempt   int    // Optional: how many times the pipeline was already retried automatically
+}
+
+// QueuedPipeline is a pipeline waiting in, or being run by, the run queue
+type QueuedPipeline struct {
+	ID          int        `json:"id"`
+	ExecutionID int        `json:"execution_id"`
+	ProjectName string     `json:"project"`
+	ConfigPath  string     `json:"config_path"`
+	Parts       []string   `json:"parts"`
+	RunIDs      []int      `json:"run_ids"`
+	Priority    int        `json:"priority"`
+	Trigger     string     `json:"trigger"`
+	State       string     `json:"state"` // "queued" or "running"
+	EnqueuedAt  time.Time  `json:"enqueued_at"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	BlockedOn   string     `json:"blocked_on,omitempty"` // Lock the pipeline is waiting for
+
+	request    RunRequest
+	startLocks []LockRequest // Locks taken before a worker starts the pipeline
+	runs       map[string]*storage.Run
+	ctx        context.Context
+	cancel     context.CancelCauseFunc
+	done       chan struct{}
+	result     *PipelineResult
+	err        error
+	index      int // position in the pending heap, -1 once taken
+}
+
+// Done is closed once the pipeline has finished or was removed from the queue
+func (p *QueuedPipeline) Done() <-chan struct{} {
+	return p.done
+}
+
+// Result returns the pipeline result, only valid once Done is closed
+func (p *QueuedPipeline) Result() (*PipelineResult, error) {
+	return p.result, p.err
+}
+
+// QueueSnapshot is the current state of the run queue
+type QueueSnapshot struct {
+	Workers int               `json:"workers"`
+	Queued  []*QueuedPipeline `json:"queued"`
+	Running []*QueuedPipeline `json:"running"`
+}
+
+// RunQueue runs queued pipelines on a bounded pool of workers
+type RunQueue struct {
+	storage *storage.Storage
+	workers int
+	locks   *LockManager
+	limits  *Limits
+	causes  FailureCauses
+	retry   *RetryPolicy
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	pending pipelineHeap
+	running map[int]*QueuedPipeline
+	nextID  int
+	closed  bool
+	wg      sync.WaitGroup
+}
+
+// NewRunQueue creates a new run queue with the number of workers of the server config.
+// Pipelines take project and concurrency group locks from locks, which may be nil.
+// Steps without their own limits get the default limits of the server, failed steps
+// the project rules don't classify its failure causes, and projects without a
+// retry policy its retry policy.
+func NewRunQueue(storage *storage.Storage, locks *LockManager, server ServerConfig) *RunQueue {
+	workers := server.Workers
+	if workers <= 0 {
+		workers = DefaultWorkers
+	}
+	q := &RunQueue{
+		storage: storage,
+		workers: workers,
+		locks:   locks,
+		limits:  server.Limits,
+		causes:  server.FailureCauses,
+		retry:   server.Retry,
+		running: make(map[int]*QueuedPipeline),
+	}
+	q.cond = sync.NewCond(&q.mu)
+
+	// Pipelines waiting for a lock may be able to start once it is released
+	if locks != nil {
+		locks.OnRelease(func() {
+			q.mu.Lock()
+			q.cond.Broadcast()
+			q.mu.Unlock()
+		})
+	}
+	return q
+}
+
+// Start launches the workers
+func (q *RunQueue) Start() {
+	log.Printf("🧵 Run queue started with %d worker(s)", q.workers)
+	for i := 0; i < q.workers; i++ {
+		q.wg.Add(1)
+		go q.worker()
+	}
+}
+
+// Stop cancels queued and running pipelines and waits for the workers to exit
+func (q *RunQueue) Stop() {
+	q.Shutdown(0)
+}
+
+// Shutdown stops accepting pipelines and cancels the queued ones. Running
+// pipelines get up to grace to finish before they are cancelled; Shutdown
+// returns once their status has been written.
+func (q *RunQueue) Shutdown(grace time.Duration) {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return
+	}
+	q.closed = true
+	for q.pending.Len() > 0 {
+		p := heap.Pop(&q.pending).(*QueuedPipeline)
+		q.abandon(p, errShutdown.Error())
+	}
+	running := len(q.running)
+	q.cond.Broadcast()
+	q.mu.Unlock()
+
+	workersDone := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(workersDone)
+	}()
+
+	if running > 0 && grace > 0 {
+		log.Printf("⏳ Waiting up to %s for %d running pipeline(s)", grace, running)
+	}
+
+	timer := time.NewTimer(grace)
+	defer timer.Stop()
+
+	select {
+	case <-workersDone:
+	case <-timer.C:
+		q.mu.Lock()
+		for _, p := range q.running {
+			log.Printf("🛑 Cancelling %s (%s): shutdown grace period expired", p.ProjectName, p.Trigger)
+			p.cancel(errShutdown)
+		}
+		q.mu.Unlock()
+		<-workersDone
+	}
+
+	log.Println("🧵 Run queue stopped")
+}
+
+// Enqueue creates queued runs for every part of the request and adds the pipeline to the queue
+func (q *RunQueue) Enqueue(req RunRequest) (*QueuedPipeline, error) {
+	q.mu.Lock()
+	closed := q.closed
+	q.mu.Unlock()
+	if closed {
+		return nil, ErrQueueClosed
+	}
+
+	var cfg *Config
+	var err error
+	if req.ConfigSnapshot != nil {
+		cfg, err = ParseConfig(req.ConfigSnapshot)
+	} else {
+		cfg, err = LoadConfig(req.ConfigPath)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %w", err)
+	}
+
+	parts, err := selectParts(cfg.GetAllParts(), RunPipelineOptions{Parts: req.Parts})
+	if err != nil {
+		return nil, err
+	}
+
+	projectName := filepath.Base(filepath.Dir(req.ConfigPath))
+
+	parent := req.Context
+	if parent == nil {
+		parent = context.Background()
+	}
+	ctx, cancel := context.WithCancelCause(parent)
+
+	p := &QueuedPipeline{
+		ProjectName: projectName,
+		ConfigPath:  req.ConfigPath,
+		Parts:       parts,
+		RunIDs:      make([]int, 0, len(parts)),
+		Priority:    req.Priority,
+		Trigger:     req.Trigger,
+		State:       "queued",
+		EnqueuedAt:  time.Now(),
+		request:     req,
+		runs:        make(map[string]*storage.Run),
+		ctx:         ctx,
+		cancel:      cancel,
+		done:        make(chan struct{}),
+	}
+
+	// Write the execution and its queued runs up front so they are visible while waiting
+	execution, err := q.storage.CreateExecution(req.ConfigPath, projectName, req.Trigger, "queued")
+	if err != nil {
+		cancel(nil)
+		return nil, err
+	}
+	p.ExecutionID = execution.ID
+	if req.Commit != nil {
+		_ = q.storage.SetExecutionCommit(execution.ID, *req.Commit)
+	}
+
+	for _, fullPartPath := range parts {
+		groupName, partName := ParsePartName(fullPartPath)
+		run, err := q.storage.CreateQueuedRun(req.ConfigPath, projectName, groupName, partName)
+		if err == nil {
+			err = q.storage.SetRunExecution(run.ID, execution.ID)
+		}
+		if err != nil {
+			cancel(nil)
+			if run != nil {
+				p.runs[fullPartPath] = run
+			}
+			q.abandon(p, "failed to queue pipeline")
+			return nil, err
+		}
+		if req.Commit != nil {
+			_ = q.storage.SetRunCommit(run.ID, *req.Commit)
+		}
+		if req.ParentRunID != 0 {
+			_ = q.storage.SetRunParent(run.ID, req.ParentRunID)
+		}
+		p.runs[fullPartPath] = run
+		p.RunIDs = append(p.RunIDs, run.ID)
+	}
+	p.startLocks = q.startLocks(cfg, p)
+
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		q.abandon(p, errShutdown.Error())
+		return nil, ErrQueueClosed
+	}
+	q.nextID++
+	p.ID = q.nextID
+	q.supersede(p)
+	heap.Push(&q.pending, p)
+	queued := q.pending.Len()
+	q.cond.Signal()
+	q.mu.Unlock()
+
+	// Cancelling the request context takes the pipeline out of the queue
+	context.AfterFunc(ctx, func() { q.remove(p) })
+
+	log.Printf("📥 Queued %s (%s, priority %d) - %d pipeline(s) waiting", projectName, req.Trigger, req.Priority, queued)
+
+	events.GetBroker().Broadcast("run_queued", map[string]interface{}{
+		"project":      projectName,
+		"parts":        parts,
+		"run_ids":      p.RunIDs,
+		"execution_id": p.ExecutionID,
+		"type":         req.Trigger,
+	})
+
+	return p, nil
+}
+
+// Snapshot returns the queued and running pipelines
+func (q *RunQueue) Snapshot() QueueSnapshot {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	snapshot := QueueSnapshot{
+		Workers: q.workers,
+		Queued:  make([]*QueuedPipeline, 0, q.pending.Len()),
+		Running: make([]*QueuedPipeline, 0, len(q.running)),
+	}
+
+	for _, p := range q.pending {
+		copied := *p
+		snapshot.Queued = append(snapshot.Queued, &copied)
+	}
+	for _, p := range q.running {
+		copied := *p
+		if q.locks != nil {
+			copied.BlockedOn = q.locks.BlockedOn(p.RunIDs)
+		}
+		snapshot.Running = append(snapshot.Running, &copied)
+	}
+
+	// Report pending pipelines in the order they will run
+	sort.Slice(snapshot.Queued, func(i, j int) bool {
+		return pipelineHeap(snapshot.Queued).Less(i, j)
+	})
+	sort.Slice(snapshot.Running, func(i, j int) bool {
+		return snapshot.Running[i].ID < snapshot.Running[j].ID
+	})
+
+	return snapshot
+}
+
+// startLocks returns the locks a pipeline needs before it can start: the
+// project's max_concurrent slot and the concurrency group of its first part.
+// Groups of later parts are waited for by the executor.
+func (q *RunQueue) startLocks(cfg *Config, p *QueuedPipeline) []LockRequest {
+	if q.locks == nil || len(p.Parts) == 0 {
+		return nil
+	}
+
+	var reqs []LockRequest
+	if lockName, limit := q.locks.ProjectLock(p.ConfigPath); limit > 0 {
+		reqs = append(reqs, LockRequest{
+			Name:     lockName,
+			Capacity: limit,
+			Project:  p.ProjectName,
+			RunIDs:   p.RunIDs,
+		})
+	}
+
+	first := p.Parts[0]
+	if concurrency := cfg.GetPartConcurrency(first); concurrency != nil {
+		var runIDs []int
+		if run, ok := p.runs[first]; ok {
+			runIDs = []int{run.ID}
+		}
+		reqs = append(reqs, LockRequest{
+			Name:             concurrency.Group,
+			Capacity:         1,
+			Project:          p.ProjectName,
+			Part:             first,
+			RunIDs:           runIDs,
+			Cancel:           p.cancel,
+			CancelInProgress: concurrency.CancelInProgress(),
+		})
+	}
+	return reqs
+}
+
+// supersede cancels the pipelines holding or waiting for a concurrency group
+// the new pipeline p takes with cancel-in-progress. Callers hold q.mu.
+func (q *RunQueue) supersede(p *QueuedPipeline) {
+	for _, req := range p.startLocks {
+		if !req.CancelInProgress {
+			continue
+		}
+		cause := fmt.Errorf("superseded by a newer run in concurrency group '%s'", req.Name)
+		for _, other := range q.pending {
+			if slices.ContainsFunc(other.startLocks, func(r LockRequest) bool { return r.Name == req.Name }) {
+				other.cancel(cause)
+			}
+		}
+		q.locks.Supersede(req.Name)
+	}
+}
+
+// next takes the first pending pipeline, in priority order, whose start locks
+// are free and takes its locks. Pipelines waiting for a busy lock stay queued
+// without occupying a worker. Returns nil if none can start. Callers hold q.mu.
+func (q *RunQueue) next() (*QueuedPipeline, map[string]func()) {
+	candidates := slices.Clone(q.pending)
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates.Less(i, j)
+	})
+
+	for _, p := range candidates {
+		var held map[string]func()
+		if len(p.startLocks) > 0 {
+			var blocked string
+			held, blocked = q.locks.TryAcquire(p.startLocks)
+			if blocked != "" {
+				p.BlockedOn = blocked
+				continue
+			}
+		}
+		p.BlockedOn = ""
+		heap.Remove(&q.pending, p.index)
+		return p, held
+	}
+	return nil, nil
+}
+
+// worker takes pipelines off the queue until it is stopped
+func (q *RunQueue) worker() {
+	defer q.wg.Done()
+
+	for {
+		q.mu.Lock()
+		var p *QueuedPipeline
+		var held map[string]func()
+		for !q.closed {
+			if p, held = q.next(); p != nil {
+				break
+			}
+			q.cond.Wait()
+		}
+		if p == nil {
+			q.mu.Unlock()
+			return
+		}
+		now := time.Now()
+		p.State = "running"
+		p.StartedAt = &now
+		q.running[p.ID] = p
+		q.mu.Unlock()
+
+		q.run(p, held)
+
+		q.mu.Lock()
+		delete(q.running, p.ID)
+		q.mu.Unlock()
+	}
+}
+
+// run executes a pipeline taken off the queue with its start locks held
+func (q *RunQueue) run(p *QueuedPipeline, held map[string]func()) {
+	defer close(p.done)
+	defer p.cancel(nil)
+	defer func() {
+		// Locks the executor did not take over, e.g. when the config failed to load
+		for _, release := range held {
+			release()
+		}
+	}()
+
+	log.Printf("🚀 Running %s (%s): %v", p.ProjectName, p.Trigger, p.Parts)
+
+	p.result, p.err = RunPipelineWithOptions(p.ConfigPath, RunPipelineOptions{
+		Storage:          q.storage,
+		StreamToTerminal: false,
+		Parts:            p.Parts,
+		Context:          p.ctx,
+		Commit:           p.request.Commit,
+		CheckoutCommit:   p.request.Checkout,
+		Runs:             p.runs,
+		Locks:            q.locks,
+		HeldLocks:        held,
+		ExecutionID:      p.ExecutionID,
+		Trigger:          p.Trigger,
+		ConfigSnapshot:   p.request.ConfigSnapshot,
+		ParentRunID:      p.request.ParentRunID,
+		ResumeFromStep:   p.request.ResumeFromStep,
+		DefaultLimits:    q.limits,
+		FailureCauses:    q.causes,
+		RetryPolicy:      q.retry,
+		RetryAttempt:     p.request.RetryAttempt,
+		AutoRetry:        true,
+	})
+
+	if p.err != nil {
+		log.Printf("❌ Pipeline failed for %s (%s): %v", p.ProjectName, p.Trigger, p.err)
+	} else {
+		log.Printf("✅ Pipeline completed for %s (%s)", p.ProjectName, p.Trigger)
+	}
+
+	if p.result != nil && len(p.result.RetryParts) > 0 {
+		q.retryPipeline(p)
+	}
+}
+
+// retryPipeline re-queues the parts of a pipeline that failed on a transient
+// error, with the same config and commit, linked to the failed run
+func (q *RunQueue) retryPipeline(p *QueuedPipeline) {
+	req := RunRequest{
+		ConfigPath:     p.ConfigPath,
+		Parts:          p.result.RetryParts,
+		Priority:       p.Priority,
+		Trigger:        "retry",
+		Commit:         p.request.Commit,
+		Checkout:       p.request.Checkout,
+		ConfigSnapshot: p.request.ConfigSnapshot,
+		ParentRunID:    p.result.RunID,
+		RetryAttempt:   p.request.RetryAttempt + 1,
+	}
+	if snapshot, err := q.storage.GetExecutionConfig(p.ExecutionID); err == nil && snapshot != "" {
+		req.ConfigSnapshot = []byte(snapshot)
+	}
+
+	retry, err := q.Enqueue(req)
+	if err != nil {
+		// The failure was not announced while a retry was expected
+		log.Printf("⚠️  Failed to retry run %d of %s: %v", p.result.RunID, p.ProjectName, err)
+		events.GetBroker().Broadcast("run_failed", runFinishedData(p.result.RunID, "failed", p.result.Failure))
+		return
+	}
+	log.Printf("🔁 Retrying run %d of %s (attempt %d) as run(s) %v", p.result.RunID, p.ProjectName, req.RetryAttempt, retry.RunIDs)
+}
+
+// remove takes a cancelled pipeline out of the queue if it has not started yet
+func (q *RunQueue) remove(p *QueuedPipeline) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if p.index < 0 {
+		// Already running (the executor stops it) or finished
+		return
+	}
+	heap.Remove(&q.pending, p.index)
+
+	reason := "cancelled while queued"
+	if cause := context.Cause(p.ctx); cause != nil && !errors.Is(cause, context.Canceled) {
+		reason = cause.Error()
+	}
+	q.abandon(p, reason)
+}
+
+// abandon closes the execution and runs of a pipeline that will never start.
+// Callers hold q.mu, or own p before it was added to the queue.
+func (q *RunQueue) abandon(p *QueuedPipeline, reason string) {
+	for _, run := range p.runs {
+		_ = q.storage.AbandonRun(run.ID, "cancelled", reason)
+		_ = q.storage.SetRunFailure(run.ID, storage.Failure{Reason: storage.FailureCancelled, Message: reason})
+		publishRunFinished(run.ID, "cancelled", &storage.Failure{Reason: storage.FailureCancelled, Message: reason})
+	}
+	if p.ExecutionID != 0 {
+		_ = q.storage.FinishExecution(p.ExecutionID, "cancelled", 0, reason)
+	}
+	p.err = fmt.Errorf("pipeline %s", reason)
+	p.cancel(nil)
+	close(p.done)
+}
+
+// pipelineHeap orders pending pipelines by priority, then by enqueue order
+type pipelineHeap []*QueuedPipeline
+
+func (h pipelineHeap) Len() int { return len(h) }
+
+func (h pipelineHeap) Less(i, j int) bool {
+	if h[i].Priority != h[j].Priority {
+		return h[i].Priority > h[j].Priority
+	}
+	return h[i].ID < h[j].ID
+}
+
+func (h pipelineHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *pipelineHeap) Push(x interface{}) {
+	p := x.(*QueuedPipeline)
+	p.index = len(*h)
+	*h = append(*h, p)
+}
+
+func (h *pipelineHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	p := old[n-1]
+	old[n-1] = nil
+	p.index = -1
+	*h = old[:n-1]
+	return p
+}
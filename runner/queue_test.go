@@ -0,0 +1,333 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"pipego/runner/storage"
+)
+
+const testQueueConfig = `parts:
+  build:
+    steps:
+      - name: build
+        run: echo build
+  deploy:
+    concurrency:
+      group: deploy
+    steps:
+      - name: deploy
+        run: echo deploy
+  hotfix:
+    concurrency:
+      group: deploy
+      policy: cancel-in-progress
+    steps:
+      - name: hotfix
+        run: echo hotfix
+`
+
+// newTestQueue starts a queue with one worker running the projects "app",
+// limited to one pipeline at a time, and "lib"
+func newTestQueue(t *testing.T) (*RunQueue, *LockManager, map[string]string) {
+	t.Helper()
+	baseDir := t.TempDir()
+
+	configs := make(map[string]string)
+	for _, name := range []string{"app", "lib"} {
+		dir := filepath.Join(baseDir, name)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		configs[name] = filepath.Join(dir, "pipego.yml")
+		if err := os.WriteFile(configs[name], []byte(testQueueConfig), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	store, err := storage.NewStorage(filepath.Join(baseDir, "pipego.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	locks := NewLockManager(&ProjectsConfig{Projects: []Project{
+		{Name: "app", Path: "app", MaxConcurrent: 1},
+		{Name: "lib", Path: "lib"},
+	}}, baseDir)
+
+	queue := NewRunQueue(store, locks, ServerConfig{Workers: 1})
+	queue.Start()
+	t.Cleanup(func() {
+		queue.Stop()
+		store.Close()
+	})
+	return queue, locks, configs
+}
+
+func enqueueTest(t *testing.T, queue *RunQueue, configPath string, parts ...string) *QueuedPipeline {
+	t.Helper()
+	p, err := queue.Enqueue(RunRequest{ConfigPath: configPath, Parts: parts, Trigger: "api"})
+	if err != nil {
+		t.Fatalf("Enqueue(%v): %v", parts, err)
+	}
+	return p
+}
+
+func waitDone(t *testing.T, p *QueuedPipeline, what string) {
+	t.Helper()
+	select {
+	case <-p.Done():
+	case <-time.After(10 * time.Second):
+		t.Fatalf("%s did not finish", what)
+	}
+}
+
+// queuedBlockedOn returns the lock a queued pipeline waits for, or "" if it is not queued
+func queuedBlockedOn(queue *RunQueue, p *QueuedPipeline) string {
+	for _, queued := range queue.Snapshot().Queued {
+		if queued.ID == p.ID {
+			return queued.BlockedOn
+		}
+	}
+	return ""
+}
+
+func TestRunQueueSkipsPipelinesWaitingForAGroup(t *testing.T) {
+	queue, locks, configs := newTestQueue(t)
+
+	// Another project's deploy holds the group
+	release := acquireNow(t, locks, LockRequest{Name: "deploy", Project: "lib", Part: "deploy"})
+
+	deploy := enqueueTest(t, queue, configs["app"], "deploy")
+	build := enqueueTest(t, queue, configs["lib"], "build")
+
+	// The only worker runs the build instead of waiting for the group
+	waitDone(t, build, "build queued behind a blocked deploy")
+	if _, err := build.Result(); err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	if blocked := queuedBlockedOn(queue, deploy); blocked != "deploy" {
+		t.Errorf("deploy blocked on %q, want deploy", blocked)
+	}
+
+	release()
+	waitDone(t, deploy, "deploy after the group was released")
+	if _, err := deploy.Result(); err != nil {
+		t.Fatalf("deploy failed: %v", err)
+	}
+	if status := locks.Snapshot(); len(status) != 0 {
+		t.Errorf("locks still held after the pipelines finished: %+v", status)
+	}
+}
+
+func TestRunQueueSkipsPipelinesOverProjectLimit(t *testing.T) {
+	queue, locks, configs := newTestQueue(t)
+
+	lockName, limit := locks.ProjectLock(configs["app"])
+	release := acquireNow(t, locks, LockRequest{Name: lockName, Capacity: limit, Project: "app"})
+
+	app := enqueueTest(t, queue, configs["app"], "build")
+	lib := enqueueTest(t, queue, configs["lib"], "build")
+
+	waitDone(t, lib, "pipeline of another project")
+	if blocked := queuedBlockedOn(queue, app); blocked != lockName {
+		t.Errorf("app blocked on %q, want %s", blocked, lockName)
+	}
+
+	release()
+	waitDone(t, app, "pipeline after the project slot was released")
+	if _, err := app.Result(); err != nil {
+		t.Fatalf("app failed: %v", err)
+	}
+}
+
+func TestRunQueueCancelInProgressSupersedesQueued(t *testing.T) {
+	queue, locks, configs := newTestQueue(t)
+
+	holderCtx, cancelHolder := context.WithCancelCause(context.Background())
+	release := acquireNow(t, locks, LockRequest{Name: "deploy", Project: "lib", Part: "deploy", Cancel: cancelHolder})
+
+	older := enqueueTest(t, queue, configs["app"], "deploy")
+	hotfix := enqueueTest(t, queue, configs["lib"], "hotfix")
+
+	waitDone(t, older, "superseded pipeline")
+	if _, err := older.Result(); err == nil || !strings.Contains(err.Error(), "superseded") {
+		t.Errorf("superseded pipeline: err = %v", err)
+	}
+	select {
+	case <-holderCtx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("holder of the group was not cancelled")
+	}
+
+	release()
+	waitDone(t, hotfix, "hotfix")
+	if _, err := hotfix.Result(); err != nil {
+		t.Fatalf("hotfix failed: %v", err)
+	}
+}
+
+// testOrderConfig has parts that note when they run, and one that runs until
+// the release file exists
+const testOrderConfig = `parts:
+  low:
+    steps:
+      - name: low
+        run: echo low >> order
+  normal:
+    steps:
+      - name: normal
+        run: echo normal >> order
+  later:
+    steps:
+      - name: later
+        run: echo later >> order
+  high:
+    steps:
+      - name: high
+        run: echo high >> order
+  slow:
+    steps:
+      - name: slow
+        run: "echo slow >> order; while [ ! -f release ]; do sleep 0.02; done"
+`
+
+// newOrderQueue creates a queue with one worker, not started yet, for a
+// project with testOrderConfig. It returns the queue and the project directory.
+func newOrderQueue(t *testing.T) (*RunQueue, string) {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "pipego.yml"), []byte(testOrderConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+	store, err := storage.NewStorage(filepath.Join(dir, "pipego.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	queue := NewRunQueue(store, nil, ServerConfig{Workers: 1})
+	t.Cleanup(func() {
+		os.WriteFile(filepath.Join(dir, "release"), nil, 0644)
+		queue.Stop()
+		store.Close()
+	})
+	return queue, dir
+}
+
+// runOrder returns the parts that ran, in order
+func runOrder(t *testing.T, dir string) []string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(dir, "order"))
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	return strings.Fields(string(data))
+}
+
+func TestRunQueuePriority(t *testing.T) {
+	queue, dir := newOrderQueue(t)
+	configPath := filepath.Join(dir, "pipego.yml")
+
+	enqueue := func(part string, priority int) *QueuedPipeline {
+		p, err := queue.Enqueue(RunRequest{ConfigPath: configPath, Parts: []string{part}, Priority: priority, Trigger: "api"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	pipelines := []*QueuedPipeline{
+		enqueue("low", -1),
+		enqueue("normal", 0),
+		enqueue("later", 0),
+		enqueue("high", 10),
+	}
+
+	// The snapshot lists them in the order they will run
+	var queued []string
+	for _, p := range queue.Snapshot().Queued {
+		queued = append(queued, p.Parts[0])
+	}
+	want := "high normal later low"
+	if got := strings.Join(queued, " "); got != want {
+		t.Errorf("queued = %s, want %s", got, want)
+	}
+
+	queue.Start()
+	for _, p := range pipelines {
+		waitDone(t, p, "queued pipeline")
+	}
+	if got := strings.Join(runOrder(t, dir), " "); got != want {
+		t.Errorf("ran %s, want %s", got, want)
+	}
+}
+
+func TestRunQueueShutdownWaitsForRunningPipelines(t *testing.T) {
+	queue, dir := newOrderQueue(t)
+	configPath := filepath.Join(dir, "pipego.yml")
+	queue.Start()
+
+	slow := enqueueTest(t, queue, configPath, "slow")
+	for deadline := time.Now().Add(10 * time.Second); len(runOrder(t, dir)) == 0; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("slow pipeline did not start")
+		}
+	}
+	waiting := enqueueTest(t, queue, configPath, "normal")
+
+	// The running pipeline finishes within the grace period
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		os.WriteFile(filepath.Join(dir, "release"), nil, 0644)
+	}()
+	start := time.Now()
+	queue.Shutdown(10 * time.Second)
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Shutdown took %s after the pipeline finished", elapsed)
+	}
+
+	if _, err := slow.Result(); err != nil {
+		t.Errorf("running pipeline failed: %v", err)
+	}
+	// The queued one never starts
+	if _, err := waiting.Result(); err == nil || !strings.Contains(err.Error(), errShutdown.Error()) {
+		t.Errorf("queued pipeline: err = %v, want %v", err, errShutdown)
+	}
+	if got := runOrder(t, dir); strings.Join(got, " ") != "slow" {
+		t.Errorf("ran %v, want only slow", got)
+	}
+	if _, err := queue.Enqueue(RunRequest{ConfigPath: configPath, Trigger: "api"}); !errors.Is(err, ErrQueueClosed) {
+		t.Errorf("Enqueue after shutdown: err = %v, want %v", err, ErrQueueClosed)
+	}
+}
+
+func TestRunQueueShutdownCancelsAfterGrace(t *testing.T) {
+	queue, dir := newOrderQueue(t)
+	configPath := filepath.Join(dir, "pipego.yml")
+	queue.Start()
+
+	slow := enqueueTest(t, queue, configPath, "slow")
+	for deadline := time.Now().Add(10 * time.Second); len(runOrder(t, dir)) == 0; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("slow pipeline did not start")
+		}
+	}
+
+	grace := 300 * time.Millisecond
+	start := time.Now()
+	queue.Shutdown(grace)
+	if elapsed := time.Since(start); elapsed < grace || elapsed > grace+5*time.Second {
+		t.Errorf("Shutdown returned after %s, want shortly after the %s grace period", elapsed, grace)
+	}
+
+	result, err := slow.Result()
+	if err == nil || result == nil || result.Status != "cancelled" {
+		t.Fatalf("pipeline running past the grace period: %+v, %v", result, err)
+	}
+	run, err := queue.storage.GetRun(slow.RunIDs[0])
+	if err != nil || run.Status != "cancelled" {
+		t.Errorf("run = %+v, %v, want it cancelled", run, err)
+	}
+}
@@ -0,0 +1,31 @@
+package runner
+
+import (
+	"log"
+	"os"
+
+	"pipego/runner/storage"
+)
+
+// ReconcileInterruptedRuns marks runs left queued or running by a pipego process
+// that is gone - e.g. a server that was killed - as "interrupted". Runs recorded
+// with this process's PID are orphaned too: it has not started any yet, so they
+// belong to an earlier process that had the same PID (common in containers).
+// Where the owner's start time was recorded, a process with its PID must have
+// started at that time to count as the owner, so a reused PID is not mistaken for it.
+func ReconcileInterruptedRuns(store *storage.Storage) {
+	self := os.Getpid()
+	marked, err := store.ReconcileInterrupted(func(pid int, start string) bool {
+		if pid == self || !processAlive(pid) {
+			return false
+		}
+		return start == "" || storage.ProcessStartTime(pid) == start
+	})
+	if err != nil {
+		log.Printf("⚠️  Failed to reconcile interrupted runs: %v", err)
+		return
+	}
+	if marked > 0 {
+		log.Printf("🧹 Marked %d orphaned run(s) as interrupted", marked)
+	}
+}
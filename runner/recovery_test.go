@@ -0,0 +1,72 @@
+package runner
+
+import (
+	"database/sql"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"pipego/runner/storage"
+)
+
+func TestReconcileInterruptedRuns(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "pipego.db")
+	store, err := storage.NewStorage(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	// A process that is still running owns one run
+	sleeper := exec.Command("sleep", "60")
+	if err := sleeper.Start(); err != nil {
+		t.Skip("sleep not available:", err)
+	}
+	defer func() {
+		sleeper.Process.Kill()
+		sleeper.Wait()
+	}()
+
+	// One left by an earlier process with the PID of this one, one by an
+	// exited process
+	exited := exec.Command("true")
+	if err := exited.Run(); err != nil {
+		t.Skip("true not available:", err)
+	}
+
+	// One by a process that had the PID of the live one before it
+	owners := map[string]int{"live": sleeper.Process.Pid, "reused pid": 0, "exited": exited.Process.Pid, "earlier owner of a live pid": sleeper.Process.Pid}
+	starts := map[string]string{"live": storage.ProcessStartTime(sleeper.Process.Pid), "earlier owner of a live pid": "boot 0"}
+	runs := make(map[string]int)
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for name, pid := range owners {
+		run, err := store.CreateRun("app/pipego.yml", "app", "", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		runs[name] = run.ID
+		if pid == 0 {
+			continue // Recorded with the PID of this process
+		}
+		if _, err := db.Exec("UPDATE runs SET owner_pid = ?, owner_start = ? WHERE id = ?", pid, starts[name], run.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ReconcileInterruptedRuns(store)
+
+	want := map[string]string{"live": "running", "reused pid": "interrupted", "exited": "interrupted", "earlier owner of a live pid": "interrupted"}
+	for name, runID := range runs {
+		run, err := store.GetRun(runID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if run.Status != want[name] {
+			t.Errorf("run of the %s owner is %s, want %s", name, run.Status, want[name])
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+
+	"pipego/runner/storage"
+)
+
+// ErrRunNotFinished is returned when re-running a run that is still queued or running
+var ErrRunNotFinished = errors.New("run has not finished yet")
+
+// ErrNothingToResume is returned when resuming a run whose steps all succeeded
+var ErrNothingToResume = errors.New("run has no failed step to resume from")
+
+// ErrResumeIsolated is returned when resuming a run of a project with an isolated
+// workspace, or a run that checked its commit out: the new run gets a fresh copy
+// of the project, without the files the skipped steps created
+var ErrResumeIsolated = errors.New("runs in an isolated workspace can only be re-run, not resumed")
+
+// PrepareRerun builds the request that re-runs a past run: the same part, commit
+// and config snapshot, with the commit checked out again if the run built it
+// in a worktree. With resume, the new run skips the steps that succeeded and
+// starts at the first step that did not. Runs in an isolated workspace cannot
+// be resumed.
+func PrepareRerun(store *storage.Storage, runID int, resume bool) (RunRequest, error) {
+	run, err := store.GetRun(runID)
+	if err != nil {
+		return RunRequest{}, err
+	}
+
+	if run.Status == "queued" || run.Status == "running" {
+		return RunRequest{}, fmt.Errorf("%w: run #%d is %s", ErrRunNotFinished, run.ID, run.Status)
+	}
+
+	fullPartPath := run.Part
+	if run.Group != "" {
+		fullPartPath = run.Group + "." + run.Part
+	}
+
+	req := RunRequest{
+		ConfigPath:  run.ConfigPath,
+		Parts:       []string{fullPartPath},
+		Trigger:     "rerun",
+		ParentRunID: run.ID,
+	}
+	if run.CommitSHA != "" {
+		commit := run.CommitInfo
+		req.Commit = &commit
+		req.Checkout = run.CheckedOut
+	}
+
+	// Use the config the run was executed with, or the current one for
+	// runs recorded before snapshots were stored
+	if run.ExecutionID != nil {
+		snapshot, err := store.GetExecutionConfig(*run.ExecutionID)
+		if err != nil {
+			return RunRequest{}, err
+		}
+		if snapshot != "" {
+			req.ConfigSnapshot = []byte(snapshot)
+		}
+	}
+
+	if !resume {
+		return req, nil
+	}
+
+	req.Trigger = "resume"
+
+	var cfg *Config
+	if req.ConfigSnapshot != nil {
+		cfg, err = ParseConfig(req.ConfigSnapshot)
+	} else {
+		cfg, err = LoadConfig(req.ConfigPath)
+	}
+	if err != nil {
+		return RunRequest{}, fmt.Errorf("failed to load config: %w", err)
+	}
+
+	if cfg.Workspace.Isolated() || req.Checkout {
+		return RunRequest{}, fmt.Errorf("%w: run #%d", ErrResumeIsolated, run.ID)
+	}
+
+	steps, exists := cfg.GetAllParts()[fullPartPath]
+	if !exists {
+		return RunRequest{}, fmt.Errorf("%w: '%s'", ErrPartNotFound, fullPartPath)
+	}
+
+	executed, err := store.GetStepExecutions(run.ID)
+	if err != nil {
+		return RunRequest{}, err
+	}
+
+	// Steps skipped by an earlier resume count as succeeded
+	resumeFrom := len(executed)
+	for i, stepExec := range executed {
+		if stepExec.Status != "success" && stepExec.Status != "skipped" {
+			resumeFrom = i
+			break
+		}
+	}
+
+	if resumeFrom >= len(steps) {
+		return RunRequest{}, fmt.Errorf("%w: run #%d", ErrNothingToResume, run.ID)
+	}
+
+	// Without a snapshot the config may have changed since the run
+	for i := 0; i < resumeFrom; i++ {
+		if executed[i].Name != steps[i].Name {
+			return RunRequest{}, fmt.Errorf("steps of part '%s' changed since run #%d, re-run it instead", fullPartPath, run.ID)
+		}
+	}
+
+	req.ResumeFromStep = resumeFrom
+	return req, nil
+}
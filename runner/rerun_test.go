@@ -0,0 +1,256 @@
+package runner
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"pipego/runner/storage"
+)
+
+func TestPrepareRerunResume(t *testing.T) {
+	tests := []struct {
+		name       string
+		workspace  string
+		wantResume int
+		wantErr    error
+	}{
+		{"shared workspace", "", 1, nil},
+		{"isolated workspace", "workspace: isolated\n", 0, ErrResumeIsolated},
+	}
+
+	for _, tt := range tests {
+		dir := t.TempDir()
+		configPath := filepath.Join(dir, "app", "pipego.yml")
+		if err := os.MkdirAll(filepath.Dir(configPath), 0755); err != nil {
+			t.Fatal(err)
+		}
+		config := tt.workspace + `parts:
+  build:
+    steps:
+      - name: generate
+        run: echo generated > out.txt
+      - name: check
+        run: exit 1
+`
+		if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		store, err := storage.NewStorage(filepath.Join(dir, "pipego.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer store.Close()
+
+		result, err := RunPipelineWithOptions(configPath, RunPipelineOptions{Storage: store})
+		if err == nil || result == nil {
+			t.Fatalf("%s: the pipeline did not fail: %v", tt.name, err)
+		}
+
+		req, err := PrepareRerun(store, result.RunID, true)
+		if !errors.Is(err, tt.wantErr) {
+			t.Fatalf("%s: PrepareRerun err = %v, want %v", tt.name, err, tt.wantErr)
+		}
+		if err == nil && req.ResumeFromStep != tt.wantResume {
+			t.Errorf("%s: ResumeFromStep = %d, want %d", tt.name, req.ResumeFromStep, tt.wantResume)
+		}
+
+		// Re-running from the start works either way
+		if _, err := PrepareRerun(store, result.RunID, false); err != nil {
+			t.Errorf("%s: re-run: %v", tt.name, err)
+		}
+
+		// The executor refuses to skip steps in a fresh workspace too
+		if tt.wantErr != nil {
+			_, err := RunPipelineWithOptions(configPath, RunPipelineOptions{Storage: store, ResumeFromStep: 1})
+			if !errors.Is(err, ErrResumeIsolated) {
+				t.Errorf("%s: resumed run err = %v, want %v", tt.name, err, ErrResumeIsolated)
+			}
+		}
+	}
+}
+
+// writeConfig writes a pipego.yml into a new project directory and returns its path
+func writeConfig(t *testing.T, dir, config string) string {
+	t.Helper()
+	configPath := filepath.Join(dir, "app", "pipego.yml")
+	if err := os.MkdirAll(filepath.Dir(configPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return configPath
+}
+
+func TestPrepareRerun(t *testing.T) {
+	dir := t.TempDir()
+	config := `parts:
+  build:
+    steps:
+      - name: setup
+        run: echo setup
+      - name: test
+        run: exit 1
+      - name: package
+        run: echo package
+`
+	configPath := writeConfig(t, dir, config)
+	store, err := storage.NewStorage(filepath.Join(dir, "pipego.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	commit := &storage.CommitInfo{CommitSHA: "abc123", Branch: "main"}
+	result, err := RunPipelineWithOptions(configPath, RunPipelineOptions{Storage: store, Commit: commit})
+	if err == nil || result == nil {
+		t.Fatalf("the pipeline did not fail: %v", err)
+	}
+
+	// The config changes after the run, the rerun uses the one it ran with
+	if err := os.WriteFile(configPath, []byte("parts:\n  other:\n    steps:\n      - name: x\n        run: echo x\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := PrepareRerun(store, result.RunID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Trigger != "rerun" || req.ParentRunID != result.RunID || !slices.Equal(req.Parts, []string{"build"}) ||
+		req.ResumeFromStep != 0 || req.ConfigPath != configPath {
+		t.Errorf("rerun request = %+v", req)
+	}
+	if req.Commit == nil || req.Commit.CommitSHA != "abc123" || req.Commit.Branch != "main" || req.Checkout {
+		t.Errorf("rerun commit = %+v, checkout %v, want the commit without a checkout", req.Commit, req.Checkout)
+	}
+	if string(req.ConfigSnapshot) != config {
+		t.Errorf("rerun config = %q, want the snapshot of the run", req.ConfigSnapshot)
+	}
+
+	req, err = PrepareRerun(store, result.RunID, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Trigger != "resume" || req.ResumeFromStep != 1 {
+		t.Errorf("resume request: trigger %q, from step %d, want resume from 1", req.Trigger, req.ResumeFromStep)
+	}
+
+	// Resuming the resumed run counts the skipped step as succeeded
+	resumed, err := RunPipelineWithOptions(configPath, RunPipelineOptions{
+		Storage:        store,
+		Parts:          req.Parts,
+		ConfigSnapshot: req.ConfigSnapshot,
+		ParentRunID:    req.ParentRunID,
+		ResumeFromStep: req.ResumeFromStep,
+	})
+	if err == nil || resumed == nil {
+		t.Fatalf("the resumed pipeline did not fail: %v", err)
+	}
+	if req, err := PrepareRerun(store, resumed.RunID, true); err != nil || req.ResumeFromStep != 1 {
+		t.Errorf("resume of the resumed run: from step %d, %v", req.ResumeFromStep, err)
+	}
+	if run, err := store.GetRun(resumed.RunID); err != nil || run.ParentRunID == nil || *run.ParentRunID != result.RunID {
+		t.Errorf("resumed run = %+v, %v, want run #%d as its parent", run, err, result.RunID)
+	}
+}
+
+func TestPrepareRerunErrors(t *testing.T) {
+	dir := t.TempDir()
+	configPath := writeConfig(t, dir, "parts:\n  build:\n    steps:\n      - name: build\n        run: echo build\n")
+	store, err := storage.NewStorage(filepath.Join(dir, "pipego.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	succeeded, err := RunPipelineWithOptions(configPath, RunPipelineOptions{Storage: store})
+	if err != nil {
+		t.Fatal(err)
+	}
+	queued, err := store.CreateQueuedRun(configPath, "app", "", "build")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := PrepareRerun(store, succeeded.RunID, true); !errors.Is(err, ErrNothingToResume) {
+		t.Errorf("resume of a successful run: err = %v, want %v", err, ErrNothingToResume)
+	}
+	if _, err := PrepareRerun(store, succeeded.RunID, false); err != nil {
+		t.Errorf("rerun of a successful run: %v", err)
+	}
+	for _, resume := range []bool{false, true} {
+		if _, err := PrepareRerun(store, queued.ID, resume); !errors.Is(err, ErrRunNotFinished) {
+			t.Errorf("rerun of a queued run (resume %v): err = %v, want %v", resume, err, ErrRunNotFinished)
+		}
+	}
+	if _, err := PrepareRerun(store, 999, false); err == nil {
+		t.Error("rerun of a missing run succeeded")
+	}
+}
+
+func TestRerunChecksTheCommitOutAgain(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir := t.TempDir()
+	configPath := writeConfig(t, dir, "parts:\n  build:\n    steps:\n      - name: version\n        run: cat version.txt\n      - name: check\n        run: exit 1\n")
+	repo := filepath.Dir(configPath)
+	if err := os.WriteFile(filepath.Join(repo, "version.txt"), []byte("1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	git(t, repo, "init", "-q", "-b", "main")
+	git(t, repo, "add", ".")
+	git(t, repo, "commit", "-q", "-m", "first")
+	sha := strings.TrimSpace(git(t, repo, "rev-parse", "HEAD"))
+
+	store, err := storage.NewStorage(filepath.Join(dir, "pipego.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	result, _ := RunPipelineWithOptions(configPath, RunPipelineOptions{
+		Storage:        store,
+		Commit:         &storage.CommitInfo{CommitSHA: sha},
+		CheckoutCommit: true,
+	})
+	if result == nil || result.RunID == 0 {
+		t.Fatal("the pipeline did not run")
+	}
+
+	// The project directory moves on to another commit
+	if err := os.WriteFile(filepath.Join(repo, "version.txt"), []byte("2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	git(t, repo, "commit", "-q", "-am", "second")
+
+	req, err := PrepareRerun(store, result.RunID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !req.Checkout {
+		t.Fatal("rerun of a checked out run does not check the commit out")
+	}
+	rerun, _ := RunPipelineWithOptions(configPath, RunPipelineOptions{
+		Storage:        store,
+		Parts:          req.Parts,
+		Commit:         req.Commit,
+		CheckoutCommit: req.Checkout,
+		ConfigSnapshot: req.ConfigSnapshot,
+		ParentRunID:    req.ParentRunID,
+	})
+	if rerun == nil || len(rerun.Steps) == 0 || strings.TrimSpace(rerun.Steps[0].Output) != "1" {
+		t.Errorf("rerun = %+v, want the output of the first commit", rerun)
+	}
+
+	// Resuming would skip steps in a fresh worktree
+	if _, err := PrepareRerun(store, result.RunID, true); !errors.Is(err, ErrResumeIsolated) {
+		t.Errorf("resume of a checked out run: err = %v, want %v", err, ErrResumeIsolated)
+	}
+}
@@ -0,0 +1,85 @@
+package runner
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+
+	"pipego/ansi"
+	"pipego/runner/storage"
+)
+
+// DefaultMaxRetries is how many times a run is retried when the policy doesn't say
+const DefaultMaxRetries = 1
+
+// RetryPolicy re-queues a run whose failed step looks transient, such as a
+// dropped connection or a rate limit. The failed part and the parts after it
+// run again in a new run linked to the failed one.
+type RetryPolicy struct {
+	// Regular expressions matched against the output of the failed step, e.g.
+	// "connection reset". Like failure cause patterns, they only see its last
+	// 64 KB: an error followed by a longer trace is not matched, a failure
+	// cause naming it by exit code or reason can be listed in Causes instead.
+	Patterns []string `yaml:"patterns,omitempty" json:"patterns,omitempty"`
+	// Failure causes named by failure_causes, e.g. "network_timeout"
+	Causes []string `yaml:"causes,omitempty" json:"causes,omitempty"`
+	Max    *int     `yaml:"max,omitempty" json:"max,omitempty"` // Retries per run (default: 1, 0 disables retries)
+
+	patterns []*regexp.Regexp // Patterns, compiled by Validate
+}
+
+// Validate checks the retry count and the patterns, and compiles the patterns
+// for shouldRetry
+func (p *RetryPolicy) Validate() error {
+	if p == nil {
+		return nil
+	}
+	if p.Max != nil && *p.Max < 0 {
+		return fmt.Errorf("invalid retry max %d", *p.Max)
+	}
+	patterns := make([]*regexp.Regexp, 0, len(p.Patterns))
+	for _, pattern := range p.Patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid retry pattern '%s': %w", pattern, err)
+		}
+		patterns = append(patterns, re)
+	}
+	p.patterns = patterns
+	return nil
+}
+
+// GetMax returns how many times a run is retried, falling back to the default
+func (p *RetryPolicy) GetMax() int {
+	if p.Max == nil {
+		return DefaultMaxRetries
+	}
+	return *p.Max
+}
+
+// shouldRetry reports whether a run that failed with failure, after attempt
+// earlier retries, should be retried. output is the end of the output of the
+// failed step. The policy must have been validated for its patterns to match.
+func (p *RetryPolicy) shouldRetry(failure *storage.Failure, output string, attempt int) bool {
+	if p == nil || failure == nil || attempt >= p.GetMax() {
+		return false
+	}
+	// Only failed steps can be transient, not cancellations or invalid configs
+	if failure.Step == "" || failure.Reason == storage.FailureCancelled || failure.Reason == storage.FailureConfigError {
+		return false
+	}
+
+	if failure.Cause != "" && slices.Contains(p.Causes, failure.Cause) {
+		return true
+	}
+	if len(p.patterns) == 0 {
+		return false
+	}
+	text := ansi.ConvertString(output, ansi.FormatText)
+	for _, re := range p.patterns {
+		if re.MatchString(text) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,268 @@
+package runner
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"pipego/events"
+	"pipego/runner/storage"
+)
+
+func TestRetryPolicyShouldRetry(t *testing.T) {
+	intPtr := func(n int) *int { return &n }
+	policy := &RetryPolicy{
+		Patterns: []string{`connection reset`, `(?i)rate limit exceeded`},
+		Causes:   []string{"network_timeout"},
+	}
+	if err := policy.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	failed := func(reason, cause string) *storage.Failure {
+		return &storage.Failure{Reason: reason, Step: "fetch", Cause: cause}
+	}
+
+	tests := []struct {
+		name    string
+		policy  *RetryPolicy
+		failure *storage.Failure
+		output  string
+		attempt int
+		want    bool
+	}{
+		{"pattern in the output", policy, failed(storage.FailureCommandFailed, ""), "read: connection reset by peer", 0, true},
+		{"pattern ignoring case", policy, failed(storage.FailureCommandFailed, ""), "API Rate Limit Exceeded", 0, true},
+		{"pattern across escape sequences", policy, failed(storage.FailureCommandFailed, ""), "connection \x1b[31mreset\x1b[0m", 0, true},
+		{"classified cause", policy, failed(storage.FailureCommandFailed, "network_timeout"), "", 0, true},
+		{"timeout with a pattern", policy, failed(storage.FailureTimeout, ""), "connection reset", 0, true},
+		{"other cause", policy, failed(storage.FailureCommandFailed, "test_failure"), "FAIL", 0, false},
+		{"no match", policy, failed(storage.FailureCommandFailed, ""), "undefined: foo", 0, false},
+		{"retries used up", policy, failed(storage.FailureCommandFailed, "network_timeout"), "", 1, false},
+		{"higher max", &RetryPolicy{Causes: []string{"network_timeout"}, Max: intPtr(3)}, failed(storage.FailureCommandFailed, "network_timeout"), "", 2, true},
+		{"higher max used up", &RetryPolicy{Causes: []string{"network_timeout"}, Max: intPtr(3)}, failed(storage.FailureCommandFailed, "network_timeout"), "", 3, false},
+		{"retries disabled", &RetryPolicy{Causes: []string{"network_timeout"}, Max: intPtr(0)}, failed(storage.FailureCommandFailed, "network_timeout"), "", 0, false},
+		{"cancelled", policy, failed(storage.FailureCancelled, "network_timeout"), "connection reset", 0, false},
+		{"invalid config", policy, failed(storage.FailureConfigError, ""), "connection reset", 0, false},
+		{"no failed step", policy, &storage.Failure{Reason: storage.FailureStorageError}, "connection reset", 0, false},
+		{"no failure", policy, nil, "connection reset", 0, false},
+		{"no policy", nil, failed(storage.FailureCommandFailed, "network_timeout"), "connection reset", 0, false},
+		{"patterns not validated", &RetryPolicy{Patterns: []string{"connection reset"}}, failed(storage.FailureCommandFailed, ""), "connection reset", 0, false},
+	}
+	for _, tt := range tests {
+		if got := tt.policy.shouldRetry(tt.failure, tt.output, tt.attempt); got != tt.want {
+			t.Errorf("%s: shouldRetry = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRetryPolicyValidate(t *testing.T) {
+	intPtr := func(n int) *int { return &n }
+	valid := []*RetryPolicy{
+		nil,
+		{},
+		{Max: intPtr(0)},
+		{Patterns: []string{`connection (reset|refused)`}, Causes: []string{"network_timeout"}, Max: intPtr(2)},
+	}
+	for _, policy := range valid {
+		if err := policy.Validate(); err != nil {
+			t.Errorf("Validate(%+v): %v", policy, err)
+		}
+	}
+
+	invalid := []*RetryPolicy{
+		{Max: intPtr(-1)},
+		{Patterns: []string{`connection (reset`}},
+	}
+	for _, policy := range invalid {
+		if err := policy.Validate(); err == nil {
+			t.Errorf("Validate(%+v) accepted an invalid policy", policy)
+		}
+	}
+
+	if got := (&RetryPolicy{}).GetMax(); got != DefaultMaxRetries {
+		t.Errorf("default max = %d, want %d", got, DefaultMaxRetries)
+	}
+}
+
+// The fetch step fails with a transient error until the attempt given in the
+// file "succeed_at", counting attempts in "attempts"
+const testRetryConfig = `retry:
+  patterns: ["connection reset"]
+  max: 2
+parts:
+  fetch:
+    steps:
+      - name: fetch
+        run: |
+          echo x >> attempts
+          if [ "$(wc -l < attempts)" -lt "$(cat succeed_at)" ]; then echo "read: connection reset by peer" >&2; exit 1; fi
+  upload:
+    steps:
+      - name: upload
+        run: echo upload
+`
+
+// newRetryQueue starts a queue for a project with testRetryConfig whose fetch
+// step succeeds on the given attempt. It returns the queue and the config path.
+func newRetryQueue(t *testing.T, succeedAt int) (*RunQueue, string) {
+	t.Helper()
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "pipego.yml")
+	if err := os.WriteFile(configPath, []byte(testRetryConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "succeed_at"), []byte(strconv.Itoa(succeedAt)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	store, err := storage.NewStorage(filepath.Join(dir, "pipego.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	queue := NewRunQueue(store, nil, ServerConfig{Workers: 1})
+	queue.Start()
+	t.Cleanup(func() {
+		queue.Stop()
+		store.Close()
+	})
+	return queue, configPath
+}
+
+// watchRunFailed collects the IDs of the runs announced as failed
+func watchRunFailed(t *testing.T) func() []int {
+	t.Helper()
+	client := make(chan string, 100)
+	events.GetBroker().Register(client)
+	var failed []int
+	collect := func() {
+		for {
+			select {
+			case message := <-client:
+				data, ok := strings.CutPrefix(message, "event: run_failed\ndata: ")
+				if !ok {
+					continue
+				}
+				var event struct {
+					RunID int `json:"run_id"`
+				}
+				if err := json.Unmarshal([]byte(strings.TrimSpace(data)), &event); err != nil {
+					t.Errorf("run_failed event %q: %v", message, err)
+				}
+				failed = append(failed, event.RunID)
+			default:
+				return
+			}
+		}
+	}
+	t.Cleanup(func() { events.GetBroker().Unregister(client) })
+	return func() []int {
+		collect()
+		return failed
+	}
+}
+
+// waitRetry waits for the retry of a run to finish and returns its run of part
+func waitRetry(t *testing.T, store *storage.Storage, runID int, part string) *storage.Run {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		children, err := store.GetChildRuns(runID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, child := range children {
+			if child.Part == part && child.FinishedAt != nil {
+				return child
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("retry of run %d did not finish", runID)
+	return nil
+}
+
+// waitIdle waits until the queue has nothing queued or running
+func waitIdle(t *testing.T, queue *RunQueue) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		snapshot := queue.Snapshot()
+		if len(snapshot.Queued) == 0 && len(snapshot.Running) == 0 {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatal("queue did not become idle")
+}
+
+func TestRunQueueRetriesTransientFailure(t *testing.T) {
+	queue, configPath := newRetryQueue(t, 2)
+	runFailed := watchRunFailed(t)
+
+	p := enqueueTest(t, queue, configPath)
+	waitDone(t, p, "pipeline")
+	result, err := p.Result()
+	if err == nil {
+		t.Fatal("first attempt succeeded")
+	}
+	if strings.Join(result.RetryParts, ",") != "fetch,upload" {
+		t.Fatalf("retry parts = %v, want the failed part and the ones after it", result.RetryParts)
+	}
+
+	retry := waitRetry(t, queue.storage, result.RunID, "fetch")
+	if retry.Status != "success" {
+		t.Fatalf("retry status = %s, want success", retry.Status)
+	}
+	if retry.ParentRunID == nil || *retry.ParentRunID != result.RunID {
+		t.Errorf("retry parent = %v, want run %d", retry.ParentRunID, result.RunID)
+	}
+	execution, err := queue.storage.GetExecution(*retry.ExecutionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if execution.Trigger != "retry" {
+		t.Errorf("retry trigger = %s, want retry", execution.Trigger)
+	}
+	if upload := waitRetry(t, queue.storage, result.RunID, "upload"); upload.Status != "success" {
+		t.Errorf("upload after the retry = %s, want success", upload.Status)
+	}
+
+	waitIdle(t, queue)
+	if failed := runFailed(); len(failed) != 0 {
+		t.Errorf("run_failed sent for runs %v although the retry succeeded", failed)
+	}
+}
+
+func TestRunQueueRetriesUpToMax(t *testing.T) {
+	queue, configPath := newRetryQueue(t, 10)
+	runFailed := watchRunFailed(t)
+
+	p := enqueueTest(t, queue, configPath)
+	waitDone(t, p, "pipeline")
+	result, _ := p.Result()
+	if len(result.RetryParts) == 0 {
+		t.Fatal("first attempt not retried")
+	}
+
+	// The config allows two retries, each linked to the attempt before it. Only
+	// the last one is announced as failed.
+	runID := result.RunID
+	for attempt := 1; attempt <= 2; attempt++ {
+		retry := waitRetry(t, queue.storage, runID, "fetch")
+		if retry.Status != "failed" {
+			t.Fatalf("retry %d status = %s, want failed", attempt, retry.Status)
+		}
+		runID = retry.ID
+	}
+
+	waitIdle(t, queue)
+	if children, err := queue.storage.GetChildRuns(runID); err != nil || len(children) != 0 {
+		t.Errorf("last retry was retried again: %v, %v", children, err)
+	}
+	if failed := runFailed(); len(failed) != 1 || failed[0] != runID {
+		t.Errorf("run_failed sent for runs %v, want only the last retry %d", failed, runID)
+	}
+}
@@ -1,9 +1,13 @@
 package runner
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"math/rand/v2"
+	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -17,27 +21,45 @@ import (
 type Scheduler struct {
 	projectsConfig *ProjectsConfig
 	storage        *storage.Storage
+	queue          *RunQueue
 	baseDir        string
 	stopChan       chan struct{}
-	lastRuns       map[string]time.Time // track last execution per schedule
-	mu             sync.RWMutex         // protect lastRuns map
-	runningJobs    map[string]bool      // track currently running schedules
+	stopped        chan struct{}
+	lastRuns       map[string]time.Time       // track last execution per schedule
+	mu             sync.RWMutex               // protect lastRuns, runningJobs and queuedJobs
+	runningJobs    map[string][]*scheduledJob // track currently running executions per schedule
+	queuedJobs     map[string]bool            // an occurrence waits for the running execution (overlap: queue)
+	deferred       map[string]bool            // schedules held back by a "defer" blackout window
+	invalid        map[string]string          // why schedules are not fired, logged when it changes
+}
+
+// scheduledJob is a single in-flight execution of a schedule
+type scheduledJob struct {
+	cancel context.CancelFunc
+	done   chan struct{} // closed once the execution has finished
 }
 
 // NewScheduler creates a new scheduler instance
-func NewScheduler(projectsConfig *ProjectsConfig, storage *storage.Storage, baseDir string) *Scheduler {
+func NewScheduler(projectsConfig *ProjectsConfig, storage *storage.Storage, queue *RunQueue, baseDir string) *Scheduler {
 	return &Scheduler{
 		projectsConfig: projectsConfig,
 		storage:        storage,
+		queue:          queue,
 		baseDir:        baseDir,
 		stopChan:       make(chan struct{}),
+		stopped:        make(chan struct{}),
 		lastRuns:       make(map[string]time.Time),
-		runningJobs:    make(map[string]bool),
+		runningJobs:    make(map[string][]*scheduledJob),
+		queuedJobs:     make(map[string]bool),
+		deferred:       make(map[string]bool),
+		invalid:        make(map[string]string),
 	}
 }
 
 // Start begins the scheduler loop
 func (s *Scheduler) Start() {
+	defer close(s.stopped)
+
 	log.Println("📅 Scheduler started")
 	ticker := time.NewTicker(1 * time.Minute)
 	defer ticker.Stop()
@@ -56,9 +78,11 @@ func (s *Scheduler) Start() {
 	}
 }
 
-// Stop gracefully stops the scheduler
+// Stop gracefully stops the scheduler and waits until it no longer fires schedules.
+// Scheduled runs already in the run queue are left to the queue.
 func (s *Scheduler) Stop() {
 	close(s.stopChan)
+	<-s.stopped
 }
 
 // tick checks all schedules and triggers runs if needed
@@ -81,48 +105,307 @@ func (s *Scheduler) tick() {
 		// Check each schedule
 		for i, schedule := range cfg.Schedules {
 			scheduleKey := fmt.Sprintf("%s-schedule-%d", project.Name, i)
+
+			// An invalid schedule is not fired at all
+			if err := schedule.Validate(); err != nil {
+				s.reportInvalid(scheduleKey, fmt.Sprintf("⚠️  Schedule %d of %s not fired: %v", i, project.Name, err))
+				continue
+			}
+			s.reportInvalid(scheduleKey, "")
 			
 			// Check if schedule should run
 			s.mu.RLock()
 			lastRun := s.lastRuns[scheduleKey]
-			isRunning := s.runningJobs[scheduleKey]
+			deferred := s.deferred[scheduleKey]
 			s.mu.RUnlock()
 
-			// Skip if already running
-			if isRunning {
+			// A deferred schedule fires as soon as its blackout window ends
+			if !deferred && !s.shouldRun(schedule, lastRun) {
 				continue
 			}
 
-			if s.shouldRun(schedule, lastRun) {
-				// Validate parts exist
-				if len(schedule.Parts) > 0 {
-					allParts := cfg.GetAllParts()
-					for _, partName := range schedule.Parts {
-						if _, exists := allParts[partName]; !exists {
-							log.Printf("⚠️  Schedule skipped: part '%s' not found in %s", partName, project.Name)
-							continue
-						}
+			// Respect blackout windows (server-level and the schedule's own)
+			if window, active := s.activeBlackout(schedule, time.Now()); active {
+				if window.ActionOrDefault() == BlackoutDefer {
+					if !deferred {
+						log.Printf("⏸️  Schedule deferred for %s: blackout window %s", project.Name, window.Describe())
+						s.mu.Lock()
+						s.deferred[scheduleKey] = true
+						s.mu.Unlock()
 					}
+					continue
 				}
 
-				// Mark as running
 				s.mu.Lock()
-				s.runningJobs[scheduleKey] = true
 				s.lastRuns[scheduleKey] = time.Now()
+				delete(s.deferred, scheduleKey)
+				s.mu.Unlock()
+
+				s.recordSkipped(configPath, cfg, schedule, fmt.Sprintf("blackout window %s", window.Describe()))
+				continue
+			}
+
+			if deferred {
+				log.Printf("▶️  Blackout window ended, running deferred schedule for %s", project.Name)
+			}
+
+			// Validate parts exist
+			if len(schedule.Parts) > 0 {
+				allParts := cfg.GetAllParts()
+				for _, partName := range schedule.Parts {
+					if _, exists := allParts[partName]; !exists {
+						log.Printf("⚠️  Schedule skipped: part '%s' not found in %s", partName, project.Name)
+						continue
+					}
+				}
+			}
+
+			s.mu.Lock()
+			s.lastRuns[scheduleKey] = time.Now()
+			delete(s.deferred, scheduleKey)
+			running := append([]*scheduledJob(nil), s.runningJobs[scheduleKey]...)
+			s.mu.Unlock()
+
+			delay := jitterDelay(schedule)
+
+			if len(running) == 0 {
+				s.startJob(project.Name, schedule, scheduleKey, nil, delay)
+				continue
+			}
+
+			// Previous execution is still in progress - apply the overlap policy
+			switch schedule.OverlapPolicy() {
+			case OverlapAllow:
+				s.startJob(project.Name, schedule, scheduleKey, nil, delay)
+			case OverlapQueue:
+				// One waiting occurrence catches up, more would only pile up behind a slow run
+				s.mu.Lock()
+				alreadyQueued := s.queuedJobs[scheduleKey]
+				s.queuedJobs[scheduleKey] = true
 				s.mu.Unlock()
+				if alreadyQueued {
+					s.recordSkipped(configPath, cfg, schedule, "an occurrence is already queued behind the running one")
+					continue
+				}
+				log.Printf("⏳ Schedule queued: %s is still running previous occurrence", project.Name)
+			case OverlapCancelPrevious:
+				log.Printf("🛑 Cancelling previous scheduled run of %s", project.Name)
+				for _, job := range running {
+					job.cancel()
+				}
+				s.startJob(project.Name, schedule, scheduleKey, running, delay)
+			default:
+				s.recordSkipped(configPath, cfg, schedule, "previous scheduled run still in progress")
+			}
+		}
+	}
+}
+
+// startJob runs a schedule in the background, after waiting for the given jobs
+// to finish and then for the jitter delay
+func (s *Scheduler) startJob(projectName string, schedule Schedule, key string, waitFor []*scheduledJob, delay time.Duration) {
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &scheduledJob{cancel: cancel, done: make(chan struct{})}
 
-				// Execute in goroutine
-				go func(p Project, sched Schedule, key string) {
-					s.executeSchedule(p.Name, sched)
-					
-					// Mark as not running
-					s.mu.Lock()
-					delete(s.runningJobs, key)
-					s.mu.Unlock()
-				}(project, schedule, scheduleKey)
+	s.mu.Lock()
+	s.runningJobs[key] = append(s.runningJobs[key], job)
+	s.mu.Unlock()
+
+	go func() {
+		defer close(job.done)
+		defer cancel()
+
+		for _, previous := range waitFor {
+			<-previous.done
+		}
+
+		if delay > 0 {
+			log.Printf("🎲 Schedule for %s delayed by %s (jitter)", projectName, delay.Round(time.Second))
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
 			}
 		}
+
+		// The waits can carry the fire into a blackout window
+		if ctx.Err() == nil && !s.blackedOut(projectName, schedule, key) {
+			s.executeSchedule(ctx, projectName, schedule)
+		}
+		s.finishJob(projectName, schedule, key, job)
+	}()
+}
+
+// finishJob removes a finished job and starts a queued occurrence if one is waiting
+func (s *Scheduler) finishJob(projectName string, schedule Schedule, key string, job *scheduledJob) {
+	s.mu.Lock()
+	jobs := s.runningJobs[key]
+	for i, j := range jobs {
+		if j == job {
+			jobs = append(jobs[:i], jobs[i+1:]...)
+			break
+		}
+	}
+	if len(jobs) == 0 {
+		delete(s.runningJobs, key)
+	} else {
+		s.runningJobs[key] = jobs
+	}
+
+	startQueued := len(jobs) == 0 && s.queuedJobs[key]
+	if startQueued {
+		delete(s.queuedJobs, key)
+	}
+	s.mu.Unlock()
+
+	if startQueued {
+		log.Printf("⏳ Starting queued scheduled run: %s", projectName)
+		s.startJob(projectName, schedule, key, nil, 0)
+	}
+}
+
+// blackedOut checks the blackout windows again when a delayed fire starts. In
+// a window it skips the fire, recording it, or defers it to the window's end.
+func (s *Scheduler) blackedOut(projectName string, schedule Schedule, key string) bool {
+	window, active := s.activeBlackout(schedule, time.Now())
+	if !active {
+		return false
+	}
+
+	if window.ActionOrDefault() == BlackoutDefer {
+		log.Printf("⏸️  Schedule deferred for %s: blackout window %s", projectName, window.Describe())
+		s.mu.Lock()
+		s.deferred[key] = true
+		s.mu.Unlock()
+		return true
+	}
+
+	project, err := s.projectsConfig.GetProject(projectName)
+	if err != nil {
+		log.Printf("❌ Schedule execution failed: %v", err)
+		return true
+	}
+	configPath := project.GetPipegoPath(s.baseDir)
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		log.Printf("❌ Failed to load config for %s: %v", projectName, err)
+		return true
 	}
+	s.recordSkipped(configPath, cfg, schedule, fmt.Sprintf("blackout window %s", window.Describe()))
+	return true
+}
+
+// reportInvalid logs why a schedule is not fired when the reason changes; an
+// empty message clears it
+func (s *Scheduler) reportInvalid(key, message string) {
+	s.mu.Lock()
+	changed := s.invalid[key] != message
+	if message == "" {
+		delete(s.invalid, key)
+	} else {
+		s.invalid[key] = message
+	}
+	s.mu.Unlock()
+
+	if changed && message != "" {
+		log.Print(message)
+	}
+}
+
+// Validate checks the overlap policy and blackout windows of a schedule
+func (s Schedule) Validate() error {
+	if s.Overlap != "" && s.Overlap != s.OverlapPolicy() {
+		return fmt.Errorf("unknown overlap policy '%s', expected %s, %s, %s or %s",
+			s.Overlap, OverlapSkip, OverlapQueue, OverlapCancelPrevious, OverlapAllow)
+	}
+	return ValidateBlackout(s.Blackout)
+}
+
+// activeBlackout returns the first blackout window covering now, checking
+// server-level windows before the schedule's own
+func (s *Scheduler) activeBlackout(schedule Schedule, now time.Time) (BlackoutWindow, bool) {
+	windows := append(append([]BlackoutWindow(nil), s.projectsConfig.Server.Blackout...), schedule.Blackout...)
+	for _, window := range windows {
+		active, err := window.Active(now)
+		if err != nil {
+			log.Printf("⚠️  Invalid blackout window %s: %v", window.Describe(), err)
+			continue
+		}
+		if active {
+			return window, true
+		}
+	}
+	return BlackoutWindow{}, false
+}
+
+// jitterDelay picks a random delay within the schedule's jitter window
+func jitterDelay(schedule Schedule) time.Duration {
+	if schedule.Jitter == "" {
+		return 0
+	}
+
+	jitter, err := parseInterval(schedule.Jitter)
+	if err != nil || jitter <= 0 {
+		log.Printf("⚠️  Invalid jitter '%s': %v", schedule.Jitter, err)
+		return 0
+	}
+
+	return rand.N(jitter)
+}
+
+// recordSkipped stores a skipped run for every part the schedule would have run
+func (s *Scheduler) recordSkipped(configPath string, cfg *Config, schedule Schedule, reason string) {
+	projectName := filepath.Base(filepath.Dir(configPath))
+
+	log.Printf("⏭️  Schedule skipped for %s: %s", projectName, reason)
+
+	for _, fullPartPath := range resolveScheduleParts(cfg, schedule) {
+		groupName, partName := ParsePartName(fullPartPath)
+		run, err := s.storage.CreateSkippedRun(configPath, projectName, groupName, partName, reason)
+		if err != nil {
+			log.Printf("❌ Failed to record skipped run for %s (%s): %v", projectName, fullPartPath, err)
+			continue
+		}
+
+		events.GetBroker().Broadcast("run_skipped", map[string]interface{}{
+			"run_id":  run.ID,
+			"project": projectName,
+			"part":    fullPartPath,
+			"reason":  reason,
+			"type":    "scheduled",
+		})
+	}
+}
+
+// resolveScheduleParts returns the full part paths a schedule covers,
+// expanding groups and falling back to every part when none are listed
+func resolveScheduleParts(cfg *Config, schedule Schedule) []string {
+	return resolveParts(cfg, schedule.Parts, schedule.Groups)
+}
+
+// resolveParts expands groups into their parts and appends the listed parts,
+// falling back to every part of the config when nothing is listed
+func resolveParts(cfg *Config, parts, groups []string) []string {
+	partsToRun := make([]string, 0)
+
+	for _, groupName := range groups {
+		groupParts, err := cfg.GetGroup(groupName)
+		if err != nil {
+			continue
+		}
+		for partPath := range groupParts {
+			partsToRun = append(partsToRun, partPath)
+		}
+	}
+	partsToRun = append(partsToRun, parts...)
+
+	if len(partsToRun) == 0 {
+		for partPath := range cfg.GetAllParts() {
+			partsToRun = append(partsToRun, partPath)
+		}
+	}
+
+	sort.Strings(partsToRun)
+	return partsToRun
 }
 
 // shouldRun determines if a schedule should be triggered now
@@ -166,7 +449,7 @@ func (s *Scheduler) shouldRun(schedule Schedule, lastRun time.Time) bool {
 }
 
 // executeSchedule triggers a pipeline run for the given schedule
-func (s *Scheduler) executeSchedule(projectName string, schedule Schedule) {
+func (s *Scheduler) executeSchedule(ctx context.Context, projectName string, schedule Schedule) {
 	project, err := s.projectsConfig.GetProject(projectName)
 	if err != nil {
 		log.Printf("❌ Schedule execution failed: %v", err)
@@ -231,33 +514,25 @@ func (s *Scheduler) executeSchedule(projectName string, schedule Schedule) {
 		"type":    "scheduled",
 	})
 
-	// If no parts or groups specified, run all parts
-	if len(partsToRun) == 0 {
-		_, err := RunPipelineWithOptions(configPath, RunPipelineOptions{
-			Storage:          s.storage,
-			StreamToTerminal: false,
-			PartFilter:       "",
-		})
-		if err != nil {
-			log.Printf("❌ Scheduled run failed for %s: %v", projectName, err)
-		} else {
-			log.Printf("✅ Scheduled run completed: %s", projectName)
-		}
+	// If no parts or groups specified, the queue runs all parts
+	pipeline, err := s.queue.Enqueue(RunRequest{
+		ConfigPath: configPath,
+		Parts:      partsToRun,
+		Trigger:    "scheduled",
+		Context:    ctx,
+	})
+	if err != nil {
+		log.Printf("❌ Failed to queue scheduled run for %s: %v", projectName, err)
 		return
 	}
 
-	// Run specific parts
-	for _, partName := range partsToRun {
-		_, err := RunPipelineWithOptions(configPath, RunPipelineOptions{
-			Storage:          s.storage,
-			StreamToTerminal: false,
-			PartFilter:       partName,
-		})
-		if err != nil {
-			log.Printf("❌ Scheduled run failed for %s (%s): %v", projectName, partName, err)
-		} else {
-			log.Printf("✅ Scheduled run completed: %s (%s)", projectName, partName)
-		}
+	// Wait so overlap policies see the schedule as running until it finishes
+	<-pipeline.Done()
+
+	if _, err := pipeline.Result(); err != nil {
+		log.Printf("❌ Scheduled run failed for %s: %v", projectName, err)
+	} else {
+		log.Printf("✅ Scheduled run completed: %s", projectName)
 	}
 }
 
@@ -0,0 +1,299 @@
+package runner
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"pipego/runner/storage"
+)
+
+// testScheduleConfig runs build once an hour; each run notes its start and
+// then waits for the release file
+const testScheduleConfig = `schedules:
+  - every: 1h
+    parts: [build]
+    overlap: %s
+parts:
+  build:
+    steps:
+      - name: build
+        run: "echo started >> starts; while [ ! -f release ]; do sleep 0.02; done"
+`
+
+// schedulerTest is a scheduler with one project, "app", whose schedule tests fire by hand
+type schedulerTest struct {
+	t          *testing.T
+	scheduler  *Scheduler
+	store      *storage.Storage
+	projectDir string
+}
+
+func newSchedulerTest(t *testing.T, overlap string) *schedulerTest {
+	t.Helper()
+	baseDir := t.TempDir()
+	projectDir := filepath.Join(baseDir, "app")
+	if err := os.MkdirAll(projectDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	config := fmt.Sprintf(testScheduleConfig, overlap)
+	if err := os.WriteFile(filepath.Join(projectDir, "pipego.yml"), []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := storage.NewStorage(filepath.Join(baseDir, "pipego.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	projects := &ProjectsConfig{Projects: []Project{{Name: "app", Path: "app"}}}
+	queue := NewRunQueue(store, NewLockManager(projects, baseDir), ServerConfig{Workers: 2})
+	queue.Start()
+
+	s := &schedulerTest{t: t, scheduler: NewScheduler(projects, store, queue, baseDir), store: store, projectDir: projectDir}
+	t.Cleanup(func() {
+		s.release()
+		s.waitIdle()
+		queue.Stop()
+		store.Close()
+	})
+	return s
+}
+
+// fire makes the schedule due and ticks, as if its interval had passed
+func (s *schedulerTest) fire() {
+	s.scheduler.mu.Lock()
+	clear(s.scheduler.lastRuns)
+	s.scheduler.mu.Unlock()
+	s.scheduler.tick()
+}
+
+// release lets the steps waiting for the release file finish
+func (s *schedulerTest) release() {
+	if err := os.WriteFile(filepath.Join(s.projectDir, "release"), nil, 0644); err != nil {
+		s.t.Fatal(err)
+	}
+}
+
+// starts returns how many runs of the step have started
+func (s *schedulerTest) starts() int {
+	data, _ := os.ReadFile(filepath.Join(s.projectDir, "starts"))
+	return strings.Count(string(data), "\n")
+}
+
+func (s *schedulerTest) waitStarts(n int) {
+	s.t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for s.starts() < n {
+		if time.Now().After(deadline) {
+			s.t.Fatalf("%d runs started, want %d", s.starts(), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// waitIdle waits until no occurrence of the schedule is running or queued
+func (s *schedulerTest) waitIdle() {
+	s.t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		s.scheduler.mu.RLock()
+		busy := len(s.scheduler.runningJobs) + len(s.scheduler.queuedJobs)
+		s.scheduler.mu.RUnlock()
+		if busy == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			s.t.Fatal("scheduled runs did not finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// runs returns the stored runs, oldest first
+func (s *schedulerTest) runs() []*storage.Run {
+	s.t.Helper()
+	runs, err := s.store.GetRuns(100)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	for i, j := 0, len(runs)-1; i < j; i, j = i+1, j-1 {
+		runs[i], runs[j] = runs[j], runs[i]
+	}
+	return runs
+}
+
+// statuses returns the statuses of the stored runs, oldest first
+func (s *schedulerTest) statuses() []string {
+	var statuses []string
+	for _, run := range s.runs() {
+		statuses = append(statuses, run.Status)
+	}
+	return statuses
+}
+
+func TestSchedulerOverlapSkip(t *testing.T) {
+	s := newSchedulerTest(t, OverlapSkip)
+	s.fire()
+	s.waitStarts(1)
+
+	// The second occurrence is recorded as skipped instead of running
+	s.fire()
+	runs := s.runs()
+	if len(runs) != 2 || runs[1].Status != "skipped" {
+		t.Fatalf("runs after an overlapping occurrence = %v, want the first and a skipped one", s.statuses())
+	}
+	skipped := runs[1]
+	if skipped.ProjectName != "app" || skipped.Part != "build" || skipped.FinishedAt == nil ||
+		skipped.Reason == nil || *skipped.Reason != "previous scheduled run still in progress" {
+		t.Errorf("skipped run = %+v", skipped)
+	}
+
+	s.release()
+	s.waitIdle()
+	if got := s.statuses(); strings.Join(got, ",") != "success,skipped" || s.starts() != 1 {
+		t.Errorf("runs = %v with %d starts, want success,skipped with 1", got, s.starts())
+	}
+}
+
+func TestSchedulerOverlapQueue(t *testing.T) {
+	s := newSchedulerTest(t, OverlapQueue)
+	s.fire()
+	s.waitStarts(1)
+
+	// The second occurrence waits for the first instead of running beside it
+	s.fire()
+	time.Sleep(200 * time.Millisecond)
+	if s.starts() != 1 {
+		t.Fatalf("queued occurrence started while the first was running")
+	}
+	s.scheduler.mu.RLock()
+	queued := s.scheduler.queuedJobs["app-schedule-0"]
+	s.scheduler.mu.RUnlock()
+	if !queued {
+		t.Errorf("second occurrence was not queued")
+	}
+
+	// Only one occurrence waits, a third is recorded as skipped
+	s.fire()
+	runs := s.runs()
+	if len(runs) != 2 || runs[1].Status != "skipped" || runs[1].Reason == nil ||
+		*runs[1].Reason != "an occurrence is already queued behind the running one" {
+		t.Fatalf("runs after a third occurrence = %v, want the first and a skipped one", s.statuses())
+	}
+
+	s.release()
+	s.waitStarts(2)
+	s.waitIdle()
+	if got := s.statuses(); strings.Join(got, ",") != "success,skipped,success" || s.starts() != 2 {
+		t.Errorf("runs = %v with %d starts, want success,skipped,success with 2", got, s.starts())
+	}
+}
+
+func TestSchedulerUnknownOverlap(t *testing.T) {
+	s := newSchedulerTest(t, "queue_all")
+
+	// A mistyped policy keeps the schedule from firing rather than falling back to skip
+	s.fire()
+	s.waitIdle()
+	if runs := s.runs(); len(runs) != 0 || s.starts() != 0 {
+		t.Errorf("runs of a schedule with an unknown overlap policy = %v", s.statuses())
+	}
+	s.scheduler.mu.RLock()
+	reason := s.scheduler.invalid["app-schedule-0"]
+	s.scheduler.mu.RUnlock()
+	if !strings.Contains(reason, "unknown overlap policy 'queue_all'") {
+		t.Errorf("invalid schedule reason = %q", reason)
+	}
+}
+
+func TestSchedulerOverlapCancelPrevious(t *testing.T) {
+	s := newSchedulerTest(t, OverlapCancelPrevious)
+	s.fire()
+	s.waitStarts(1)
+
+	// The second occurrence cancels the first and starts once it has stopped
+	s.fire()
+	s.waitStarts(2)
+	runs := s.runs()
+	if len(runs) != 2 || runs[0].Status != "cancelled" {
+		t.Fatalf("runs after cancelling the previous occurrence = %v, want the first cancelled", s.statuses())
+	}
+
+	s.release()
+	s.waitIdle()
+	if got := s.statuses(); strings.Join(got, ",") != "cancelled,success" {
+		t.Errorf("runs = %v, want cancelled,success", got)
+	}
+}
+
+func TestSchedulerOverlapAllow(t *testing.T) {
+	s := newSchedulerTest(t, OverlapAllow)
+	s.fire()
+	s.waitStarts(1)
+
+	// Both occurrences run at the same time
+	s.fire()
+	s.waitStarts(2)
+	if got := s.statuses(); strings.Join(got, ",") != "running,running" {
+		t.Errorf("runs while both occurrences run = %v", got)
+	}
+
+	s.release()
+	s.waitIdle()
+	if got := s.statuses(); strings.Join(got, ",") != "success,success" {
+		t.Errorf("runs = %v, want two successful ones", got)
+	}
+}
+
+func TestSchedulerBlackoutAfterJitter(t *testing.T) {
+	s := newSchedulerTest(t, OverlapSkip)
+	today := time.Now().Format("2006-01-02")
+
+	// The blackout window has started by the time the jitter delay is over
+	schedule := Schedule{Parts: []string{"build"}, Blackout: []BlackoutWindow{{From: today, To: today, Reason: "freeze"}}}
+	s.scheduler.startJob("app", schedule, "app-schedule-0", nil, 10*time.Millisecond)
+	s.waitIdle()
+	runs := s.runs()
+	if len(runs) != 1 || runs[0].Status != "skipped" || runs[0].Reason == nil || !strings.Contains(*runs[0].Reason, "freeze") {
+		t.Fatalf("runs after a fire delayed into a blackout window = %v, want one skipped", s.statuses())
+	}
+	if s.starts() != 0 {
+		t.Errorf("%d runs started in a blackout window", s.starts())
+	}
+
+	// A defer window holds the schedule back until it ends
+	schedule.Blackout[0].Action = BlackoutDefer
+	s.scheduler.startJob("app", schedule, "app-schedule-0", nil, 10*time.Millisecond)
+	s.waitIdle()
+	s.scheduler.mu.RLock()
+	deferred := s.scheduler.deferred["app-schedule-0"]
+	s.scheduler.mu.RUnlock()
+	if !deferred || len(s.runs()) != 1 || s.starts() != 0 {
+		t.Errorf("deferred = %v with runs %v, want the schedule deferred without a run", deferred, s.statuses())
+	}
+}
+
+func TestSchedulerInvalidBlackout(t *testing.T) {
+	s := newSchedulerTest(t, OverlapSkip)
+	config := strings.Replace(fmt.Sprintf(testScheduleConfig, OverlapSkip), "    overlap: skip\n",
+		"    overlap: skip\n    blackout:\n      - cron: \"* 25 * * *\"\n", 1)
+	if err := os.WriteFile(filepath.Join(s.projectDir, "pipego.yml"), []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// A mistyped window keeps the schedule from firing rather than being ignored
+	s.fire()
+	s.waitIdle()
+	if runs := s.runs(); len(runs) != 0 || s.starts() != 0 {
+		t.Errorf("runs of a schedule with an invalid blackout window = %v", s.statuses())
+	}
+	s.scheduler.mu.RLock()
+	reason := s.scheduler.invalid["app-schedule-0"]
+	s.scheduler.mu.RUnlock()
+	if !strings.Contains(reason, "cron value out of range") {
+		t.Errorf("invalid schedule reason = %q", reason)
+	}
+}
@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// AddAnnotations stores the annotations of a step
+func (s *Storage) AddAnnotations(annotations []*Annotation) error {
+	if len(annotations) == 0 {
+		return nil
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to add annotations: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, a := range annotations {
+		result, err := tx.Exec(
+			`INSERT INTO annotations (run_id, step_id, type, message, title, file, line, col, log_line, end_log_line) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			a.RunID, a.StepID, a.Type, a.Message, a.Title, a.File, a.Line, a.Col, a.LogLine, a.EndLogLine,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to add annotation: %w", err)
+		}
+		id, err := result.LastInsertId()
+		if err != nil {
+			return fmt.Errorf("failed to get annotation ID: %w", err)
+		}
+		a.ID = int(id)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to add annotations: %w", err)
+	}
+	return nil
+}
+
+// GetAnnotations retrieves the annotations of a run in the order they were emitted
+func (s *Storage) GetAnnotations(runID int) ([]*Annotation, error) {
+	rows, err := s.db.Query(
+		`SELECT id, run_id, step_id, type, message, title, file, line, col, log_line, end_log_line
+			FROM annotations WHERE run_id = ? ORDER BY step_id ASC, log_line ASC, id ASC`,
+		runID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query annotations: %w", err)
+	}
+	defer rows.Close()
+
+	annotations := make([]*Annotation, 0)
+	for rows.Next() {
+		var a Annotation
+		var line, col, endLogLine sql.NullInt64
+		err := rows.Scan(&a.ID, &a.RunID, &a.StepID, &a.Type, &a.Message, &a.Title, &a.File, &line, &col, &a.LogLine, &endLogLine)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan annotation: %w", err)
+		}
+		a.Line = nullInt(line)
+		a.Col = nullInt(col)
+		a.EndLogLine = nullInt(endLogLine)
+		annotations = append(annotations, &a)
+	}
+
+	return annotations, rows.Err()
+}
+
+// nullInt converts a nullable integer column
+func nullInt(value sql.NullInt64) *int {
+	if !value.Valid {
+		return nil
+	}
+	n := int(value.Int64)
+	return &n
+}
@@ -0,0 +1,220 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// executionColumns lists the executions columns in the order scanExecution expects them
+const executionColumns = `id, status, config_path, project_name, "trigger", queued_at, started_at, finished_at, duration, error,
+	commit_sha, branch, tag, commit_author, commit_message`
+
+// scanExecution scans a single row selected with executionColumns
+func scanExecution(row rowScanner) (*Execution, error) {
+	var e Execution
+	var queuedAt, startedAt, finishedAt sql.NullTime
+	var duration, errorMsg sql.NullString
+
+	err := row.Scan(&e.ID, &e.Status, &e.ConfigPath, &e.ProjectName, &e.Trigger, &queuedAt, &startedAt, &finishedAt, &duration, &errorMsg,
+		&e.CommitSHA, &e.Branch, &e.Tag, &e.CommitAuthor, &e.CommitMessage)
+	if err != nil {
+		return nil, err
+	}
+
+	if queuedAt.Valid {
+		e.QueuedAt = &queuedAt.Time
+	}
+	if startedAt.Valid {
+		e.StartedAt = &startedAt.Time
+	}
+	if finishedAt.Valid {
+		e.FinishedAt = &finishedAt.Time
+	}
+	if duration.Valid {
+		durationStr := duration.String
+		e.Duration = &durationStr
+	}
+	if errorMsg.Valid {
+		errorStr := errorMsg.String
+		e.Error = &errorStr
+	}
+
+	return &e, nil
+}
+
+// CreateExecution creates a pipeline execution record, either "queued" or "running"
+func (s *Storage) CreateExecution(configPath, projectName, trigger, status string) (*Execution, error) {
+	now := time.Now()
+	e := &Execution{
+		Status:      status,
+		ConfigPath:  configPath,
+		ProjectName: projectName,
+		Trigger:     trigger,
+		Summary:     map[string]int{},
+	}
+	if status == "queued" {
+		e.QueuedAt = &now
+	} else {
+		e.StartedAt = &now
+	}
+
+	result, err := s.db.Exec(
+		`INSERT INTO executions (status, config_path, project_name, "trigger", queued_at, started_at, owner_pid, owner_start) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		status, configPath, projectName, trigger, e.QueuedAt, e.StartedAt, s.ownerPID, s.ownerStart,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create execution: %w", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get execution ID: %w", err)
+	}
+	e.ID = int(id)
+
+	return e, nil
+}
+
+// StartExecution marks a queued execution as running from now on
+func (s *Storage) StartExecution(executionID int) error {
+	_, err := s.db.Exec(
+		"UPDATE executions SET status = ?, started_at = ? WHERE id = ?",
+		"running", time.Now(), executionID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to start execution: %w", err)
+	}
+	return nil
+}
+
+// SetExecutionCommit records the commit an execution was triggered for
+func (s *Storage) SetExecutionCommit(executionID int, commit CommitInfo) error {
+	_, err := s.db.Exec(
+		"UPDATE executions SET commit_sha = ?, branch = ?, tag = ?, commit_author = ?, commit_message = ? WHERE id = ?",
+		commit.CommitSHA, commit.Branch, commit.Tag, commit.CommitAuthor, commit.CommitMessage, executionID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to set execution commit: %w", err)
+	}
+	return nil
+}
+
+// SetExecutionConfig stores the pipeline config an execution ran with
+func (s *Storage) SetExecutionConfig(executionID int, snapshot string) error {
+	_, err := s.db.Exec("UPDATE executions SET config_snapshot = ? WHERE id = ?", snapshot, executionID)
+	if err != nil {
+		return fmt.Errorf("failed to set execution config: %w", err)
+	}
+	return nil
+}
+
+// GetExecutionConfig returns the pipeline config an execution ran with, or "" if it was not recorded
+func (s *Storage) GetExecutionConfig(executionID int) (string, error) {
+	var snapshot sql.NullString
+	err := s.db.QueryRow("SELECT config_snapshot FROM executions WHERE id = ?", executionID).Scan(&snapshot)
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("execution not found")
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get execution config: %w", err)
+	}
+	return snapshot.String, nil
+}
+
+// FinishExecution records the final status of an execution. errorMsg may be empty.
+func (s *Storage) FinishExecution(executionID int, status string, duration time.Duration, errorMsg string) error {
+	var errorValue interface{}
+	if errorMsg != "" {
+		errorValue = errorMsg
+	}
+
+	_, err := s.db.Exec(
+		"UPDATE executions SET status = ?, finished_at = ?, duration = ?, error = ? WHERE id = ?",
+		status, time.Now(), duration.String(), errorValue, executionID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to finish execution: %w", err)
+	}
+	return nil
+}
+
+// GetExecution retrieves a single execution by ID with the summary of its part runs
+func (s *Storage) GetExecution(executionID int) (*Execution, error) {
+	e, err := scanExecution(s.db.QueryRow(`SELECT `+executionColumns+` FROM executions WHERE id = ?`, executionID))
+
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("execution not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get execution: %w", err)
+	}
+
+	if err := s.loadExecutionSummary(e); err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+// GetExecutions retrieves executions, most recent first, optionally for a single project
+func (s *Storage) GetExecutions(limit int, projectName string) ([]*Execution, error) {
+	query := `SELECT ` + executionColumns + ` FROM executions`
+	args := []interface{}{}
+	if projectName != "" {
+		query += ` WHERE project_name = ?`
+		args = append(args, projectName)
+	}
+	query += ` ORDER BY id DESC LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query executions: %w", err)
+	}
+
+	executions := make([]*Execution, 0)
+	for rows.Next() {
+		e, err := scanExecution(rows)
+		if err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan execution: %w", err)
+		}
+		executions = append(executions, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, e := range executions {
+		if err := s.loadExecutionSummary(e); err != nil {
+			return nil, err
+		}
+	}
+
+	return executions, nil
+}
+
+// loadExecutionSummary counts the part runs of an execution by status
+func (s *Storage) loadExecutionSummary(e *Execution) error {
+	rows, err := s.db.Query(`SELECT status, COUNT(*) FROM runs WHERE execution_id = ? GROUP BY status`, e.ID)
+	if err != nil {
+		return fmt.Errorf("failed to summarize execution: %w", err)
+	}
+	defer rows.Close()
+
+	e.PartCount = 0
+	e.Summary = make(map[string]int)
+	for rows.Next() {
+		var status string
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			return fmt.Errorf("failed to scan execution summary: %w", err)
+		}
+		e.Summary[status] = count
+		e.PartCount += count
+	}
+
+	return rows.Err()
+}
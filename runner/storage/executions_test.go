@@ -0,0 +1,144 @@
+package storage
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+// addTestExecution creates an execution of project with a run per part, each
+// finished with the given status
+func addTestExecution(t *testing.T, s *Storage, project string, statuses map[string]string, parts ...string) *Execution {
+	t.Helper()
+	execution, err := s.CreateExecution(project+"/pipego.yml", project, "api", "queued")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, part := range parts {
+		run, err := s.CreateQueuedRun(project+"/pipego.yml", project, "", part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.SetRunExecution(run.ID, execution.ID); err != nil {
+			t.Fatal(err)
+		}
+		if status := statuses[part]; status != "" {
+			if err := s.UpdateRunStatus(run.ID, status, time.Second); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return execution
+}
+
+func TestExecutionTree(t *testing.T) {
+	s := newTestStorage(t)
+
+	execution := addTestExecution(t, s, "app", map[string]string{"build": "success", "test": "failed"}, "build", "test", "deploy")
+	if execution.Status != "queued" || execution.QueuedAt == nil || execution.StartedAt != nil {
+		t.Errorf("queued execution = %+v", execution)
+	}
+	if err := s.StartExecution(execution.ID); err != nil {
+		t.Fatal(err)
+	}
+	commit := CommitInfo{CommitSHA: "abc123", Branch: "main", CommitAuthor: "Dev", CommitMessage: "Fix"}
+	if err := s.SetExecutionCommit(execution.ID, commit); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.FinishExecution(execution.ID, "failed", 3*time.Second, "part test failed"); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.GetExecution(execution.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != "failed" || got.Trigger != "api" || got.ProjectName != "app" || got.StartedAt == nil || got.FinishedAt == nil ||
+		got.Duration == nil || *got.Duration != "3s" || got.Error == nil || *got.Error != "part test failed" || got.CommitInfo != commit {
+		t.Errorf("execution = %+v", got)
+	}
+	// The deploy run never left the queue
+	if got.PartCount != 3 || got.Summary["success"] != 1 || got.Summary["failed"] != 1 || got.Summary["queued"] != 1 {
+		t.Errorf("summary = %d parts, %v", got.PartCount, got.Summary)
+	}
+
+	runs, err := s.GetExecutionRuns(execution.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parts []string
+	for _, run := range runs {
+		if run.ExecutionID == nil || *run.ExecutionID != execution.ID {
+			t.Errorf("run %d belongs to execution %v", run.ID, run.ExecutionID)
+		}
+		parts = append(parts, run.Part)
+	}
+	if len(parts) != 3 || parts[0] != "build" || parts[1] != "test" || parts[2] != "deploy" {
+		t.Errorf("runs of the execution = %v, want build, test, deploy", parts)
+	}
+
+	if _, err := s.GetExecution(execution.ID + 100); err == nil {
+		t.Error("GetExecution found an unknown execution")
+	}
+	if runs, err := s.GetExecutionRuns(execution.ID + 100); err != nil || len(runs) != 0 {
+		t.Errorf("runs of an unknown execution = %v, %v", runs, err)
+	}
+}
+
+func TestGetExecutions(t *testing.T) {
+	s := newTestStorage(t)
+	first := addTestExecution(t, s, "app", nil, "build")
+	lib := addTestExecution(t, s, "lib", nil, "build")
+	last := addTestExecution(t, s, "app", map[string]string{"build": "success"}, "build")
+
+	ids := func(executions []*Execution) []int {
+		var ids []int
+		for _, e := range executions {
+			ids = append(ids, e.ID)
+		}
+		return ids
+	}
+	tests := []struct {
+		limit   int
+		project string
+		want    []int
+	}{
+		{10, "", []int{last.ID, lib.ID, first.ID}},
+		{2, "", []int{last.ID, lib.ID}},
+		{10, "app", []int{last.ID, first.ID}},
+		{10, "other", nil},
+	}
+	for _, tt := range tests {
+		executions, err := s.GetExecutions(tt.limit, tt.project)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := ids(executions); !slices.Equal(got, tt.want) {
+			t.Errorf("GetExecutions(%d, %q) = %v, want %v", tt.limit, tt.project, got, tt.want)
+		}
+	}
+
+	// Listed executions carry their summary too
+	executions, _ := s.GetExecutions(1, "app")
+	if len(executions) != 1 || executions[0].PartCount != 1 || executions[0].Summary["success"] != 1 {
+		t.Errorf("summary of a listed execution = %+v", executions)
+	}
+}
+
+func TestExecutionConfig(t *testing.T) {
+	s := newTestStorage(t)
+	execution := addTestExecution(t, s, "app", nil, "build")
+
+	if config, err := s.GetExecutionConfig(execution.ID); err != nil || config != "" {
+		t.Errorf("config before it was stored = %q, %v", config, err)
+	}
+	if err := s.SetExecutionConfig(execution.ID, "parts: {}\n"); err != nil {
+		t.Fatal(err)
+	}
+	if config, err := s.GetExecutionConfig(execution.ID); err != nil || config != "parts: {}\n" {
+		t.Errorf("config = %q, %v", config, err)
+	}
+	if _, err := s.GetExecutionConfig(execution.ID + 100); err == nil {
+		t.Error("GetExecutionConfig found an unknown execution")
+	}
+}
@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// IdempotencyKeyTTL is how long an Idempotency-Key is remembered
+const IdempotencyKeyTTL = 24 * time.Hour
+
+// ReserveIdempotencyKey claims a key for a new request. It returns nil if the key
+// was free, or the earlier use of the key otherwise. Expired keys are dropped first.
+func (s *Storage) ReserveIdempotencyKey(scope, key, fingerprint string) (*IdempotencyKey, error) {
+	now := time.Now()
+
+	if _, err := s.db.Exec("DELETE FROM idempotency_keys WHERE created_at < ?", now.Add(-IdempotencyKeyTTL)); err != nil {
+		return nil, fmt.Errorf("failed to expire idempotency keys: %w", err)
+	}
+
+	result, err := s.db.Exec(
+		"INSERT OR IGNORE INTO idempotency_keys (scope, key, fingerprint, created_at) VALUES (?, ?, ?, ?)",
+		scope, key, fingerprint, now,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reserve idempotency key: %w", err)
+	}
+
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return nil, fmt.Errorf("failed to reserve idempotency key: %w", err)
+	}
+	if inserted > 0 {
+		return nil, nil
+	}
+
+	existing := &IdempotencyKey{Key: key, Scope: scope}
+	var runIDs string
+	err = s.db.QueryRow(
+		"SELECT fingerprint, run_ids, created_at FROM idempotency_keys WHERE scope = ? AND key = ?",
+		scope, key,
+	).Scan(&existing.Fingerprint, &runIDs, &existing.CreatedAt)
+	if err == sql.ErrNoRows {
+		// Released between the insert and the lookup, let the caller retry
+		return nil, fmt.Errorf("idempotency key was released concurrently")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get idempotency key: %w", err)
+	}
+
+	if runIDs != "" {
+		if err := json.Unmarshal([]byte(runIDs), &existing.RunIDs); err != nil {
+			return nil, fmt.Errorf("failed to parse idempotency key runs: %w", err)
+		}
+	}
+
+	return existing, nil
+}
+
+// CompleteIdempotencyKey records the runs queued by the request that reserved the key
+func (s *Storage) CompleteIdempotencyKey(scope, key string, runIDs []int) error {
+	encoded, err := json.Marshal(runIDs)
+	if err != nil {
+		return fmt.Errorf("failed to encode run IDs: %w", err)
+	}
+
+	_, err = s.db.Exec(
+		"UPDATE idempotency_keys SET run_ids = ? WHERE scope = ? AND key = ?",
+		string(encoded), scope, key,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to complete idempotency key: %w", err)
+	}
+	return nil
+}
+
+// ReleaseIdempotencyKey forgets a reserved key whose request failed, so it can be retried
+func (s *Storage) ReleaseIdempotencyKey(scope, key string) error {
+	_, err := s.db.Exec("DELETE FROM idempotency_keys WHERE scope = ? AND key = ?", scope, key)
+	if err != nil {
+		return fmt.Errorf("failed to release idempotency key: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestIdempotencyKeys(t *testing.T) {
+	s := newTestStorage(t)
+
+	existing, err := s.ReserveIdempotencyKey("project:app", "k1", "part=build")
+	if err != nil || existing != nil {
+		t.Fatalf("reserving a new key: %+v, %v", existing, err)
+	}
+
+	// Until the first request completes, the key is in use without runs
+	existing, err = s.ReserveIdempotencyKey("project:app", "k1", "part=build")
+	if err != nil || existing == nil || existing.Fingerprint != "part=build" || len(existing.RunIDs) != 0 {
+		t.Fatalf("reserving a key in progress: %+v, %v", existing, err)
+	}
+
+	if err := s.CompleteIdempotencyKey("project:app", "k1", []int{4, 5}); err != nil {
+		t.Fatal(err)
+	}
+	existing, err = s.ReserveIdempotencyKey("project:app", "k1", "part=build")
+	if err != nil || existing == nil || !slices.Equal(existing.RunIDs, []int{4, 5}) || existing.CreatedAt.IsZero() {
+		t.Fatalf("reserving a completed key: %+v, %v", existing, err)
+	}
+
+	// The earlier fingerprint is returned for the caller to compare
+	existing, err = s.ReserveIdempotencyKey("project:app", "k1", "part=deploy")
+	if err != nil || existing == nil || existing.Fingerprint != "part=build" {
+		t.Fatalf("reserving a key with other parameters: %+v, %v", existing, err)
+	}
+
+	// Keys are separate per scope
+	existing, err = s.ReserveIdempotencyKey("project:lib", "k1", "part=build")
+	if err != nil || existing != nil {
+		t.Fatalf("reserving a key of another scope: %+v, %v", existing, err)
+	}
+
+	// A released key is free again
+	if err := s.ReleaseIdempotencyKey("project:lib", "k1"); err != nil {
+		t.Fatal(err)
+	}
+	existing, err = s.ReserveIdempotencyKey("project:lib", "k1", "part=deploy")
+	if err != nil || existing != nil {
+		t.Fatalf("reserving a released key: %+v, %v", existing, err)
+	}
+}
+
+func TestIdempotencyKeysExpire(t *testing.T) {
+	s := newTestStorage(t)
+
+	if _, err := s.ReserveIdempotencyKey("project:app", "old", "part=build"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CompleteIdempotencyKey("project:app", "old", []int{1}); err != nil {
+		t.Fatal(err)
+	}
+	_, err := s.db.Exec("UPDATE idempotency_keys SET created_at = ? WHERE key = 'old'", time.Now().Add(-IdempotencyKeyTTL-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	existing, err := s.ReserveIdempotencyKey("project:app", "old", "part=deploy")
+	if err != nil || existing != nil {
+		t.Fatalf("reserving an expired key: %+v, %v", existing, err)
+	}
+}
@@ -0,0 +1,507 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"pipego/ansi"
+)
+
+// Step logs are stored under <data dir>/logs/<run id>/<step id>.log.gz. Every
+// appended chunk is a separate gzip member, so the file can grow while the step
+// runs and still reads as one gzip stream. A sidecar index records where each
+// member ends, uncompressed and compressed, so a range of the log can be read
+// without decompressing it from the start. Another sidecar, .lines, holds the
+// time and stream of each line as "<offset ms> <stream>", so the log itself
+// stays plain text. Lines are numbered from 1; a line following a gap, such as
+// where output was left out of a truncated log, has its number as a third field.
+
+// Streams a line of output can come from
+const (
+	StreamStdout = "stdout"
+	StreamStderr = "stderr"
+	StreamSystem = "system" // Written by pipego, e.g. where output was truncated
+)
+
+// OutputLine is one line of step output
+type OutputLine struct {
+	Line     int    `json:"line"`                // 1-based line number within the step, 0 = the one after the previous line
+	OffsetMs *int64 `json:"offset_ms,omitempty"` // When it was written, relative to the step start
+	Stream   string `json:"stream,omitempty"`    // Not known for steps stored before lines were tagged
+	Text     string `json:"text"`
+}
+
+// inlineLogLimit is how much of a log file LoadStepOutputs puts in Output
+const inlineLogLimit = 1 << 20
+
+// StepLogPath returns the path of a step log relative to the data directory
+func StepLogPath(runID, stepID int) string {
+	return filepath.Join("logs", strconv.Itoa(runID), strconv.Itoa(stepID)+".log.gz")
+}
+
+// LogWriter appends chunks of output to a step log
+type LogWriter struct {
+	file       *os.File
+	index      *os.File
+	lines      *os.File
+	size       int64 // Uncompressed bytes written
+	compressed int64
+	linesSize  int64 // Bytes in the lines sidecar
+	indexSize  int64 // Bytes in the index
+	line       int   // Number of the last line
+}
+
+// CreateStepLog creates the log file of a step and records it on the step
+func (s *Storage) CreateStepLog(runID, stepID int) (*LogWriter, error) {
+	relPath := StepLogPath(runID, stepID)
+	path := filepath.Join(s.dataDir, relPath)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %w", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create step log: %w", err)
+	}
+	index, err := os.OpenFile(path+".idx", os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to create step log index: %w", err)
+	}
+	lines, err := os.OpenFile(path+".lines", os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		file.Close()
+		index.Close()
+		return nil, fmt.Errorf("failed to create step log lines: %w", err)
+	}
+	w := &LogWriter{file: file, index: index, lines: lines}
+
+	if _, err := s.db.Exec("UPDATE step_executions SET log_path = ?, log_size = 0 WHERE id = ?", relPath, stepID); err != nil {
+		w.Close()
+		return nil, fmt.Errorf("failed to set step log: %w", err)
+	}
+
+	return w, nil
+}
+
+// Append compresses lines and appends them to the log
+func (w *LogWriter) Append(lines []OutputLine) error {
+	if len(lines) == 0 {
+		return nil
+	}
+
+	var text, meta, buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	number := w.line
+	for _, line := range lines {
+		text.WriteString(line.Text)
+		text.WriteByte('\n')
+		var offset int64
+		if line.OffsetMs != nil {
+			offset = *line.OffsetMs
+		}
+		if line.Line != 0 && line.Line != number+1 {
+			number = line.Line
+			fmt.Fprintf(&meta, "%d %s %d\n", offset, line.Stream, number)
+		} else {
+			number++
+			fmt.Fprintf(&meta, "%d %s\n", offset, line.Stream)
+		}
+	}
+	if _, err := gz.Write(text.Bytes()); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+
+	// The text goes first, then its line details. The index is written last, so
+	// a member cut short by a crash is never read. A failed append is undone,
+	// so appending the same lines again keeps the three files in step.
+	index := fmt.Sprintf("%d %d\n", w.size+int64(text.Len()), w.compressed+int64(buf.Len()))
+	if _, err := w.file.Write(buf.Bytes()); err != nil {
+		w.rollback()
+		return fmt.Errorf("failed to append to step log: %w", err)
+	}
+	if _, err := w.lines.Write(meta.Bytes()); err != nil {
+		w.rollback()
+		return fmt.Errorf("failed to append to step log lines: %w", err)
+	}
+	if _, err := w.index.WriteString(index); err != nil {
+		w.rollback()
+		return fmt.Errorf("failed to index step log: %w", err)
+	}
+	w.size += int64(text.Len())
+	w.compressed += int64(buf.Len())
+	w.linesSize += int64(meta.Len())
+	w.indexSize += int64(len(index))
+	w.line = number
+	return nil
+}
+
+// rollback cuts the files back to what the appends before the current one wrote
+func (w *LogWriter) rollback() {
+	w.file.Truncate(w.compressed)
+	w.lines.Truncate(w.linesSize)
+	w.index.Truncate(w.indexSize)
+}
+
+// Size returns the uncompressed size of the log
+func (w *LogWriter) Size() int64 {
+	return w.size
+}
+
+// Close closes the log file
+func (w *LogWriter) Close() error {
+	w.index.Close()
+	w.lines.Close()
+	return w.file.Close()
+}
+
+// SetStepLogSize records the size of a step log and how many bytes of output
+// were left out of it because of the log size limits
+func (s *Storage) SetStepLogSize(stepID int, size, truncated int64) error {
+	_, err := s.db.Exec("UPDATE step_executions SET log_size = ?, log_truncated = ? WHERE id = ?", size, truncated, stepID)
+	if err != nil {
+		return fmt.Errorf("failed to set step log size: %w", err)
+	}
+	return nil
+}
+
+// logMember is where a gzip member of a log ends
+type logMember struct {
+	end           int64 // Uncompressed
+	compressedEnd int64
+}
+
+// LogReader reads a step log as plain text. It implements io.ReadSeeker, so it
+// can be served with http.ServeContent, which handles range requests.
+type LogReader struct {
+	file    *os.File
+	members []logMember
+	size    int64
+
+	pos   int64        // Position of the next Read
+	gz    *gzip.Reader // Decompressor, positioned at gzPos
+	gzPos int64
+}
+
+// OpenStepLog opens a log by the path recorded on its step
+func (s *Storage) OpenStepLog(logPath string) (*LogReader, error) {
+	path := filepath.Join(s.dataDir, logPath)
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open step log: %w", err)
+	}
+
+	members, err := readLogIndex(path + ".idx")
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	r := &LogReader{file: file, members: members}
+	if len(members) > 0 {
+		r.size = members[len(members)-1].end
+	}
+	return r, nil
+}
+
+// OpenConvertedStepLog opens the log of a finished step converted to format,
+// see ansi.Convert. The conversion is done once, into <log>.<format> next to
+// the log, so it can be served in ranges like the log itself. The log must
+// not grow any more.
+func (s *Storage) OpenConvertedStepLog(logPath, format string) (*os.File, error) {
+	path := filepath.Join(s.dataDir, logPath+"."+format)
+	if file, err := os.Open(path); err == nil {
+		return file, nil
+	}
+
+	r, err := s.OpenStepLog(logPath)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	// Written under a temporary name, so a conversion cut short is never served
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create converted step log: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	w := bufio.NewWriter(tmp)
+	err = ansi.Convert(w, r, format)
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert step log: %w", err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open converted step log: %w", err)
+	}
+	return file, nil
+}
+
+// readLogIndex reads the member index of a log
+func readLogIndex(path string) ([]logMember, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open step log index: %w", err)
+	}
+	defer file.Close()
+
+	var members []logMember
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			break
+		}
+		end, err1 := strconv.ParseInt(fields[0], 10, 64)
+		compressedEnd, err2 := strconv.ParseInt(fields[1], 10, 64)
+		if err1 != nil || err2 != nil {
+			break
+		}
+		members = append(members, logMember{end: end, compressedEnd: compressedEnd})
+	}
+	return members, scanner.Err()
+}
+
+// Size returns the uncompressed size of the log
+func (r *LogReader) Size() int64 {
+	return r.size
+}
+
+// Read reads plain text from the current position
+func (r *LogReader) Read(p []byte) (int, error) {
+	if r.pos >= r.size {
+		return 0, io.EOF
+	}
+	if r.gz == nil || r.gzPos != r.pos {
+		if err := r.reposition(); err != nil {
+			return 0, err
+		}
+	}
+
+	if remaining := r.size - r.pos; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	n, err := r.gz.Read(p)
+	r.pos += int64(n)
+	r.gzPos += int64(n)
+	if r.pos >= r.size {
+		// The decompressor may already be reading what follows the last indexed
+		// member, such as a member cut short by a crash, which is not part of the log
+		err = nil
+	} else if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// reposition starts decompressing at the member holding pos and skips to pos
+func (r *LogReader) reposition() error {
+	i := sort.Search(len(r.members), func(i int) bool { return r.members[i].end > r.pos })
+	var start, compressedStart int64
+	if i > 0 {
+		start = r.members[i-1].end
+		compressedStart = r.members[i-1].compressedEnd
+	}
+
+	if _, err := r.file.Seek(compressedStart, io.SeekStart); err != nil {
+		return err
+	}
+	var err error
+	if r.gz == nil {
+		r.gz, err = gzip.NewReader(r.file)
+	} else {
+		err = r.gz.Reset(r.file)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read step log: %w", err)
+	}
+
+	if _, err := io.CopyN(io.Discard, r.gz, r.pos-start); err != nil {
+		return fmt.Errorf("failed to read step log: %w", err)
+	}
+	r.gzPos = r.pos
+	return nil
+}
+
+// Seek sets the position of the next Read
+func (r *LogReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("negative position")
+	}
+	r.pos = offset
+	return offset, nil
+}
+
+// Close closes the log file
+func (r *LogReader) Close() error {
+	return r.file.Close()
+}
+
+// loadInlineLog puts the log file of a step in its Output, or only its end
+// for logs larger than inlineLogLimit
+func (s *Storage) loadInlineLog(step *StepExecution) error {
+	r, err := s.OpenStepLog(step.LogPath)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	prefix := ""
+	if r.Size() > inlineLogLimit {
+		omitted := r.Size() - inlineLogLimit
+		prefix = fmt.Sprintf("[%d bytes of earlier output omitted, read the step log for all of it]\n", omitted)
+		if _, err := r.Seek(omitted, io.SeekStart); err != nil {
+			return err
+		}
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	step.Output = prefix + string(data)
+	return nil
+}
+
+// Page sizes of ReadStepLines for the API
+const (
+	DefaultStepLinesLimit = 5000
+	MaxStepLinesLimit     = 50000
+)
+
+// StepLineReader reads the stored output of a step one line at a time, so a
+// large log is never held in memory whole
+type StepLineReader struct {
+	text    *bufio.Reader
+	meta    *bufio.Reader // "<offset ms> <stream>" per line, nil without details
+	closers []io.Closer
+	line    int
+}
+
+// newStepLineReader reads lines from text with their details from meta, which can be nil
+func newStepLineReader(text, meta io.Reader) *StepLineReader {
+	r := &StepLineReader{text: bufio.NewReader(text)}
+	if meta != nil {
+		r.meta = bufio.NewReader(meta)
+	}
+	return r
+}
+
+// OpenStepLines opens the stored output of a step for reading line by line,
+// from its log file, or from the database for steps stored before logs were files
+func (s *Storage) OpenStepLines(step *StepExecution) (*StepLineReader, error) {
+	if step.LogPath == "" {
+		return newStepLineReader(strings.NewReader(step.Output), nil), nil
+	}
+
+	logReader, err := s.OpenStepLog(step.LogPath)
+	if err != nil {
+		return nil, err
+	}
+	// Logs written before lines were tagged have no details
+	meta, err := os.Open(filepath.Join(s.dataDir, step.LogPath+".lines"))
+	if err != nil {
+		r := newStepLineReader(logReader, nil)
+		r.closers = []io.Closer{logReader}
+		return r, nil
+	}
+	r := newStepLineReader(logReader, meta)
+	r.closers = []io.Closer{logReader, meta}
+	return r, nil
+}
+
+// Next returns the next line, numbered from 1, or io.EOF after the last one
+func (r *StepLineReader) Next() (OutputLine, error) {
+	text, err := r.text.ReadString('\n')
+	if err != nil && (err != io.EOF || text == "") {
+		return OutputLine{}, err
+	}
+	r.line++
+	line := OutputLine{Line: r.line, Text: strings.TrimSuffix(text, "\n")}
+
+	if r.meta != nil {
+		details, err := r.meta.ReadString('\n')
+		if err != nil && details == "" {
+			// Fewer details than lines, the rest have none
+			r.meta = nil
+			return line, nil
+		}
+		offsetField, rest, found := strings.Cut(strings.TrimSuffix(details, "\n"), " ")
+		if offset, err := strconv.ParseInt(offsetField, 10, 64); found && err == nil {
+			stream, numberField, numbered := strings.Cut(rest, " ")
+			line.OffsetMs = &offset
+			line.Stream = stream
+			if number, err := strconv.Atoi(numberField); numbered && err == nil && number > r.line {
+				r.line = number
+				line.Line = number
+			}
+		}
+	}
+	return line, nil
+}
+
+// Close closes the files being read
+func (r *StepLineReader) Close() error {
+	for _, c := range r.closers {
+		c.Close()
+	}
+	return nil
+}
+
+// ReadStepLines returns up to limit lines of the stored output of a step,
+// starting at line from, and whether more follow. A limit of 0 reads to the end.
+func (s *Storage) ReadStepLines(step *StepExecution, from, limit int) ([]OutputLine, bool, error) {
+	r, err := s.OpenStepLines(step)
+	if err != nil {
+		return nil, false, err
+	}
+	defer r.Close()
+
+	var lines []OutputLine
+	for {
+		line, err := r.Next()
+		if err == io.EOF {
+			return lines, false, nil
+		} else if err != nil {
+			return nil, false, err
+		}
+		if line.Line < from {
+			continue
+		}
+		if limit > 0 && len(lines) == limit {
+			return lines, true, nil
+		}
+		lines = append(lines, line)
+	}
+}
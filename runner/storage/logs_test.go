@@ -0,0 +1,367 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeTestLog writes a step log in several appends, one gzip member each,
+// and returns the step and the text written
+func writeTestLog(t *testing.T, s *Storage, chunks ...[]string) (*StepExecution, string) {
+	t.Helper()
+	run, err := s.CreateRun("pipego.yml", "app", "", "build")
+	if err != nil {
+		t.Fatal(err)
+	}
+	step, err := s.CreateStepExecution(run.ID, "build", "make", "", "build", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := s.CreateStepLog(run.ID, step.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	var text strings.Builder
+	for _, chunk := range chunks {
+		var lines []OutputLine
+		for _, line := range chunk {
+			lines = append(lines, OutputLine{Text: line})
+			text.WriteString(line + "\n")
+		}
+		if err := w.Append(lines); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if w.Size() != int64(text.Len()) {
+		t.Errorf("writer size = %d, want %d", w.Size(), text.Len())
+	}
+	step.LogPath = StepLogPath(run.ID, step.ID)
+	return step, text.String()
+}
+
+func TestLogReaderRanges(t *testing.T) {
+	s := newTestStorage(t)
+	var chunks [][]string
+	for i := range 20 {
+		var chunk []string
+		for j := range i + 1 {
+			chunk = append(chunk, fmt.Sprintf("chunk %d line %d", i, j))
+		}
+		chunks = append(chunks, chunk)
+	}
+	step, text := writeTestLog(t, s, chunks...)
+
+	r, err := s.OpenStepLog(step.LogPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if r.Size() != int64(len(text)) {
+		t.Fatalf("size = %d, want %d", r.Size(), len(text))
+	}
+
+	all, err := io.ReadAll(r)
+	if err != nil || string(all) != text {
+		t.Fatalf("reading the whole log: %v", err)
+	}
+
+	// Ranges from the start, inside and across members, and up to the end
+	size := int64(len(text))
+	ranges := [][2]int64{{0, 1}, {0, 100}, {5, 40}, {99, 900}, {size / 2, size}, {size - 1, size}, {size - 10, size}, {17, 18}}
+	for _, rg := range ranges {
+		if _, err := r.Seek(rg[0], io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, rg[1]-rg[0])
+		if _, err := io.ReadFull(r, buf); err != nil {
+			t.Errorf("reading %d-%d: %v", rg[0], rg[1], err)
+			continue
+		}
+		if want := text[rg[0]:rg[1]]; string(buf) != want {
+			t.Errorf("reading %d-%d = %q, want %q", rg[0], rg[1], buf, want)
+		}
+	}
+
+	// Backwards, from the end and from the current position
+	if pos, err := r.Seek(-6, io.SeekEnd); err != nil || pos != size-6 {
+		t.Fatalf("seek from the end = %d, %v", pos, err)
+	}
+	if rest, _ := io.ReadAll(r); string(rest) != text[size-6:] {
+		t.Errorf("end of the log = %q", rest)
+	}
+	r.Seek(10, io.SeekStart)
+	if pos, _ := r.Seek(-5, io.SeekCurrent); pos != 5 {
+		t.Errorf("seek back from 10 = %d", pos)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(r, buf); err != nil || string(buf) != text[5:9] {
+		t.Errorf("read after seeking back = %q, %v", buf, err)
+	}
+
+	if _, err := r.Seek(-1, io.SeekStart); err == nil {
+		t.Error("seek before the start succeeded")
+	}
+	r.Seek(size+10, io.SeekStart)
+	if n, err := r.Read(buf); n != 0 || err != io.EOF {
+		t.Errorf("read past the end = %d, %v", n, err)
+	}
+}
+
+func TestLogReaderIgnoresUnindexedMember(t *testing.T) {
+	s := newTestStorage(t)
+	step, text := writeTestLog(t, s, []string{"first"}, []string{"second"})
+
+	// A member cut short by a crash, before it was indexed
+	path := filepath.Join(s.DataDir(), step.LogPath)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{0x1f, 0x8b, 0x08, 0x00})
+	f.Close()
+
+	r, err := s.OpenStepLog(step.LogPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if data, err := io.ReadAll(r); err != nil || string(data) != text {
+		t.Errorf("log with a partial member = %q, %v, want %q", data, err, text)
+	}
+}
+
+func TestInlineLogKeepsTheEnd(t *testing.T) {
+	s := newTestStorage(t)
+	line := strings.Repeat("x", 1023)
+	var chunk []string
+	for range inlineLogLimit/1024 + 10 {
+		chunk = append(chunk, line)
+	}
+	step, text := writeTestLog(t, s, chunk, []string{"the last line"})
+
+	steps, err := s.GetStepExecutions(step.RunID)
+	if err != nil || len(steps) != 1 {
+		t.Fatalf("GetStepExecutions = %d steps, %v", len(steps), err)
+	}
+	if steps[0].Output != "" {
+		t.Errorf("GetStepExecutions read the log of the step")
+	}
+	s.LoadStepOutputs(steps)
+	omitted := len(text) - inlineLogLimit
+	prefix := fmt.Sprintf("[%d bytes of earlier output omitted, read the step log for all of it]\n", omitted)
+	if want := prefix + text[omitted:]; steps[0].Output != want {
+		t.Errorf("inline output starts %q and has %d bytes, want %d", steps[0].Output[:100], len(steps[0].Output), len(want))
+	}
+}
+
+func TestReadStepLines(t *testing.T) {
+	s := newTestStorage(t)
+	run, err := s.CreateRun("pipego.yml", "app", "", "build")
+	if err != nil {
+		t.Fatal(err)
+	}
+	step, err := s.CreateStepExecution(run.ID, "build", "make", "", "build", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := s.CreateStepLog(run.ID, step.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	written := []OutputLine{
+		{OffsetMs: int64Ptr(0), Stream: StreamStdout, Text: "compiling"},
+		{OffsetMs: int64Ptr(12), Stream: StreamStderr, Text: "warning: unused"},
+		{OffsetMs: int64Ptr(12), Stream: StreamStdout, Text: ""},
+	}
+	if err := w.Append(written[:2]); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Append(written[2:]); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	step.LogPath = StepLogPath(run.ID, step.ID)
+
+	lines, more, err := s.ReadStepLines(step, 1, 0)
+	if err != nil || more {
+		t.Fatal(more, err)
+	}
+	if len(lines) != len(written) {
+		t.Fatalf("got %d lines, want %d: %+v", len(lines), len(written), lines)
+	}
+	for i, line := range lines {
+		want := written[i]
+		if line.Line != i+1 || line.Text != want.Text || line.Stream != want.Stream || line.OffsetMs == nil || *line.OffsetMs != *want.OffsetMs {
+			t.Errorf("line %d = %+v, want %+v", i+1, line, want)
+		}
+	}
+
+	// A page of them
+	lines, more, err = s.ReadStepLines(step, 2, 1)
+	if err != nil || !more || len(lines) != 1 || lines[0].Line != 2 || lines[0].Text != "warning: unused" {
+		t.Errorf("page of lines = %+v, more %v, %v", lines, more, err)
+	}
+	lines, more, err = s.ReadStepLines(step, 2, 2)
+	if err != nil || more || len(lines) != 2 || lines[1].Line != 3 {
+		t.Errorf("last page of lines = %+v, more %v, %v", lines, more, err)
+	}
+
+	// Logs from before lines were tagged have only the text
+	if err := os.Remove(filepath.Join(s.DataDir(), step.LogPath+".lines")); err != nil {
+		t.Fatal(err)
+	}
+	lines, _, err = s.ReadStepLines(step, 1, 0)
+	if err != nil || len(lines) != 3 || lines[1].Text != "warning: unused" || lines[1].Stream != "" || lines[1].OffsetMs != nil {
+		t.Errorf("lines without details = %+v, %v", lines, err)
+	}
+
+	// So do steps whose output is in the database
+	inline := &StepExecution{Output: "one\ntwo\n"}
+	lines, _, err = s.ReadStepLines(inline, 1, 0)
+	if err != nil || len(lines) != 2 || lines[1].Line != 2 || lines[1].Text != "two" || lines[1].Stream != "" {
+		t.Errorf("inline lines = %+v, %v", lines, err)
+	}
+}
+
+func TestStepLineReader(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		meta   []string
+		want   []OutputLine
+	}{
+		{"empty", "", []string{"0 stdout"}, nil},
+		{"empty lines", "a\n\n", nil, []OutputLine{{Line: 1, Text: "a"}, {Line: 2, Text: ""}}},
+		{"no details", "a\nb", nil, []OutputLine{{Line: 1, Text: "a"}, {Line: 2, Text: "b"}}},
+		{"details", "a\nb\n", []string{"5 stdout", "7 stderr", ""},
+			[]OutputLine{{Line: 1, Text: "a", OffsetMs: int64Ptr(5), Stream: StreamStdout}, {Line: 2, Text: "b", OffsetMs: int64Ptr(7), Stream: StreamStderr}}},
+		// A log appended to by an older version can have more details than lines
+		{"more details than lines", "a\n", []string{"5 stdout", "7 stderr"},
+			[]OutputLine{{Line: 1, Text: "a", OffsetMs: int64Ptr(5), Stream: StreamStdout}}},
+		{"fewer details than lines", "a\nb\n", []string{"5 system"},
+			[]OutputLine{{Line: 1, Text: "a", OffsetMs: int64Ptr(5), Stream: StreamSystem}, {Line: 2, Text: "b"}}},
+		// Lines after a gap carry their number
+		{"numbered lines", "a\n[...]\nd\ne\n", []string{"1 stdout", "2 system 2", "3 stdout 4", "4 stdout"},
+			[]OutputLine{{Line: 1, Text: "a", OffsetMs: int64Ptr(1), Stream: StreamStdout}, {Line: 2, Text: "[...]", OffsetMs: int64Ptr(2), Stream: StreamSystem},
+				{Line: 4, Text: "d", OffsetMs: int64Ptr(3), Stream: StreamStdout}, {Line: 5, Text: "e", OffsetMs: int64Ptr(4), Stream: StreamStdout}}},
+		{"invalid details", "a\nb\n", []string{"x stdout", "7"},
+			[]OutputLine{{Line: 1, Text: "a"}, {Line: 2, Text: "b"}}},
+	}
+	for _, tt := range tests {
+		var meta io.Reader
+		if tt.meta != nil {
+			meta = strings.NewReader(strings.Join(tt.meta, "\n"))
+		}
+		r := newStepLineReader(strings.NewReader(tt.output), meta)
+		var got []OutputLine
+		for {
+			line, err := r.Next()
+			if err != nil {
+				if err != io.EOF {
+					t.Errorf("%s: %v", tt.name, err)
+				}
+				break
+			}
+			got = append(got, line)
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+			continue
+		}
+		for i := range got {
+			g, w := got[i], tt.want[i]
+			sameOffset := (g.OffsetMs == nil) == (w.OffsetMs == nil) && (g.OffsetMs == nil || *g.OffsetMs == *w.OffsetMs)
+			if g.Line != w.Line || g.Text != w.Text || g.Stream != w.Stream || !sameOffset {
+				t.Errorf("%s: line %d = %+v, want %+v", tt.name, i+1, g, w)
+			}
+		}
+	}
+}
+
+func TestOpenConvertedStepLog(t *testing.T) {
+	s := newTestStorage(t)
+	step, _ := writeTestLog(t, s, []string{"\x1b[31mred\x1b[0m", "progress 10%\rprogress 100%"}, []string{"done"})
+
+	read := func() string {
+		t.Helper()
+		f, err := s.OpenConvertedStepLog(step.LogPath, "text")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		data, err := io.ReadAll(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+	if got, want := read(), "red\nprogress 100%\ndone\n"; got != want {
+		t.Errorf("converted log = %q, want %q", got, want)
+	}
+
+	// Later reads use the converted file instead of converting again
+	path := filepath.Join(s.DataDir(), step.LogPath+".text")
+	if err := os.WriteFile(path, []byte("cached\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := read(); got != "cached\n" {
+		t.Errorf("second read = %q, want the converted file", got)
+	}
+	if tmp, _ := filepath.Glob(path + ".*.tmp"); len(tmp) != 0 {
+		t.Errorf("temporary files left behind: %v", tmp)
+	}
+}
+
+func TestLogWriterRollsBackFailedAppend(t *testing.T) {
+	s := newTestStorage(t)
+	step, _ := writeTestLog(t, s, []string{"first"})
+	path := filepath.Join(s.DataDir(), step.LogPath)
+
+	// Reopen the log as the writer left it, with the log itself closed so the
+	// next append fails
+	w, err := s.CreateStepLog(step.RunID, step.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	for _, f := range []struct {
+		file *os.File
+		size *int64
+	}{{w.file, &w.compressed}, {w.lines, &w.linesSize}, {w.index, &w.indexSize}} {
+		info, err := f.file.Stat()
+		if err != nil {
+			t.Fatal(err)
+		}
+		*f.size = info.Size()
+	}
+	w.size = int64(len("first\n"))
+	w.file.Close()
+
+	second := []OutputLine{{OffsetMs: int64Ptr(5), Stream: StreamStderr, Text: "second"}}
+	if err := w.Append(second); err == nil {
+		t.Fatal("append to a closed log succeeded")
+	}
+	if w.file, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Append(second); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Append([]OutputLine{{OffsetMs: int64Ptr(9), Stream: StreamStdout, Text: "third"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	lines, _, err := s.ReadStepLines(step, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 3 || lines[1].Text != "second" || lines[1].Stream != StreamStderr || lines[2].OffsetMs == nil || *lines[2].OffsetMs != 9 {
+		t.Errorf("lines after a retried append = %+v", lines)
+	}
+}
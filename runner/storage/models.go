@@ -5,14 +5,50 @@ import "time"
 // Run represents a pipeline execution
 type Run struct {
 	ID          int        `json:"id"`
-	Status      string     `json:"status"` // "running", "success", "failed"
+	Status      string     `json:"status"` // "queued", "running", "success", "failed", "cancelled", "skipped", "interrupted"
 	ConfigPath  string     `json:"config_path"`
 	ProjectName string     `json:"project_name"`
-	Group       string     `json:"group"` // The group (e.g., "frontend", "backend") or empty for ungrouped
-	Part        string     `json:"part"`  // The part being executed (e.g., "deploy", "tests" or full path "frontend.deploy")
+	Group       string     `json:"group"`               // The group (e.g., "frontend", "backend") or empty for ungrouped
+	Part        string     `json:"part"`                // The part being executed (e.g., "deploy", "tests" or full path "frontend.deploy")
+	QueuedAt    *time.Time `json:"queued_at,omitempty"` // When the run was put in the run queue
 	StartedAt   time.Time  `json:"started_at"`
 	FinishedAt  *time.Time `json:"finished_at,omitempty"`
 	Duration    *string    `json:"duration,omitempty"`
+	Reason      *string    `json:"reason,omitempty"`        // Why the run was skipped or cancelled, if it was
+	ExecutionID *int       `json:"execution_id,omitempty"`  // The pipeline execution this part run belongs to
+	ParentRunID *int       `json:"parent_run_id,omitempty"` // The run this one re-runs or resumes
+	// Directory the steps ran in when the project uses isolated workspaces
+	WorkspacePath string `json:"workspace_path,omitempty"`
+	// Whether the steps ran in a worktree of the commit instead of the project directory
+	CheckedOut bool     `json:"checked_out,omitempty"`
+	Failure    *Failure `json:"failure,omitempty"` // Why the run failed or was cancelled
+	CommitInfo
+}
+
+// Execution is one run of a pipeline, owning the runs of its parts
+type Execution struct {
+	ID          int        `json:"id"`
+	Status      string     `json:"status"` // "queued", "running", "success", "failed", "cancelled", "interrupted"
+	ConfigPath  string     `json:"config_path"`
+	ProjectName string     `json:"project_name"`
+	Trigger     string     `json:"trigger"` // "manual", "api", "cli", "scheduled", "watch", "git", "webhook"
+	QueuedAt    *time.Time `json:"queued_at,omitempty"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+	Duration    *string    `json:"duration,omitempty"`
+	Error       *string    `json:"error,omitempty"` // Why the execution did not succeed
+	CommitInfo
+	PartCount int            `json:"part_count"`
+	Summary   map[string]int `json:"summary"` // Number of part runs by status
+}
+
+// CommitInfo describes the commit a run was triggered for
+type CommitInfo struct {
+	CommitSHA     string `json:"commit_sha,omitempty"`
+	Branch        string `json:"branch,omitempty"`
+	Tag           string `json:"tag,omitempty"` // Set instead of Branch for tag pushes
+	CommitAuthor  string `json:"commit_author,omitempty"`
+	CommitMessage string `json:"commit_message,omitempty"`
 }
 
 // StepExecution represents execution of a single step
@@ -20,7 +56,7 @@ type StepExecution struct {
 	ID         int        `json:"id"`
 	RunID      int        `json:"run_id"`
 	Name       string     `json:"name"`
-	Status     string     `json:"status"` // "running", "success", "failed"
+	Status     string     `json:"status"` // "running", "success", "failed", "cancelled", "skipped", "interrupted", "stalled"
 	Command    string     `json:"command"`
 	Output     string     `json:"output"`
 	Group      string     `json:"group"`    // The group this step belongs to
@@ -29,5 +65,95 @@ type StepExecution struct {
 	StartedAt  time.Time  `json:"started_at"`
 	FinishedAt *time.Time `json:"finished_at,omitempty"`
 	Duration   *string    `json:"duration,omitempty"`
+	// Last time the step wrote output, updated while it runs
+	LastActivityAt *time.Time `json:"last_activity_at,omitempty"`
+	Failure        *Failure   `json:"failure,omitempty"` // Why the step failed or was cancelled
+	// Log file relative to the data directory, empty for steps that kept their
+	// output in the database. Output then holds at most the last megabyte of it.
+	LogPath      string `json:"-"`
+	LogSize      int64  `json:"log_size,omitempty"`      // Bytes in the log file
+	LogTruncated int64  `json:"log_truncated,omitempty"` // Bytes of output left out of the log by the size limits
+	// Output line by line with the stream and time of each line, only filled on request
+	Lines     []OutputLine `json:"lines,omitempty"`
+	MoreLines bool         `json:"more_lines,omitempty"` // Whether lines follow those in Lines
+	StepUsage
+}
+
+// StepUsage is how the process of a step exited and the resources it used
+type StepUsage struct {
+	ExitCode    *int   `json:"exit_code,omitempty"`     // Not set if the process was killed by a signal
+	Signal      string `json:"signal,omitempty"`        // Signal that terminated the process, e.g. "killed"
+	UserCPUMs   *int64 `json:"user_cpu_ms,omitempty"`   // CPU time spent in user mode
+	SystemCPUMs *int64 `json:"system_cpu_ms,omitempty"` // CPU time spent in the kernel
+	MaxRSSKB    *int64 `json:"max_rss_kb,omitempty"`    // Peak resident set size of the largest process
 }
 
+// WebhookDelivery records a received forge webhook, used to ignore redeliveries
+type WebhookDelivery struct {
+	DeliveryID  string    `json:"delivery_id"`
+	ProjectName string    `json:"project_name"`
+	Provider    string    `json:"provider"` // "github", "gitlab" or "gitea"
+	Ref         string    `json:"ref"`
+	CommitSHA   string    `json:"commit_sha"`
+	ReceivedAt  time.Time `json:"received_at"`
+}
+
+// IdempotencyKey maps a client-supplied Idempotency-Key to the runs its request queued
+type IdempotencyKey struct {
+	Key         string    `json:"key"`
+	Scope       string    `json:"scope"`       // What the key applies to, e.g. "project:web"
+	Fingerprint string    `json:"fingerprint"` // Request parameters the key was first used with
+	RunIDs      []int     `json:"run_ids"`     // Empty while the first request is still being handled
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Annotation types. A group is a section of a step's output that can be folded.
+const (
+	AnnotationGroup   = "group"
+	AnnotationNotice  = "notice"
+	AnnotationWarning = "warning"
+	AnnotationError   = "error"
+)
+
+// Annotation is a section or message a step emitted with a workflow command
+// such as ::group::Install or ::warning file=x.go,line=10::msg
+type Annotation struct {
+	ID      int    `json:"id"`
+	RunID   int    `json:"run_id"`
+	StepID  int    `json:"step_id"`
+	Type    string `json:"type"`    // "group", "notice", "warning" or "error"
+	Message string `json:"message"` // Name of a group
+	Title   string `json:"title,omitempty"`
+	File    string `json:"file,omitempty"` // Source location the message is about
+	Line    *int   `json:"line,omitempty"`
+	Col     *int   `json:"col,omitempty"`
+	// Lines of the step output the command is on; a group runs to its ::endgroup::
+	LogLine    int  `json:"log_line"`
+	EndLogLine *int `json:"end_log_line,omitempty"`
+}
+
+// Failure reasons. Steps that exceeded a resource limit have a more specific
+// reason, such as "memory_limit".
+const (
+	FailureCommandFailed = "command_failed" // The command exited with an error
+	FailureTimeout       = "timeout"        // Killed for running or staying silent for too long
+	FailureCancelled     = "cancelled"      // Cancelled by a user, a newer run or a shutdown
+	FailureConfigError   = "config_error"   // The config or the settings of a step are invalid
+	FailureStorageError  = "storage_error"  // The run or its files could not be stored
+)
+
+// Failure describes why a run or step did not succeed
+type Failure struct {
+	Reason   string `json:"reason"`
+	ExitCode *int   `json:"exit_code,omitempty"` // Exit code of the failed command, if it exited
+	Message  string `json:"message"`
+	Step     string `json:"step,omitempty"`  // On runs: the step that failed
+	Cause    string `json:"cause,omitempty"` // Named by the failure cause rules, e.g. "network_timeout"
+}
+
+// StepSummary is the markdown a step wrote to its PIPEGO_SUMMARY file
+type StepSummary struct {
+	StepID   int    `json:"step_id"`
+	Step     string `json:"step"`
+	Markdown string `json:"markdown"`
+}
@@ -0,0 +1,120 @@
+package storage
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"os"
+	"time"
+)
+
+// ProcessStartTime identifies when a process started, as the boot ID and the
+// start time in clock ticks since boot from /proc, so a PID reused by another
+// process, also after a reboot, is told apart from the process that had it.
+// It returns "" where /proc is not available.
+func ProcessStartTime(pid int) string {
+	bootID, err := os.ReadFile("/proc/sys/kernel/random/boot_id")
+	if err != nil {
+		return ""
+	}
+	stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return ""
+	}
+
+	// The start time is the 20th field after the parenthesised command name: "pid (comm) S ..."
+	idx := bytes.LastIndexByte(stat, ')')
+	if idx < 0 {
+		return ""
+	}
+	fields := bytes.Fields(stat[idx+1:])
+	if len(fields) < 20 {
+		return ""
+	}
+	return string(bytes.TrimSpace(bootID)) + " " + string(fields[19])
+}
+
+// processOwner is the process recorded on unfinished runs and executions
+type processOwner struct {
+	pid   sql.NullInt64
+	start string // "" when not recorded
+}
+
+// ReconcileInterrupted marks the unfinished runs, steps and executions of processes
+// that are gone as "interrupted". isAlive reports whether the owner process still
+// runs, given its PID and its start time if recorded (see ProcessStartTime); rows
+// recorded before owners were tracked are treated as orphaned.
+// Returns the number of runs that were marked.
+func (s *Storage) ReconcileInterrupted(isAlive func(pid int, start string) bool) (int, error) {
+	owners, err := s.unfinishedOwners()
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	marked := 0
+	for _, owner := range owners {
+		if owner.pid.Valid && isAlive(int(owner.pid.Int64), owner.start) {
+			continue
+		}
+
+		reason := "pipego exited before the run finished"
+		if owner.pid.Valid {
+			reason = fmt.Sprintf("pipego process %d exited before the run finished", owner.pid.Int64)
+		}
+
+		// owner_pid IS ? matches NULL owners too
+		_, err := s.db.Exec(
+			`UPDATE step_executions SET status = 'interrupted', finished_at = ?
+			WHERE status = 'running' AND run_id IN (SELECT id FROM runs WHERE status IN ('queued', 'running') AND owner_pid IS ? AND owner_start = ?)`,
+			now, owner.pid, owner.start,
+		)
+		if err != nil {
+			return marked, fmt.Errorf("failed to reconcile step executions: %w", err)
+		}
+
+		result, err := s.db.Exec(
+			`UPDATE runs SET status = 'interrupted', finished_at = ?, reason = ? WHERE status IN ('queued', 'running') AND owner_pid IS ? AND owner_start = ?`,
+			now, reason, owner.pid, owner.start,
+		)
+		if err != nil {
+			return marked, fmt.Errorf("failed to reconcile runs: %w", err)
+		}
+		if affected, err := result.RowsAffected(); err == nil {
+			marked += int(affected)
+		}
+
+		_, err = s.db.Exec(
+			`UPDATE executions SET status = 'interrupted', finished_at = ?, error = ? WHERE status IN ('queued', 'running') AND owner_pid IS ? AND owner_start = ?`,
+			now, reason, owner.pid, owner.start,
+		)
+		if err != nil {
+			return marked, fmt.Errorf("failed to reconcile executions: %w", err)
+		}
+	}
+
+	return marked, nil
+}
+
+// unfinishedOwners returns the distinct owners of queued or running runs and executions
+func (s *Storage) unfinishedOwners() ([]processOwner, error) {
+	rows, err := s.db.Query(
+		`SELECT owner_pid, owner_start FROM runs WHERE status IN ('queued', 'running')
+		UNION SELECT owner_pid, owner_start FROM executions WHERE status IN ('queued', 'running')`,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query unfinished runs: %w", err)
+	}
+	defer rows.Close()
+
+	var owners []processOwner
+	for rows.Next() {
+		var owner processOwner
+		if err := rows.Scan(&owner.pid, &owner.start); err != nil {
+			return nil, fmt.Errorf("failed to scan run owner: %w", err)
+		}
+		owners = append(owners, owner)
+	}
+
+	return owners, rows.Err()
+}
@@ -0,0 +1,161 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+// ownedRuns is what one owner process left behind: an execution with a
+// running run, whose first step finished and second is running, and a queued run
+type ownedRuns struct {
+	execution, running, queued int
+	finishedStep, runningStep  int
+}
+
+// addOwnedRuns records runs as the process with the given PID and start time,
+// 0 for rows recorded before owners were tracked
+func addOwnedRuns(t *testing.T, s *Storage, pid int, start string) ownedRuns {
+	t.Helper()
+	s.ownerPID, s.ownerStart = pid, start
+	defer func() { s.ownerPID, s.ownerStart = 0, "" }()
+
+	execution, err := s.CreateExecution("app/pipego.yml", "app", "api", "running")
+	if err != nil {
+		t.Fatal(err)
+	}
+	running, err := s.CreateRun("app/pipego.yml", "app", "", "build")
+	if err != nil {
+		t.Fatal(err)
+	}
+	queued, err := s.CreateQueuedRun("app/pipego.yml", "app", "", "deploy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, runID := range []int{running.ID, queued.ID} {
+		if err := s.SetRunExecution(runID, execution.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	finished, err := s.CreateStepExecution(running.ID, "compile", "make", "", "build", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UpdateStepExecution(finished.ID, "success", "ok\n", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	step, err := s.CreateStepExecution(running.ID, "test", "make test", "", "build", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pid == 0 {
+		for _, table := range []string{"runs", "executions"} {
+			if _, err := s.db.Exec(`UPDATE ` + table + ` SET owner_pid = NULL WHERE owner_pid = 0`); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return ownedRuns{execution: execution.ID, running: running.ID, queued: queued.ID, finishedStep: finished.ID, runningStep: step.ID}
+}
+
+// checkOwnedRuns checks the statuses of the rows addOwnedRuns created
+func checkOwnedRuns(t *testing.T, s *Storage, name string, owned ownedRuns, interrupted bool) {
+	t.Helper()
+	wantRunning, wantQueued, wantExecution, wantStep := "running", "queued", "running", "running"
+	if interrupted {
+		wantRunning, wantQueued, wantExecution, wantStep = "interrupted", "interrupted", "interrupted", "interrupted"
+	}
+
+	for runID, want := range map[int]string{owned.running: wantRunning, owned.queued: wantQueued} {
+		run, err := s.GetRun(runID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if run.Status != want || (interrupted && (run.FinishedAt == nil || run.Reason == nil)) {
+			t.Errorf("%s: run %s is %s with reason %v, want %s", name, run.Part, run.Status, run.Reason, want)
+		}
+	}
+
+	execution, err := s.GetExecution(owned.execution)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if execution.Status != wantExecution || (interrupted && (execution.FinishedAt == nil || execution.Error == nil)) {
+		t.Errorf("%s: execution is %s with error %v, want %s", name, execution.Status, execution.Error, wantExecution)
+	}
+
+	steps, err := s.GetStepExecutions(owned.running)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(steps) != 2 || steps[0].Status != "success" || steps[1].Status != wantStep {
+		t.Errorf("%s: steps = %+v, want the finished one left alone and the running one %s", name, steps, wantStep)
+	}
+}
+
+func TestReconcileInterrupted(t *testing.T) {
+	s := newTestStorage(t)
+	const deadPID, alivePID = 1001, 1002
+
+	dead := addOwnedRuns(t, s, deadPID, "")
+	alive := addOwnedRuns(t, s, alivePID, "boot 200")
+	// The live PID was the owner's before, but that process started earlier
+	reused := addOwnedRuns(t, s, alivePID, "boot 100")
+	// Rows recorded before owners were tracked have no process that could
+	// still run them, so they count as orphaned
+	untracked := addOwnedRuns(t, s, 0, "")
+
+	// A finished run of the dead process keeps its status
+	s.ownerPID = deadPID
+	done, err := s.CreateRun("app/pipego.yml", "app", "", "lint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UpdateRunStatus(done.ID, "success", time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	var checked []int
+	isAlive := func(pid int, start string) bool {
+		checked = append(checked, pid)
+		return pid == alivePID && start == "boot 200"
+	}
+	marked, err := s.ReconcileInterrupted(isAlive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if marked != 6 {
+		t.Errorf("marked %d runs, want the running and queued runs of the dead, reused and untracked owners", marked)
+	}
+	if len(checked) != 3 {
+		t.Errorf("isAlive called for %v, want the three recorded owners only", checked)
+	}
+
+	checkOwnedRuns(t, s, "dead owner", dead, true)
+	checkOwnedRuns(t, s, "live owner", alive, false)
+	checkOwnedRuns(t, s, "owner of a reused PID", reused, true)
+	checkOwnedRuns(t, s, "untracked owner", untracked, true)
+
+	if run, err := s.GetRun(done.ID); err != nil || run.Status != "success" {
+		t.Errorf("finished run = %+v, %v, want it left as success", run, err)
+	}
+	run, err := s.GetRun(dead.running)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "pipego process 1001 exited before the run finished"; run.Reason == nil || *run.Reason != want {
+		t.Errorf("reason = %v, want %q", run.Reason, want)
+	}
+	run, err = s.GetRun(untracked.running)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "pipego exited before the run finished"; run.Reason == nil || *run.Reason != want {
+		t.Errorf("untracked reason = %v, want %q", run.Reason, want)
+	}
+
+	// Reconciling again finds nothing left of the dead owners
+	if marked, err := s.ReconcileInterrupted(isAlive); err != nil || marked != 0 {
+		t.Errorf("second reconcile marked %d runs, %v", marked, err)
+	}
+}
@@ -6,12 +6,76 @@ import (
 	"time"
 )
 
+// runColumns lists the runs columns in the order scanRun expects them
+const runColumns = `id, status, config_path, project_name, "group", part, started_at, finished_at, duration, reason,
+	commit_sha, branch, tag, commit_author, commit_message, queued_at, execution_id, parent_run_id, workspace_path,
+	failure_reason, failure_exit_code, failure_message, failure_step, failure_cause, checked_out`
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanRun scans a single row selected with runColumns
+func scanRun(row rowScanner) (*Run, error) {
+	var r Run
+	var finishedAt sql.NullTime
+	var duration sql.NullString
+	var reason sql.NullString
+	var queuedAt sql.NullTime
+	var executionID sql.NullInt64
+	var parentRunID sql.NullInt64
+	var workspacePath sql.NullString
+	var failureReason, failureMessage, failureStep, failureCause sql.NullString
+	var failureExitCode sql.NullInt64
+
+	err := row.Scan(&r.ID, &r.Status, &r.ConfigPath, &r.ProjectName, &r.Group, &r.Part, &r.StartedAt, &finishedAt, &duration, &reason,
+		&r.CommitSHA, &r.Branch, &r.Tag, &r.CommitAuthor, &r.CommitMessage, &queuedAt, &executionID, &parentRunID, &workspacePath,
+		&failureReason, &failureExitCode, &failureMessage, &failureStep, &failureCause, &r.CheckedOut)
+	if err != nil {
+		return nil, err
+	}
+
+	if finishedAt.Valid {
+		r.FinishedAt = &finishedAt.Time
+	}
+	if duration.Valid {
+		durationStr := duration.String
+		r.Duration = &durationStr
+	}
+	if reason.Valid {
+		reasonStr := reason.String
+		r.Reason = &reasonStr
+	}
+	if queuedAt.Valid {
+		r.QueuedAt = &queuedAt.Time
+	}
+	if executionID.Valid {
+		id := int(executionID.Int64)
+		r.ExecutionID = &id
+	}
+	if parentRunID.Valid {
+		id := int(parentRunID.Int64)
+		r.ParentRunID = &id
+	}
+	r.WorkspacePath = workspacePath.String
+	if failureReason.String != "" {
+		r.Failure = &Failure{Reason: failureReason.String, Message: failureMessage.String, Step: failureStep.String, Cause: failureCause.String}
+		if failureExitCode.Valid {
+			exitCode := int(failureExitCode.Int64)
+			r.Failure.ExitCode = &exitCode
+		}
+	}
+
+	return &r, nil
+}
+
 // CreateRun creates a new run record
 func (s *Storage) CreateRun(configPath, projectName, groupName, part string) (*Run, error) {
 	now := time.Now()
 	result, err := s.db.Exec(
-		`INSERT INTO runs (status, config_path, project_name, "group", part, started_at) VALUES (?, ?, ?, ?, ?, ?)`,
-		"running", configPath, projectName, groupName, part, now,
+		`INSERT INTO runs (status, config_path, project_name, "group", part, started_at, owner_pid, owner_start) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		"running", configPath, projectName, groupName, part, now, s.ownerPID, s.ownerStart,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create run: %w", err)
@@ -33,6 +97,87 @@ func (s *Storage) CreateRun(configPath, projectName, groupName, part string) (*R
 	}, nil
 }
 
+// CreateQueuedRun creates a run record for a pipeline waiting in the run queue
+func (s *Storage) CreateQueuedRun(configPath, projectName, groupName, part string) (*Run, error) {
+	now := time.Now()
+	result, err := s.db.Exec(
+		`INSERT INTO runs (status, config_path, project_name, "group", part, queued_at, started_at, owner_pid, owner_start) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		"queued", configPath, projectName, groupName, part, now, now, s.ownerPID, s.ownerStart,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create queued run: %w", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get run ID: %w", err)
+	}
+
+	return &Run{
+		ID:          int(id),
+		Status:      "queued",
+		ConfigPath:  configPath,
+		ProjectName: projectName,
+		Group:       groupName,
+		Part:        part,
+		QueuedAt:    &now,
+		StartedAt:   now,
+	}, nil
+}
+
+// StartRun marks a queued run as running from now on
+func (s *Storage) StartRun(runID int) error {
+	_, err := s.db.Exec(
+		"UPDATE runs SET status = ?, started_at = ? WHERE id = ?",
+		"running", time.Now(), runID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to start run: %w", err)
+	}
+	return nil
+}
+
+// AbandonRun closes a run that never started (e.g. "cancelled" or "skipped") with a reason
+func (s *Storage) AbandonRun(runID int, status, reason string) error {
+	_, err := s.db.Exec(
+		"UPDATE runs SET status = ?, reason = ?, finished_at = ? WHERE id = ?",
+		status, reason, time.Now(), runID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to abandon run: %w", err)
+	}
+	return nil
+}
+
+// CreateSkippedRun records a run that was never started, together with the reason
+func (s *Storage) CreateSkippedRun(configPath, projectName, groupName, part, reason string) (*Run, error) {
+	now := time.Now()
+	result, err := s.db.Exec(
+		`INSERT INTO runs (status, config_path, project_name, "group", part, started_at, finished_at, reason) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		"skipped", configPath, projectName, groupName, part, now, now, reason,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create skipped run: %w", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get run ID: %w", err)
+	}
+
+	return &Run{
+		ID:          int(id),
+		Status:      "skipped",
+		ConfigPath:  configPath,
+		ProjectName: projectName,
+		Group:       groupName,
+		Part:        part,
+		StartedAt:   now,
+		FinishedAt:  &now,
+		Reason:      &reason,
+	}, nil
+}
+
 // UpdateRunStatus updates the status and finish time of a run
 func (s *Storage) UpdateRunStatus(runID int, status string, duration time.Duration) error {
 	now := time.Now()
@@ -47,9 +192,119 @@ func (s *Storage) UpdateRunStatus(runID int, status string, duration time.Durati
 	return nil
 }
 
+// SetRunCommit records the commit a run was triggered for
+func (s *Storage) SetRunCommit(runID int, commit CommitInfo) error {
+	_, err := s.db.Exec(
+		"UPDATE runs SET commit_sha = ?, branch = ?, tag = ?, commit_author = ?, commit_message = ? WHERE id = ?",
+		commit.CommitSHA, commit.Branch, commit.Tag, commit.CommitAuthor, commit.CommitMessage, runID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to set run commit: %w", err)
+	}
+	return nil
+}
+
+// SetRunCheckedOut records that a run built its commit in a worktree of it,
+// so a rerun checks the same commit out again
+func (s *Storage) SetRunCheckedOut(runID int) error {
+	_, err := s.db.Exec("UPDATE runs SET checked_out = 1 WHERE id = ?", runID)
+	if err != nil {
+		return fmt.Errorf("failed to set run checkout: %w", err)
+	}
+	return nil
+}
+
+// SetRunExecution attaches a run to the pipeline execution it belongs to
+func (s *Storage) SetRunExecution(runID, executionID int) error {
+	_, err := s.db.Exec("UPDATE runs SET execution_id = ? WHERE id = ?", executionID, runID)
+	if err != nil {
+		return fmt.Errorf("failed to set run execution: %w", err)
+	}
+	return nil
+}
+
+// GetExecutionRuns retrieves the part runs of an execution in the order they were created
+func (s *Storage) GetExecutionRuns(executionID int) ([]*Run, error) {
+	rows, err := s.db.Query(`SELECT `+runColumns+` FROM runs WHERE execution_id = ? ORDER BY id ASC`, executionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query execution runs: %w", err)
+	}
+	defer rows.Close()
+
+	runs := make([]*Run, 0)
+	for rows.Next() {
+		r, err := scanRun(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan run: %w", err)
+		}
+		runs = append(runs, r)
+	}
+
+	return runs, rows.Err()
+}
+
+// SetRunParent links a rerun or resumed run to the run it was started from
+func (s *Storage) SetRunParent(runID, parentRunID int) error {
+	_, err := s.db.Exec("UPDATE runs SET parent_run_id = ? WHERE id = ?", parentRunID, runID)
+	if err != nil {
+		return fmt.Errorf("failed to set run parent: %w", err)
+	}
+	return nil
+}
+
+// SetRunWorkspace records the isolated workspace a run's steps ran in
+func (s *Storage) SetRunWorkspace(runID int, workspacePath string) error {
+	_, err := s.db.Exec("UPDATE runs SET workspace_path = ? WHERE id = ?", workspacePath, runID)
+	if err != nil {
+		return fmt.Errorf("failed to set run workspace: %w", err)
+	}
+	return nil
+}
+
+// GetChildRuns retrieves the reruns and resumes started from a run, oldest first
+func (s *Storage) GetChildRuns(runID int) ([]*Run, error) {
+	rows, err := s.db.Query(`SELECT `+runColumns+` FROM runs WHERE parent_run_id = ? ORDER BY id ASC`, runID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query child runs: %w", err)
+	}
+	defer rows.Close()
+
+	runs := make([]*Run, 0)
+	for rows.Next() {
+		r, err := scanRun(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan run: %w", err)
+		}
+		runs = append(runs, r)
+	}
+
+	return runs, rows.Err()
+}
+
+// SetRunReason records why a run ended the way it did
+func (s *Storage) SetRunReason(runID int, reason string) error {
+	_, err := s.db.Exec("UPDATE runs SET reason = ? WHERE id = ?", reason, runID)
+	if err != nil {
+		return fmt.Errorf("failed to set run reason: %w", err)
+	}
+	return nil
+}
+
+// SetRunFailure records why a run failed or was cancelled
+func (s *Storage) SetRunFailure(runID int, failure Failure) error {
+	_, err := s.db.Exec(
+		"UPDATE runs SET failure_reason = ?, failure_exit_code = ?, failure_message = ?, failure_step = ?, failure_cause = ? WHERE id = ?",
+		failure.Reason, failure.ExitCode, failure.Message, failure.Step, failure.Cause, runID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to set run failure: %w", err)
+	}
+	return nil
+}
+
 // GetRuns retrieves all runs, ordered by most recent first
 func (s *Storage) GetRuns(limit int) ([]*Run, error) {
-	query := `SELECT id, status, config_path, project_name, "group", part, started_at, finished_at, duration FROM runs ORDER BY started_at DESC LIMIT ?`
+	query := `SELECT ` + runColumns + ` FROM runs ORDER BY started_at DESC LIMIT ?`
 	rows, err := s.db.Query(query, limit)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query runs: %w", err)
@@ -58,24 +313,12 @@ func (s *Storage) GetRuns(limit int) ([]*Run, error) {
 
 	var runs []*Run
 	for rows.Next() {
-		var r Run
-		var finishedAt sql.NullTime
-		var duration sql.NullString
-
-		err := rows.Scan(&r.ID, &r.Status, &r.ConfigPath, &r.ProjectName, &r.Group, &r.Part, &r.StartedAt, &finishedAt, &duration)
+		r, err := scanRun(rows)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan run: %w", err)
 		}
 
-		if finishedAt.Valid {
-			r.FinishedAt = &finishedAt.Time
-		}
-		if duration.Valid {
-			durationStr := duration.String
-			r.Duration = &durationStr
-		}
-
-		runs = append(runs, &r)
+		runs = append(runs, r)
 	}
 
 	return runs, rows.Err()
@@ -83,14 +326,7 @@ func (s *Storage) GetRuns(limit int) ([]*Run, error) {
 
 // GetRun retrieves a single run by ID
 func (s *Storage) GetRun(runID int) (*Run, error) {
-	var r Run
-	var finishedAt sql.NullTime
-	var duration sql.NullString
-
-	err := s.db.QueryRow(
-		`SELECT id, status, config_path, project_name, "group", part, started_at, finished_at, duration FROM runs WHERE id = ?`,
-		runID,
-	).Scan(&r.ID, &r.Status, &r.ConfigPath, &r.ProjectName, &r.Group, &r.Part, &r.StartedAt, &finishedAt, &duration)
+	r, err := scanRun(s.db.QueryRow(`SELECT `+runColumns+` FROM runs WHERE id = ?`, runID))
 
 	if err == sql.ErrNoRows {
 		return nil, fmt.Errorf("run not found")
@@ -99,14 +335,5 @@ func (s *Storage) GetRun(runID int) (*Run, error) {
 		return nil, fmt.Errorf("failed to get run: %w", err)
 	}
 
-	if finishedAt.Valid {
-		r.FinishedAt = &finishedAt.Time
-	}
-	if duration.Valid {
-		durationStr := duration.String
-		r.Duration = &durationStr
-	}
-
-	return &r, nil
+	return r, nil
 }
-
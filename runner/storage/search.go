@@ -0,0 +1,363 @@
+package storage
+
+import (
+	"bufio"
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"pipego/ansi"
+)
+
+// The name, command and output (without escape sequences) of each finished
+// step are indexed in step_index, a contentless FTS5 table: it holds only the
+// index, the output itself stays in the step logs. Snippets are taken from the
+// logs of the steps found. Such a table can only be queried with MATCH, so
+// step_executions.search_indexed records which steps are in it.
+
+// Markers around the matches in search snippets
+const (
+	SnippetMatchStart = "\x02"
+	SnippetMatchEnd   = "\x03"
+)
+
+// snippetContext is how many bytes of output snippets show around a match
+const snippetContext = 60
+
+// maxSnippetLine is the longest line of output searched for a snippet
+const maxSnippetLine = 1 << 20
+
+// SearchResult is a step whose output, name or command matched a search
+type SearchResult struct {
+	RunID       int       `json:"run_id"`
+	StepID      int       `json:"step_id"`
+	ProjectName string    `json:"project_name"`
+	Group       string    `json:"group"`
+	Part        string    `json:"part"`
+	RunStatus   string    `json:"run_status"`
+	StartedAt   time.Time `json:"started_at"`
+	Step        string    `json:"step"`
+	StepStatus  string    `json:"step_status"`
+	Snippet     string    `json:"snippet"` // Matches are between SnippetMatchStart and SnippetMatchEnd
+}
+
+// Number of results a search returns by default and at most. Each one reads
+// the log of its step for the snippet.
+const (
+	DefaultSearchLimit = 50
+	MaxSearchLimit     = 200
+)
+
+// SearchOptions narrows a search
+type SearchOptions struct {
+	Project string    // Only runs of this project
+	Since   time.Time // Only runs started at or after this time
+	Limit   int       // At most MaxSearchLimit, DefaultSearchLimit if not set
+}
+
+// initSearch creates the full-text index. go-sqlite3 only includes FTS5 when
+// built with the sqlite_fts5 tag, which the Makefile sets. An FTS4 index made
+// by earlier versions is replaced, and its steps are indexed again by
+// IndexMissingStepOutputs.
+func (s *Storage) initSearch() error {
+	var definition string
+	err := s.db.QueryRow(`SELECT sql FROM sqlite_master WHERE type = 'table' AND name = 'step_index'`).Scan(&definition)
+	if err == nil && !strings.Contains(strings.ToLower(definition), "fts5") {
+		if _, err := s.db.Exec(`DROP TABLE step_index`); err != nil {
+			return fmt.Errorf("failed to replace search index: %w", err)
+		}
+		if _, err := s.db.Exec(`UPDATE step_executions SET search_indexed = 0`); err != nil {
+			return fmt.Errorf("failed to replace search index: %w", err)
+		}
+	} else if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("failed to read search index: %w", err)
+	}
+
+	_, err = s.db.Exec(`CREATE VIRTUAL TABLE IF NOT EXISTS step_index USING fts5(
+		name, command, output, content=''
+	)`)
+	if err != nil && strings.Contains(err.Error(), "no such module") {
+		return fmt.Errorf("failed to create search index: %w (build with -tags sqlite_fts5, see the Makefile)", err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create search index: %w", err)
+	}
+	return nil
+}
+
+// IndexStepOutput makes the name, command and output of a finished step
+// searchable. Steps are indexed once; indexing a step again does nothing.
+func (s *Storage) IndexStepOutput(stepID int) error {
+	var name, command string
+	var output, logPath sql.NullString
+	var indexed bool
+	err := s.db.QueryRow("SELECT name, command, output, log_path, search_indexed FROM step_executions WHERE id = ?", stepID).
+		Scan(&name, &command, &output, &logPath, &indexed)
+	if err != nil {
+		return fmt.Errorf("failed to get step execution: %w", err)
+	}
+	if indexed {
+		return nil
+	}
+
+	var text strings.Builder
+	if err := s.convertStepOutput(&text, output.String, logPath.String); err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to index step output: %w", err)
+	}
+	defer tx.Rollback()
+
+	// The background indexer and IndexMissingStepOutputs may both get here
+	result, err := tx.Exec("UPDATE step_executions SET search_indexed = 1 WHERE id = ? AND search_indexed = 0", stepID)
+	if err != nil {
+		return fmt.Errorf("failed to index step output: %w", err)
+	}
+	if updated, err := result.RowsAffected(); err != nil || updated == 0 {
+		return err
+	}
+	// A contentless index keeps only the terms, not the text given here
+	_, err = tx.Exec("INSERT INTO step_index (rowid, name, command, output) VALUES (?, ?, ?, ?)", stepID, name, command, text.String())
+	if err != nil {
+		return fmt.Errorf("failed to index step output: %w", err)
+	}
+	return tx.Commit()
+}
+
+// QueueStepIndex indexes a finished step in the background, so reading and
+// converting its log doesn't hold up the pipeline. Steps are indexed one at a
+// time in the order they finished. Those still waiting when the storage is
+// closed are indexed by IndexMissingStepOutputs.
+func (s *Storage) QueueStepIndex(stepID int) {
+	s.indexMu.Lock()
+	defer s.indexMu.Unlock()
+	if s.indexClosed {
+		return
+	}
+	s.indexQueue = append(s.indexQueue, stepID)
+	if len(s.indexQueue) == 1 && !s.indexing {
+		s.indexing = true
+		s.indexWG.Add(1)
+		go s.indexQueued()
+	}
+}
+
+// indexQueued indexes the steps of QueueStepIndex until none are left
+func (s *Storage) indexQueued() {
+	defer s.indexWG.Done()
+	for {
+		s.indexMu.Lock()
+		if len(s.indexQueue) == 0 || s.indexClosed {
+			s.indexing = false
+			s.indexMu.Unlock()
+			return
+		}
+		stepID := s.indexQueue[0]
+		s.indexQueue = s.indexQueue[1:]
+		s.indexMu.Unlock()
+
+		if err := s.IndexStepOutput(stepID); err != nil {
+			log.Printf("⚠️  Failed to index output of step %d: %v", stepID, err)
+		}
+	}
+}
+
+// stopIndexing drops the steps waiting to be indexed and waits for the one
+// being indexed
+func (s *Storage) stopIndexing() {
+	s.indexMu.Lock()
+	s.indexClosed = true
+	s.indexQueue = nil
+	s.indexMu.Unlock()
+	s.indexWG.Wait()
+}
+
+// convertStepOutput writes the output of a step as plain text, from its log
+// if it has one
+func (s *Storage) convertStepOutput(w io.Writer, output, logPath string) error {
+	if logPath == "" {
+		return ansi.Convert(w, strings.NewReader(output), ansi.FormatText)
+	}
+	r, err := s.OpenStepLog(logPath)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if err := ansi.Convert(w, r, ansi.FormatText); err != nil {
+		return fmt.Errorf("failed to read step log: %w", err)
+	}
+	return nil
+}
+
+// IndexMissingStepOutputs indexes finished steps stored before search existed.
+// It returns how many were indexed.
+func (s *Storage) IndexMissingStepOutputs() (int, error) {
+	rows, err := s.db.Query(`SELECT id FROM step_executions
+		WHERE finished_at IS NOT NULL AND search_indexed = 0 ORDER BY id`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query unindexed steps: %w", err)
+	}
+	var stepIDs []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan step ID: %w", err)
+		}
+		stepIDs = append(stepIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	indexed := 0
+	for _, id := range stepIDs {
+		// Steps whose logs are gone are left out
+		if err := s.IndexStepOutput(id); err == nil {
+			indexed++
+		}
+	}
+	return indexed, nil
+}
+
+// SearchStepOutputs finds steps whose output, name or command contain query
+// as a phrase, newest runs first
+func (s *Storage) SearchStepOutputs(query string, opts SearchOptions) ([]*SearchResult, error) {
+	if opts.Limit <= 0 {
+		opts.Limit = DefaultSearchLimit
+	}
+	opts.Limit = min(opts.Limit, MaxSearchLimit)
+
+	// The index splits text into words at punctuation, so dropping ASCII
+	// punctuation from the query matches the same steps and keeps it from being
+	// FTS syntax
+	words := strings.Fields(strings.Map(func(r rune) rune {
+		if r < utf8.RuneSelf && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			return ' '
+		}
+		return r
+	}, query))
+	results := make([]*SearchResult, 0)
+	if len(words) == 0 {
+		return results, nil
+	}
+
+	rows, err := s.db.Query(
+		`SELECT r.id, st.id, r.project_name, r."group", r.part, r.status, r.started_at, st.name, st.status,
+			st.command, st.output, st.log_path
+		FROM step_index
+		JOIN step_executions st ON st.id = step_index.rowid
+		JOIN runs r ON r.id = st.run_id
+		WHERE step_index MATCH ? AND (? = '' OR r.project_name = ?) AND r.started_at >= ?
+		ORDER BY r.started_at DESC, st.id ASC LIMIT ?`,
+		`"`+strings.Join(words, " ")+`"`, opts.Project, opts.Project, opts.Since, opts.Limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search step outputs: %w", err)
+	}
+
+	var outputs []stepOutput
+	for rows.Next() {
+		var result SearchResult
+		var out stepOutput
+		var output, logPath sql.NullString
+		err := rows.Scan(&result.RunID, &result.StepID, &result.ProjectName, &result.Group, &result.Part, &result.RunStatus,
+			&result.StartedAt, &result.Step, &result.StepStatus, &out.command, &output, &logPath)
+		if err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan search result: %w", err)
+		}
+		out.output, out.logPath = output.String, logPath.String
+		results = append(results, &result)
+		outputs = append(outputs, out)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Logs are read once the query is done, so they don't hold up the database
+	for i, result := range results {
+		result.Snippet = s.snippet(result.Step, outputs[i], query)
+	}
+	return results, nil
+}
+
+// stepOutput is where the output of a step found by a search is
+type stepOutput struct {
+	command string
+	output  string // Inline output of steps stored before logs existed
+	logPath string
+}
+
+// snippet returns the output around the first match of query, or the name and
+// command of the step when only they match or the match spans lines
+func (s *Storage) snippet(name string, out stepOutput, query string) string {
+	query = strings.TrimSpace(query)
+	fallback := markMatches(name+": "+out.command, query)
+	needle := strings.ToLower(query)
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(s.convertStepOutput(pw, out.output, out.logPath))
+	}()
+	defer pr.Close()
+
+	scanner := bufio.NewScanner(pr)
+	scanner.Buffer(nil, maxSnippetLine)
+	for scanner.Scan() {
+		line := scanner.Text()
+		i := strings.Index(strings.ToLower(line), needle)
+		if i < 0 {
+			continue
+		}
+		start, end := max(i-snippetContext, 0), min(i+len(needle)+snippetContext, len(line))
+		// Cut at rune boundaries
+		for start > 0 && !utf8.RuneStart(line[start]) {
+			start--
+		}
+		for end < len(line) && !utf8.RuneStart(line[end]) {
+			end++
+		}
+		text := line[start:end]
+		if start > 0 {
+			text = "…" + text
+		}
+		if end < len(line) {
+			text += "…"
+		}
+		return markMatches(text, query)
+	}
+	return fallback
+}
+
+// markMatches puts the snippet markers around each case-insensitive match of query
+func markMatches(snippet, query string) string {
+	if query == "" {
+		return snippet
+	}
+	lower := strings.ToLower(snippet)
+	needle := strings.ToLower(query)
+
+	var marked strings.Builder
+	for {
+		i := strings.Index(lower, needle)
+		if i < 0 || len(lower) != len(snippet) {
+			marked.WriteString(snippet)
+			return marked.String()
+		}
+		marked.WriteString(snippet[:i])
+		marked.WriteString(SnippetMatchStart + snippet[i:i+len(needle)] + SnippetMatchEnd)
+		snippet = snippet[i+len(needle):]
+		lower = lower[i+len(needle):]
+	}
+}
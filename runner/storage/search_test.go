@@ -0,0 +1,209 @@
+package storage
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// addSearchStep creates a finished run of project with one step writing output to its log
+func addSearchStep(t *testing.T, s *Storage, project, name, command, output string) int {
+	t.Helper()
+	run := addTestRun(t, s, project, "build", "success")
+	exec, err := s.CreateStepExecution(run.ID, name, command, "", "build", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	log, err := s.CreateStepLog(run.ID, exec.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines []OutputLine
+	for _, line := range strings.Split(output, "\n") {
+		lines = append(lines, OutputLine{Text: line})
+	}
+	if err := log.Append(lines); err != nil {
+		t.Fatal(err)
+	}
+	log.Close()
+	if err := s.UpdateStepExecution(exec.ID, "success", "", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	return exec.ID
+}
+
+func TestSearchStepOutputs(t *testing.T) {
+	s := newTestStorage(t)
+
+	refused := addSearchStep(t, s, "app", "test", "go test ./...",
+		"ok pipego/api\n\x1b[31mdial tcp: Connection refused\x1b[0m while fetching\nFAIL")
+	other := addSearchStep(t, s, "lib", "test", "make check", "connection refused")
+	named := addSearchStep(t, s, "app", "deploy", "./deploy.sh --target staging", "done")
+	long := addSearchStep(t, s, "app", "lint", "golangci-lint run", strings.Repeat("x ", 100)+"timeout exceeded"+strings.Repeat(" y", 100))
+
+	// Steps the executor queued for indexing are indexed in the background;
+	// those missed are indexed when the server starts or the CLI searches
+	indexed, err := s.IndexMissingStepOutputs()
+	if err != nil || indexed != 4 {
+		t.Fatalf("IndexMissingStepOutputs = %d, %v, want 4", indexed, err)
+	}
+	if err := s.IndexStepOutput(refused); err != nil {
+		t.Errorf("indexing a step twice: %v", err)
+	}
+	if indexed, _ := s.IndexMissingStepOutputs(); indexed != 0 {
+		t.Errorf("%d steps indexed again", indexed)
+	}
+
+	tests := []struct {
+		name    string
+		query   string
+		opts    SearchOptions
+		want    []int
+		snippet string
+	}{
+		{"phrase in a log", "connection refused", SearchOptions{Project: "app"}, []int{refused},
+			"dial tcp: \x02Connection refused\x03 while fetching"},
+		{"all projects", "Connection Refused", SearchOptions{}, []int{other, refused}, ""},
+		{"punctuation is not query syntax", `"tcp: connection"*`, SearchOptions{Project: "app"}, []int{refused}, ""},
+		{"words out of order", "refused connection", SearchOptions{}, nil, ""},
+		{"command", "staging", SearchOptions{}, []int{named},
+			"deploy: ./deploy.sh --target \x02staging\x03"},
+		{"long line", "timeout exceeded", SearchOptions{}, []int{long},
+			"…" + strings.Repeat("x ", 30) + "\x02timeout exceeded\x03" + strings.Repeat(" y", 30) + "…"},
+		{"since", "refused", SearchOptions{Since: time.Now().Add(time.Hour)}, nil, ""},
+		{"limit", "refused", SearchOptions{Limit: 1}, []int{other}, ""},
+		{"only punctuation", "--", SearchOptions{}, nil, ""},
+	}
+	for _, tt := range tests {
+		results, err := s.SearchStepOutputs(tt.query, tt.opts)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		var got []int
+		for _, result := range results {
+			got = append(got, result.StepID)
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: found steps %v, want %v", tt.name, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: found steps %v, want %v", tt.name, got, tt.want)
+				break
+			}
+		}
+		if tt.snippet != "" && results[0].Snippet != tt.snippet {
+			t.Errorf("%s: snippet = %q, want %q", tt.name, results[0].Snippet, tt.snippet)
+		}
+	}
+}
+
+func TestSearchIndexHoldsNoOutput(t *testing.T) {
+	s := newTestStorage(t)
+	addSearchStep(t, s, "app", "test", "true", "a rather distinctive line of output")
+	if _, err := s.IndexMissingStepOutputs(); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := s.SearchStepOutputs("distinctive", SearchOptions{})
+	if err != nil || len(results) != 1 {
+		t.Fatalf("search found %d steps, %v", len(results), err)
+	}
+
+	// The output is only in the log, the index holds its words but not the text
+	db, err := os.ReadFile(filepath.Join(s.DataDir(), "pipego.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(db, []byte("rather distinctive line")) {
+		t.Error("the database holds a copy of the step output")
+	}
+}
+
+func TestSearchReplacesFTS4Index(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "pipego.db")
+	s, err := NewStorage(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addSearchStep(t, s, "app", "test", "true", "indexed by an earlier version")
+	if _, err := s.IndexMissingStepOutputs(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The FTS4 index of earlier versions
+	for _, query := range []string{
+		`DROP TABLE step_index`,
+		`CREATE VIRTUAL TABLE step_index USING fts4(name, command, output, content="")`,
+	} {
+		if _, err := s.db.Exec(query); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.Close()
+
+	s, err = NewStorage(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if indexed, err := s.IndexMissingStepOutputs(); err != nil || indexed != 1 {
+		t.Fatalf("indexed %d steps again, %v, want 1", indexed, err)
+	}
+	if results, err := s.SearchStepOutputs("earlier version", SearchOptions{}); err != nil || len(results) != 1 {
+		t.Errorf("search after replacing the index found %d steps, %v", len(results), err)
+	}
+}
+
+func TestQueueStepIndex(t *testing.T) {
+	s := newTestStorage(t)
+	first := addSearchStep(t, s, "app", "test", "true", "indexed in the background")
+	second := addSearchStep(t, s, "app", "lint", "true", "also in the background")
+	s.QueueStepIndex(first)
+	s.QueueStepIndex(second)
+	s.QueueStepIndex(first)
+
+	var results []*SearchResult
+	for deadline := time.Now().Add(5 * time.Second); len(results) < 2 && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		var err error
+		if results, err = s.SearchStepOutputs("background", SearchOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(results) != 2 {
+		t.Fatalf("search found %d steps, want both queued steps", len(results))
+	}
+
+	// Steps queued once the storage is closed are left for IndexMissingStepOutputs
+	s.Close()
+	s.QueueStepIndex(first)
+}
+
+func TestSearchLimit(t *testing.T) {
+	s := newTestStorage(t)
+	run := addTestRun(t, s, "app", "build", "success")
+	for range MaxSearchLimit + 5 {
+		exec, err := s.CreateStepExecution(run.ID, "test", "true", "", "build", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.UpdateStepExecution(exec.ID, "success", "flaky\n", time.Second); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.IndexMissingStepOutputs(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct{ limit, want int }{{0, DefaultSearchLimit}, {10, 10}, {MaxSearchLimit + 1000, MaxSearchLimit}} {
+		results, err := s.SearchStepOutputs("flaky", SearchOptions{Limit: tt.limit})
+		if err != nil || len(results) != tt.want {
+			t.Errorf("limit %d: found %d steps, %v, want %d", tt.limit, len(results), err, tt.want)
+		}
+	}
+}
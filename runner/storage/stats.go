@@ -3,19 +3,46 @@ package storage
 import (
 	"database/sql"
 	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
 )
 
 // PartRunStats represents the latest runs grouped by part
 type PartRunStats struct {
-	Group        string  `json:"group"`        // Group name (e.g., "frontend", "backend") or empty
-	Part         string  `json:"part"`         // Part name or full path (e.g., "deploy" or "frontend.deploy")
-	RunID        int     `json:"run_id"`
-	Status       string  `json:"status"`
-	Duration     *string `json:"duration,omitempty"`
-	StartedAt    string  `json:"started_at"`
-	StepCount    int     `json:"step_count"`
+	Group     string         `json:"group"` // Group name (e.g., "frontend", "backend") or empty
+	Part      string         `json:"part"`  // Part name or full path (e.g., "deploy" or "frontend.deploy")
+	RunID     int            `json:"run_id"`
+	Status    string         `json:"status"`
+	Duration  *string        `json:"duration,omitempty"`
+	StartedAt string         `json:"started_at"`
+	StepCount int            `json:"step_count"`
+	CPUMs     *int64         `json:"cpu_ms,omitempty"`     // User and system CPU time of all steps
+	MaxRSSKB  *int64         `json:"max_rss_kb,omitempty"` // Largest peak RSS of any step
+	Steps     []StepRunStats `json:"steps,omitempty"`
+}
+
+// StepRunStats represents the outcome and resource usage of one step of a run
+type StepRunStats struct {
+	Name     string  `json:"name"`
+	Status   string  `json:"status"`
+	Duration *string `json:"duration,omitempty"`
+	Cause    string  `json:"cause,omitempty"` // Why the step failed, as named by the failure cause rules
+	StepUsage
 }
 
+// FailureCauseStats counts the failed steps of a project with the same cause
+type FailureCauseStats struct {
+	Cause     string    `json:"cause"` // "unclassified" when no rule matched
+	Count     int       `json:"count"`
+	LastRunID int       `json:"last_run_id"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// UnclassifiedCause groups failed steps that no failure cause rule matched
+const UnclassifiedCause = "unclassified"
+
 // GetLatestRunsByPart returns the latest runs for each part of a project
 func (s *Storage) GetLatestRunsByPart(projectName string, limit int) ([]PartRunStats, error) {
 	// Simple query without window functions for better SQLite compatibility
@@ -27,7 +54,9 @@ func (s *Storage) GetLatestRunsByPart(projectName string, limit int) ([]PartRunS
 			r.status,
 			r.duration,
 			r.started_at,
-			COUNT(se.id) as step_count
+			COUNT(se.id) as step_count,
+			SUM(se.user_cpu_ms + se.system_cpu_ms) as cpu_ms,
+			MAX(se.max_rss_kb) as max_rss_kb
 		FROM runs r
 		LEFT JOIN step_executions se ON r.id = se.run_id
 		WHERE r.project_name = ?
@@ -48,6 +77,7 @@ func (s *Storage) GetLatestRunsByPart(projectName string, limit int) ([]PartRunS
 	for rows.Next() {
 		var stat PartRunStats
 		var duration sql.NullString
+		var cpuMs, maxRSSKB sql.NullInt64
 
 		err := rows.Scan(
 			&stat.Group,
@@ -57,6 +87,8 @@ func (s *Storage) GetLatestRunsByPart(projectName string, limit int) ([]PartRunS
 			&duration,
 			&stat.StartedAt,
 			&stat.StepCount,
+			&cpuMs,
+			&maxRSSKB,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan run stats: %w", err)
@@ -73,7 +105,118 @@ func (s *Storage) GetLatestRunsByPart(projectName string, limit int) ([]PartRunS
 			durationStr := duration.String
 			stat.Duration = &durationStr
 		}
+		if cpuMs.Valid {
+			stat.CPUMs = &cpuMs.Int64
+		}
+		if maxRSSKB.Valid {
+			stat.MaxRSSKB = &maxRSSKB.Int64
+		}
+
+		stats = append(stats, stat)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	// Per-step usage, so regressions can be traced to a single step
+	runIDs := make([]int, len(stats))
+	for i := range stats {
+		runIDs[i] = stats[i].RunID
+	}
+	steps, err := s.getStepRunStats(runIDs)
+	if err != nil {
+		return nil, err
+	}
+	for i := range stats {
+		stats[i].Steps = steps[stats[i].RunID]
+	}
+
+	return stats, nil
+}
+
+// getStepRunStats returns the steps of the runs by run ID in one query, reading
+// only their outcome and usage columns, not their output or log files
+func (s *Storage) getStepRunStats(runIDs []int) (map[int][]StepRunStats, error) {
+	steps := make(map[int][]StepRunStats)
+	if len(runIDs) == 0 {
+		return steps, nil
+	}
+
+	args := make([]interface{}, len(runIDs))
+	for i, runID := range runIDs {
+		args[i] = runID
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(runIDs)), ", ")
+
+	rows, err := s.db.Query(
+		`SELECT run_id, name, status, duration, exit_code, signal, user_cpu_ms, system_cpu_ms, max_rss_kb, failure_cause
+		FROM step_executions WHERE run_id IN (`+placeholders+`) ORDER BY run_id, id`,
+		args...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query step stats: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var runID int
+		var step StepRunStats
+		var duration, failureCause sql.NullString
+		var usage usageColumns
+
+		err := rows.Scan(&runID, &step.Name, &step.Status, &duration,
+			&usage.exitCode, &usage.signal, &usage.userCPUMs, &usage.systemCPUMs, &usage.maxRSSKB, &failureCause)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan step stats: %w", err)
+		}
+		if duration.Valid {
+			durationStr := duration.String
+			step.Duration = &durationStr
+		}
+		step.StepUsage = usage.toStepUsage()
+		step.Cause = failureCause.String
 
+		steps[runID] = append(steps[runID], step)
+	}
+
+	return steps, rows.Err()
+}
+
+// GetFailureCauseStats counts the failed steps of a project since a time by cause,
+// the most frequent cause first. Cancelled steps are not failures and are left out.
+func (s *Storage) GetFailureCauseStats(projectName string, since time.Time) ([]FailureCauseStats, error) {
+	rows, err := s.db.Query(`
+		SELECT
+			COALESCE(NULLIF(se.failure_cause, ''), ?) as cause,
+			COUNT(*) as count,
+			MAX(r.id) as last_run_id,
+			MAX(se.started_at) as last_seen
+		FROM step_executions se
+		JOIN runs r ON r.id = se.run_id
+		WHERE r.project_name = ? AND se.status IN ('failed', 'stalled') AND se.started_at >= ?
+		GROUP BY cause
+		ORDER BY count DESC, last_seen DESC
+	`, UnclassifiedCause, projectName, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query failure causes: %w", err)
+	}
+	defer rows.Close()
+
+	stats := make([]FailureCauseStats, 0)
+	for rows.Next() {
+		var stat FailureCauseStats
+		var lastSeen string
+		if err := rows.Scan(&stat.Cause, &stat.Count, &stat.LastRunID, &lastSeen); err != nil {
+			return nil, fmt.Errorf("failed to scan failure cause stats: %w", err)
+		}
+		// Aggregates lose the column type, so the time comes back as text
+		for _, format := range sqlite3.SQLiteTimestampFormats {
+			if t, err := time.Parse(format, lastSeen); err == nil {
+				stat.LastSeen = t
+				break
+			}
+		}
 		stats = append(stats, stat)
 	}
 
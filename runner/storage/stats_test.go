@@ -0,0 +1,161 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestStorage opens a storage in a temporary data directory
+func newTestStorage(t *testing.T) *Storage {
+	t.Helper()
+	s, err := NewStorage(filepath.Join(t.TempDir(), "pipego.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// testStep is a finished step created by addTestRun
+type testStep struct {
+	name   string
+	status string
+	usage  StepUsage
+	cause  string
+}
+
+func int64Ptr(v int64) *int64 { return &v }
+func intPtr(v int) *int       { return &v }
+
+// addTestRun creates a finished run of a part with the given steps, each with a log
+func addTestRun(t *testing.T, s *Storage, project, part, status string, steps ...testStep) *Run {
+	t.Helper()
+	run, err := s.CreateRun(filepath.Join(project, "pipego.yml"), project, "", part)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, step := range steps {
+		exec, err := s.CreateStepExecution(run.ID, step.name, "true", "", part, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		log, err := s.CreateStepLog(run.ID, exec.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := log.Append([]OutputLine{{Text: "output of " + step.name}}); err != nil {
+			t.Fatal(err)
+		}
+		log.Close()
+		if err := s.UpdateStepExecution(exec.ID, step.status, "", time.Second); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.SetStepUsage(exec.ID, step.usage); err != nil {
+			t.Fatal(err)
+		}
+		if step.status == "failed" {
+			if err := s.SetStepFailure(exec.ID, Failure{Reason: FailureCommandFailed, Cause: step.cause}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := s.UpdateRunStatus(run.ID, status, 2*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	return run
+}
+
+func TestGetLatestRunsByPart(t *testing.T) {
+	s := newTestStorage(t)
+
+	usage := func(user, system, rss int64, exitCode int) StepUsage {
+		return StepUsage{ExitCode: intPtr(exitCode), UserCPUMs: int64Ptr(user), SystemCPUMs: int64Ptr(system), MaxRSSKB: int64Ptr(rss)}
+	}
+
+	addTestRun(t, s, "app", "build", "success", testStep{"compile", "success", usage(10, 1, 100, 0), ""})
+	latest := addTestRun(t, s, "app", "build", "failed",
+		testStep{"compile", "success", usage(20, 2, 300, 0), ""},
+		testStep{"test", "failed", usage(5, 5, 200, 1), "flaky_test"},
+	)
+	deploy := addTestRun(t, s, "app", "deploy", "success", testStep{"push", "success", StepUsage{ExitCode: intPtr(0)}, ""})
+	addTestRun(t, s, "other", "build", "success", testStep{"compile", "success", usage(1, 1, 1, 0), ""})
+
+	// Stats come from the database alone, the logs are never read
+	if err := os.RemoveAll(filepath.Join(s.DataDir(), "logs")); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := s.GetLatestRunsByPart("app", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 2 {
+		t.Fatalf("got %d runs, want the latest of each of 2 parts: %+v", len(stats), stats)
+	}
+
+	build, deployStats := stats[0], stats[1]
+	if build.Part != "build" || build.RunID != latest.ID || build.Status != "failed" || build.StepCount != 2 {
+		t.Errorf("build stats = %+v", build)
+	}
+	if build.CPUMs == nil || *build.CPUMs != 32 || build.MaxRSSKB == nil || *build.MaxRSSKB != 300 {
+		t.Errorf("build usage: cpu %v, rss %v", build.CPUMs, build.MaxRSSKB)
+	}
+	if len(build.Steps) != 2 {
+		t.Fatalf("build steps = %+v", build.Steps)
+	}
+	test := build.Steps[1]
+	if test.Name != "test" || test.Status != "failed" || test.Cause != "flaky_test" || test.Duration == nil ||
+		test.ExitCode == nil || *test.ExitCode != 1 || *test.UserCPUMs != 5 || *test.SystemCPUMs != 5 || *test.MaxRSSKB != 200 {
+		t.Errorf("test step stats = %+v", test)
+	}
+	if build.Steps[0].Name != "compile" || build.Steps[0].Cause != "" {
+		t.Errorf("compile step stats = %+v", build.Steps[0])
+	}
+
+	if deployStats.Part != "deploy" || deployStats.RunID != deploy.ID || deployStats.CPUMs != nil || len(deployStats.Steps) != 1 {
+		t.Errorf("deploy stats = %+v", deployStats)
+	}
+
+	stats, err = s.GetLatestRunsByPart("app", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 3 || len(stats[1].Steps) != 1 {
+		t.Errorf("with a limit of 5: %+v", stats)
+	}
+
+	stats, err = s.GetLatestRunsByPart("missing", 5)
+	if err != nil || len(stats) != 0 {
+		t.Errorf("unknown project: %+v, %v", stats, err)
+	}
+}
+
+func TestGetFailureCauseStats(t *testing.T) {
+	s := newTestStorage(t)
+
+	addTestRun(t, s, "app", "build", "failed", testStep{"test", "failed", StepUsage{}, "flaky_test"})
+	addTestRun(t, s, "app", "build", "failed", testStep{"test", "failed", StepUsage{}, ""})
+	last := addTestRun(t, s, "app", "build", "failed", testStep{"test", "failed", StepUsage{}, "flaky_test"})
+	addTestRun(t, s, "app", "build", "success", testStep{"test", "success", StepUsage{}, ""})
+
+	stats, err := s.GetFailureCauseStats("app", time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 2 {
+		t.Fatalf("got %+v", stats)
+	}
+	if stats[0].Cause != "flaky_test" || stats[0].Count != 2 || stats[0].LastRunID != last.ID || stats[0].LastSeen.IsZero() {
+		t.Errorf("most frequent cause = %+v", stats[0])
+	}
+	if stats[1].Cause != UnclassifiedCause || stats[1].Count != 1 {
+		t.Errorf("unclassified = %+v", stats[1])
+	}
+
+	stats, err = s.GetFailureCauseStats("app", time.Now().Add(time.Hour))
+	if err != nil || len(stats) != 0 {
+		t.Errorf("failures since the future: %+v, %v", stats, err)
+	}
+}
@@ -49,12 +49,124 @@ func (s *Storage) UpdateStepExecution(stepID int, status, output string, duratio
 	return nil
 }
 
-// GetStepExecutions retrieves all step executions for a run
-func (s *Storage) GetStepExecutions(runID int) ([]*StepExecution, error) {
-	rows, err := s.db.Query(
-		`SELECT id, run_id, name, status, command, output, "group", part, category, started_at, finished_at, duration FROM step_executions WHERE run_id = ? ORDER BY id ASC`,
-		runID,
+// TouchStepExecution records the last time a running step wrote output
+func (s *Storage) TouchStepExecution(stepID int, at time.Time) error {
+	_, err := s.db.Exec("UPDATE step_executions SET last_activity_at = ? WHERE id = ?", at, stepID)
+	if err != nil {
+		return fmt.Errorf("failed to touch step execution: %w", err)
+	}
+	return nil
+}
+
+// SetStepUsage records how the process of a step exited and the resources it used
+func (s *Storage) SetStepUsage(stepID int, usage StepUsage) error {
+	var signal interface{}
+	if usage.Signal != "" {
+		signal = usage.Signal
+	}
+
+	_, err := s.db.Exec(
+		"UPDATE step_executions SET exit_code = ?, signal = ?, user_cpu_ms = ?, system_cpu_ms = ?, max_rss_kb = ? WHERE id = ?",
+		usage.ExitCode, signal, usage.UserCPUMs, usage.SystemCPUMs, usage.MaxRSSKB, stepID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to set step usage: %w", err)
+	}
+	return nil
+}
+
+// SetStepFailure records why a step failed. Its exit code is part of the step usage.
+func (s *Storage) SetStepFailure(stepID int, failure Failure) error {
+	_, err := s.db.Exec(
+		"UPDATE step_executions SET failure_reason = ?, failure_message = ?, failure_cause = ? WHERE id = ?",
+		failure.Reason, failure.Message, failure.Cause, stepID,
 	)
+	if err != nil {
+		return fmt.Errorf("failed to set step failure: %w", err)
+	}
+	return nil
+}
+
+// usageColumns scans the nullable usage columns of step_executions
+type usageColumns struct {
+	exitCode    sql.NullInt64
+	signal      sql.NullString
+	userCPUMs   sql.NullInt64
+	systemCPUMs sql.NullInt64
+	maxRSSKB    sql.NullInt64
+}
+
+func (c usageColumns) toStepUsage() StepUsage {
+	var usage StepUsage
+	if c.exitCode.Valid {
+		exitCode := int(c.exitCode.Int64)
+		usage.ExitCode = &exitCode
+	}
+	usage.Signal = c.signal.String
+	if c.userCPUMs.Valid {
+		usage.UserCPUMs = &c.userCPUMs.Int64
+	}
+	if c.systemCPUMs.Valid {
+		usage.SystemCPUMs = &c.systemCPUMs.Int64
+	}
+	if c.maxRSSKB.Valid {
+		usage.MaxRSSKB = &c.maxRSSKB.Int64
+	}
+	return usage
+}
+
+// stepColumns are the columns scanStep reads, in order
+const stepColumns = `id, run_id, name, status, command, output, "group", part, category, started_at, finished_at, duration, last_activity_at,
+	exit_code, signal, user_cpu_ms, system_cpu_ms, max_rss_kb, failure_reason, failure_message, failure_cause,
+	log_path, log_size, log_truncated`
+
+// scanStep reads a step execution selected with stepColumns. The output of
+// steps with a log file is left empty, see LoadStepOutputs.
+func scanStep(row rowScanner) (*StepExecution, error) {
+	var step StepExecution
+	var output sql.NullString
+	var finishedAt sql.NullTime
+	var duration sql.NullString
+	var lastActivityAt sql.NullTime
+	var usage usageColumns
+	var failureReason, failureMessage, failureCause sql.NullString
+	var logPath sql.NullString
+	var logSize, logTruncated sql.NullInt64
+
+	err := row.Scan(&step.ID, &step.RunID, &step.Name, &step.Status, &step.Command, &output, &step.Group, &step.Part, &step.Category, &step.StartedAt, &finishedAt, &duration, &lastActivityAt,
+		&usage.exitCode, &usage.signal, &usage.userCPUMs, &usage.systemCPUMs, &usage.maxRSSKB, &failureReason, &failureMessage, &failureCause,
+		&logPath, &logSize, &logTruncated)
+	if err != nil {
+		return nil, err
+	}
+	step.StepUsage = usage.toStepUsage()
+	if failureReason.String != "" {
+		step.Failure = &Failure{Reason: failureReason.String, ExitCode: step.ExitCode, Message: failureMessage.String, Cause: failureCause.String}
+	}
+	step.LogPath = logPath.String
+	step.LogSize = logSize.Int64
+	step.LogTruncated = logTruncated.Int64
+
+	if output.Valid {
+		step.Output = output.String
+	}
+	if finishedAt.Valid {
+		step.FinishedAt = &finishedAt.Time
+	}
+	if duration.Valid {
+		durationStr := duration.String
+		step.Duration = &durationStr
+	}
+	if lastActivityAt.Valid {
+		step.LastActivityAt = &lastActivityAt.Time
+	}
+	return &step, nil
+}
+
+// GetStepExecutions retrieves all step executions for a run, without the
+// output of those with a log file; callers returning it use LoadStepOutputs
+func (s *Storage) GetStepExecutions(runID int) ([]*StepExecution, error) {
+	rows, err := s.db.Query(`SELECT `+stepColumns+` FROM step_executions WHERE run_id = ? ORDER BY id ASC`, runID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query step executions: %w", err)
 	}
@@ -62,30 +174,38 @@ func (s *Storage) GetStepExecutions(runID int) ([]*StepExecution, error) {
 
 	var steps []*StepExecution
 	for rows.Next() {
-		var step StepExecution
-		var output sql.NullString
-		var finishedAt sql.NullTime
-		var duration sql.NullString
-
-		err := rows.Scan(&step.ID, &step.RunID, &step.Name, &step.Status, &step.Command, &output, &step.Group, &step.Part, &step.Category, &step.StartedAt, &finishedAt, &duration)
+		step, err := scanStep(rows)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan step execution: %w", err)
 		}
+		steps = append(steps, step)
+	}
 
-		if output.Valid {
-			step.Output = output.String
-		}
-		if finishedAt.Valid {
-			step.FinishedAt = &finishedAt.Time
-		}
-		if duration.Valid {
-			durationStr := duration.String
-			step.Duration = &durationStr
-		}
+	return steps, rows.Err()
+}
 
-		steps = append(steps, &step)
+// GetStepExecution retrieves a step execution of a run, without its output
+func (s *Storage) GetStepExecution(runID, stepID int) (*StepExecution, error) {
+	step, err := scanStep(s.db.QueryRow(`SELECT `+stepColumns+` FROM step_executions WHERE run_id = ? AND id = ?`, runID, stepID))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("step not found")
 	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get step execution: %w", err)
+	}
+	return step, nil
+}
 
-	return steps, rows.Err()
+// LoadStepOutputs puts the log file of each step in its Output, or only the
+// end of a large one. A log that can't be read is noted in the output.
+func (s *Storage) LoadStepOutputs(steps []*StepExecution) {
+	for _, step := range steps {
+		if step.LogPath == "" {
+			continue
+		}
+		if err := s.loadInlineLog(step); err != nil {
+			step.Output = fmt.Sprintf("[failed to read step log: %v]\n", err)
+		}
+	}
 }
 
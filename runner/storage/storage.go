@@ -3,13 +3,26 @@ package storage
 import (
 	"database/sql"
 	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
 // Storage handles database operations
 type Storage struct {
-	db *sql.DB
+	db       *sql.DB
+	ownerPID   int    // Recorded on new runs so crashed processes can be detected
+	ownerStart string // When the owner process started, see ProcessStartTime
+	dataDir    string // Directory of the database, also holding run workspaces
+
+	// Steps waiting to be indexed for search in the background
+	indexMu     sync.Mutex
+	indexQueue  []int
+	indexing    bool
+	indexClosed bool
+	indexWG     sync.WaitGroup
 }
 
 // NewStorage creates a new storage instance
@@ -19,7 +32,7 @@ func NewStorage(dbPath string) (*Storage, error) {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
 
-	storage := &Storage{db: db}
+	storage := &Storage{db: db, ownerPID: os.Getpid(), ownerStart: ProcessStartTime(os.Getpid()), dataDir: filepath.Dir(dbPath)}
 	if err := storage.initSchema(); err != nil {
 		return nil, fmt.Errorf("failed to initialize schema: %w", err)
 	}
@@ -27,10 +40,35 @@ func NewStorage(dbPath string) (*Storage, error) {
 	return storage, nil
 }
 
+// DataDir returns the directory holding the database
+func (s *Storage) DataDir() string {
+	return s.dataDir
+}
+
 // initSchema creates the database tables and handles migrations
 func (s *Storage) initSchema() error {
 	// Create tables with new schema
 	queries := []string{
+		`CREATE TABLE IF NOT EXISTS executions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			status TEXT NOT NULL,
+			config_path TEXT NOT NULL,
+			project_name TEXT NOT NULL DEFAULT '',
+			"trigger" TEXT NOT NULL DEFAULT '',
+			queued_at DATETIME,
+			started_at DATETIME,
+			finished_at DATETIME,
+			duration TEXT,
+			error TEXT,
+			commit_sha TEXT NOT NULL DEFAULT '',
+			branch TEXT NOT NULL DEFAULT '',
+			tag TEXT NOT NULL DEFAULT '',
+			commit_author TEXT NOT NULL DEFAULT '',
+			commit_message TEXT NOT NULL DEFAULT '',
+			config_snapshot TEXT,
+			owner_pid INTEGER,
+			owner_start TEXT NOT NULL DEFAULT ''
+		)`,
 		`CREATE TABLE IF NOT EXISTS runs (
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
 			status TEXT NOT NULL,
@@ -40,7 +78,25 @@ func (s *Storage) initSchema() error {
 			part TEXT NOT NULL DEFAULT 'default',
 			started_at DATETIME NOT NULL,
 			finished_at DATETIME,
-			duration TEXT
+			duration TEXT,
+			reason TEXT,
+			commit_sha TEXT NOT NULL DEFAULT '',
+			branch TEXT NOT NULL DEFAULT '',
+			tag TEXT NOT NULL DEFAULT '',
+			commit_author TEXT NOT NULL DEFAULT '',
+			commit_message TEXT NOT NULL DEFAULT '',
+			queued_at DATETIME,
+			execution_id INTEGER REFERENCES executions(id) ON DELETE CASCADE,
+			parent_run_id INTEGER,
+			owner_pid INTEGER,
+			owner_start TEXT NOT NULL DEFAULT '',
+			workspace_path TEXT,
+			failure_reason TEXT,
+			failure_exit_code INTEGER,
+			failure_message TEXT,
+			failure_step TEXT,
+			failure_cause TEXT,
+			checked_out INTEGER NOT NULL DEFAULT 0
 		)`,
 		`CREATE TABLE IF NOT EXISTS step_executions (
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
@@ -55,6 +111,50 @@ func (s *Storage) initSchema() error {
 			started_at DATETIME NOT NULL,
 			finished_at DATETIME,
 			duration TEXT,
+			last_activity_at DATETIME,
+			exit_code INTEGER,
+			signal TEXT,
+			user_cpu_ms INTEGER,
+			system_cpu_ms INTEGER,
+			max_rss_kb INTEGER,
+			failure_reason TEXT,
+			failure_message TEXT,
+			failure_cause TEXT,
+			log_path TEXT,
+			log_size INTEGER,
+			log_truncated INTEGER,
+			summary TEXT,
+			search_indexed INTEGER NOT NULL DEFAULT 0,
+			FOREIGN KEY(run_id) REFERENCES runs(id) ON DELETE CASCADE
+		)`,
+		`CREATE TABLE IF NOT EXISTS webhook_deliveries (
+			delivery_id TEXT PRIMARY KEY,
+			project_name TEXT NOT NULL,
+			provider TEXT NOT NULL,
+			ref TEXT NOT NULL DEFAULT '',
+			commit_sha TEXT NOT NULL DEFAULT '',
+			received_at DATETIME NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS idempotency_keys (
+			scope TEXT NOT NULL,
+			key TEXT NOT NULL,
+			fingerprint TEXT NOT NULL DEFAULT '',
+			run_ids TEXT NOT NULL DEFAULT '',
+			created_at DATETIME NOT NULL,
+			PRIMARY KEY (scope, key)
+		)`,
+		`CREATE TABLE IF NOT EXISTS annotations (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			run_id INTEGER NOT NULL,
+			step_id INTEGER NOT NULL,
+			type TEXT NOT NULL,
+			message TEXT NOT NULL DEFAULT '',
+			title TEXT NOT NULL DEFAULT '',
+			file TEXT NOT NULL DEFAULT '',
+			line INTEGER,
+			col INTEGER,
+			log_line INTEGER NOT NULL,
+			end_log_line INTEGER,
 			FOREIGN KEY(run_id) REFERENCES runs(id) ON DELETE CASCADE
 		)`,
 		`CREATE INDEX IF NOT EXISTS idx_runs_status ON runs(status)`,
@@ -62,10 +162,12 @@ func (s *Storage) initSchema() error {
 		`CREATE INDEX IF NOT EXISTS idx_runs_project_name ON runs(project_name)`,
 		`CREATE INDEX IF NOT EXISTS idx_runs_group ON runs("group")`,
 		`CREATE INDEX IF NOT EXISTS idx_runs_part ON runs(part)`,
+		`CREATE INDEX IF NOT EXISTS idx_executions_project_name ON executions(project_name)`,
 		`CREATE INDEX IF NOT EXISTS idx_step_executions_run_id ON step_executions(run_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_step_executions_group ON step_executions("group")`,
 		`CREATE INDEX IF NOT EXISTS idx_step_executions_part ON step_executions(part)`,
 		`CREATE INDEX IF NOT EXISTS idx_step_executions_category ON step_executions(category)`,
+		`CREATE INDEX IF NOT EXISTS idx_annotations_run_id ON annotations(run_id)`,
 	}
 
 	for _, query := range queries {
@@ -97,6 +199,63 @@ func (s *Storage) migrateSchema() error {
 		`ALTER TABLE step_executions ADD COLUMN "group" TEXT NOT NULL DEFAULT ''`,
 		// Add category to step_executions if it doesn't exist
 		`ALTER TABLE step_executions ADD COLUMN category TEXT NOT NULL DEFAULT ''`,
+		// Add skip reason to runs if it doesn't exist
+		`ALTER TABLE runs ADD COLUMN reason TEXT`,
+		// Add commit metadata to runs if it doesn't exist
+		`ALTER TABLE runs ADD COLUMN commit_sha TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE runs ADD COLUMN branch TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE runs ADD COLUMN commit_author TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE runs ADD COLUMN commit_message TEXT NOT NULL DEFAULT ''`,
+		// Add enqueue time to runs if it doesn't exist
+		`ALTER TABLE runs ADD COLUMN queued_at DATETIME`,
+		// Add owning execution to runs if it doesn't exist
+		`ALTER TABLE runs ADD COLUMN execution_id INTEGER REFERENCES executions(id) ON DELETE CASCADE`,
+		`CREATE INDEX IF NOT EXISTS idx_runs_execution_id ON runs(execution_id)`,
+		// Add the run a rerun or resume was started from if it doesn't exist
+		`ALTER TABLE runs ADD COLUMN parent_run_id INTEGER`,
+		`CREATE INDEX IF NOT EXISTS idx_runs_parent_run_id ON runs(parent_run_id)`,
+		// Add the config an execution ran with if it doesn't exist
+		`ALTER TABLE executions ADD COLUMN config_snapshot TEXT`,
+		// Add the process running a run or execution if it doesn't exist
+		`ALTER TABLE runs ADD COLUMN owner_pid INTEGER`,
+		`ALTER TABLE executions ADD COLUMN owner_pid INTEGER`,
+		// Add the last output time of a step if it doesn't exist
+		`ALTER TABLE step_executions ADD COLUMN last_activity_at DATETIME`,
+		// Add how a step exited and its resource usage if they don't exist
+		`ALTER TABLE step_executions ADD COLUMN exit_code INTEGER`,
+		`ALTER TABLE step_executions ADD COLUMN signal TEXT`,
+		`ALTER TABLE step_executions ADD COLUMN user_cpu_ms INTEGER`,
+		`ALTER TABLE step_executions ADD COLUMN system_cpu_ms INTEGER`,
+		`ALTER TABLE step_executions ADD COLUMN max_rss_kb INTEGER`,
+		// Add why a step failed if it doesn't exist
+		`ALTER TABLE step_executions ADD COLUMN failure_reason TEXT`,
+		// Add the isolated workspace of a run if it doesn't exist
+		`ALTER TABLE runs ADD COLUMN workspace_path TEXT`,
+		// Add the log file of a step if it doesn't exist
+		`ALTER TABLE step_executions ADD COLUMN log_path TEXT`,
+		`ALTER TABLE step_executions ADD COLUMN log_size INTEGER`,
+		`ALTER TABLE step_executions ADD COLUMN log_truncated INTEGER`,
+		// Add whether a step is in the search index if it doesn't exist
+		`ALTER TABLE step_executions ADD COLUMN search_indexed INTEGER NOT NULL DEFAULT 0`,
+		// Add why a run failed and the message of a step failure if they don't exist
+		`ALTER TABLE runs ADD COLUMN failure_reason TEXT`,
+		`ALTER TABLE runs ADD COLUMN failure_exit_code INTEGER`,
+		`ALTER TABLE runs ADD COLUMN failure_message TEXT`,
+		`ALTER TABLE runs ADD COLUMN failure_step TEXT`,
+		`ALTER TABLE step_executions ADD COLUMN failure_message TEXT`,
+		// Add the markdown summary of a step if it doesn't exist
+		`ALTER TABLE step_executions ADD COLUMN summary TEXT`,
+		// Add the classified cause of a failure if it doesn't exist
+		`ALTER TABLE runs ADD COLUMN failure_cause TEXT`,
+		`ALTER TABLE step_executions ADD COLUMN failure_cause TEXT`,
+		// Add whether a run checked its commit out if it doesn't exist
+		`ALTER TABLE runs ADD COLUMN checked_out INTEGER NOT NULL DEFAULT 0`,
+		// Add when the process running a run or execution started if it doesn't exist
+		`ALTER TABLE runs ADD COLUMN owner_start TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE executions ADD COLUMN owner_start TEXT NOT NULL DEFAULT ''`,
+		// Add the pushed tag a run or execution was triggered for if it doesn't exist
+		`ALTER TABLE runs ADD COLUMN tag TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE executions ADD COLUMN tag TEXT NOT NULL DEFAULT ''`,
 	}
 
 	for _, migration := range migrations {
@@ -104,11 +263,12 @@ func (s *Storage) migrateSchema() error {
 		s.db.Exec(migration)
 	}
 
-	return nil
+	return s.initSearch()
 }
 
-// Close closes the database connection
+// Close closes the database connection, once the step being indexed for search is done
 func (s *Storage) Close() error {
+	s.stopIndexing()
 	return s.db.Close()
 }
 
@@ -0,0 +1,39 @@
+package storage
+
+import (
+	"fmt"
+)
+
+// SetStepSummary stores the markdown summary written by a step
+func (s *Storage) SetStepSummary(stepID int, markdown string) error {
+	_, err := s.db.Exec("UPDATE step_executions SET summary = ? WHERE id = ?", markdown, stepID)
+	if err != nil {
+		return fmt.Errorf("failed to set step summary: %w", err)
+	}
+	return nil
+}
+
+// GetRunSummary retrieves the summaries of the steps of a run in the order they ran.
+// Steps that wrote no summary are left out.
+func (s *Storage) GetRunSummary(runID int) ([]*StepSummary, error) {
+	rows, err := s.db.Query(
+		`SELECT id, name, summary FROM step_executions
+			WHERE run_id = ? AND summary IS NOT NULL AND summary != '' ORDER BY id ASC`,
+		runID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query step summaries: %w", err)
+	}
+	defer rows.Close()
+
+	summaries := make([]*StepSummary, 0)
+	for rows.Next() {
+		var summary StepSummary
+		if err := rows.Scan(&summary.StepID, &summary.Step, &summary.Markdown); err != nil {
+			return nil, fmt.Errorf("failed to scan step summary: %w", err)
+		}
+		summaries = append(summaries, &summary)
+	}
+
+	return summaries, rows.Err()
+}
@@ -0,0 +1,38 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+)
+
+// RecordWebhookDelivery stores a webhook delivery.
+// Returns false if a delivery with the same ID was already recorded.
+func (s *Storage) RecordWebhookDelivery(delivery WebhookDelivery) (bool, error) {
+	if delivery.ReceivedAt.IsZero() {
+		delivery.ReceivedAt = time.Now()
+	}
+
+	result, err := s.db.Exec(
+		`INSERT OR IGNORE INTO webhook_deliveries (delivery_id, project_name, provider, ref, commit_sha, received_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		delivery.DeliveryID, delivery.ProjectName, delivery.Provider, delivery.Ref, delivery.CommitSHA, delivery.ReceivedAt,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to record webhook delivery: %w", err)
+	}
+
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to record webhook delivery: %w", err)
+	}
+
+	return inserted > 0, nil
+}
+
+// ForgetWebhookDelivery removes a recorded delivery, so a redelivery of a
+// webhook that could not be handled is processed again
+func (s *Storage) ForgetWebhookDelivery(deliveryID string) error {
+	if _, err := s.db.Exec(`DELETE FROM webhook_deliveries WHERE delivery_id = ?`, deliveryID); err != nil {
+		return fmt.Errorf("failed to forget webhook delivery: %w", err)
+	}
+	return nil
+}
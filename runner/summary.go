@@ -0,0 +1,184 @@
+package runner
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"pipego/ansi"
+)
+
+// maxSummarySize is how much markdown a step can publish in its summary
+const maxSummarySize = 1 << 20
+
+// summaryFile is the file a step appends its markdown summary to. Its path is
+// given to the step in PIPEGO_SUMMARY.
+type summaryFile struct {
+	path string
+}
+
+// newSummaryFile creates an empty summary file for a step
+func newSummaryFile() (*summaryFile, error) {
+	f, err := os.CreateTemp("", "pipego-summary-*.md")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create summary file: %w", err)
+	}
+	f.Close()
+	return &summaryFile{path: f.Name()}, nil
+}
+
+// env returns the environment variables telling the step where to write
+func (f *summaryFile) env() []string {
+	if f == nil {
+		return nil
+	}
+	return []string{"PIPEGO_SUMMARY=" + f.path}
+}
+
+// collect reads what the step wrote, sanitised, and removes the file
+func (f *summaryFile) collect() (string, error) {
+	if f == nil {
+		return "", nil
+	}
+	defer os.Remove(f.path)
+
+	file, err := os.Open(f.path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read summary file: %w", err)
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxSummarySize+1))
+	if err != nil {
+		return "", fmt.Errorf("failed to read summary file: %w", err)
+	}
+
+	markdown := string(data)
+	truncated := len(data) > maxSummarySize
+	if truncated {
+		markdown = markdown[:maxSummarySize]
+		if i := strings.LastIndexByte(markdown, '\n'); i >= 0 {
+			markdown = markdown[:i+1]
+		}
+	}
+	markdown = sanitizeSummary(markdown)
+	if truncated {
+		markdown += fmt.Sprintf("\n*Summary truncated at %d KB*\n", maxSummarySize/1024)
+	}
+	return markdown, nil
+}
+
+// summaryTags are the HTML elements summaries can use, without attributes.
+// Other tags are escaped so they show as text.
+var summaryTags = map[string]bool{
+	"b": true, "br": true, "code": true, "details": true, "em": true, "hr": true,
+	"i": true, "kbd": true, "li": true, "ol": true, "p": true, "pre": true,
+	"strong": true, "sub": true, "summary": true, "sup": true, "ul": true,
+	"table": true, "thead": true, "tbody": true, "tr": true, "th": true, "td": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+}
+
+// summaryLinkSchemes are the schemes links in summaries can have, besides
+// relative links without one
+var summaryLinkSchemes = map[string]bool{"http": true, "https": true, "mailto": true}
+
+var (
+	summaryTagPattern      = regexp.MustCompile(`^</?([a-zA-Z][a-zA-Z0-9]*)\s*/?>`)
+	summaryAutolinkPattern = regexp.MustCompile(`^<https?://[^\s<>]+>`)
+	// The destinations of inline links and images, and of link definitions.
+	// Both are matched anywhere, as definitions can be in lists and quotes and
+	// destinations can follow on the next line; only unsafe ones are changed.
+	summaryLinkPattern       = regexp.MustCompile(`\]\(\s*(<[^<>\n]*>|(?:[^\s()]|\([^\s()]*\))+)`)
+	summaryDefinitionPattern = regexp.MustCompile(`\]:\s*(<[^<>\n]*>|\S+)`)
+	// Backslash escapes markdown removes from link destinations
+	summaryEscapePattern = regexp.MustCompile("\\\\([!-/:-@\\[-`{-~])")
+)
+
+// sanitizeSummary makes markdown written by a step safe to render: escape
+// sequences and control characters are removed, HTML other than a few
+// formatting elements is escaped, and links other than http, https, mailto and
+// relative ones are disabled. The whole text is sanitised the same way, code
+// spans and fenced code blocks included: where those start and end depends on
+// the lists, quotes and HTML blocks around them, which is easy to get wrong, so
+// HTML in code shows escaped as &lt; rather than risk it being rendered.
+func sanitizeSummary(markdown string) string {
+	text := ansi.ConvertString(markdown, ansi.FormatText)
+	text = disableLinks(text, summaryDefinitionPattern)
+	text = disableLinks(text, summaryLinkPattern)
+	return escapeSummaryHTML(text)
+}
+
+// disableLinks replaces the link destinations pattern finds with "#" unless
+// they are safe to follow
+func disableLinks(text string, pattern *regexp.Regexp) string {
+	matches := pattern.FindAllStringSubmatchIndex(text, -1)
+	for i := len(matches) - 1; i >= 0; i-- {
+		start, end := matches[i][2], matches[i][3]
+		if !safeLinkDestination(text[start:end]) {
+			text = text[:start] + "#" + text[end:]
+		}
+	}
+	return text
+}
+
+// safeLinkDestination reports whether a link destination is relative or has an
+// allowed scheme once it is decoded the way markdown renderers and browsers
+// decode it: backslash escapes, HTML entities and percent-escapes are undone,
+// and whitespace and control characters are dropped.
+func safeLinkDestination(dest string) bool {
+	dest = strings.TrimSuffix(strings.TrimPrefix(dest, "<"), ">")
+	// Decoded until nothing changes, so escapes can't hide inside each other
+	for range 5 {
+		decoded := html.UnescapeString(summaryEscapePattern.ReplaceAllString(dest, "$1"))
+		if unescaped, err := url.PathUnescape(decoded); err == nil {
+			decoded = unescaped
+		}
+		decoded = strings.Map(func(r rune) rune {
+			if unicode.IsSpace(r) || unicode.IsControl(r) {
+				return -1
+			}
+			return r
+		}, decoded)
+		if decoded == dest {
+			break
+		}
+		dest = decoded
+	}
+
+	i := strings.IndexAny(dest, ":/?#")
+	if i < 0 || dest[i] != ':' {
+		return true
+	}
+	return summaryLinkSchemes[strings.ToLower(dest[:i])]
+}
+
+// escapeSummaryHTML escapes the HTML in a piece of markdown that is not
+// an allowed tag or an autolink
+func escapeSummaryHTML(text string) string {
+	var out strings.Builder
+	for {
+		i := strings.IndexByte(text, '<')
+		if i < 0 {
+			out.WriteString(text)
+			return out.String()
+		}
+		out.WriteString(text[:i])
+		text = text[i:]
+
+		if m := summaryTagPattern.FindStringSubmatch(text); m != nil && summaryTags[strings.ToLower(m[1])] {
+			out.WriteString(m[0])
+			text = text[len(m[0]):]
+		} else if link := summaryAutolinkPattern.FindString(text); link != "" {
+			out.WriteString(link)
+			text = text[len(link):]
+		} else {
+			out.WriteString("&lt;")
+			text = text[1:]
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package runner
+
+import (
+	"strings"
+	"testing"
+)
+
+// sanitize runs sanitizeSummary without the line break it ends the text with
+func sanitize(markdown string) string {
+	return strings.TrimSuffix(sanitizeSummary(markdown), "\n")
+}
+
+func TestSanitizeSummaryLinks(t *testing.T) {
+	tests := []struct {
+		markdown string
+		want     string
+	}{
+		{"[x](javascript:alert(1))", "[x](#)"},
+		{"[x](&#106;avascript:alert(1))", "[x](#)"},
+		{"[y](javascript&#58;alert(1))", "[y](#)"},
+		{"[y](javascript&colon;alert(1))", "[y](#)"},
+		{"[y](javascript&#x3A;alert(1))", "[y](#)"},
+		{"[z](%6Aavascript:alert(1))", "[z](#)"},
+		{"[z](javascript%3Aalert(1))", "[z](#)"},
+		{"[z](&#37;6Aavascript:alert(1))", "[z](#)"},
+		{"[u](JavaScript:alert(1))", "[u](#)"},
+		{"[w](java&#9;script:alert(1))", "[w](#)"},
+		{"[w](java%0Ascript:alert(1))", "[w](#)"},
+		{"[e](javascript\\:alert(1))", "[e](#)"},
+		{"[a]( <javascript:alert(1)> )", "[a]( # )"},
+		{"[v](vbscript:msgbox(1))", "[v](#)"},
+		{"![img](data:image/svg+xml;base64,PHN2Zz4=)", "![img](#)"},
+		{"[t](file:///etc/passwd \"title\")", "[t](# \"title\")"},
+		{"[ref]: javascript:alert(1)", "[ref]: #"},
+		{"  [ref]: &#106;avascript:alert(1) \"title\"", "  [ref]: # \"title\""},
+		{"[ref]: <data:text/html,x>", "[ref]: #"},
+		{"see [a](javascript:x) and [b](https://example.com)", "see [a](#) and [b](https://example.com)"},
+
+		// Allowed
+		{"[docs](https://example.com/a_(b))", "[docs](https://example.com/a_(b))"},
+		{"[docs](HTTP://example.com)", "[docs](HTTP://example.com)"},
+		{"[mail](mailto:dev@example.com)", "[mail](mailto:dev@example.com)"},
+		{"[report](coverage/index.html)", "[report](coverage/index.html)"},
+		{"[anchor](#results)", "[anchor](#results)"},
+		{"[path](./a:b)", "[path](./a:b)"},
+		{"[query](?a=b:c)", "[query](?a=b:c)"},
+		{"[ref]: https://example.com", "[ref]: https://example.com"},
+
+		// Destinations on the next line, and definitions in lists, quotes and
+		// with escaped brackets in the label
+		{"[x](\njavascript:alert(1))", "[x](\n#)"},
+		{"[ref]:\n  javascript:alert(1)", "[ref]:\n  #"},
+		{"- a\n    - b\n\n      [ref]: javascript:alert(1)", "- a\n    - b\n\n      [ref]: #"},
+		{"> [ref]: javascript:alert(1)", "> [ref]: #"},
+		{"[a\\]b]: javascript:alert(1)", "[a\\]b]: #"},
+
+		// Code is sanitised like the rest of the text
+		{"```\n[x](javascript:alert(1))\n```", "```\n[x](#)\n```"},
+		{"`[x](javascript:alert(1))`", "`[x](#)`"},
+	}
+	for _, tt := range tests {
+		if got := sanitize(tt.markdown); got != tt.want {
+			t.Errorf("sanitizeSummary(%q) = %q, want %q", tt.markdown, got, tt.want)
+		}
+	}
+}
+
+func TestSanitizeSummaryHTML(t *testing.T) {
+	tests := []struct {
+		markdown string
+		want     string
+	}{
+		{"<b>bold</b> <br/>", "<b>bold</b> <br/>"},
+		{"<script>alert(1)</script>", "&lt;script>alert(1)&lt;/script>"},
+		{`<img src=x onerror=alert(1)>`, `&lt;img src=x onerror=alert(1)>`},
+		{`<b onclick="x">b</b>`, `&lt;b onclick="x">b</b>`},
+		{"<https://example.com>", "<https://example.com>"},
+		{"<javascript:alert(1)>", "&lt;javascript:alert(1)>"},
+		{"`<script>`", "`&lt;script>`"},
+		{"\\`<img src=x onerror=alert(1)>\\`", "\\`&lt;img src=x onerror=alert(1)>\\`"},
+		{"``<img src=x onerror=alert(1)>`", "``&lt;img src=x onerror=alert(1)>`"},
+		{"\x1b[31mred\x1b[0m", "red"},
+	}
+	for _, tt := range tests {
+		if got := sanitize(tt.markdown); got != tt.want {
+			t.Errorf("sanitizeSummary(%q) = %q, want %q", tt.markdown, got, tt.want)
+		}
+	}
+}
+
+func TestSanitizeSummaryFences(t *testing.T) {
+	// Whether a line is inside a fenced code block depends on the blocks
+	// around it, so HTML is escaped inside fences as well
+	tests := []struct {
+		name     string
+		markdown string
+		want     string
+	}{
+		{"backticks", "```go\n<script>\n```\n<script>", "```go\n&lt;script>\n```\n&lt;script>"},
+		{"tildes", "~~~\n<script>\n~~~", "~~~\n&lt;script>\n~~~"},
+		{"fence in a list item", "- item\n\n  ```\n<script>alert(1)</script>\n<img src=x onerror=alert(1)>\n",
+			"- item\n\n  ```\n&lt;script>alert(1)&lt;/script>\n&lt;img src=x onerror=alert(1)>"},
+		{"fence in an ordered list item", "1. item\n\n   ```\n<img src=x onerror=alert(1)>", "1. item\n\n   ```\n&lt;img src=x onerror=alert(1)>"},
+		{"fence in a nested list item", "- a\n  - b\n\n    ```\n<script>", "- a\n  - b\n\n    ```\n&lt;script>"},
+		{"fence in a quote", "> ```\n<script>alert(1)</script>", "> ```\n&lt;script>alert(1)&lt;/script>"},
+		{"fence in a quoted list", "> - item\n>\n>   ```\n> <script>", "> - item\n>\n>   ```\n> &lt;script>"},
+		{"fence in an HTML block", "<details>\n```\n<script>\n\n```\n<b>", "<details>\n```\n&lt;script>\n\n```\n<b>"},
+		{"fence in a pre block", "<pre>\n\n```\n<script>\n</pre>", "<pre>\n\n```\n&lt;script>\n</pre>"},
+	}
+	for _, tt := range tests {
+		if got := sanitize(tt.markdown); got != tt.want {
+			t.Errorf("%s: sanitizeSummary(%q) = %q, want %q", tt.name, tt.markdown, got, tt.want)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package runner
 
 import (
+	"context"
 	"time"
 
 	"pipego/runner/storage"
@@ -8,26 +9,49 @@ import (
 
 // PipelineResult represents the result of running a pipeline
 type PipelineResult struct {
-	Status   string        `json:"status"` // "success" or "failed"
-	RunID    int           `json:"run_id"`
-	Steps    []StepResult  `json:"steps"`
-	Duration time.Duration `json:"duration"`
-	Error    error         `json:"error,omitempty"`
+	Status      string           `json:"status"`       // "success" or "failed"
+	ExecutionID int              `json:"execution_id"` // The execution owning the part runs (0 without storage)
+	RunID       int              `json:"run_id"`       // The run of the last part that was started
+	Steps       []StepResult     `json:"steps"`
+	Duration    time.Duration    `json:"duration"`
+	Failure     *storage.Failure `json:"failure,omitempty"` // Why the pipeline did not succeed
+	Error       error            `json:"-"`
+	// Parts to run again because the failure looked transient, see RunPipelineOptions.AutoRetry
+	RetryParts []string `json:"retry_parts,omitempty"`
 }
 
 // StepResult represents the result of executing a single step
 type StepResult struct {
-	Name     string        `json:"name"`
-	Status   string        `json:"status"` // "success" or "failed"
-	Output   string        `json:"output"`
-	Duration time.Duration `json:"duration"`
-	Error    error         `json:"error,omitempty"`
+	Name     string             `json:"name"`
+	Status   string             `json:"status"` // "success" or "failed"
+	Output   string             `json:"output"`
+	Duration time.Duration      `json:"duration"`
+	Usage    *storage.StepUsage `json:"usage,omitempty"`   // Exit status and resource usage of the process
+	Failure  *storage.Failure   `json:"failure,omitempty"` // Why the step did not succeed
+	Summary  string             `json:"summary,omitempty"` // Markdown the step wrote to PIPEGO_SUMMARY
+	Error    error              `json:"-"`
 }
 
 // RunPipelineOptions configures how the pipeline should be executed
 type RunPipelineOptions struct {
-	Storage          *storage.Storage // Optional storage for database persistence
-	StreamToTerminal bool             // If true, also stream output to terminal
-	PartFilter       string           // Optional: run only this specific part (empty = run all)
+	Storage          *storage.Storage        // Optional storage for database persistence
+	StreamToTerminal bool                    // If true, also stream output to terminal
+	PartFilter       string                  // Optional: run only this specific part (empty = run all)
+	Parts            []string                // Optional: run these parts in order (takes precedence over PartFilter)
+	Context          context.Context         // Optional: cancelling it kills the running step (nil = never cancelled)
+	Commit           *storage.CommitInfo     // Optional: commit that triggered the run, recorded on each run
+	CheckoutCommit   bool                    // Optional: run in a worktree of Commit, whatever the workspace config says
+	Runs             map[string]*storage.Run // Optional: runs created when the pipeline was queued, by part path
+	Locks            *LockManager            // Optional: enforces project limits and part concurrency groups
+	HeldLocks        map[string]func()       // Optional: locks the caller already took, by name, with their release functions
+	ExecutionID      int                     // Optional: execution created when the pipeline was queued (0 = create one)
+	Trigger          string                  // Optional: what started the run, recorded on a new execution (default: "cli")
+	ConfigSnapshot   []byte                  // Optional: run this config instead of reading configPath
+	ParentRunID      int                     // Optional: the run this one re-runs or resumes
+	ResumeFromStep   int                     // Optional: skip this many steps of the first part
+	DefaultLimits    *Limits                 // Optional: resource limits of steps that don't set their own
+	FailureCauses    FailureCauses           // Optional: server rules naming why steps fail, after the project's own
+	RetryPolicy      *RetryPolicy            // Optional: retry policy of projects without their own
+	RetryAttempt     int                     // Optional: how many times the pipeline was already retried automatically
+	AutoRetry        bool                    // Optional: the caller re-queues the RetryParts of a transient failure
 }
-
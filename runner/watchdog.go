@@ -0,0 +1,100 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"pipego/events"
+)
+
+// heartbeatInterval is how often the last output time of a step is written to storage
+const heartbeatInterval = 5 * time.Second
+
+// watchdogTick is how often a running step is checked for output
+const watchdogTick = time.Second
+
+// StallError is the cause of a step killed for producing no output
+type StallError struct {
+	Timeout time.Duration
+}
+
+func (e *StallError) Error() string {
+	return fmt.Sprintf("no output for %s", e.Timeout)
+}
+
+// activityWriter records when a step last wrote to stdout or stderr
+type activityWriter struct {
+	start time.Time
+	last  atomic.Int64 // UnixNano of the last write
+}
+
+func newActivityWriter() *activityWriter {
+	a := &activityWriter{start: time.Now()}
+	a.last.Store(a.start.UnixNano())
+	return a
+}
+
+func (a *activityWriter) Write(p []byte) (int, error) {
+	if len(p) > 0 {
+		a.last.Store(time.Now().UnixNano())
+	}
+	return len(p), nil
+}
+
+// Last returns the time of the last output, or the start of the step if there was none
+func (a *activityWriter) Last() time.Time {
+	return time.Unix(0, a.last.Load())
+}
+
+// stepWatch identifies the step being watched, for the heartbeat and the stall event
+type stepWatch struct {
+	stepID    int // 0 without storage
+	runID     int
+	step      string
+	groupName string
+	partName  string
+	timeout   time.Duration // 0 = never stall
+}
+
+// watchStep writes heartbeats while the step produces output and cancels it with a
+// StallError once it has been silent for longer than its timeout. It returns when
+// ctx is done.
+func watchStep(ctx context.Context, cancel context.CancelCauseFunc, activity *activityWriter, w stepWatch, opts RunPipelineOptions) {
+	ticker := time.NewTicker(watchdogTick)
+	defer ticker.Stop()
+
+	// Output written before the first tick still needs a heartbeat
+	flushed := activity.start
+	var flushedAt time.Time
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		last := activity.Last()
+		if opts.Storage != nil && w.stepID != 0 && last.After(flushed) && time.Since(flushedAt) >= heartbeatInterval {
+			if err := opts.Storage.TouchStepExecution(w.stepID, last); err == nil {
+				flushed = last
+				flushedAt = time.Now()
+			}
+		}
+
+		if w.timeout > 0 && time.Since(last) >= w.timeout {
+			events.GetBroker().Broadcast("step_stalled", map[string]interface{}{
+				"run_id":           w.runID,
+				"step":             w.step,
+				"group":            w.groupName,
+				"part":             w.partName,
+				"timeout":          w.timeout.String(),
+				"last_activity_at": last,
+			})
+			cancel(&StallError{Timeout: w.timeout})
+			return
+		}
+	}
+}
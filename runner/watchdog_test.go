@@ -0,0 +1,92 @@
+package runner
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"pipego/runner/storage"
+)
+
+// processRunning reports whether a process exists and has not exited
+func processRunning(pid int) bool {
+	stat, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return false
+	}
+	// The state follows the command name in parentheses; Z is an exited process not yet reaped
+	fields := strings.Fields(string(stat[strings.LastIndexByte(string(stat), ')')+1:]))
+	return len(fields) > 0 && fields[0] != "Z"
+}
+
+func TestStalledStepIsKilled(t *testing.T) {
+	if _, err := os.Stat("/proc/self/stat"); err != nil {
+		t.Skip("needs /proc to check the step's processes")
+	}
+
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "pipego.yml")
+	// The step starts a child in its process group and then goes silent
+	config := `parts:
+  build:
+    steps:
+      - name: hang
+        no_output_timeout: 1s
+        run: "echo starting; sleep 30 & echo $! > child.pid; wait"
+`
+	if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	store, err := storage.NewStorage(filepath.Join(dir, "pipego.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	start := time.Now()
+	result, err := RunPipelineWithOptions(configPath, RunPipelineOptions{Storage: store})
+	if err == nil {
+		t.Fatal("silent step succeeded")
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("silent step ran for %s", elapsed)
+	}
+
+	if len(result.Steps) != 1 {
+		t.Fatalf("steps = %+v", result.Steps)
+	}
+	step := result.Steps[0]
+	if step.Status != "stalled" || step.Failure == nil || step.Failure.Reason != storage.FailureTimeout ||
+		!strings.Contains(step.Failure.Message, "no output for 1s") {
+		t.Errorf("stalled step = %s with failure %+v", step.Status, step.Failure)
+	}
+
+	// The whole process group is killed, not just the shell
+	data, err := os.ReadFile(filepath.Join(dir, "child.pid"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if processRunning(pid) {
+		t.Errorf("child process %d of the stalled step is still running", pid)
+	}
+
+	// The heartbeat recorded when the step last wrote, before it went silent
+	steps, err := store.GetStepExecutions(result.RunID)
+	if err != nil || len(steps) != 1 {
+		t.Fatalf("GetStepExecutions = %d steps, %v", len(steps), err)
+	}
+	stored := steps[0]
+	if stored.Status != "stalled" {
+		t.Errorf("stored status = %s, want stalled", stored.Status)
+	}
+	if stored.LastActivityAt == nil || stored.LastActivityAt.Before(start.Add(-time.Second)) || stored.LastActivityAt.After(start.Add(time.Second)) {
+		t.Errorf("last_activity_at = %v, want around the start at %v", stored.LastActivityAt, start)
+	}
+}
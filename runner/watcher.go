@@ -0,0 +1,423 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"pipego/events"
+)
+
+// watchPollInterval is how often watched directories are scanned for changes
+const watchPollInterval = time.Second
+
+// defaultWatchDebounce is used when a watch trigger has no debounce configured
+const defaultWatchDebounce = time.Second
+
+// FileWatcher runs the watch triggers of all projects while the server is up
+type FileWatcher struct {
+	projectsConfig *ProjectsConfig
+	queue          *RunQueue
+	baseDir        string
+	dataDir        string // never watched, the database lives here
+	stopChan       chan struct{}
+}
+
+// NewFileWatcher creates a new file watcher instance
+func NewFileWatcher(projectsConfig *ProjectsConfig, queue *RunQueue, baseDir, dataDir string) *FileWatcher {
+	return &FileWatcher{
+		projectsConfig: projectsConfig,
+		queue:          queue,
+		baseDir:        baseDir,
+		dataDir:        dataDir,
+		stopChan:       make(chan struct{}),
+	}
+}
+
+// Start watches every project with a watch trigger until Stop is called
+func (w *FileWatcher) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for _, project := range w.projectsConfig.Projects {
+		configPath := project.GetPipegoPath(w.baseDir)
+		cfg, err := LoadConfig(configPath)
+		if err != nil || cfg.Watch == nil {
+			continue
+		}
+
+		log.Printf("👀 Watching %s for changes", project.Name)
+
+		wg.Add(1)
+		go func(projectName, configPath string, trigger *WatchTrigger) {
+			defer wg.Done()
+
+			var runs restarter
+			watchFiles(ctx, filepath.Dir(configPath), trigger, []string{w.dataDir}, runs.running, func(changed []string) {
+				log.Printf("👀 %d file(s) changed in %s, triggering run", len(changed), projectName)
+				runs.restart(ctx, func(runCtx context.Context) {
+					w.runWatchTrigger(runCtx, projectName, configPath)
+				})
+			})
+			runs.wait()
+		}(project.Name, configPath, cfg.Watch)
+	}
+
+	<-w.stopChan
+	cancel()
+	wg.Wait()
+	log.Println("👀 File watcher stopped")
+}
+
+// Stop stops watching and cancels runs started by the watcher
+func (w *FileWatcher) Stop() {
+	close(w.stopChan)
+}
+
+// runWatchTrigger runs the parts selected by the project's watch trigger
+func (w *FileWatcher) runWatchTrigger(ctx context.Context, projectName, configPath string) {
+	// Reload config so edits to pipego.yml are picked up
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		log.Printf("❌ Failed to load config for %s: %v", projectName, err)
+		return
+	}
+
+	var parts, groups []string
+	if cfg.Watch != nil {
+		parts, groups = cfg.Watch.Parts, cfg.Watch.Groups
+	}
+	partsToRun := resolveParts(cfg, parts, groups)
+
+	events.GetBroker().Broadcast("run_started", map[string]interface{}{
+		"project": projectName,
+		"parts":   partsToRun,
+		"groups":  groups,
+		"type":    "watch",
+	})
+
+	pipeline, err := w.queue.Enqueue(RunRequest{
+		ConfigPath: configPath,
+		Parts:      partsToRun,
+		Trigger:    "watch",
+		Context:    ctx,
+	})
+	if err != nil {
+		log.Printf("❌ Failed to queue watch run for %s: %v", projectName, err)
+		return
+	}
+
+	<-pipeline.Done()
+
+	_, err = pipeline.Result()
+	if ctx.Err() != nil {
+		log.Printf("🛑 Watch run for %s cancelled", projectName)
+	} else if err != nil {
+		log.Printf("❌ Watch run failed for %s: %v", projectName, err)
+	} else {
+		log.Printf("✅ Watch run completed: %s", projectName)
+	}
+}
+
+// WatchPipeline runs the pipeline once and then again whenever files next to
+// the config change. Changes made while a run is in progress, e.g. by its own
+// steps, are ignored. ignoreDirs are absolute directories that never trigger a run.
+func WatchPipeline(ctx context.Context, configPath string, opts RunPipelineOptions, ignoreDirs []string) error {
+	// Runs change the working directory, so resolve the path up front
+	configPath, err := filepath.Abs(configPath)
+	if err != nil {
+		return fmt.Errorf("failed to resolve config path: %w", err)
+	}
+
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		return err
+	}
+
+	trigger := cfg.Watch
+	if trigger == nil {
+		trigger = &WatchTrigger{}
+	}
+
+	// An explicit part filter wins over the parts configured for the trigger
+	partsToRun := []string{opts.PartFilter}
+	if opts.PartFilter == "" {
+		partsToRun = resolveParts(cfg, trigger.Parts, trigger.Groups)
+	}
+
+	run := func(runCtx context.Context) {
+		runOpts := opts
+		runOpts.Context = runCtx
+		runOpts.Parts = partsToRun
+		_, err := RunPipelineWithOptions(configPath, runOpts)
+		if runCtx.Err() != nil {
+			fmt.Println("\n🛑 Run cancelled")
+			return
+		}
+		if err != nil {
+			fmt.Printf("\n❌ Pipeline failed: %v\n", err)
+		}
+		fmt.Println("\n👀 Waiting for changes...")
+	}
+
+	var runs restarter
+	runs.restart(ctx, run)
+
+	watchFiles(ctx, filepath.Dir(configPath), trigger, ignoreDirs, runs.running, func(changed []string) {
+		fmt.Printf("\n🔄 Changed: %s\n", strings.Join(changed, ", "))
+		runs.restart(ctx, run)
+	})
+
+	runs.wait()
+	return nil
+}
+
+// restarter runs one job at a time, cancelling the current job when a new one starts
+type restarter struct {
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// restart cancels the running job, waits for it to exit and starts job in the background
+func (r *restarter) restart(parent context.Context, job func(ctx context.Context)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.cancel != nil {
+		r.cancel()
+		<-r.done
+	}
+
+	ctx, cancel := context.WithCancel(parent)
+	done := make(chan struct{})
+	r.cancel, r.done = cancel, done
+
+	go func() {
+		defer close(done)
+		defer cancel()
+		job(ctx)
+	}()
+}
+
+// running reports whether a job is in progress
+func (r *restarter) running() bool {
+	r.mu.Lock()
+	done := r.done
+	r.mu.Unlock()
+
+	if done == nil {
+		return false
+	}
+	select {
+	case <-done:
+		return false
+	default:
+		return true
+	}
+}
+
+// wait blocks until the current job has finished
+func (r *restarter) wait() {
+	r.mu.Lock()
+	done := r.done
+	r.mu.Unlock()
+
+	if done != nil {
+		<-done
+	}
+}
+
+// fileStamp identifies a version of a file for change detection
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// watchFiles polls root until ctx is done and calls onChange with the changed
+// paths once the tree has been quiet for the trigger's debounce period.
+// Nothing is reported while busy returns true: a run writes into the project
+// (dependencies, build output), which would otherwise start it again. The
+// tree is snapshotted afresh once it returns false.
+func watchFiles(ctx context.Context, root string, trigger *WatchTrigger, ignoreDirs []string, busy func() bool, onChange func(changed []string)) {
+	debounce := defaultWatchDebounce
+	if trigger.Debounce != "" {
+		d, err := parseInterval(trigger.Debounce)
+		if err != nil {
+			log.Printf("⚠️  Invalid debounce '%s': %v", trigger.Debounce, err)
+		} else {
+			debounce = d
+		}
+	}
+
+	// Runs change the working directory, so never walk a relative path
+	if absRoot, err := filepath.Abs(root); err == nil {
+		root = absRoot
+	}
+
+	previous := snapshotFiles(root, trigger, ignoreDirs)
+	pending := make(map[string]bool)
+	var lastChange time.Time
+	wasBusy := false
+
+	ticker := time.NewTicker(watchPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if busy() {
+			wasBusy = true
+			pending = make(map[string]bool)
+			continue
+		}
+		if wasBusy {
+			// What changed during the run is the run's doing
+			previous = snapshotFiles(root, trigger, ignoreDirs)
+			wasBusy = false
+			continue
+		}
+
+		current := snapshotFiles(root, trigger, ignoreDirs)
+		for _, changed := range diffSnapshots(previous, current) {
+			pending[changed] = true
+			lastChange = time.Now()
+		}
+		previous = current
+
+		if len(pending) > 0 && time.Since(lastChange) >= debounce {
+			changed := make([]string, 0, len(pending))
+			for p := range pending {
+				changed = append(changed, p)
+			}
+			sort.Strings(changed)
+			pending = make(map[string]bool)
+
+			onChange(changed)
+		}
+	}
+}
+
+// snapshotFiles records the stamp of every watched file under root
+func snapshotFiles(root string, trigger *WatchTrigger, ignoreDirs []string) map[string]fileStamp {
+	excludes := append([]string{".git/**"}, trigger.Exclude...)
+	snapshot := make(map[string]fileStamp)
+
+	filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Files can disappear while walking, just skip them
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, p)
+		if err != nil || rel == "." {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+
+		if d.IsDir() {
+			for _, dir := range ignoreDirs {
+				if p == dir {
+					return filepath.SkipDir
+				}
+			}
+			if matchAnyGlob(excludes, rel) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if matchAnyGlob(excludes, rel) {
+			return nil
+		}
+		if len(trigger.Include) > 0 && !matchAnyGlob(trigger.Include, rel) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		snapshot[rel] = fileStamp{modTime: info.ModTime(), size: info.Size()}
+		return nil
+	})
+
+	return snapshot
+}
+
+// diffSnapshots returns the paths that were added, modified or removed
+func diffSnapshots(previous, current map[string]fileStamp) []string {
+	changed := make([]string, 0)
+	for p, stamp := range current {
+		old, exists := previous[p]
+		if !exists || !old.modTime.Equal(stamp.modTime) || old.size != stamp.size {
+			changed = append(changed, p)
+		}
+	}
+	for p := range previous {
+		if _, exists := current[p]; !exists {
+			changed = append(changed, p)
+		}
+	}
+	return changed
+}
+
+// matchAnyGlob reports whether name matches at least one of the patterns
+func matchAnyGlob(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matchGlob(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchGlob matches a slash separated path against a glob pattern.
+// "**" matches any number of directories, and a pattern without a slash
+// (e.g. "*.go") is matched against the file name at any depth.
+func matchGlob(pattern, name string) bool {
+	if !strings.Contains(pattern, "/") {
+		ok, _ := path.Match(pattern, path.Base(name))
+		return ok
+	}
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+// matchSegments matches path segments, expanding "**" recursively
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+
+	return len(name) == 0
+}
@@ -0,0 +1,166 @@
+package runner
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		// Without a slash the file name is matched at any depth
+		{"*.go", "main.go", true},
+		{"*.go", "runner/storage/logs.go", true},
+		{"*.go", "main.go.orig", false},
+		{"*_test.go", "runner/queue_test.go", true},
+		{"Makefile", "sub/Makefile", true},
+
+		// With a slash the whole path is matched
+		{"src/*.go", "src/main.go", true},
+		{"src/*.go", "src/pkg/main.go", false},
+		{"src/*.go", "other/src/main.go", false},
+
+		// "**" matches any number of directories, none included
+		{"src/**/*.go", "src/main.go", true},
+		{"src/**/*.go", "src/a/b/c/main.go", true},
+		{"src/**/*.go", "lib/main.go", false},
+		{"**/testdata/*", "testdata/x.json", true},
+		{"**/testdata/*", "api/testdata/x.json", true},
+		{"docs/**", "docs/guide/index.md", true},
+		{"docs/**", "docs", true},
+		{".git/**", ".git/objects/ab/cdef", true},
+		{".git/**", ".github/workflows/ci.yml", false},
+		{"a/**/b/**/c", "a/x/b/y/z/c", true},
+		{"a/**/b/**/c", "a/x/y/c", false},
+
+		{"node_modules/**", "web/node_modules/x.js", false},
+		{"[", "file", false},
+	}
+	for _, tt := range tests {
+		if got := matchGlob(tt.pattern, tt.name); got != tt.want {
+			t.Errorf("matchGlob(%q, %q) = %v, want %v", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSnapshotFiles(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{
+		"main.go", "main_test.go", "README.md",
+		"runner/queue.go", "runner/testdata/config.yml",
+		".git/HEAD", "build/out.go", "data/pipego.db",
+	} {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		trigger WatchTrigger
+		want    []string
+	}{
+		{"everything", WatchTrigger{},
+			[]string{"README.md", "build/out.go", "main.go", "main_test.go", "runner/queue.go", "runner/testdata/config.yml"}},
+		{"include and exclude", WatchTrigger{Include: []string{"*.go"}, Exclude: []string{"*_test.go", "build/**"}},
+			[]string{"main.go", "runner/queue.go"}},
+		{"include a directory", WatchTrigger{Include: []string{"runner/**"}},
+			[]string{"runner/queue.go", "runner/testdata/config.yml"}},
+	}
+	for _, tt := range tests {
+		snapshot := snapshotFiles(root, &tt.trigger, []string{filepath.Join(root, "data")})
+		var got []string
+		for name := range snapshot {
+			got = append(got, name)
+		}
+		sort.Strings(got)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: watched %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDiffSnapshots(t *testing.T) {
+	now := time.Now()
+	previous := map[string]fileStamp{
+		"same.go":    {modTime: now, size: 1},
+		"touched.go": {modTime: now, size: 1},
+		"grown.go":   {modTime: now, size: 1},
+		"removed.go": {modTime: now, size: 1},
+	}
+	current := map[string]fileStamp{
+		"same.go":    {modTime: now, size: 1},
+		"touched.go": {modTime: now.Add(time.Second), size: 1},
+		"grown.go":   {modTime: now, size: 2},
+		"added.go":   {modTime: now, size: 1},
+	}
+
+	changed := diffSnapshots(previous, current)
+	sort.Strings(changed)
+	if want := []string{"added.go", "grown.go", "removed.go", "touched.go"}; !slices.Equal(changed, want) {
+		t.Errorf("changed = %v, want %v", changed, want)
+	}
+}
+
+func TestWatchPipelineIgnoresChangesByItsRun(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "pipego.yml")
+	// The step writes build output into the watched project, slower than the poll interval
+	config := `watch:
+  debounce: 100ms
+parts:
+  build:
+    steps:
+      - name: build
+        run: "echo x >> runs; sleep 1.5; date +%s%N > build.out"
+`
+	if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	runs := func() int {
+		data, _ := os.ReadFile(filepath.Join(dir, "runs"))
+		return strings.Count(string(data), "\n")
+	}
+	waitRuns := func(want int) {
+		t.Helper()
+		for deadline := time.Now().Add(10 * time.Second); runs() < want; time.Sleep(50 * time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("%d run(s), want %d", runs(), want)
+			}
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- WatchPipeline(ctx, configPath, RunPipelineOptions{}, nil) }()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// The files the run wrote don't start it again
+	waitRuns(1)
+	time.Sleep(5 * time.Second)
+	if n := runs(); n != 1 {
+		t.Fatalf("run restarted itself: %d runs", n)
+	}
+
+	// A change once it is over does
+	if err := os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitRuns(2)
+}
@@ -0,0 +1,86 @@
+package runner
+
+import (
+	"path"
+	"sort"
+	"strings"
+)
+
+// PushEvent is a push of a branch or tag reported by a forge webhook
+type PushEvent struct {
+	Provider   string // "github", "gitlab" or "gitea"
+	DeliveryID string
+	Ref        string // Full ref, e.g. "refs/heads/main" or "refs/tags/v1.0.0"
+	SHA        string
+	Author     string
+	Message    string
+	Deleted    bool // The branch or tag was deleted, nothing to run
+}
+
+// Branch returns the pushed branch name, or "" for tag pushes
+func (e *PushEvent) Branch() string {
+	if !strings.HasPrefix(e.Ref, "refs/heads/") {
+		return ""
+	}
+	return strings.TrimPrefix(e.Ref, "refs/heads/")
+}
+
+// Tag returns the pushed tag name, or "" for branch pushes
+func (e *PushEvent) Tag() string {
+	if !strings.HasPrefix(e.Ref, "refs/tags/") {
+		return ""
+	}
+	return strings.TrimPrefix(e.Ref, "refs/tags/")
+}
+
+// MatchPushRules returns the parts the push rules of a config select for the event.
+// A rule without branches and tags matches every branch push; tags only match
+// rules that list them. Returns nil when no rule matches.
+func MatchPushRules(cfg *Config, event *PushEvent) []string {
+	if cfg.On == nil || event.Deleted {
+		return nil
+	}
+
+	branch, tag := event.Branch(), event.Tag()
+	matched := make(map[string]bool)
+	anyRule := false
+
+	for _, rule := range cfg.On.Push {
+		ruleMatches := false
+		switch {
+		case tag != "":
+			ruleMatches = globMatchAny(rule.Tags, tag)
+		case branch != "":
+			ruleMatches = (len(rule.Branches) == 0 && len(rule.Tags) == 0) || globMatchAny(rule.Branches, branch)
+		}
+		if !ruleMatches {
+			continue
+		}
+
+		anyRule = true
+		for _, partPath := range resolveParts(cfg, rule.Parts, rule.Groups) {
+			matched[partPath] = true
+		}
+	}
+
+	if !anyRule {
+		return nil
+	}
+
+	parts := make([]string, 0, len(matched))
+	for partPath := range matched {
+		parts = append(parts, partPath)
+	}
+	sort.Strings(parts)
+	return parts
+}
+
+// globMatchAny reports whether name matches one of the glob patterns
+func globMatchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,297 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"pipego/runner/storage"
+)
+
+// DefaultWorkspaceKeepFor is how long kept workspaces stay before they are cleaned up
+const DefaultWorkspaceKeepFor = 24 * time.Hour
+
+// Validate checks the workspace mode and strategy
+func (w *Workspace) Validate() error {
+	if w == nil {
+		return nil
+	}
+	switch w.Mode {
+	case "", WorkspaceShared, WorkspaceIsolated:
+	default:
+		return fmt.Errorf("invalid workspace mode '%s', expected '%s' or '%s'", w.Mode, WorkspaceShared, WorkspaceIsolated)
+	}
+	switch w.Strategy {
+	case "", WorkspaceCopy, WorkspaceWorktree:
+	default:
+		return fmt.Errorf("invalid workspace strategy '%s', expected '%s' or '%s'", w.Strategy, WorkspaceCopy, WorkspaceWorktree)
+	}
+	if w.KeepFor != "" {
+		if keepFor, err := time.ParseDuration(w.KeepFor); err != nil || keepFor < 0 {
+			return fmt.Errorf("invalid workspace keep_for '%s'", w.KeepFor)
+		}
+	}
+	return nil
+}
+
+// GetKeepFor returns how long kept workspaces stay, falling back to the default
+func (w *Workspace) GetKeepFor() time.Duration {
+	if w == nil || w.KeepFor == "" {
+		return DefaultWorkspaceKeepFor
+	}
+	keepFor, err := time.ParseDuration(w.KeepFor)
+	if err != nil {
+		return DefaultWorkspaceKeepFor
+	}
+	return keepFor
+}
+
+// checksOutCommit reports whether a run builds its commit in a worktree of it
+func checksOutCommit(opts RunPipelineOptions) bool {
+	return opts.CheckoutCommit && opts.Commit != nil && opts.Commit.CommitSHA != ""
+}
+
+// checkoutWorkspace returns the workspace a pipeline runs in: the configured
+// one, or a worktree of the commit when the run has to build that commit
+// rather than whatever the project directory holds
+func checkoutWorkspace(ws *Workspace, opts RunPipelineOptions) *Workspace {
+	if !checksOutCommit(opts) {
+		return ws
+	}
+	checkout := Workspace{Mode: WorkspaceIsolated, Strategy: WorkspaceWorktree}
+	if ws != nil {
+		checkout.KeepOnFailure = ws.KeepOnFailure
+		checkout.KeepFor = ws.KeepFor
+	}
+	return &checkout
+}
+
+// runWorkspace is the isolated directory the parts of one pipeline run in
+type runWorkspace struct {
+	root string // Directory created for the run
+	dir  string // Where steps run: the project directory inside root
+	repo string // Repository the worktree belongs to, empty for copies
+}
+
+// workspacesDir returns the directory holding the workspaces of a project
+func workspacesDir(opts RunPipelineOptions, projectName string) string {
+	base := filepath.Join(os.TempDir(), "pipego-workspaces")
+	if opts.Storage != nil {
+		base = filepath.Join(opts.Storage.DataDir(), "workspaces")
+	}
+	return filepath.Join(base, projectName)
+}
+
+// createWorkspace copies the project directory, or checks it out as a git
+// worktree, into a new directory for one pipeline run. Workspaces of the
+// project that have been kept for longer than keep_for are removed first.
+func createWorkspace(ws *Workspace, projectDir, projectName string, opts RunPipelineOptions) (*runWorkspace, error) {
+	parent := workspacesDir(opts, projectName)
+	cleanupWorkspaces(parent, ws.GetKeepFor(), opts.Storage)
+
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create workspaces directory: %w", err)
+	}
+	root, err := os.MkdirTemp(parent, fmt.Sprintf("exec-%d-", opts.ExecutionID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create workspace: %w", err)
+	}
+
+	w := &runWorkspace{root: root, dir: root}
+	if ws.Strategy == WorkspaceWorktree {
+		ctx := opts.Context
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		err = w.checkoutWorktree(ctx, projectDir, opts.Commit)
+	} else {
+		// The data directory may lie inside the project, don't copy it into itself
+		skip := parent
+		if opts.Storage != nil {
+			skip = opts.Storage.DataDir()
+		}
+		err = copyDir(projectDir, root, skip)
+	}
+	if err != nil {
+		w.remove()
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// checkoutWorktree adds a detached git worktree of the project repository at
+// the commit of the run, or HEAD. Uncommitted changes are not part of it. A
+// commit the repository doesn't have yet is fetched first.
+func (w *runWorkspace) checkoutWorktree(ctx context.Context, projectDir string, commit *storage.CommitInfo) error {
+	top, err := runGit(projectDir, "rev-parse", "--show-toplevel")
+	top = strings.TrimSpace(top)
+	if err != nil {
+		return fmt.Errorf("worktree workspace needs a git repository: %w", err)
+	}
+	rel, err := filepath.Rel(top, projectDir)
+	if err != nil {
+		return err
+	}
+
+	ref := "HEAD"
+	if commit != nil && commit.CommitSHA != "" {
+		ref = commit.CommitSHA
+		if err := fetchCommit(ctx, top, ref); err != nil {
+			return fmt.Errorf("failed to get commit for worktree: %w", err)
+		}
+	}
+
+	// Forget worktrees whose directories were cleaned up
+	_, _ = runGit(top, "worktree", "prune")
+	if _, err := runGit(top, "worktree", "add", "--detach", w.root, ref); err != nil {
+		return fmt.Errorf("failed to add worktree: %w", err)
+	}
+
+	w.repo = top
+	w.dir = filepath.Join(w.root, rel)
+	return nil
+}
+
+// remove deletes the workspace, detaching it from its repository if it is a worktree
+func (w *runWorkspace) remove() {
+	if w.repo != "" {
+		if _, err := runGit(w.repo, "worktree", "remove", "--force", w.root); err == nil {
+			return
+		}
+	}
+	if err := os.RemoveAll(w.root); err != nil {
+		log.Printf("⚠️  Failed to remove workspace %s: %v", w.root, err)
+	}
+	if w.repo != "" {
+		_, _ = runGit(w.repo, "worktree", "prune")
+	}
+}
+
+// finish removes the workspace once the pipeline is over, or keeps it for
+// inspection if the pipeline failed and keep_on_failure is set
+func (w *runWorkspace) finish(ws *Workspace, result *PipelineResult) {
+	failed := result.Status != "success" && result.Status != "cancelled"
+	if failed && ws.KeepOnFailure {
+		log.Printf("📁 Kept workspace of failed run: %s", w.root)
+		return
+	}
+	w.remove()
+}
+
+// cleanupWorkspaces removes workspaces older than keepFor, except those of
+// executions that are still running
+func cleanupWorkspaces(parent string, keepFor time.Duration, store *storage.Storage) {
+	entries, err := os.ReadDir(parent)
+	if err != nil {
+		return
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || time.Since(info.ModTime()) < keepFor {
+			continue
+		}
+		if store != nil && workspaceInUse(store, entry.Name()) {
+			continue
+		}
+
+		path := filepath.Join(parent, entry.Name())
+		if err := os.RemoveAll(path); err != nil {
+			log.Printf("⚠️  Failed to remove workspace %s: %v", path, err)
+			continue
+		}
+		log.Printf("🧹 Removed expired workspace %s", path)
+	}
+}
+
+// workspaceInUse reports whether a workspace named "exec-<id>-..." belongs to
+// an execution that has not finished
+func workspaceInUse(store *storage.Storage, name string) bool {
+	fields := strings.SplitN(name, "-", 3)
+	if len(fields) < 2 || fields[0] != "exec" {
+		return false
+	}
+	executionID, err := strconv.Atoi(fields[1])
+	if err != nil || executionID == 0 {
+		return false
+	}
+	execution, err := store.GetExecution(executionID)
+	if err != nil {
+		return false
+	}
+	return execution.Status == "queued" || execution.Status == "running"
+}
+
+// copyDir copies the tree at src into dst, which must exist. Files keep their
+// modes and symlinks are copied as links. The directory skip is left out.
+func copyDir(src, dst, skip string) error {
+	skipAbs, _ := filepath.Abs(skip)
+
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if abs, _ := filepath.Abs(path); d.IsDir() && abs == skipAbs {
+			return filepath.SkipDir
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case d.IsDir():
+			if rel == "." {
+				return nil
+			}
+			return os.Mkdir(target, info.Mode().Perm())
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case info.Mode().IsRegular():
+			return copyFile(path, target, info.Mode().Perm())
+		default:
+			// Sockets, pipes and devices are not copied
+			return nil
+		}
+	})
+}
+
+func copyFile(src, dst string, perm fs.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,226 @@
+package runner
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"pipego/runner/storage"
+)
+
+// writeFiles creates files under dir, with their parent directories
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCopyDir(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	writeFiles(t, src, map[string]string{
+		"pipego.yml":        "parts: {}",
+		"src/main.go":       "package main",
+		".pipego/pipego.db": "data",
+	})
+	if err := os.Chmod(filepath.Join(src, "src/main.go"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("src/main.go", filepath.Join(src, "link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/nonexistent", filepath.Join(src, "dangling")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := copyDir(src, dst, filepath.Join(src, ".pipego")); err != nil {
+		t.Fatal(err)
+	}
+
+	if data, err := os.ReadFile(filepath.Join(dst, "src/main.go")); err != nil || string(data) != "package main" {
+		t.Errorf("copied file = %q, %v", data, err)
+	}
+	if info, err := os.Stat(filepath.Join(dst, "src/main.go")); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("copied file mode = %v, %v, want 0600", info.Mode(), err)
+	}
+	// Symlinks stay links, even when they point nowhere
+	for name, want := range map[string]string{"link": "src/main.go", "dangling": "/nonexistent"} {
+		if link, err := os.Readlink(filepath.Join(dst, name)); err != nil || link != want {
+			t.Errorf("copied %s links to %q, %v, want %q", name, link, err, want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dst, ".pipego")); !os.IsNotExist(err) {
+		t.Errorf("skipped directory was copied: %v", err)
+	}
+}
+
+func TestCreateWorkspaceCopy(t *testing.T) {
+	projectDir := filepath.Join(t.TempDir(), "app")
+	// The data directory, and the workspaces in it, lie inside the project
+	writeFiles(t, projectDir, map[string]string{"pipego.yml": "parts: {}", "build.sh": "make", ".pipego/logs/1.log": "x"})
+	store, err := storage.NewStorage(filepath.Join(projectDir, ".pipego", "pipego.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	ws := &Workspace{Mode: WorkspaceIsolated}
+	w, err := createWorkspace(ws, projectDir, "app", RunPipelineOptions{Storage: store, ExecutionID: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parent := filepath.Join(projectDir, ".pipego", "workspaces", "app"); filepath.Dir(w.root) != parent || !strings.HasPrefix(filepath.Base(w.root), "exec-7-") {
+		t.Errorf("workspace created at %s, want exec-7-* in %s", w.root, parent)
+	}
+	if w.dir != w.root || w.repo != "" {
+		t.Errorf("copy workspace dir = %s, repo = %q", w.dir, w.repo)
+	}
+	if _, err := os.Stat(filepath.Join(w.dir, "build.sh")); err != nil {
+		t.Errorf("project file not copied: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(w.dir, ".pipego")); !os.IsNotExist(err) {
+		t.Errorf("data directory copied into the workspace: %v", err)
+	}
+
+	w.finish(ws, &PipelineResult{Status: "success"})
+	if _, err := os.Stat(w.root); !os.IsNotExist(err) {
+		t.Errorf("workspace of a successful run was kept: %v", err)
+	}
+}
+
+func TestWorkspaceKeepOnFailure(t *testing.T) {
+	tests := []struct {
+		keepOnFailure bool
+		status        string
+		kept          bool
+	}{
+		{true, "failed", true},
+		{true, "success", false},
+		{true, "cancelled", false},
+		{false, "failed", false},
+	}
+	for _, tt := range tests {
+		root := t.TempDir()
+		w := &runWorkspace{root: root, dir: root}
+		w.finish(&Workspace{Mode: WorkspaceIsolated, KeepOnFailure: tt.keepOnFailure}, &PipelineResult{Status: tt.status})
+		_, err := os.Stat(root)
+		if kept := err == nil; kept != tt.kept {
+			t.Errorf("keep_on_failure %v, %s run: kept = %v, want %v", tt.keepOnFailure, tt.status, kept, tt.kept)
+		}
+	}
+}
+
+func TestCleanupWorkspaces(t *testing.T) {
+	dir := t.TempDir()
+	store, err := storage.NewStorage(filepath.Join(dir, "pipego.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	running, err := store.CreateExecution("pipego.yml", "app", "api", "running")
+	if err != nil {
+		t.Fatal(err)
+	}
+	finished, err := store.CreateExecution("pipego.yml", "app", "api", "running")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.FinishExecution(finished.ID, "failed", time.Second, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	parent := filepath.Join(dir, "workspaces", "app")
+	old := time.Now().Add(-2 * time.Hour)
+	workspaces := map[string]bool{ // Name and whether it is kept
+		fmt.Sprintf("exec-%d-1", running.ID):  true,
+		fmt.Sprintf("exec-%d-2", finished.ID): false,
+		"exec-0-3":                            false,
+		"exec-999-4":                          false,
+	}
+	for name := range workspaces {
+		path := filepath.Join(parent, name)
+		writeFiles(t, path, map[string]string{"file": "x"})
+		if err := os.Chtimes(path, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Kept for less than keep_for
+	fresh := fmt.Sprintf("exec-%d-5", finished.ID)
+	writeFiles(t, filepath.Join(parent, fresh), map[string]string{"file": "x"})
+	workspaces[fresh] = true
+
+	cleanupWorkspaces(parent, time.Hour, store)
+
+	for name, want := range workspaces {
+		_, err := os.Stat(filepath.Join(parent, name))
+		if kept := err == nil; kept != want {
+			t.Errorf("workspace %s kept = %v, want %v", name, kept, want)
+		}
+	}
+}
+
+func TestCreateWorkspaceWorktree(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	repo := t.TempDir()
+	projectDir := filepath.Join(repo, "services", "app")
+	writeFiles(t, projectDir, map[string]string{"pipego.yml": "parts: {}", "version.txt": "1"})
+	git(t, repo, "init", "-q", "-b", "main")
+	git(t, repo, "add", ".")
+	git(t, repo, "commit", "-q", "-m", "first")
+	first := strings.TrimSpace(git(t, repo, "rev-parse", "HEAD"))
+	writeFiles(t, projectDir, map[string]string{"version.txt": "2"})
+	git(t, repo, "commit", "-q", "-am", "second")
+
+	// Uncommitted changes are not part of the worktree
+	writeFiles(t, projectDir, map[string]string{"version.txt": "dirty"})
+
+	ws := &Workspace{Mode: WorkspaceIsolated, Strategy: WorkspaceWorktree}
+	tests := []struct {
+		commit *storage.CommitInfo
+		want   string
+	}{
+		{nil, "2"},
+		{&storage.CommitInfo{CommitSHA: first}, "1"},
+	}
+	for _, tt := range tests {
+		w, err := createWorkspace(ws, projectDir, "app", RunPipelineOptions{Commit: tt.commit})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if w.dir != filepath.Join(w.root, "services", "app") {
+			t.Errorf("worktree project dir = %s, want services/app in %s", w.dir, w.root)
+		}
+		if version, err := os.ReadFile(filepath.Join(w.dir, "version.txt")); err != nil || string(version) != tt.want {
+			t.Errorf("commit %+v: version = %q, %v, want %q", tt.commit, version, err, tt.want)
+		}
+		if worktrees := git(t, repo, "worktree", "list"); !strings.Contains(worktrees, w.root) {
+			t.Errorf("worktree %s not added:\n%s", w.root, worktrees)
+		}
+
+		w.finish(ws, &PipelineResult{Status: "success"})
+		if _, err := os.Stat(w.root); !os.IsNotExist(err) {
+			t.Errorf("worktree directory was kept: %v", err)
+		}
+		if worktrees := git(t, repo, "worktree", "list"); strings.Count(worktrees, "\n") != 1 {
+			t.Errorf("worktree not removed:\n%s", worktrees)
+		}
+	}
+
+	// The project directory must be in a repository
+	if _, err := createWorkspace(ws, t.TempDir(), "other", RunPipelineOptions{}); err == nil {
+		t.Error("worktree workspace created outside a git repository")
+	}
+}
@@ -70,7 +70,7 @@ export const api = {
   },
 
   getRun: async (runId: number): Promise<RunDetail> => {
-    const res = await fetch(`${API_BASE}/runs/${runId}`);
+    const res = await fetch(`${API_BASE}/runs/${runId}?format=text`);
     if (!res.ok) throw new Error('Failed to fetch run');
     return res.json();
   },